@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultAliasMinLength = 4   // The shortest custom alias allowed unless configured otherwise
+	maxAliasLength        = 64  // The longest custom alias allowed
+	namespaceSeparator    = "/" // Separates the segments of a namespaced alias like team/launch
+	maxNamespaceDepth     = 4   // The most segments a namespaced alias may have
+)
+
+var (
+	// aliasPattern the characters a custom alias may use
+	aliasPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	// sourcePattern what a creation source tag like "ios-app" may look like
+	sourcePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,32}$`)
+)
+
+// routeNamespacedURIs let the routes taking a single :uri segment, like
+// the management API, address namespaced links. Their separator is
+// escaped, as in /api/v1/urls/team%2Flaunch, and routing matches the raw
+// path so the escaped separator stays inside the segment
+func routeNamespacedURIs(r *gin.Engine) {
+	r.UseRawPath = true
+	r.UnescapePathValues = true
+}
+
+// ValidateAlias make sure a requested custom alias can be used as a uri.
+// minLength stops users grabbing ultra short premium aliases. With
+// namespaces an alias may have several segments like team/launch, and the
+// reserved words apply to the first one
+func ValidateAlias(alias string, minLength int, reserved map[string]ReservedHandler, namespaces bool) error {
+	if len(alias) < minLength {
+		return fmt.Errorf("alias must be at least %d characters", minLength)
+	}
+	if len(alias) > maxAliasLength {
+		return fmt.Errorf("alias must be at most %d characters", maxAliasLength)
+	}
+
+	segments := []string{alias}
+	if namespaces {
+		segments = strings.Split(alias, namespaceSeparator)
+		if len(segments) > maxNamespaceDepth {
+			return fmt.Errorf("alias may have at most %d segments", maxNamespaceDepth)
+		}
+	}
+	for _, segment := range segments {
+		if !aliasPattern.MatchString(segment) {
+			if namespaces {
+				return fmt.Errorf("alias segments may only contain letters, digits, '-' and '_'")
+			}
+			return fmt.Errorf("alias may only contain letters, digits, '-' and '_'")
+		}
+	}
+	// the api prefix is taken by our own routes once aliases have segments
+	if isReserved(segments[0], reserved) || (len(segments) > 1 && segments[0] == "api") {
+		return fmt.Errorf("alias %q is reserved", segments[0])
+	}
+
+	return nil
+}
+
+// ValidateSource make sure a creation source tag is a short plain token
+func ValidateSource(source string) error {
+	if source != "" && !sourcePattern.MatchString(source) {
+		return fmt.Errorf("source must be up to 32 letters, digits, '.', '-' or '_'")
+	}
+
+	return nil
+}
+
+// uriCondition the SQL condition matching a short link against the uri in
+// $1. In case insensitive mode the normalized lookup_uri is matched so
+// "Foo" and "foo" are the same link while uri keeps the casing it was
+// created with
+func uriCondition(caseInsensitive bool) string {
+	if caseInsensitive {
+		return "lookup_uri = lower($1)"
+	}
+	return "uri = $1"
+}
+
+// legacyURICondition the SQL condition matching links created before case
+// insensitive mode was turned on, which have no lookup_uri. An exact match
+// of the uri in $1 wins over one that only differs in case
+const legacyURICondition = "lookup_uri IS NULL AND lower(uri) = lower($1) ORDER BY uri = $1 DESC"
+
+// lookupURI the normalized form stored for a uri. Nothing is stored unless
+// case insensitive mode is on
+func lookupURI(uri string, caseInsensitive bool) *string {
+	if !caseInsensitive {
+		return nil
+	}
+	normalized := strings.ToLower(uri)
+	return &normalized
+}
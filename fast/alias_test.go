@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestValidateAlias(t *testing.T) {
+	reserved := map[string]ReservedHandler{"status": {}}
+	tests := []struct {
+		name       string
+		alias      string
+		namespaces bool
+		valid      bool
+	}{
+		{"plain", "launch", false, true},
+		{"dashes and underscores", "spring_launch-2", false, true},
+		{"at the minimum", "abcd", false, true},
+		{"too short", "abc", false, false},
+		{"at the maximum", strings.Repeat("a", maxAliasLength), false, true},
+		{"too long", strings.Repeat("a", maxAliasLength+1), false, false},
+		{"bad characters", "launch!", false, false},
+		{"default reserved word", "ping", false, false},
+		{"configured reserved word", "status", false, false},
+		{"segments without namespaces", "team/launch", false, false},
+		{"namespaced", "team/launch", true, true},
+		{"deepest namespace", "a/b/c/d", true, true},
+		{"too deep", "a/b/c/d/e", true, false},
+		{"empty segment", "team//launch", true, false},
+		{"reserved first segment", "ping/launch", true, false},
+		{"reserved later segment", "team/ping", true, true},
+		{"api prefix", "api/launch", true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateAlias(tt.alias, defaultAliasMinLength, reserved, tt.namespaces); (err == nil) != tt.valid {
+				t.Errorf("ValidateAlias(%q) = %v, want valid = %t", tt.alias, err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestURICondition(t *testing.T) {
+	tests := []struct {
+		caseInsensitive bool
+		condition       string
+		lookup          *string
+	}{
+		{false, "uri = $1", nil},
+		{true, "lookup_uri = lower($1)", func() *string { s := "launch"; return &s }()},
+	}
+
+	for _, tt := range tests {
+		if got := uriCondition(tt.caseInsensitive); got != tt.condition {
+			t.Errorf("uriCondition(%t) = %q, want %q", tt.caseInsensitive, got, tt.condition)
+		}
+		got := lookupURI("Launch", tt.caseInsensitive)
+		if (got == nil) != (tt.lookup == nil) || (got != nil && *got != *tt.lookup) {
+			t.Errorf("lookupURI(Launch, %t) = %v, want %v", tt.caseInsensitive, got, tt.lookup)
+		}
+	}
+}
+
+func TestValidateSource(t *testing.T) {
+	tests := []struct {
+		source string
+		valid  bool
+	}{
+		{"", true},
+		{"ios-app", true},
+		{"web_v2.1", true},
+		{strings.Repeat("a", 32), true},
+		{strings.Repeat("a", 33), false},
+		{"ios app", false},
+		{"ios/app", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			if err := ValidateSource(tt.source); (err == nil) != tt.valid {
+				t.Errorf("ValidateSource(%q) = %v, want valid = %t", tt.source, err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestRouteNamespacedURIs(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		body       string
+		namespaces bool
+		status     int
+	}{
+		{"update", http.MethodPut, "/api/v1/urls/team%2Flaunch", `{"url": "https://example.com/b"}`, true, http.StatusOK},
+		{"delete", http.MethodDelete, "/api/v1/urls/team%2Flaunch", "", true, http.StatusOK},
+		{"lowercase escape", http.MethodDelete, "/api/v1/urls/team%2flaunch", "", true, http.StatusOK},
+		{"unescaped separator", http.MethodDelete, "/api/v1/urls/team/launch", "", true, http.StatusNotFound},
+		{"namespaces off", http.MethodDelete, "/api/v1/urls/team%2Flaunch", "", false, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := (&fakeDB{}).
+				onFunc("WITH old AS", func(args []interface{}) fakeResult {
+					if args[0] != "team/launch" {
+						return fakeResult{}
+					}
+					return fakeResult{rows: [][]interface{}{{"team/launch", "https://example.com/b"}}}
+				}).
+				onFunc("WITH deleted AS", ownedLink("team/launch", testOwnerKey.ID, 1))
+			r := testRouter()
+			if tt.namespaces {
+				routeNamespacedURIs(r)
+			}
+			r.PUT("/api/v1/urls/:uri", requireAPIKey, updateURLHandler(context.Background(), fake, NewRedirectCache(10), false, "fa.st", nil, IDNConfig{}, testSugar))
+			r.DELETE("/api/v1/urls/:uri", requireAPIKey, deleteURLHandler(context.Background(), fake, NewRedirectCache(10), false, testSugar))
+
+			w := serve(r, tt.method, tt.target, testOwnerKey, tt.body)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status == http.StatusOK && !strings.Contains(w.Body.String(), `"uri":"team/launch"`) {
+				t.Errorf("body = %s, want the namespaced uri", w.Body)
+			}
+		})
+	}
+}
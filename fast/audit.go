@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aeekayy/systems/fast/db"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+const (
+	auditActionCreate   = "create"
+	auditActionDelete   = "delete"
+	auditActionUpdate   = "update"
+	auditActionMerge    = "merge"
+	auditActionTransfer = "transfer"
+	auditActionDisable  = "disable"
+	defaultAuditLimit   = 100  // The number of audit entries returned when no limit is given
+	maxAuditLimit       = 1000 // The most audit entries returned in one request
+)
+
+// AuditEntry a mutating action taken against a short link
+type AuditEntry struct {
+	Action  string    `json:"action" yaml:"action"`
+	URI     string    `json:"uri" yaml:"uri"`
+	Actor   string    `json:"actor,omitempty" yaml:"actor,omitempty"`
+	Created time.Time `json:"created" yaml:"created"`
+}
+
+// recordAudit write an audit entry for a mutating action. actor is the API
+// key id, empty for anonymous requests
+func recordAudit(ctx context.Context, dbConn db.Querier, action, uri, actor string) error {
+	_, err := dbConn.Exec(ctx, "INSERT INTO audit_log(action, uri, actor) VALUES($1, $2, NULLIF($3, ''));", action, uri, actor)
+	return err
+}
+
+// auditLogHandler list audit entries newest first, optionally filtered by
+// uri, action and actor
+func auditLogHandler(ctx context.Context, dbConn db.Querier, sugar *zap.SugaredLogger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sugar := requestSugar(c, sugar)
+		limit := defaultAuditLimit
+		if val := c.Query("limit"); val != "" {
+			parsed, err := strconv.Atoi(val)
+			if err != nil || parsed <= 0 {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": "limit must be a positive number",
+				})
+				return
+			}
+			limit = parsed
+		}
+		if limit > maxAuditLimit {
+			limit = maxAuditLimit
+		}
+
+		rows, err := dbConn.Query(ctx, `SELECT action, uri, COALESCE(actor, ''), created FROM audit_log
+			WHERE ($1 = '' OR uri = $1) AND ($2 = '' OR action = $2) AND ($3 = '' OR actor = $3)
+			ORDER BY created DESC LIMIT $4;`, c.Query("uri"), c.Query("action"), c.Query("actor"), limit)
+		if err != nil {
+			sugar.Errorf("error retrieving audit log: %s", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "error retrieving audit log",
+			})
+			return
+		}
+		defer rows.Close()
+
+		entries := []AuditEntry{}
+		for rows.Next() {
+			var entry AuditEntry
+			if err := rows.Scan(&entry.Action, &entry.URI, &entry.Actor, &entry.Created); err != nil {
+				sugar.Errorf("error reading audit log: %s", err)
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"error": "error retrieving audit log",
+				})
+				return
+			}
+			entries = append(entries, entry)
+		}
+		if err := rows.Err(); err != nil {
+			sugar.Errorf("error reading audit log: %s", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "error retrieving audit log",
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"data": entries,
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRecordAudit(t *testing.T) {
+	fake := &fakeDB{}
+	if err := recordAudit(context.Background(), fake, auditActionDelete, "launch", testOwnerKey.ID); err != nil {
+		t.Fatalf("recordAudit() = %v", err)
+	}
+	inserts := fake.statements("INSERT INTO audit_log")
+	if len(inserts) != 1 || inserts[0].args[0] != auditActionDelete || inserts[0].args[1] != "launch" || inserts[0].args[2] != testOwnerKey.ID {
+		t.Errorf("inserts = %v, want one delete of launch by %s", inserts, testOwnerKey.ID)
+	}
+}
+
+func TestAuditLogHandler(t *testing.T) {
+	created := time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		query   string
+		status  int
+		filters []interface{}
+	}{
+		{"defaults", "", http.StatusOK, []interface{}{"", "", "", defaultAuditLimit}},
+		{"filtered", "?uri=launch&action=delete&actor=k1&limit=5", http.StatusOK, []interface{}{"launch", "delete", "k1", 5}},
+		{"limit capped", "?limit=5000", http.StatusOK, []interface{}{"", "", "", maxAuditLimit}},
+		{"bad limit", "?limit=-1", http.StatusBadRequest, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := (&fakeDB{}).on("FROM audit_log", fakeResult{rows: [][]interface{}{
+				{auditActionDelete, "launch", testOwnerKey.ID, created},
+				{auditActionCreate, "launch", "", created.Add(-time.Hour)},
+			}})
+			r := testRouter()
+			r.GET("/api/v1/admin/audit", auditLogHandler(context.Background(), fake, testSugar))
+			w := serve(r, http.MethodGet, "/api/v1/admin/audit"+tt.query, testAdminKey, "")
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			args := fake.statements("FROM audit_log")[0].args
+			for i, want := range tt.filters {
+				if args[i] != want {
+					t.Errorf("argument %d = %v, want %v", i+1, args[i], want)
+				}
+			}
+			var body struct {
+				Data []AuditEntry `json:"data"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("couldn't decode %s: %s", w.Body, err)
+			}
+			if len(body.Data) != 2 || body.Data[0].Actor != testOwnerKey.ID || body.Data[1].Actor != "" {
+				t.Errorf("entries = %+v, want the two rows in order", body.Data)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+)
+
+const (
+	apiKeyHeader     = "X-API-Key" // The header clients send their API key in
+	apiKeyContextKey = "api_key"   // Where the authenticated key is stored in the gin context
+)
+
+// APIKey a configured API key. The ID is what we record as the actor so
+// the secret itself never ends up in the database or logs. Links created
+// with a key that has a Domain live under that vanity domain
+type APIKey struct {
+	ID     string `mapstructure:"id" yaml:"id"`
+	Key    string `mapstructure:"key" yaml:"key"`
+	Admin  bool   `mapstructure:"admin" yaml:"admin"`
+	Domain string `mapstructure:"domain" yaml:"domain"`
+}
+
+// loadAPIKeys read the API keys for the environment
+func loadAPIKeys(env string) ([]APIKey, error) {
+	var keys []APIKey
+	if err := viper.UnmarshalKey(fmt.Sprintf("%s.api_keys", env), &keys); err != nil {
+		return nil, fmt.Errorf("couldn't read api keys: %w", err)
+	}
+
+	for i, key := range keys {
+		if key.ID == "" || key.Key == "" {
+			return nil, fmt.Errorf("api keys need an id and a key")
+		}
+		keys[i].Domain = strings.TrimSuffix(strings.ToLower(key.Domain), ".")
+	}
+
+	return keys, nil
+}
+
+// authenticate identify the API key of the request if there is one. Requests
+// without a key are anonymous and carry on; an unknown key is rejected
+func authenticate(keys []APIKey) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		presented := c.GetHeader(apiKeyHeader)
+		if presented == "" {
+			c.Next()
+			return
+		}
+
+		if key, ok := findAPIKey(keys, presented); ok {
+			c.Set(apiKeyContextKey, key)
+			c.Next()
+			return
+		}
+
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": "invalid api key",
+		})
+	}
+}
+
+// findAPIKey the configured key a client presented, compared in constant
+// time so keys can't be guessed a byte at a time
+func findAPIKey(keys []APIKey, presented string) (APIKey, bool) {
+	for _, key := range keys {
+		if subtle.ConstantTimeCompare([]byte(presented), []byte(key.Key)) == 1 {
+			return key, true
+		}
+	}
+	return APIKey{}, false
+}
+
+// requireAPIKey only let authenticated requests through
+func requireAPIKey(c *gin.Context) {
+	if _, ok := currentAPIKey(c); !ok {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": "api key required",
+		})
+		return
+	}
+
+	c.Next()
+}
+
+// requireAdmin only let admin API keys through
+func requireAdmin(c *gin.Context) {
+	key, ok := currentAPIKey(c)
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": "api key required",
+		})
+		return
+	}
+	if !key.Admin {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"error": "admin api key required",
+		})
+		return
+	}
+
+	c.Next()
+}
+
+// currentAPIKey the API key the request was authenticated with
+func currentAPIKey(c *gin.Context) (APIKey, bool) {
+	val, ok := c.Get(apiKeyContextKey)
+	if !ok {
+		return APIKey{}, false
+	}
+	key, ok := val.(APIKey)
+	return key, ok
+}
+
+// actorID the id to record for whoever made the request. Anonymous requests
+// have an empty actor
+func actorID(c *gin.Context) string {
+	key, _ := currentAPIKey(c)
+	return key.ID
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+var (
+	testAdminKey = APIKey{ID: "ops", Key: "ops-secret", Admin: true}
+	testOwnerKey = APIKey{ID: "acme", Key: "acme-secret"}
+	testOtherKey = APIKey{ID: "globex", Key: "globex-secret"}
+	testAPIKeys  = []APIKey{testAdminKey, testOwnerKey, testOtherKey}
+	testSugar    = zap.NewNop().Sugar()
+)
+
+// testRouter a router that authenticates the test keys, like main's
+func testRouter() *gin.Engine {
+	r := gin.New()
+	r.Use(authenticate(testAPIKeys))
+	return r
+}
+
+// serve send a request to the router as key, anonymously when key is empty
+func serve(r http.Handler, method, target string, key APIKey, body string) *httptest.ResponseRecorder {
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, target, reader)
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if key.Key != "" {
+		req.Header.Set(apiKeyHeader, key.Key)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// withConfig set a configuration key for the test, resetting the
+// configuration once it's done
+func withConfig(t *testing.T, key string, value interface{}) {
+	t.Helper()
+	viper.Set(key, value)
+	t.Cleanup(viper.Reset)
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	tests := []struct {
+		name       string
+		middleware gin.HandlerFunc
+		key        APIKey
+		status     int
+	}{
+		{"anonymous passes authenticate", func(c *gin.Context) { c.Next() }, APIKey{}, http.StatusOK},
+		{"unknown key", func(c *gin.Context) { c.Next() }, APIKey{Key: "nope"}, http.StatusUnauthorized},
+		{"api key required", requireAPIKey, APIKey{}, http.StatusUnauthorized},
+		{"api key accepted", requireAPIKey, testOwnerKey, http.StatusOK},
+		{"admin required", requireAdmin, testOwnerKey, http.StatusForbidden},
+		{"admin anonymous", requireAdmin, APIKey{}, http.StatusUnauthorized},
+		{"admin accepted", requireAdmin, testAdminKey, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := testRouter()
+			r.GET("/", tt.middleware, func(c *gin.Context) {
+				c.String(http.StatusOK, actorID(c))
+			})
+			w := serve(r, http.MethodGet, "/", tt.key, "")
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+			if w.Code == http.StatusOK && w.Body.String() != tt.key.ID {
+				t.Errorf("actor = %q, want %q", w.Body.String(), tt.key.ID)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+)
+
+const (
+	malformedJSONCode = "malformed_json" // The error code for request bodies that aren't JSON at all
+	invalidFieldCode  = "invalid_field"  // The error code for JSON with a field of the wrong type or value
+)
+
+// FieldError what was wrong with one field of a request body
+type FieldError struct {
+	Field string `json:"field" yaml:"field"`
+	Error string `json:"error" yaml:"error"`
+}
+
+// bindError tell the client why its request body couldn't be bound. A body
+// that isn't JSON, or is cut short, gets a 400 with code malformed_json.
+// JSON with fields of the wrong type, or failing validation, gets a 422
+// naming the fields so clients can tell a broken request from a bad value
+func bindError(c *gin.Context, err error) {
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "request body isn't valid JSON",
+			"code":  malformedJSONCode,
+		})
+		return
+	}
+
+	// anything else came from a field decoding itself, like a ttl that isn't a duration
+	message := fmt.Sprintf("invalid request: %s", err)
+	var fields []FieldError
+	var typeErr *json.UnmarshalTypeError
+	var validationErrs validator.ValidationErrors
+	switch {
+	case errors.As(err, &typeErr):
+		fields = append(fields, FieldError{Field: typeErr.Field, Error: fmt.Sprintf("must be a %s", typeErr.Type)})
+		message = fmt.Sprintf("invalid request: %s must be a %s", typeErr.Field, typeErr.Type)
+	case errors.As(err, &validationErrs):
+		for _, fieldErr := range validationErrs {
+			fields = append(fields, FieldError{Field: fieldErr.Field(), Error: fmt.Sprintf("failed the %s check", fieldErr.Tag())})
+		}
+		message = "invalid request: some fields aren't valid"
+	}
+
+	response := gin.H{
+		"error": message,
+		"code":  invalidFieldCode,
+	}
+	if len(fields) > 0 {
+		response["fields"] = fields
+	}
+	c.JSON(http.StatusUnprocessableEntity, response)
+}
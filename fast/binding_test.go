@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestBindError(t *testing.T) {
+	type request struct {
+		URL  string `json:"url" binding:"required"`
+		Hits int    `json:"hits"`
+	}
+	r := gin.New()
+	r.POST("/bind", func(c *gin.Context) {
+		var req request
+		if err := c.ShouldBindJSON(&req); err != nil {
+			bindError(c, err)
+			return
+		}
+		c.Status(http.StatusOK)
+	})
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		code   string
+		fields []FieldError
+	}{
+		{"valid", `{"url": "https://example.com/a", "hits": 3}`, http.StatusOK, "", nil},
+		{"not json", `url=https://example.com/a`, http.StatusBadRequest, malformedJSONCode, nil},
+		{"cut short", `{"url": "https://exa`, http.StatusBadRequest, malformedJSONCode, nil},
+		{"empty", ``, http.StatusBadRequest, malformedJSONCode, nil},
+		{"wrong type", `{"url": "https://example.com/a", "hits": "three"}`, http.StatusUnprocessableEntity, invalidFieldCode, []FieldError{{Field: "hits", Error: "must be a int"}}},
+		{"missing field", `{"hits": 3}`, http.StatusUnprocessableEntity, invalidFieldCode, []FieldError{{Field: "URL", Error: "failed the required check"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(r, http.MethodPost, "/bind", APIKey{}, tt.body)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status == http.StatusOK {
+				return
+			}
+
+			var body struct {
+				Error  string       `json:"error"`
+				Code   string       `json:"code"`
+				Fields []FieldError `json:"fields"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("couldn't decode %s: %s", w.Body, err)
+			}
+			if body.Code != tt.code || body.Error == "" {
+				t.Errorf("got code %q and error %q, want %q with a message", body.Code, body.Error, tt.code)
+			}
+			if len(body.Fields) != len(tt.fields) {
+				t.Fatalf("fields = %+v, want %+v", body.Fields, tt.fields)
+			}
+			for i := range tt.fields {
+				if body.Fields[i] != tt.fields[i] {
+					t.Errorf("field %d = %+v, want %+v", i, body.Fields[i], tt.fields[i])
+				}
+			}
+		})
+	}
+}
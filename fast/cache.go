@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aeekayy/systems/fast/db"
+	"github.com/gin-gonic/gin"
+	pgx "github.com/jackc/pgx/v4"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultCacheTTL         = 5 * time.Minute // How long a redirect is cached when the link doesn't say
+	defaultCacheEditableTTL = 5 * time.Second // How long editable, temporary, links are cached so edits show up quickly
+	defaultCacheMaxEntries  = 10000           // The most links kept in the redirect cache
+	maxCacheWarmURIs        = 1000            // The most uris warmed in one request
+)
+
+// RedirectLink what a redirect needs to know about a link. Domain is the
+// vanity domain the link lives under, empty for the default domain.
+// CacheTTL is the link's own cache ttl, zero when it uses the configured one.
+// Disabled links were turned off after their destination kept failing
+// health checks
+type RedirectLink struct {
+	URI          string
+	Domain       string
+	OriginalURL  string
+	Destinations []Destination
+	Rules        []RedirectRule
+	Title        string
+	Description  string
+	Interstitial bool
+	RedirectType string
+	Confirmed    bool
+	Expires      *time.Time
+	CacheTTL     time.Duration
+	Signed       bool
+	Disabled     bool
+}
+
+// loadRedirectLink read the link for a short uri
+func loadRedirectLink(ctx context.Context, dbConn db.Querier, uri string, caseInsensitive bool) (RedirectLink, error) {
+	var link RedirectLink
+	var cacheTTL *int64
+	err := dbConn.QueryRow(ctx, "SELECT uri, COALESCE(domain, ''), original_url, destinations, rules, COALESCE(title, ''), COALESCE(description, ''), interstitial, redirect_type, confirmed, expires, cache_ttl, signed, disabled IS NOT NULL FROM "+urlsTable+" WHERE "+uriCondition(caseInsensitive)+" LIMIT 1;", uri).
+		Scan(&link.URI, &link.Domain, &link.OriginalURL, &link.Destinations, &link.Rules, &link.Title, &link.Description, &link.Interstitial, &link.RedirectType, &link.Confirmed, &link.Expires, &cacheTTL, &link.Signed, &link.Disabled)
+	if cacheTTL != nil {
+		link.CacheTTL = time.Duration(*cacheTTL) * time.Second
+	}
+	return link, err
+}
+
+// loadLegacyRedirectLink look up a link created before case insensitive
+// mode was turned on, for when loadRedirectLink finds nothing
+func loadLegacyRedirectLink(ctx context.Context, dbConn db.Querier, uri string) (RedirectLink, error) {
+	var link RedirectLink
+	var cacheTTL *int64
+	err := dbConn.QueryRow(ctx, "SELECT uri, COALESCE(domain, ''), original_url, destinations, rules, COALESCE(title, ''), COALESCE(description, ''), interstitial, redirect_type, confirmed, expires, cache_ttl, signed, disabled IS NOT NULL FROM "+urlsTable+" WHERE "+legacyURICondition+" LIMIT 1;", uri).
+		Scan(&link.URI, &link.Domain, &link.OriginalURL, &link.Destinations, &link.Rules, &link.Title, &link.Description, &link.Interstitial, &link.RedirectType, &link.Confirmed, &link.Expires, &cacheTTL, &link.Signed, &link.Disabled)
+	if cacheTTL != nil {
+		link.CacheTTL = time.Duration(*cacheTTL) * time.Second
+	}
+	return link, err
+}
+
+// resolveRedirectLink the link for a short uri from the redirect cache, or
+// from the database when it isn't cached, caching what was read. Links from
+// before case insensitive mode have no lookup_uri yet, legacyFallback looks
+// them up by their uri instead
+func resolveRedirectLink(ctx context.Context, dbConn db.Querier, rc *RedirectCache, cfg CacheConfig, uri string, caseInsensitive, legacyFallback bool, sugar *zap.SugaredLogger) (RedirectLink, error) {
+	key := cacheKey(uri, caseInsensitive)
+	link, cached := rc.Get(key)
+	if cfg.Enabled {
+		observeCacheLookup(cached)
+	}
+	if cached {
+		return link, nil
+	}
+
+	link, err := loadRedirectLink(ctx, dbConn, uri, caseInsensitive)
+	if errors.Is(err, pgx.ErrNoRows) && caseInsensitive && legacyFallback {
+		link, err = loadLegacyRedirectLink(ctx, dbConn, uri)
+		if err == nil {
+			sugar.Infof("resolved %s through the legacy uri %s, it needs a lookup_uri", uri, link.URI)
+		}
+	}
+	if err != nil {
+		return link, err
+	}
+	rc.Set(key, link, cfg.TTLFor(link, time.Now()))
+
+	return link, nil
+}
+
+// CacheConfig the in memory redirect cache. TTL applies to links without
+// their own cache ttl, EditableTTL caps temporary links since those are the
+// ones expected to change
+type CacheConfig struct {
+	Enabled     bool          `mapstructure:"enabled" yaml:"enabled"`
+	TTL         time.Duration `mapstructure:"ttl" yaml:"ttl"`
+	EditableTTL time.Duration `mapstructure:"editable_ttl" yaml:"editable_ttl"`
+	MaxEntries  int           `mapstructure:"max_entries" yaml:"max_entries"`
+}
+
+// loadCacheConfig read the redirect cache settings for the environment
+func loadCacheConfig(env string) (CacheConfig, error) {
+	var cfg CacheConfig
+	if err := viper.UnmarshalKey(fmt.Sprintf("%s.cache", env), &cfg); err != nil {
+		return cfg, fmt.Errorf("couldn't read cache configuration: %w", err)
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = defaultCacheTTL
+	}
+	if cfg.EditableTTL <= 0 {
+		cfg.EditableTTL = defaultCacheEditableTTL
+	}
+	if cfg.MaxEntries <= 0 {
+		cfg.MaxEntries = defaultCacheMaxEntries
+	}
+
+	return cfg, nil
+}
+
+// TTLFor how long a link may be cached: its own ttl or the configured one,
+// capped for editable links and never past the link's expiry
+func (cfg CacheConfig) TTLFor(link RedirectLink, now time.Time) time.Duration {
+	ttl := cfg.TTL
+	if link.CacheTTL > 0 {
+		ttl = link.CacheTTL
+	}
+	if link.RedirectType == redirectTemporary && ttl > cfg.EditableTTL {
+		ttl = cfg.EditableTTL
+	}
+	if link.Expires != nil {
+		if untilExpiry := link.Expires.Sub(now); untilExpiry < ttl {
+			ttl = untilExpiry
+		}
+	}
+
+	return ttl
+}
+
+// cacheEntry a cached link and when it goes stale
+type cacheEntry struct {
+	link    RedirectLink
+	expires time.Time
+}
+
+// RedirectCache keep recently followed links in memory so busy links don't
+// hit the database on every redirect. A nil cache caches nothing
+type RedirectCache struct {
+	mu         sync.Mutex
+	entries    map[string]cacheEntry
+	maxEntries int
+}
+
+// NewRedirectCache an empty cache holding up to maxEntries links
+func NewRedirectCache(maxEntries int) *RedirectCache {
+	return &RedirectCache{entries: map[string]cacheEntry{}, maxEntries: maxEntries}
+}
+
+// cacheKey the key a uri is cached under, matching how it is looked up
+func cacheKey(uri string, caseInsensitive bool) string {
+	if caseInsensitive {
+		return strings.ToLower(uri)
+	}
+	return uri
+}
+
+// Get the cached link for a key if it hasn't gone stale
+func (rc *RedirectCache) Get(key string) (RedirectLink, bool) {
+	if rc == nil {
+		return RedirectLink{}, false
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	entry, ok := rc.entries[key]
+	if !ok {
+		return RedirectLink{}, false
+	}
+	if !time.Now().Before(entry.expires) {
+		delete(rc.entries, key)
+		return RedirectLink{}, false
+	}
+	return entry.link, true
+}
+
+// Set cache a link for ttl. When the cache is full stale entries are
+// dropped first, then arbitrary ones
+func (rc *RedirectCache) Set(key string, link RedirectLink, ttl time.Duration) {
+	if rc == nil || ttl <= 0 {
+		return
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	now := time.Now()
+	if _, ok := rc.entries[key]; !ok && len(rc.entries) >= rc.maxEntries {
+		for k, entry := range rc.entries {
+			if !now.Before(entry.expires) {
+				delete(rc.entries, k)
+			}
+		}
+		for k := range rc.entries {
+			if len(rc.entries) < rc.maxEntries {
+				break
+			}
+			delete(rc.entries, k)
+		}
+	}
+	rc.entries[key] = cacheEntry{link: link, expires: now.Add(ttl)}
+}
+
+// Delete drop a link from the cache, reporting whether it was cached
+func (rc *RedirectCache) Delete(key string) bool {
+	if rc == nil {
+		return false
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	_, ok := rc.entries[key]
+	delete(rc.entries, key)
+	return ok
+}
+
+// cacheInvalidateHandler drop a uri from the redirect cache so the next
+// redirect reads it from the database, for links changed outside the service
+func cacheInvalidateHandler(rc *RedirectCache, caseInsensitive bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uri := c.Param("uri")
+		c.JSON(http.StatusOK, gin.H{
+			"data": gin.H{
+				"uri":         uri,
+				"invalidated": rc.Delete(cacheKey(uri, caseInsensitive)),
+			},
+		})
+	}
+}
+
+// CacheWarmRequest the uris to load into the redirect cache
+type CacheWarmRequest struct {
+	URIs []string `json:"uris" yaml:"uris"`
+}
+
+// cacheWarmHandler load uris into the redirect cache ahead of a traffic
+// spike, reporting which were warmed and which don't exist
+func cacheWarmHandler(ctx context.Context, dbConn db.Querier, rc *RedirectCache, cfg CacheConfig, caseInsensitive bool, sugar *zap.SugaredLogger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sugar := requestSugar(c, sugar)
+		if rc == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "redirect cache is disabled",
+			})
+			return
+		}
+
+		var json CacheWarmRequest
+		if err := c.ShouldBindJSON(&json); err != nil {
+			bindError(c, err)
+			return
+		}
+		if len(json.URIs) > maxCacheWarmURIs {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("at most %d uris can be warmed at once", maxCacheWarmURIs),
+			})
+			return
+		}
+
+		warmed, missing := []string{}, []string{}
+		for _, uri := range json.URIs {
+			link, err := loadRedirectLink(ctx, dbConn, uri, caseInsensitive)
+			if errors.Is(err, pgx.ErrNoRows) {
+				missing = append(missing, uri)
+				continue
+			}
+			if err != nil {
+				sugar.Errorf("error warming cache: %s", err)
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"error": "error warming cache",
+				})
+				return
+			}
+			rc.Set(cacheKey(uri, caseInsensitive), link, cfg.TTLFor(link, time.Now()))
+			warmed = append(warmed, uri)
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"data": gin.H{
+				"warmed":  warmed,
+				"missing": missing,
+			},
+		})
+	}
+}
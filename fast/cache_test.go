@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	pgx "github.com/jackc/pgx/v4"
+)
+
+func TestCacheConfigTTLFor(t *testing.T) {
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	soon := now.Add(30 * time.Second)
+	cfg := CacheConfig{Enabled: true, TTL: 10 * time.Minute, EditableTTL: time.Minute}
+
+	tests := []struct {
+		name string
+		link RedirectLink
+		want time.Duration
+	}{
+		{"configured default", RedirectLink{RedirectType: redirectPermanent}, 10 * time.Minute},
+		{"per link ttl", RedirectLink{RedirectType: redirectPermanent, CacheTTL: time.Hour}, time.Hour},
+		{"editable links are capped", RedirectLink{RedirectType: redirectTemporary}, time.Minute},
+		{"editable per link ttl is capped", RedirectLink{RedirectType: redirectTemporary, CacheTTL: time.Hour}, time.Minute},
+		{"short editable per link ttl", RedirectLink{RedirectType: redirectTemporary, CacheTTL: 5 * time.Second}, 5 * time.Second},
+		{"never past expiry", RedirectLink{RedirectType: redirectPermanent, Expires: &soon}, 30 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cfg.TTLFor(tt.link, now); got != tt.want {
+				t.Errorf("TTLFor() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedirectCache(t *testing.T) {
+	rc := NewRedirectCache(2)
+	rc.Set("a", RedirectLink{URI: "a"}, time.Hour)
+	rc.Set("stale", RedirectLink{URI: "stale"}, time.Nanosecond)
+	rc.Set("skipped", RedirectLink{URI: "skipped"}, 0)
+	time.Sleep(time.Millisecond)
+
+	if _, ok := rc.Get("stale"); ok {
+		t.Errorf("a stale entry was returned")
+	}
+	if _, ok := rc.Get("skipped"); ok {
+		t.Errorf("an entry with no ttl was cached")
+	}
+	rc.Set("b", RedirectLink{URI: "b"}, time.Hour)
+	rc.Set("c", RedirectLink{URI: "c"}, time.Hour)
+	if len(rc.entries) > 2 {
+		t.Errorf("cache holds %d entries, want at most 2", len(rc.entries))
+	}
+	if link, ok := rc.Get("c"); !ok || link.URI != "c" {
+		t.Errorf("Get(c) = %v, %v, want the newest entry", link, ok)
+	}
+	if !rc.Delete("c") || rc.Delete("c") {
+		t.Errorf("Delete should report the entry once")
+	}
+
+	var disabled *RedirectCache
+	disabled.Set("a", RedirectLink{}, time.Hour)
+	if _, ok := disabled.Get("a"); ok || disabled.Delete("a") {
+		t.Errorf("a nil cache cached something")
+	}
+}
+
+func TestCacheInvalidateHandler(t *testing.T) {
+	destination := "https://example.com/a"
+	fake := (&fakeDB{}).onFunc("SELECT uri", func(args []interface{}) fakeResult {
+		return fakeResult{rows: [][]interface{}{redirectRow("launch", destination)}}
+	})
+	cfg := CacheConfig{Enabled: true, TTL: time.Hour, EditableTTL: time.Minute}
+	rc := NewRedirectCache(10)
+	resolve := func() string {
+		link, err := resolveRedirectLink(context.Background(), fake, rc, cfg, "Launch", true, false, testSugar)
+		if err != nil {
+			t.Fatalf("resolveRedirectLink() = %v", err)
+		}
+		return link.OriginalURL
+	}
+
+	if got := resolve(); got != destination {
+		t.Fatalf("first redirect went to %s, want %s", got, destination)
+	}
+	// an external process points the link somewhere else
+	destination = "https://example.com/b"
+	if got := resolve(); got != "https://example.com/a" {
+		t.Fatalf("cached redirect went to %s, want the cached destination", got)
+	}
+
+	r := testRouter()
+	r.POST("/api/v1/admin/cache/invalidate/:uri", requireAdmin, cacheInvalidateHandler(rc, true))
+	tests := []struct {
+		name string
+		key  APIKey
+		want int
+		body string
+	}{
+		{"needs an admin", testOwnerKey, http.StatusForbidden, ""},
+		{"invalidates", testAdminKey, http.StatusOK, `{"data":{"invalidated":true,"uri":"LAUNCH"}}`},
+		{"nothing left to invalidate", testAdminKey, http.StatusOK, `{"data":{"invalidated":false,"uri":"LAUNCH"}}`},
+	}
+	for _, tt := range tests {
+		w := serve(r, http.MethodPost, "/api/v1/admin/cache/invalidate/LAUNCH", tt.key, "")
+		if w.Code != tt.want {
+			t.Fatalf("%s: status = %d, want %d", tt.name, w.Code, tt.want)
+		}
+		if tt.body != "" && w.Body.String() != tt.body {
+			t.Errorf("%s: body = %s, want %s", tt.name, w.Body, tt.body)
+		}
+	}
+
+	before := len(fake.statements("SELECT uri"))
+	if got := resolve(); got != destination {
+		t.Errorf("redirect after invalidating went to %s, want %s", got, destination)
+	}
+	if after := len(fake.statements("SELECT uri")); after != before+1 {
+		t.Errorf("the redirect after invalidating made %d reads, want 1", after-before)
+	}
+}
+
+func TestResolveRedirectLinkLegacyFallback(t *testing.T) {
+	fake := (&fakeDB{}).
+		on("lookup_uri = lower", fakeResult{}).
+		on("SELECT uri", fakeResult{rows: [][]interface{}{redirectRow("Launch", "https://example.com")}})
+
+	tests := []struct {
+		name     string
+		fallback bool
+		err      error
+	}{
+		{"without the fallback", false, pgx.ErrNoRows},
+		{"with the fallback", true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := resolveRedirectLink(context.Background(), fake, nil, CacheConfig{}, "launch", true, tt.fallback, testSugar)
+			if !errors.Is(err, tt.err) {
+				t.Errorf("resolveRedirectLink() = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	if got := cacheKey("Launch", true); got != "launch" {
+		t.Errorf("case insensitive key = %q, want launch", got)
+	}
+	if got := cacheKey("Launch", false); got != "Launch" {
+		t.Errorf("case sensitive key = %q, want Launch", got)
+	}
+}
+
+func TestCacheWarmHandler(t *testing.T) {
+	rows := map[string][]interface{}{
+		"launch": redirectRow("launch", "https://example.com/a"),
+		"promo":  redirectRow("promo", "https://example.com/b"),
+	}
+	tests := []struct {
+		name     string
+		cache    bool
+		body     string
+		status   int
+		warmed   []string
+		missing  []string
+		response string
+	}{
+		{"warms", true, `{"uris": ["launch", "promo"]}`, http.StatusOK, []string{"launch", "promo"}, nil, `{"data":{"missing":[],"warmed":["launch","promo"]}}`},
+		{"reports missing", true, `{"uris": ["launch", "gone"]}`, http.StatusOK, []string{"launch"}, []string{"gone"}, `{"data":{"missing":["gone"],"warmed":["launch"]}}`},
+		{"nothing to warm", true, `{"uris": []}`, http.StatusOK, nil, nil, `{"data":{"missing":[],"warmed":[]}}`},
+		{"too many", true, `{"uris": [` + strings.Repeat(`"launch",`, maxCacheWarmURIs) + `"promo"]}`, http.StatusBadRequest, nil, nil, ""},
+		{"cache disabled", false, `{"uris": ["launch"]}`, http.StatusServiceUnavailable, nil, nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := (&fakeDB{}).onFunc("SELECT uri, COALESCE(domain", linkRows(rows))
+			var rc *RedirectCache
+			if tt.cache {
+				rc = NewRedirectCache(10)
+			}
+			r := testRouter()
+			r.POST("/api/v1/admin/cache/warm", requireAdmin, cacheWarmHandler(context.Background(), fake, rc, CacheConfig{Enabled: tt.cache, TTL: time.Hour}, true, testSugar))
+			w := serve(r, http.MethodPost, "/api/v1/admin/cache/warm", testAdminKey, tt.body)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.response != "" && w.Body.String() != tt.response {
+				t.Errorf("body = %s, want %s", w.Body, tt.response)
+			}
+			if rc == nil {
+				return
+			}
+
+			for _, uri := range tt.warmed {
+				if link, ok := rc.Get(cacheKey(uri, true)); !ok || link.URI != uri {
+					t.Errorf("%s isn't cached after warming", uri)
+				}
+			}
+			for _, uri := range tt.missing {
+				if _, ok := rc.Get(cacheKey(uri, true)); ok {
+					t.Errorf("missing link %s was cached", uri)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/aeekayy/systems/fast/db"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// campaignPattern what a campaign id like "spring-launch" may look like
+var campaignPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// CampaignStats totals across the links of a campaign. Clicks counts
+// every redirect, ClicksLast24Hours the clicks recorded in the last day
+type CampaignStats struct {
+	Campaign          string     `json:"campaign" yaml:"campaign"`
+	Links             int64      `json:"links" yaml:"links"`
+	Clicks            int64      `json:"clicks" yaml:"clicks"`
+	ClicksLast24Hours int64      `json:"clicks_last_24h" yaml:"clicks_last_24h"`
+	TopLinks          []LinkHits `json:"top_links" yaml:"top_links"`
+}
+
+// ValidateCampaign make sure a campaign id is a short plain token
+func ValidateCampaign(campaign string) error {
+	if campaign != "" && !campaignPattern.MatchString(campaign) {
+		return fmt.Errorf("campaign must be up to 64 letters, digits, '.', '-' or '_'")
+	}
+
+	return nil
+}
+
+// campaignStatsHandler aggregate the clicks of every link created under a
+// campaign, with its most followed links. API keys only count the links
+// they own, admins everyone's
+func campaignStatsHandler(ctx context.Context, dbConn db.Querier, sugar *zap.SugaredLogger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sugar := requestSugar(c, sugar)
+		campaign := c.Param("id")
+		if err := ValidateCampaign(campaign); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		key, _ := currentAPIKey(c)
+		owner := key.ID
+		if key.Admin {
+			owner = ""
+		}
+
+		stats := CampaignStats{Campaign: campaign, TopLinks: []LinkHits{}}
+		err := dbConn.QueryRow(ctx, `WITH links AS (
+				SELECT uri, hits FROM `+urlsTable+` WHERE campaign = $1 AND ($2 = '' OR owner = $2)
+			)
+			SELECT (SELECT count(*) FROM links), (SELECT COALESCE(sum(hits), 0)::bigint FROM links),
+				(SELECT count(*) FROM clicks WHERE uri IN (SELECT uri FROM links) AND created > now() - interval '24 hours');`, campaign, owner).
+			Scan(&stats.Links, &stats.Clicks, &stats.ClicksLast24Hours)
+		if err != nil {
+			sugar.Errorf("error retrieving campaign stats: %s", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "error retrieving campaign stats",
+			})
+			return
+		}
+		if stats.Links == 0 {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "campaign not found",
+			})
+			return
+		}
+
+		rows, err := dbConn.Query(ctx, "SELECT uri, hits FROM "+urlsTable+" WHERE campaign = $1 AND ($2 = '' OR owner = $2) ORDER BY hits DESC LIMIT $3;", campaign, owner, defaultTopLinks)
+		if err != nil {
+			sugar.Errorf("error retrieving campaign links: %s", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "error retrieving campaign stats",
+			})
+			return
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var link LinkHits
+			if err := rows.Scan(&link.URI, &link.Hits); err != nil {
+				sugar.Errorf("error reading campaign links: %s", err)
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"error": "error retrieving campaign stats",
+				})
+				return
+			}
+			stats.TopLinks = append(stats.TopLinks, link)
+		}
+		if err := rows.Err(); err != nil {
+			sugar.Errorf("error reading campaign links: %s", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "error retrieving campaign stats",
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"data": stats,
+		})
+	}
+}
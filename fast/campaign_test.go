@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestValidateCampaign(t *testing.T) {
+	tests := []struct {
+		campaign string
+		valid    bool
+	}{
+		{"", true},
+		{"spring-launch", true},
+		{"Q3_2022.email", true},
+		{strings.Repeat("a", 64), true},
+		{strings.Repeat("a", 65), false},
+		{"spring launch", false},
+		{"launch/2022", false},
+		{"früh", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.campaign, func(t *testing.T) {
+			if err := ValidateCampaign(tt.campaign); (err == nil) != tt.valid {
+				t.Errorf("ValidateCampaign(%q) = %v, want valid %t", tt.campaign, err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestCampaignStatsHandler(t *testing.T) {
+	tests := []struct {
+		name   string
+		id     string
+		key    APIKey
+		links  int64
+		status int
+		owner  string
+	}{
+		{"anonymous", "spring-launch", APIKey{}, 2, http.StatusUnauthorized, ""},
+		{"owner", "spring-launch", testOwnerKey, 2, http.StatusOK, testOwnerKey.ID},
+		{"admin", "spring-launch", testAdminKey, 2, http.StatusOK, ""},
+		{"no links", "spring-launch", testOtherKey, 0, http.StatusNotFound, testOtherKey.ID},
+		{"bad id", "spring launch", testOwnerKey, 2, http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := (&fakeDB{}).
+				on("WITH links AS", fakeResult{rows: [][]interface{}{{tt.links, int64(12), int64(4)}}}).
+				on("ORDER BY hits DESC", fakeResult{rows: [][]interface{}{{"launch", int64(10)}, {"promo", int64(2)}}})
+			r := testRouter()
+			r.GET("/api/v1/campaigns/:id/stats", requireAPIKey, campaignStatsHandler(context.Background(), fake, testSugar))
+			w := serve(r, http.MethodGet, "/api/v1/campaigns/"+strings.ReplaceAll(tt.id, " ", "%20")+"/stats", tt.key, "")
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status == http.StatusUnauthorized || tt.status == http.StatusBadRequest {
+				return
+			}
+
+			if args := fake.statements("WITH links AS")[0].args; args[0] != tt.id || args[1] != tt.owner {
+				t.Errorf("counted campaign %v of owner %q, want %s of %q", args[0], args[1], tt.id, tt.owner)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			var body struct {
+				Data CampaignStats `json:"data"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("couldn't decode %s: %s", w.Body, err)
+			}
+			stats := body.Data
+			if stats.Campaign != tt.id || stats.Links != 2 || stats.Clicks != 12 || stats.ClicksLast24Hours != 4 {
+				t.Errorf("stats = %+v, want 2 links, 12 clicks and 4 in the last day", stats)
+			}
+			if len(stats.TopLinks) != 2 || stats.TopLinks[0] != (LinkHits{URI: "launch", Hits: 10}) {
+				t.Errorf("top links = %+v, want launch first", stats.TopLinks)
+			}
+			if args := fake.statements("ORDER BY hits DESC")[0].args; args[1] != tt.owner {
+				t.Errorf("listed the links of owner %q, want %q", args[1], tt.owner)
+			}
+		})
+	}
+}
+
+func TestLinkCreatorCampaign(t *testing.T) {
+	tests := []struct {
+		name     string
+		campaign string
+		status   int
+	}{
+		{"no campaign", "", 0},
+		{"campaign", "spring-launch", 0},
+		{"bad campaign", "spring launch", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := (&fakeDB{}).onFunc("INSERT INTO urls", insertedLinks)
+			_, err := testCreator(fake).create(context.Background(), ShortenURLRequest{URL: "https://example.com/a", Campaign: tt.campaign}, creation{Key: testOwnerKey}, testSugar)
+			if tt.status != 0 {
+				var cerr *creationError
+				if !errors.As(err, &cerr) || cerr.Status != tt.status {
+					t.Fatalf("create() = %v, want a %d", err, tt.status)
+				}
+				if inserts := fake.statements("INSERT INTO urls"); len(inserts) != 0 {
+					t.Errorf("inserted %d links with a bad campaign", len(inserts))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("create() = %v", err)
+			}
+			if got := fake.statements("INSERT INTO urls")[0].args[21]; got != tt.campaign {
+				t.Errorf("stored campaign %v, want %q", got, tt.campaign)
+			}
+		})
+	}
+}
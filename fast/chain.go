@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// errRedirectLoop a destination's redirects led back to one of our hosts
+var errRedirectLoop = errors.New("redirects back to a short link")
+
+// defaultBlockedShorteners public shorteners we won't point a link at
+// unless configured otherwise, to avoid redirect chains
+var defaultBlockedShorteners = []string{
+	"bit.ly",
+	"tinyurl.com",
+	"t.co",
+	"goo.gl",
+	"ow.ly",
+	"is.gd",
+	"buff.ly",
+	"rebrand.ly",
+}
+
+// checkShortenerChain refuse destinations that are themselves short links,
+// either ours or from a blocked shortener, so links can't be chained. Hosts
+// match on the domain or any subdomain of it
+func checkShortenerChain(destination, ownHost string, blocked []string) error {
+	parsed, err := url.Parse(destination)
+	if err != nil {
+		return fmt.Errorf("couldn't parse url: %s", err)
+	}
+	host := strings.TrimSuffix(strings.ToLower(parsed.Hostname()), ".")
+
+	if hostMatches(host, strings.ToLower(ownHost)) {
+		return fmt.Errorf("%s is already a short link", destination)
+	}
+	for _, shortener := range blocked {
+		if hostMatches(host, strings.ToLower(shortener)) {
+			return fmt.Errorf("links to the shortener %s aren't allowed", shortener)
+		}
+	}
+
+	return nil
+}
+
+// checkRedirectLoop follow the destination's redirects and refuse it when
+// they lead back to our host or a vanity domain, which would make the new
+// link part of a loop through another shortener. Destinations that can't
+// be fetched aren't refused, that is what verify_on_create is for
+func checkRedirectLoop(ctx context.Context, client *http.Client, destination, ownHost string, vanityHosts map[string]bool) error {
+	ours := func(u *url.URL) bool {
+		host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+		return hostMatches(host, strings.ToLower(ownHost)) || vanityHosts[host]
+	}
+	loopClient := *client
+	loopClient.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if ours(req.URL) {
+			return errRedirectLoop
+		}
+		if client.CheckRedirect != nil {
+			return client.CheckRedirect(req, via)
+		}
+		return nil
+	}
+
+	if _, err := unwrapURL(ctx, &loopClient, destination); errors.Is(err, errRedirectLoop) {
+		return fmt.Errorf("%s %w", destination, errRedirectLoop)
+	}
+	return nil
+}
+
+// hostMatches whether host is the domain or a subdomain of it
+func hostMatches(host, domain string) bool {
+	if domain == "" {
+		return false
+	}
+	// the configured host may carry a port
+	if i := strings.LastIndex(domain, ":"); i >= 0 && !strings.Contains(domain, "]") {
+		domain = domain[:i]
+	}
+
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckShortenerChain(t *testing.T) {
+	tests := []struct {
+		destination string
+		ownHost     string
+		wantErr     bool
+	}{
+		{"https://example.com/a", "fa.st", false},
+		{"https://fa.st/abc", "fa.st", true},
+		{"https://FA.ST./abc", "fa.st", true},
+		{"https://links.fa.st/abc", "fa.st:8080", true},
+		{"https://notfa.st/abc", "fa.st", false},
+		{"https://bit.ly/abc", "fa.st", true},
+		{"https://eu.bit.ly/abc", "fa.st", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.destination, func(t *testing.T) {
+			err := checkShortenerChain(tt.destination, tt.ownHost, []string{"bit.ly"})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkShortenerChain(%q) = %v, want error %t", tt.destination, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckRedirectLoop(t *testing.T) {
+	// another shortener whose links point wherever ?to= says
+	shortener := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if to := r.URL.Query().Get("to"); to != "" {
+			http.Redirect(w, r, to, http.StatusFound)
+		}
+	}))
+	defer shortener.Close()
+	vanity := map[string]bool{"go.initech.example": true}
+
+	tests := []struct {
+		name        string
+		destination string
+		loop        bool
+	}{
+		{"no redirects", shortener.URL + "/page", false},
+		{"redirects elsewhere", shortener.URL + "/a?to=" + shortener.URL + "/page", false},
+		{"back to us", shortener.URL + "/a?to=https://fa.st/abc", true},
+		{"back to us after a hop", shortener.URL + "/a?to=" + shortener.URL + "/b%3Fto%3Dhttps://fa.st/abc", true},
+		{"back to a vanity domain", shortener.URL + "/a?to=https://go.initech.example/abc", true},
+		{"unreachable", "http://127.0.0.1:1/a", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkRedirectLoop(context.Background(), shortener.Client(), tt.destination, "fa.st", vanity)
+			if errors.Is(err, errRedirectLoop) != tt.loop {
+				t.Errorf("checkRedirectLoop(%q) = %v, want a loop %t", tt.destination, err, tt.loop)
+			}
+		})
+	}
+}
+
+func TestLinkCreatorRefusesLoops(t *testing.T) {
+	shortener := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "https://fa.st/abc", http.StatusFound)
+	}))
+	defer shortener.Close()
+
+	fake := (&fakeDB{}).onFunc("INSERT INTO urls", insertedLinks)
+	creator := testCreator(fake)
+	creator.client = shortener.Client()
+	creator.loopCheck = true
+
+	_, err := creator.create(context.Background(), ShortenURLRequest{URL: shortener.URL + "/loop"}, creation{}, testSugar)
+	var cerr *creationError
+	if !errors.As(err, &cerr) || cerr.Status != http.StatusBadRequest {
+		t.Fatalf("create() = %v, want a %d", err, http.StatusBadRequest)
+	}
+	if inserts := fake.statements("INSERT INTO urls"); len(inserts) != 0 {
+		t.Errorf("inserted a looping link")
+	}
+}
+
+func TestLinkCreatorRefusesChains(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		blocked []string
+		status  int
+	}{
+		{"plain destination", "https://example.com/a", defaultBlockedShorteners, 0},
+		{"our own short link", "https://fa.st/abc", nil, http.StatusBadRequest},
+		{"default blocklist", "https://bit.ly/abc", defaultBlockedShorteners, http.StatusBadRequest},
+		{"configured blocklist", "https://lnkd.in/abc", []string{"lnkd.in"}, http.StatusBadRequest},
+		{"not on the configured blocklist", "https://bit.ly/abc", []string{"lnkd.in"}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := (&fakeDB{}).onFunc("INSERT INTO urls", insertedLinks)
+			creator := testCreator(fake)
+			creator.blocked = tt.blocked
+
+			_, err := creator.create(context.Background(), ShortenURLRequest{URL: tt.url}, creation{}, testSugar)
+			if tt.status == 0 {
+				if err != nil {
+					t.Errorf("create() = %v", err)
+				}
+				return
+			}
+			var cerr *creationError
+			if !errors.As(err, &cerr) || cerr.Status != tt.status {
+				t.Fatalf("create() = %v, want a %d", err, tt.status)
+			}
+			if inserts := fake.statements("INSERT INTO urls"); len(inserts) != 0 {
+				t.Errorf("inserted a chained link")
+			}
+		})
+	}
+}
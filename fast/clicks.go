@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/aeekayy/systems/fast/db"
+	"github.com/gin-gonic/gin"
+	pgx "github.com/jackc/pgx/v4"
+	"go.uber.org/zap"
+)
+
+// Click a single redirect of a short link, kept for analytics
+type Click struct {
+	URI     string
+	Referer string
+	Country string
+	Device  string
+}
+
+// recordClick store a click of a short link
+func recordClick(ctx context.Context, dbConn db.Querier, click Click) error {
+	_, err := dbConn.Exec(ctx, "INSERT INTO clicks(uri, referer, country, device) VALUES($1, NULLIF($2, ''), NULLIF($3, ''), NULLIF($4, ''));",
+		click.URI, click.Referer, click.Country, click.Device)
+	return err
+}
+
+// parseTimeRange read the from and to query params as RFC 3339 times. A
+// missing from starts at the beginning of time and a missing to is now
+func parseTimeRange(c *gin.Context) (time.Time, time.Time, error) {
+	from := time.Unix(0, 0).UTC()
+	to := time.Now().UTC()
+
+	if val := c.Query("from"); val != "" {
+		parsed, err := time.Parse(time.RFC3339, val)
+		if err != nil {
+			return from, to, errors.New("from must be an RFC 3339 time")
+		}
+		from = parsed
+	}
+	if val := c.Query("to"); val != "" {
+		parsed, err := time.Parse(time.RFC3339, val)
+		if err != nil {
+			return from, to, errors.New("to must be an RFC 3339 time")
+		}
+		to = parsed
+	}
+	if to.Before(from) {
+		return from, to, errors.New("to must not be before from")
+	}
+
+	return from, to, nil
+}
+
+// clickCountHandler the total clicks of a short link between from and to.
+// This is a single indexed count, cheap enough to call often. Only the owner
+// of the link or an admin key can read it
+func clickCountHandler(ctx context.Context, dbConn db.Querier, caseInsensitive bool, sugar *zap.SugaredLogger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sugar := requestSugar(c, sugar)
+		from, to, err := parseTimeRange(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		key, _ := currentAPIKey(c)
+		owner := key.ID
+		if key.Admin {
+			owner = ""
+		}
+
+		var uri string
+		err = dbConn.QueryRow(ctx, "SELECT uri FROM "+urlsTable+" WHERE "+uriCondition(caseInsensitive)+" AND ($2 = '' OR owner = $2) LIMIT 1;", c.Param("uri"), owner).Scan(&uri)
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "uri not found",
+			})
+			return
+		}
+		if err != nil {
+			sugar.Errorf("error retrieving URI: %s", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "error retrieving URI",
+			})
+			return
+		}
+
+		var count int64
+		err = dbConn.QueryRow(ctx, "SELECT count(*) FROM clicks WHERE uri = $1 AND created >= $2 AND created < $3;", uri, from, to).Scan(&count)
+		if err != nil {
+			sugar.Errorf("error counting clicks: %s", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "error counting clicks",
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"data": gin.H{
+				"uri":    uri,
+				"from":   from,
+				"to":     to,
+				"clicks": count,
+			},
+		})
+	}
+}
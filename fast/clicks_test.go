@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClickCountHandler(t *testing.T) {
+	fake := (&fakeDB{}).
+		onFunc("SELECT uri FROM", ownedLink("launch", testOwnerKey.ID, 1)).
+		onFunc("FROM clicks", func(args []interface{}) fakeResult {
+			if !args[1].(time.Time).Equal(time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)) {
+				return fakeResult{rows: [][]interface{}{{int64(0)}}}
+			}
+			return fakeResult{rows: [][]interface{}{{int64(42)}}}
+		})
+
+	r := testRouter()
+	r.GET("/api/v1/urls/:uri/clicks/count", requireAPIKey, clickCountHandler(context.Background(), fake, false, testSugar))
+
+	tests := []struct {
+		name   string
+		query  string
+		key    APIKey
+		status int
+		clicks string
+	}{
+		{"anonymous", "", APIKey{}, http.StatusUnauthorized, ""},
+		{"another key", "", testOtherKey, http.StatusNotFound, ""},
+		{"owner", "?from=2022-06-01T00:00:00Z", testOwnerKey, http.StatusOK, `"clicks":42`},
+		{"admin", "?from=2022-06-01T00:00:00Z", testAdminKey, http.StatusOK, `"clicks":42`},
+		{"to before from", "?from=2022-06-01T00:00:00Z&to=2022-05-01T00:00:00Z", testOwnerKey, http.StatusBadRequest, ""},
+		{"bad to", "?to=tomorrow", testOwnerKey, http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(r, http.MethodGet, "/api/v1/urls/launch/clicks/count"+tt.query, tt.key, "")
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if !strings.Contains(w.Body.String(), tt.clicks) {
+				t.Errorf("body = %s, want %s", w.Body, tt.clicks)
+			}
+		})
+	}
+}
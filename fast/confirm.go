@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aeekayy/systems/fast/db"
+	"github.com/gin-gonic/gin"
+	pgx "github.com/jackc/pgx/v4"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+const (
+	confirmationTokenBytes     = 16        // Random bytes in a confirmation token
+	defaultConfirmationResends = 3         // Confirmation emails a link may be sent per resend window
+	defaultConfirmationWindow  = time.Hour // How long the resend limit of a link lasts
+)
+
+// ConfirmationConfig the SMTP server confirmation emails are sent through.
+// Without an SMTP host links can still be created with an email but the
+// confirmation email can't be sent. ResendLimit caps the emails sent for
+// each link per ResendWindow so an owner's inbox can't be flooded
+type ConfirmationConfig struct {
+	SMTPHost     string        `mapstructure:"smtp_host" yaml:"smtp_host"`
+	Username     string        `mapstructure:"username" yaml:"username"`
+	Password     string        `mapstructure:"password" yaml:"password"`
+	From         string        `mapstructure:"from" yaml:"from"`
+	ResendLimit  int           `mapstructure:"resend_limit" yaml:"resend_limit"`
+	ResendWindow time.Duration `mapstructure:"resend_window" yaml:"resend_window"`
+}
+
+// Mailer send an email
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// SMTPMailer send emails through an SMTP server
+type SMTPMailer struct {
+	cfg ConfirmationConfig
+}
+
+// loadConfirmationConfig read the confirmation email settings for the environment
+func loadConfirmationConfig(env string) (ConfirmationConfig, error) {
+	cfg := ConfirmationConfig{ResendLimit: defaultConfirmationResends, ResendWindow: defaultConfirmationWindow}
+	if err := viper.UnmarshalKey(fmt.Sprintf("%s.confirmation", env), &cfg); err != nil {
+		return cfg, fmt.Errorf("couldn't read confirmation configuration: %w", err)
+	}
+	if cfg.SMTPHost != "" && cfg.From == "" {
+		return cfg, errors.New("confirmation emails need a from address")
+	}
+	if cfg.ResendLimit < 1 {
+		return cfg, errors.New("confirmation resend_limit must be at least 1")
+	}
+	if cfg.ResendWindow <= 0 {
+		cfg.ResendWindow = defaultConfirmationWindow
+	}
+
+	return cfg, nil
+}
+
+// newMailer the mailer for the configuration, nil when no SMTP host is set
+func newMailer(cfg ConfirmationConfig) Mailer {
+	if cfg.SMTPHost == "" {
+		return nil
+	}
+
+	return SMTPMailer{cfg: cfg}
+}
+
+// Send send a plain text email
+func (m SMTPMailer) Send(to, subject, body string) error {
+	var auth smtp.Auth
+	if m.cfg.Username != "" {
+		host := m.cfg.SMTPHost
+		if i := strings.LastIndex(host, ":"); i >= 0 {
+			host = host[:i]
+		}
+		auth = smtp.PlainAuth("", m.cfg.Username, m.cfg.Password, host)
+	}
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n", m.cfg.From, to, subject, body)
+
+	return smtp.SendMail(m.cfg.SMTPHost, auth, m.cfg.From, []string{to}, []byte(msg))
+}
+
+// ValidateEmail make sure an owner email is a single plain address
+func ValidateEmail(email string) error {
+	if email == "" {
+		return nil
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return errors.New("email must be a plain email address")
+	}
+
+	return nil
+}
+
+// newConfirmationToken a random token for the confirmation link
+func newConfirmationToken() (string, error) {
+	b := make([]byte, confirmationTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("couldn't generate confirmation token: %w", err)
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// sendConfirmationHandler email the owner of an unconfirmed link the link
+// that confirms it. Links don't redirect until they are confirmed. Only the
+// key that created the link or an admin key can ask for the email, and
+// resends counts the emails of each link so they can't be used for spam
+func sendConfirmationHandler(ctx context.Context, dbConn db.Querier, mailer Mailer, resends *RateLimiter, domain LinkDomain, caseInsensitive bool, sugar *zap.SugaredLogger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sugar := requestSugar(c, sugar)
+		if mailer == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "confirmation emails are not configured",
+			})
+			return
+		}
+
+		key, _ := currentAPIKey(c)
+		owner := key.ID
+		if key.Admin {
+			owner = ""
+		}
+
+		var uri, email, token string
+		var confirmed bool
+		err := dbConn.QueryRow(ctx, "SELECT uri, COALESCE(owner_email, ''), confirmed, COALESCE(confirmation_token, '') FROM "+urlsTable+" WHERE "+uriCondition(caseInsensitive)+" AND ($2 = '' OR owner = $2) LIMIT 1;", c.Param("uri"), owner).
+			Scan(&uri, &email, &confirmed, &token)
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "uri not found",
+			})
+			return
+		}
+		if err != nil {
+			sugar.Errorf("error retrieving URI: %s", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "error sending confirmation",
+			})
+			return
+		}
+		if confirmed {
+			c.JSON(http.StatusConflict, gin.H{
+				"error": "link is already confirmed",
+			})
+			return
+		}
+		if email == "" || token == "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "link has no owner email",
+			})
+			return
+		}
+		if allowed, reset := resends.Allow(uri, time.Now()); !allowed {
+			c.Header("Retry-After", strconv.Itoa(int(reset.Seconds())+1))
+			c.JSON(http.StatusTooManyRequests, gin.H{
+				"error": "too many confirmation emails for this link, try again later",
+			})
+			return
+		}
+
+		link := fmt.Sprintf("%s://%s/api/v1/confirm/%s", domain.Scheme, domain.Host, token)
+		body := fmt.Sprintf("Confirm your short link %s by opening %s\n\nIt won't redirect until it is confirmed.", domain.NewShortenURL(uri).ShortenLongURL, link)
+		if err := mailer.Send(email, "Confirm your short link", body); err != nil {
+			sugar.Errorf("error sending confirmation email: %s", err)
+			c.JSON(http.StatusBadGateway, gin.H{
+				"error": "error sending confirmation",
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"data": gin.H{"uri": uri, "sent": true},
+		})
+	}
+}
+
+// confirmHandler confirm the link a confirmation token was issued for and
+// drop it from the redirect cache so it starts redirecting straight away
+func confirmHandler(ctx context.Context, dbConn db.Querier, rc *RedirectCache, caseInsensitive bool, sugar *zap.SugaredLogger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sugar := requestSugar(c, sugar)
+		var uri string
+		err := dbConn.QueryRow(ctx, "UPDATE "+urlsTable+" SET confirmed = true, confirmation_token = NULL WHERE confirmation_token = $1 RETURNING uri;", c.Param("token")).Scan(&uri)
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "unknown confirmation token",
+			})
+			return
+		}
+		if err != nil {
+			sugar.Errorf("error confirming link: %s", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "error confirming link",
+			})
+			return
+		}
+		rc.Delete(cacheKey(uri, caseInsensitive))
+
+		c.JSON(http.StatusOK, gin.H{
+			"data": gin.H{"uri": uri, "confirmed": true},
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeMailer remember the emails it was asked to send
+type fakeMailer struct {
+	mu   sync.Mutex
+	sent []string
+}
+
+func (m *fakeMailer) Send(to, subject, body string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, to+": "+body)
+	return nil
+}
+
+func TestValidateEmail(t *testing.T) {
+	tests := []struct {
+		email string
+		valid bool
+	}{
+		{"", true},
+		{"owner@example.com", true},
+		{"Owner <owner@example.com>", false},
+		{"owner@example.com, other@example.com", false},
+		{"not an address", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.email, func(t *testing.T) {
+			if err := ValidateEmail(tt.email); (err == nil) != tt.valid {
+				t.Errorf("ValidateEmail(%q) = %v, want valid %v", tt.email, err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestSendConfirmationHandler(t *testing.T) {
+	links := map[string][]interface{}{
+		"launch":    {"launch", "owner@example.com", false, "token"},
+		"confirmed": {"confirmed", "owner@example.com", true, ""},
+		"no-email":  {"no-email", "", false, ""},
+	}
+	fake := (&fakeDB{}).onFunc("SELECT uri, COALESCE(owner_email", func(args []interface{}) fakeResult {
+		row, ok := links[args[0].(string)]
+		if !ok || (args[1] != "" && args[1] != testOwnerKey.ID) {
+			return fakeResult{}
+		}
+		return fakeResult{rows: [][]interface{}{row}}
+	})
+	mailer := &fakeMailer{}
+	domain := LinkDomain{Scheme: "https", Host: "fa.st"}
+
+	r := testRouter()
+	r.POST("/api/v1/urls/:uri/confirmation", requireAPIKey, sendConfirmationHandler(context.Background(), fake, mailer, NewRateLimiter(2, time.Hour), domain, false, testSugar))
+
+	tests := []struct {
+		name   string
+		uri    string
+		key    APIKey
+		status int
+		sent   int
+	}{
+		{"anonymous", "launch", APIKey{}, http.StatusUnauthorized, 0},
+		{"another key", "launch", testOtherKey, http.StatusNotFound, 0},
+		{"owner", "launch", testOwnerKey, http.StatusOK, 1},
+		{"admin resend", "launch", testAdminKey, http.StatusOK, 2},
+		{"over the resend limit", "launch", testOwnerKey, http.StatusTooManyRequests, 2},
+		{"already confirmed", "confirmed", testOwnerKey, http.StatusConflict, 2},
+		{"no owner email", "no-email", testOwnerKey, http.StatusBadRequest, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(r, http.MethodPost, "/api/v1/urls/"+tt.uri+"/confirmation", tt.key, "")
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if len(mailer.sent) != tt.sent {
+				t.Errorf("sent %d emails, want %d", len(mailer.sent), tt.sent)
+			}
+			if tt.status == http.StatusTooManyRequests && w.Header().Get("Retry-After") == "" {
+				t.Errorf("no Retry-After header")
+			}
+		})
+	}
+	if !strings.Contains(mailer.sent[0], "https://fa.st/api/v1/confirm/token") {
+		t.Errorf("email = %q, want the confirmation link", mailer.sent[0])
+	}
+}
+
+func TestConfirmHandler(t *testing.T) {
+	fake := (&fakeDB{}).onFunc("SET confirmed = true", func(args []interface{}) fakeResult {
+		if args[0] != "token" {
+			return fakeResult{}
+		}
+		return fakeResult{rows: [][]interface{}{{"launch"}}}
+	})
+	rc := NewRedirectCache(10)
+	rc.Set(cacheKey("launch", false), RedirectLink{URI: "launch"}, time.Hour)
+
+	r := testRouter()
+	r.GET("/api/v1/confirm/:token", confirmHandler(context.Background(), fake, rc, false, testSugar))
+
+	if w := serve(r, http.MethodGet, "/api/v1/confirm/wrong", APIKey{}, ""); w.Code != http.StatusNotFound {
+		t.Errorf("unknown token status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if _, ok := rc.Get(cacheKey("launch", false)); !ok {
+		t.Fatalf("an unknown token dropped the link from the cache")
+	}
+	if w := serve(r, http.MethodGet, "/api/v1/confirm/token", APIKey{}, ""); w.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if _, ok := rc.Get(cacheKey("launch", false)); ok {
+		t.Errorf("the confirmed link is still cached")
+	}
+}
@@ -0,0 +1,475 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/aeekayy/systems/fast/db"
+	"github.com/gin-gonic/gin"
+	pgx "github.com/jackc/pgx/v4"
+	"go.uber.org/zap"
+)
+
+// linkCreator create short links. The shorten endpoint, alias claims, the
+// gRPC server and imports all go through it, so however a link is made it
+// is normalized, checked and stored the same way
+type linkCreator struct {
+	dbConn          db.Querier
+	domain          LinkDomain
+	enabled         func() bool
+	signingSecret   []byte
+	rewrites        []RewriteRule
+	idn             IDNConfig
+	blocked         []string
+	reserved        map[string]ReservedHandler
+	aliasMinLength  int
+	namespaces      bool
+	caseInsensitive bool
+	strictAlias     StrictAliasConfig
+	uriStrategy     string
+	obfuscator      IDObfuscator
+	maxAttempts     int
+	maxTTL          time.Duration
+	rawJSONMaxBytes int
+	idempotencyTTL  time.Duration
+	compareBody     bool
+	verify          string
+	loopCheck       bool
+	vanityHosts     map[string]bool
+	client          *http.Client
+}
+
+// creation who a link is created for. Key is the API key of the request,
+// empty for anonymous ones. IdempotencyKey makes a retried request get the
+// link the first one created
+type creation struct {
+	Key            APIKey
+	IdempotencyKey string
+	Referer        string
+}
+
+// creationError why a link couldn't be created, with the status and error
+// code it is reported with
+type creationError struct {
+	Status int
+	Code   string
+	Msg    string
+}
+
+func (e *creationError) Error() string {
+	return e.Msg
+}
+
+// errCreatingLink the error for failures that aren't the client's fault,
+// which are logged rather than reported
+var errCreatingLink = &creationError{Status: http.StatusInternalServerError, Msg: "error creating URL"}
+
+// invalidLink a request that can't become a link
+func invalidLink(err error) *creationError {
+	return &creationError{Status: http.StatusBadRequest, Msg: fmt.Sprintf("error creating URL: %s", err)}
+}
+
+// destinationsOf every url a request can redirect to, the primary one first
+func destinationsOf(req *ShortenURLRequest) []*string {
+	destinations := []*string{&req.URL}
+	for i := range req.Destinations {
+		destinations = append(destinations, &req.Destinations[i].URL)
+	}
+	for i := range req.Rules {
+		destinations = append(destinations, &req.Rules[i].URL)
+	}
+	return destinations
+}
+
+// normalize a destination the way it is stored and checked: rewritten by
+// the operator's rules, with an internationalized host as punycode and
+// lookalikes refused
+func (lc *linkCreator) normalize(destination string) (string, error) {
+	return normalizeDestination(lc.idn, rewriteDestination(lc.rewrites, destination))
+}
+
+// checkDestination refuse destinations that are other short links, ours,
+// a blocked shortener's or the vanity domain the link lives under, since
+// those only build redirect chains
+func (lc *linkCreator) checkDestination(destination string, domain LinkDomain) error {
+	if err := checkShortenerChain(destination, lc.domain.Host, lc.blocked); err != nil {
+		return err
+	}
+	return checkShortenerChain(destination, domain.Host, nil)
+}
+
+// check validate a normalized request without touching the database
+func (lc *linkCreator) check(req ShortenURLRequest, domain LinkDomain) error {
+	if _, err := GenerateURL(req.URL, domain); err != nil {
+		return err
+	}
+	for _, destination := range destinationsOf(&req) {
+		if err := lc.checkDestination(*destination, domain); err != nil {
+			return err
+		}
+	}
+	if err := ValidateRedirectType(req.RedirectType); err != nil {
+		return err
+	}
+	if err := ValidateSource(req.Source); err != nil {
+		return err
+	}
+	if err := ValidateCampaign(req.Campaign); err != nil {
+		return err
+	}
+	if err := ValidateEmail(req.Email); err != nil {
+		return err
+	}
+	if err := ValidateRules(req.Rules); err != nil {
+		return err
+	}
+	if err := ValidateDestinations(req.Destinations); err != nil {
+		return err
+	}
+	if req.Signed && len(lc.signingSecret) == 0 {
+		return errors.New("signed links aren't configured")
+	}
+	return nil
+}
+
+// checkAlias make sure a custom alias can be given to actor: it follows the
+// alias rules, isn't taken or held by someone else and isn't too similar to
+// an existing uri when strict aliases are on
+func (lc *linkCreator) checkAlias(ctx context.Context, alias, actor string, sugar *zap.SugaredLogger) *creationError {
+	if err := ValidateAlias(alias, lc.aliasMinLength, lc.reserved, lc.namespaces); err != nil {
+		return invalidLink(err)
+	}
+
+	var taken bool
+	if err := lc.dbConn.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM "+urlsTable+" WHERE "+uriCondition(lc.caseInsensitive)+");", alias).Scan(&taken); err != nil {
+		sugar.Errorf("error checking alias: %s", err)
+		return errCreatingLink
+	}
+	if taken {
+		return &creationError{Status: http.StatusConflict, Msg: "alias is already in use"}
+	}
+
+	holder, err := aliasHolder(ctx, lc.dbConn, alias, lc.caseInsensitive)
+	if err != nil {
+		sugar.Errorf("error checking alias hold: %s", err)
+		return errCreatingLink
+	}
+	if holder != "" && holder != actor {
+		return &creationError{Status: http.StatusConflict, Msg: "alias is held by someone else", Code: aliasHeldCode}
+	}
+
+	similar, err := similarAlias(ctx, lc.dbConn, lc.strictAlias, alias)
+	if err != nil {
+		sugar.Errorf("error checking alias: %s", err)
+		return errCreatingLink
+	}
+	if similar != "" {
+		return invalidLink(fmt.Errorf("alias is too similar to %s", similar))
+	}
+	return nil
+}
+
+// nextURI the uri to try for a link without a custom alias. The first
+// attempt of the random strategy is already drawn by GenerateURL, so later
+// attempts and the other strategies generate one here
+func (lc *linkCreator) nextURI(ctx context.Context, attempt int, generated, slug string, sugar *zap.SugaredLogger) (string, *creationError) {
+	uri := generated
+	if attempt > 1 || lc.uriStrategy != uriStrategyRandom {
+		var err error
+		uri, err = generateURI(ctx, lc.dbConn, lc.uriStrategy, lc.obfuscator, slug)
+		if err != nil {
+			sugar.Errorf("error generating uri: %s", err)
+			return "", errCreatingLink
+		}
+	}
+	// generated uris are checked like aliases so a misconfiguration can't
+	// create links nobody can follow
+	if uri == "" {
+		sugar.Errorf("the %s uri strategy generated an empty uri, check the uri configuration", lc.uriStrategy)
+		return "", &creationError{Status: http.StatusInternalServerError, Msg: "uri generation is misconfigured", Code: uriMisconfiguredCode}
+	}
+	return uri, nil
+}
+
+// errURISpaceExhausted every attempt at a uri collided
+func (lc *linkCreator) errURISpaceExhausted(sugar *zap.SugaredLogger) *creationError {
+	// every attempt collided, so the uri space is close to full
+	sugar.Errorf("couldn't generate a unique uri after %d attempts, the %s uri space may be saturated and uris may need to be longer", lc.maxAttempts, lc.uriStrategy)
+	return &creationError{Status: http.StatusServiceUnavailable, Msg: "couldn't generate a unique uri, try again later", Code: uriSpaceExhaustedCode}
+}
+
+// create make a link for the request
+func (lc *linkCreator) create(ctx context.Context, req ShortenURLRequest, cr creation, sugar *zap.SugaredLogger) (*ShortenURL, error) {
+	// operators can stop new links during incidents while redirects keep working
+	if lc.enabled != nil && !lc.enabled() {
+		return nil, &creationError{Status: http.StatusServiceUnavailable, Msg: "url creation is disabled"}
+	}
+
+	// taken before anything in the request is rewritten
+	bodyHash := requestHash(req)
+
+	for _, destination := range destinationsOf(&req) {
+		normalized, err := lc.normalize(*destination)
+		if err != nil {
+			return nil, invalidLink(err)
+		}
+		*destination = normalized
+	}
+
+	// links created with a key that has a vanity domain live under it
+	domain := keyDomain(cr.Key, lc.domain)
+	var vanityDomain string
+	if domain.Host != lc.domain.Host {
+		vanityDomain = domain.Host
+	}
+
+	// a retried request with the same idempotency key gets the short URL
+	// that was created the first time instead of a duplicate link. Keys
+	// are per API key, so two clients can't see each other's links
+	if cr.IdempotencyKey != "" {
+		existing, err := lc.idempotentLink(ctx, cr.Key.ID, cr.IdempotencyKey, bodyHash, sugar)
+		if existing != nil || err != nil {
+			return existing, err
+		}
+		// keys are unique per owner, so one past its ttl is let go
+		if err := lc.expireIdempotencyKey(ctx, cr.Key.ID, cr.IdempotencyKey); err != nil {
+			sugar.Errorf("error expiring idempotency key: %s", err)
+			return nil, errCreatingLink
+		}
+	}
+
+	generatedURL, err := GenerateURL(req.URL, domain)
+	if err != nil {
+		return nil, invalidLink(err)
+	}
+	if err := lc.check(req, domain); err != nil {
+		return nil, invalidLink(err)
+	}
+	redirectType := req.RedirectType
+	if redirectType == "" {
+		redirectType = redirectPermanent
+	}
+
+	expires, err := linkExpiry(req.TTL, req.TTLSeconds, lc.maxTTL, time.Now())
+	if err != nil {
+		return nil, invalidLink(err)
+	}
+
+	var cacheTTL *int64
+	if req.CacheTTL != nil {
+		seconds := int64(time.Duration(*req.CacheTTL) / time.Second)
+		if seconds <= 0 || seconds > math.MaxInt32 {
+			return nil, invalidLink(errors.New("cache_ttl must be at least a second"))
+		}
+		cacheTTL = &seconds
+	}
+
+	// links with an owner email wait for the owner to confirm them
+	var confirmationToken *string
+	if req.Email != "" {
+		token, err := newConfirmationToken()
+		if err != nil {
+			sugar.Errorf("error creating URL: %s", err)
+			return nil, errCreatingLink
+		}
+		confirmationToken = &token
+	}
+
+	// a destination redirecting back to us through another shortener would
+	// loop once the link exists
+	if lc.loopCheck {
+		for _, destination := range destinationsOf(&req) {
+			if err := checkRedirectLoop(ctx, lc.client, *destination, lc.domain.Host, lc.vanityHosts); err != nil {
+				return nil, invalidLink(err)
+			}
+		}
+	}
+
+	var probe *ProbeResult
+	if lc.verify != verifyOff {
+		result := probeURL(ctx, lc.client, req.URL)
+		if !result.Reachable && lc.verify == verifyReject {
+			return nil, invalidLink(fmt.Errorf("destination is unreachable: %s", result.Error))
+		}
+		probe = &result
+	}
+
+	if req.Alias != "" {
+		if err := lc.checkAlias(ctx, req.Alias, cr.Key.ID, sugar); err != nil {
+			return nil, err
+		}
+		generatedURL = domain.NewShortenURL(req.Alias)
+	}
+
+	details := URLJSON{}
+	details.Referer = cr.Referer
+	details.Agent = "test"
+	details = details.Truncate(lc.rawJSONMaxBytes)
+
+	var key, keyHash *string
+	if cr.IdempotencyKey != "" {
+		key, keyHash = &cr.IdempotencyKey, &bodyHash
+	}
+
+	// two requests can pass the alias check or generate the same uri at
+	// once, so the insert itself skips conflicting rows and we try again
+	// with a new uri, or give up for a custom alias
+	inserted := false
+	// the insert returns what the response needs so it doesn't take
+	// another round trip
+	var created time.Time
+	uri := generatedURL.URI
+	for attempt := 1; attempt <= lc.maxAttempts && !inserted; attempt++ {
+		if req.Alias == "" {
+			var cerr *creationError
+			uri, cerr = lc.nextURI(ctx, attempt, generatedURL.URI, slugFor(req.URL, req.Title), sugar)
+			if cerr != nil {
+				return nil, cerr
+			}
+			if isReserved(uri, lc.reserved) {
+				continue
+			}
+		}
+
+		var insertedURI string
+		err = lc.dbConn.QueryRow(ctx, "INSERT INTO "+urlsTable+"(original_url, uri, raw_json, idempotency_key, destinations, rules, title, description, lookup_uri, interstitial, source, redirect_type, owner_email, confirmed, confirmation_token, expires, cache_ttl, domain, owner, signed, idempotency_hash, campaign) VALUES($1, $2, $3, $4, $5, $6, NULLIF($7, ''), NULLIF($8, ''), $9, $10, NULLIF($11, ''), $12, NULLIF($13, ''), $14, $15, $16, $17, NULLIF($18, ''), NULLIF($19, ''), $20, $21, NULLIF($22, '')) ON CONFLICT DO NOTHING RETURNING uri, created;", req.URL, uri, details, key, req.Destinations, req.Rules, req.Title, req.Description, lookupURI(uri, lc.caseInsensitive), req.Interstitial, req.Source, redirectType, req.Email, confirmationToken == nil, confirmationToken, expires, cacheTTL, vanityDomain, cr.Key.ID, req.Signed, keyHash, req.Campaign).Scan(&insertedURI, &created)
+		switch {
+		case err == nil:
+			inserted = true
+		case !errors.Is(err, pgx.ErrNoRows):
+			sugar.Errorf("error creating URL: %w", err)
+			return nil, invalidLink(err)
+		case cr.IdempotencyKey != "":
+			// a request with the same key may have won the race, its
+			// link is the answer to ours too
+			existing, err := lc.idempotentLink(ctx, cr.Key.ID, cr.IdempotencyKey, bodyHash, sugar)
+			if existing != nil || err != nil {
+				return existing, err
+			}
+			if req.Alias != "" {
+				return nil, &creationError{Status: http.StatusConflict, Msg: "alias is already in use"}
+			}
+		case req.Alias != "":
+			return nil, &creationError{Status: http.StatusConflict, Msg: "alias is already in use"}
+		}
+	}
+	if !inserted {
+		return nil, lc.errURISpaceExhausted(sugar)
+	}
+
+	generatedURL = storedShortURL(lc.domain, vanityDomain, uri, req.Signed, lc.signingSecret)
+	generatedURL.Title = req.Title
+	generatedURL.Description = req.Description
+	generatedURL.Unconfirmed = confirmationToken != nil
+	generatedURL.Expires = expires
+	generatedURL.Probe = probe
+	generatedURL.OriginalURL = req.URL
+	generatedURL.Created = &created
+
+	if err := recordAudit(ctx, lc.dbConn, auditActionCreate, generatedURL.URI, cr.Key.ID); err != nil {
+		sugar.Errorf("error writing audit log: %s", err)
+	}
+	if req.Alias != "" {
+		if err := releaseHold(ctx, lc.dbConn, req.Alias, lc.caseInsensitive); err != nil {
+			sugar.Errorf("error releasing alias hold: %s", err)
+		}
+	}
+
+	return generatedURL, nil
+}
+
+// idempotentLink the link an earlier request of owner with the same
+// idempotency key created, nil when there is none within the ttl. It has
+// the same fields the first response had, so a retry can't be told apart
+func (lc *linkCreator) idempotentLink(ctx context.Context, owner, idempotencyKey, bodyHash string, sugar *zap.SugaredLogger) (*ShortenURL, error) {
+	var existingURI, existingTitle, existingDescription, existingHost, existingOriginalURL string
+	var existingSigned, existingConfirmed bool
+	var existingCreated time.Time
+	var existingExpires *time.Time
+	var existingHash *string
+	err := lc.dbConn.QueryRow(ctx, "SELECT uri, COALESCE(title, ''), COALESCE(description, ''), COALESCE(domain, ''), signed, created, idempotency_hash, original_url, expires, confirmed FROM "+urlsTable+" WHERE COALESCE(owner, '') = $1 AND idempotency_key = $2 AND created > $3 LIMIT 1;", owner, idempotencyKey, time.Now().Add(-lc.idempotencyTTL)).Scan(&existingURI, &existingTitle, &existingDescription, &existingHost, &existingSigned, &existingCreated, &existingHash, &existingOriginalURL, &existingExpires, &existingConfirmed)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		sugar.Errorf("error retrieving idempotency key: %s", err)
+		return nil, errCreatingLink
+	}
+	// links from before hashes were stored can't be compared
+	if lc.compareBody && existingHash != nil && *existingHash != bodyHash {
+		return nil, &creationError{Status: http.StatusUnprocessableEntity, Msg: "idempotency key was already used for a different request", Code: idempotencyMismatchCode}
+	}
+
+	existingURL := storedShortURL(lc.domain, existingHost, existingURI, existingSigned, lc.signingSecret)
+	existingURL.Title = existingTitle
+	existingURL.Description = existingDescription
+	existingURL.Unconfirmed = !existingConfirmed
+	existingURL.Expires = existingExpires
+	existingURL.OriginalURL = existingOriginalURL
+	existingURL.Created = &existingCreated
+	return existingURL, nil
+}
+
+// expireIdempotencyKey take an idempotency key of owner off the link it was
+// used for once that is older than the ttl, so the key can be used again
+func (lc *linkCreator) expireIdempotencyKey(ctx context.Context, owner, idempotencyKey string) error {
+	_, err := lc.dbConn.Exec(ctx, "UPDATE "+urlsTable+" SET idempotency_key = NULL WHERE COALESCE(owner, '') = $1 AND idempotency_key = $2 AND created <= $3;", owner, idempotencyKey, time.Now().Add(-lc.idempotencyTTL))
+	return err
+}
+
+// creationFailed report why a link couldn't be created
+func creationFailed(c *gin.Context, err error) {
+	var cerr *creationError
+	if !errors.As(err, &cerr) {
+		cerr = errCreatingLink
+	}
+	body := gin.H{
+		"error": cerr.Msg,
+	}
+	if cerr.Code != "" {
+		body["code"] = cerr.Code
+	}
+	c.JSON(cerr.Status, body)
+}
+
+// shortenHandler create a short link for the request body. Claims of a held
+// alias come through here too, with the alias they hold
+func shortenHandler(creator *linkCreator, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sugar := requestSugar(c, logger.Sugar())
+		var json ShortenURLRequest
+		if err := c.ShouldBindJSON(&json); err != nil {
+			bindError(c, err)
+			return
+		}
+		// a claim creates the link with the alias it holds
+		if claimed := c.GetString(claimedAliasContextKey); claimed != "" {
+			json.Alias = claimed
+		}
+
+		key, _ := currentAPIKey(c)
+		generatedURL, err := creator.create(c.Request.Context(), json, creation{
+			Key:            key,
+			IdempotencyKey: c.GetHeader(idempotencyKeyHeader),
+			Referer:        c.Request.Header.Get("referer"),
+		}, sugar)
+		if err != nil {
+			creationFailed(c, err)
+			return
+		}
+
+		requestLogger(c, logger).Info("created new url",
+			zap.String("uri", generatedURL.URI),
+			zap.String("shorten_url", generatedURL.ShortenLongURL),
+			zap.Int("original_url_length", len(json.URL)),
+			zap.String("client_ip", c.ClientIP()),
+		)
+		c.JSON(http.StatusOK, gin.H{
+			"data": generatedURL,
+		})
+	}
+}
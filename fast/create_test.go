@@ -0,0 +1,426 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCreator a linkCreator over fake with the defaults main uses
+func testCreator(fake *fakeDB) *linkCreator {
+	return &linkCreator{
+		dbConn:          fake,
+		domain:          LinkDomain{Host: "fa.st", Scheme: "https"},
+		aliasMinLength:  3,
+		caseInsensitive: true,
+		uriStrategy:     uriStrategyRandom,
+		maxAttempts:     3,
+		idempotencyTTL:  time.Hour,
+	}
+}
+
+// insertedLinks answer inserts as if every uri was free
+func insertedLinks(args []interface{}) fakeResult {
+	return fakeResult{rows: [][]interface{}{{args[1], time.Now()}}}
+}
+
+func TestLinkCreatorCreate(t *testing.T) {
+	tests := []struct {
+		name   string
+		req    ShortenURLRequest
+		setup  func(*fakeDB)
+		status int
+		uri    string
+	}{
+		{"random uri", ShortenURLRequest{URL: "https://example.com/a"}, nil, 0, ""},
+		{"custom alias", ShortenURLRequest{URL: "https://example.com/a", Alias: "launch"}, nil, 0, "launch"},
+		{"not a url", ShortenURLRequest{URL: "example"}, nil, http.StatusBadRequest, ""},
+		{"own short link", ShortenURLRequest{URL: "https://fa.st/abc"}, nil, http.StatusBadRequest, ""},
+		{"bad redirect type", ShortenURLRequest{URL: "https://example.com/a", RedirectType: "303"}, nil, http.StatusBadRequest, ""},
+		{"alias taken", ShortenURLRequest{URL: "https://example.com/a", Alias: "launch"}, func(f *fakeDB) {
+			f.on("SELECT EXISTS", fakeResult{rows: [][]interface{}{{true}}})
+		}, http.StatusConflict, ""},
+		{"alias held", ShortenURLRequest{URL: "https://example.com/a", Alias: "launch"}, func(f *fakeDB) {
+			f.on("FROM alias_holds", fakeResult{rows: [][]interface{}{{"globex"}}})
+		}, http.StatusConflict, ""},
+		{"alias lost the race", ShortenURLRequest{URL: "https://example.com/a", Alias: "launch"}, func(f *fakeDB) {
+			f.on("INSERT INTO urls", fakeResult{})
+		}, http.StatusConflict, ""},
+		{"uri space exhausted", ShortenURLRequest{URL: "https://example.com/a"}, func(f *fakeDB) {
+			f.on("INSERT INTO urls", fakeResult{})
+		}, http.StatusServiceUnavailable, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeDB{}
+			if tt.setup != nil {
+				tt.setup(fake)
+			}
+			fake.on("SELECT EXISTS", fakeResult{rows: [][]interface{}{{false}}}).onFunc("INSERT INTO urls", insertedLinks)
+
+			link, err := testCreator(fake).create(context.Background(), tt.req, creation{Key: testOwnerKey}, testSugar)
+			if tt.status != 0 {
+				var cerr *creationError
+				if !errors.As(err, &cerr) || cerr.Status != tt.status {
+					t.Fatalf("create() = %v, want a %d", err, tt.status)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("create() = %v", err)
+			}
+			if tt.uri != "" && link.URI != tt.uri {
+				t.Errorf("uri = %s, want %s", link.URI, tt.uri)
+			}
+			if link.ShortenLongURL != "https://fa.st/"+link.URI {
+				t.Errorf("shorten_long_url = %s, want https://fa.st/%s", link.ShortenLongURL, link.URI)
+			}
+			inserts := fake.statements("INSERT INTO urls")
+			if len(inserts) != 1 || inserts[0].args[18] != testOwnerKey.ID {
+				t.Errorf("inserts = %v, want one owned by %s", inserts, testOwnerKey.ID)
+			}
+		})
+	}
+}
+
+func TestLinkCreatorRetriesTakenURIs(t *testing.T) {
+	// another request inserts the uri first, so our insert skips it
+	var attempts int
+	fake := (&fakeDB{}).onFunc("INSERT INTO urls", func(args []interface{}) fakeResult {
+		attempts++
+		if attempts == 1 {
+			return fakeResult{}
+		}
+		return insertedLinks(args)
+	})
+
+	link, err := testCreator(fake).create(context.Background(), ShortenURLRequest{URL: "https://example.com/a"}, creation{}, testSugar)
+	if err != nil {
+		t.Fatalf("create() = %v", err)
+	}
+	inserts := fake.statements("INSERT INTO urls")
+	if len(inserts) != 2 {
+		t.Fatalf("inserted %d times, want a retry after the conflict", len(inserts))
+	}
+	if inserts[0].args[1] == inserts[1].args[1] {
+		t.Errorf("retried with the taken uri %s", inserts[0].args[1])
+	}
+	if link.URI != inserts[1].args[1] {
+		t.Errorf("uri = %s, want the retried %s", link.URI, inserts[1].args[1])
+	}
+}
+
+func TestLinkCreatorIdempotency(t *testing.T) {
+	req := ShortenURLRequest{URL: "https://example.com/a"}
+	stored := func(owner string, hash string) func(args []interface{}) fakeResult {
+		return func(args []interface{}) fakeResult {
+			if args[0] != owner || args[1] != "retry-1" {
+				return fakeResult{}
+			}
+			return fakeResult{rows: [][]interface{}{{"first", "", "", "", false, time.Now(), hash, "https://example.com/a", nil, true}}}
+		}
+	}
+
+	tests := []struct {
+		name   string
+		key    APIKey
+		setup  func(*fakeDB)
+		uri    string
+		status int
+	}{
+		{"retry gets the first link", testOwnerKey, func(f *fakeDB) {
+			f.onFunc("idempotency_key = $2 AND created >", stored(testOwnerKey.ID, requestHash(req)))
+		}, "first", 0},
+		{"another owner's key is separate", testOtherKey, func(f *fakeDB) {
+			f.onFunc("idempotency_key = $2 AND created >", stored(testOwnerKey.ID, requestHash(req)))
+		}, "", 0},
+		{"different request", testOwnerKey, func(f *fakeDB) {
+			f.onFunc("idempotency_key = $2 AND created >", stored(testOwnerKey.ID, "other"))
+		}, "", http.StatusUnprocessableEntity},
+		{"concurrent request won the insert", testOwnerKey, func(f *fakeDB) {
+			// the first lookup misses, the insert loses to the other request
+			var lookups int
+			f.onFunc("idempotency_key = $2 AND created >", func(args []interface{}) fakeResult {
+				lookups++
+				if lookups == 1 {
+					return fakeResult{}
+				}
+				return stored(testOwnerKey.ID, requestHash(req))(args)
+			}).on("INSERT INTO urls", fakeResult{})
+		}, "first", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeDB{}
+			tt.setup(fake)
+			fake.onFunc("INSERT INTO urls", insertedLinks)
+			creator := testCreator(fake)
+			creator.compareBody = true
+
+			link, err := creator.create(context.Background(), req, creation{Key: tt.key, IdempotencyKey: "retry-1"}, testSugar)
+			if tt.status != 0 {
+				var cerr *creationError
+				if !errors.As(err, &cerr) || cerr.Status != tt.status {
+					t.Fatalf("create() = %v, want a %d", err, tt.status)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("create() = %v", err)
+			}
+			if tt.uri != "" && link.URI != tt.uri {
+				t.Errorf("uri = %s, want %s", link.URI, tt.uri)
+			}
+			if tt.uri == "" && link.URI == "first" {
+				t.Error("got the link another owner created with the key")
+			}
+			if link.OriginalURL != req.URL || link.Created == nil {
+				t.Errorf("link = %+v, want the fields of the first response", link)
+			}
+		})
+	}
+}
+
+func TestShortenHandler(t *testing.T) {
+	fake := (&fakeDB{}).onFunc("INSERT INTO urls", insertedLinks)
+	r := testRouter()
+	r.POST("/api/v1/shorten", shortenHandler(testCreator(fake), testSugar.Desugar()))
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"created", `{"url": "https://example.com/a"}`, http.StatusOK},
+		{"malformed", `{"url": `, http.StatusBadRequest},
+		{"rejected", `{"url": "https://fa.st/abc"}`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(r, http.MethodPost, "/api/v1/shorten", testOwnerKey, tt.body)
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+}
+
+func TestLinkCreatorCreationFlag(t *testing.T) {
+	tests := []struct {
+		name    string
+		enabled func() bool
+		status  int
+	}{
+		{"no flag", nil, 0},
+		{"enabled", func() bool { return true }, 0},
+		{"disabled", func() bool { return false }, http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := (&fakeDB{}).onFunc("INSERT INTO urls", insertedLinks)
+			creator := testCreator(fake)
+			creator.enabled = tt.enabled
+
+			_, err := creator.create(context.Background(), ShortenURLRequest{URL: "https://example.com/a"}, creation{}, testSugar)
+			if tt.status == 0 {
+				if err != nil {
+					t.Errorf("create() = %v", err)
+				}
+				return
+			}
+			var cerr *creationError
+			if !errors.As(err, &cerr) || cerr.Status != tt.status {
+				t.Fatalf("create() = %v, want a %d", err, tt.status)
+			}
+			if inserts := fake.statements("INSERT INTO urls"); len(inserts) != 0 {
+				t.Errorf("inserted %d links while creation is disabled", len(inserts))
+			}
+		})
+	}
+}
+
+func TestLinkCreatorStoresTitles(t *testing.T) {
+	tests := []struct {
+		name        string
+		title       string
+		description string
+	}{
+		{"untitled", "", ""},
+		{"title only", "Spring Launch", ""},
+		{"title and description", "Spring Launch", "Everything new this spring"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := (&fakeDB{}).onFunc("INSERT INTO urls", insertedLinks)
+			req := ShortenURLRequest{URL: "https://example.com/a", Title: tt.title, Description: tt.description}
+			link, err := testCreator(fake).create(context.Background(), req, creation{}, testSugar)
+			if err != nil {
+				t.Fatalf("create() = %v", err)
+			}
+			if link.Title != tt.title || link.Description != tt.description {
+				t.Errorf("link = %q, %q, want %q, %q", link.Title, link.Description, tt.title, tt.description)
+			}
+			args := fake.statements("INSERT INTO urls")[0].args
+			if args[6] != tt.title || args[7] != tt.description {
+				t.Errorf("stored %q, %q, want %q, %q", args[6], args[7], tt.title, tt.description)
+			}
+		})
+	}
+}
+
+func TestLinkCreatorExhaustion(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxAttempts int
+	}{
+		{"one attempt", 1},
+		{"default attempts", defaultMaxURIAttempts},
+		{"more attempts", 8},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// every uri collides, as in a saturated namespace
+			fake := (&fakeDB{}).on("INSERT INTO urls", fakeResult{})
+			creator := testCreator(fake)
+			creator.maxAttempts = tt.maxAttempts
+
+			_, err := creator.create(context.Background(), ShortenURLRequest{URL: "https://example.com/a"}, creation{}, testSugar)
+			var cerr *creationError
+			if !errors.As(err, &cerr) || cerr.Status != http.StatusServiceUnavailable || cerr.Code != uriSpaceExhaustedCode {
+				t.Fatalf("create() = %v, want a %d with code %s", err, http.StatusServiceUnavailable, uriSpaceExhaustedCode)
+			}
+			if inserts := fake.statements("INSERT INTO urls"); len(inserts) != tt.maxAttempts {
+				t.Errorf("tried %d uris, want %d", len(inserts), tt.maxAttempts)
+			}
+		})
+	}
+
+	fake := (&fakeDB{}).on("INSERT INTO urls", fakeResult{})
+	r := testRouter()
+	r.Use(problemDetails)
+	r.POST("/api/v1/shorten", shortenHandler(testCreator(fake), testSugar.Desugar()))
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/shorten", strings.NewReader(`{"url": "https://example.com/a"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", problemContentType)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	var problem Problem
+	if err := json.Unmarshal(w.Body.Bytes(), &problem); err != nil || problem.Status != http.StatusServiceUnavailable || problem.Code != uriSpaceExhaustedCode {
+		t.Errorf("problem = %s, want a %d with code %s", w.Body, http.StatusServiceUnavailable, uriSpaceExhaustedCode)
+	}
+}
+
+func TestLinkCreatorGeneratedURIGuards(t *testing.T) {
+	tests := []struct {
+		name     string
+		length   int
+		strategy string
+		title    string
+		status   int
+		code     string
+	}{
+		{"random", defaultURILength, uriStrategyRandom, "", 0, ""},
+		{"zero length", 0, uriStrategyRandom, "", http.StatusInternalServerError, uriMisconfiguredCode},
+		{"always reserved", defaultURILength, uriStrategySlug, "ping", http.StatusServiceUnavailable, uriSpaceExhaustedCode},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Cleanup(func() { uriLength = defaultURILength })
+			uriLength = tt.length
+			fake := (&fakeDB{}).
+				on("SELECT EXISTS(SELECT 1", fakeResult{rows: [][]interface{}{{false, 1}}}).
+				onFunc("INSERT INTO urls", insertedLinks)
+			creator := testCreator(fake)
+			creator.uriStrategy = tt.strategy
+
+			link, err := creator.create(context.Background(), ShortenURLRequest{URL: "https://example.com/", Title: tt.title}, creation{}, testSugar)
+			if tt.status == 0 {
+				if err != nil || len(link.URI) != tt.length {
+					t.Errorf("create() = %v, %v, want a %d character uri", link, err, tt.length)
+				}
+				return
+			}
+			var cerr *creationError
+			if !errors.As(err, &cerr) || cerr.Status != tt.status || cerr.Code != tt.code {
+				t.Fatalf("create() = %v, want a %d with code %s", err, tt.status, tt.code)
+			}
+			if inserts := fake.statements("INSERT INTO urls"); len(inserts) != 0 {
+				t.Errorf("inserted %d links with unusable uris", len(inserts))
+			}
+		})
+	}
+}
+
+func TestLinkCreatorReturnsCreated(t *testing.T) {
+	created := time.Date(2022, 6, 1, 12, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		req     ShortenURLRequest
+		taken   int
+		inserts int
+	}{
+		{"random uri", ShortenURLRequest{URL: "https://example.com/a"}, 0, 1},
+		{"custom alias", ShortenURLRequest{URL: "https://example.com/a", Alias: "launch"}, 0, 1},
+		{"after a taken uri", ShortenURLRequest{URL: "https://example.com/a"}, 1, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts int
+			fake := (&fakeDB{}).
+				on("SELECT EXISTS", fakeResult{rows: [][]interface{}{{false}}}).
+				onFunc("INSERT INTO urls", func(args []interface{}) fakeResult {
+					attempts++
+					if attempts <= tt.taken {
+						return fakeResult{}
+					}
+					return fakeResult{rows: [][]interface{}{{args[1], created}}}
+				})
+
+			link, err := testCreator(fake).create(context.Background(), tt.req, creation{Key: testOwnerKey}, testSugar)
+			if err != nil {
+				t.Fatalf("create() = %v", err)
+			}
+			if link.Created == nil || !link.Created.Equal(created) {
+				t.Errorf("created = %v, want %v from the insert", link.Created, created)
+			}
+			inserts := fake.statements("INSERT INTO urls")
+			if len(inserts) != tt.inserts || !strings.Contains(inserts[0].sql, "RETURNING uri, created") {
+				t.Errorf("ran %d inserts, want %d returning the created time", len(inserts), tt.inserts)
+			}
+			for _, call := range fake.calls {
+				if strings.HasPrefix(call.sql, "SELECT") && strings.Contains(call.sql, "created") {
+					t.Errorf("read the link back after inserting it: %s", call.sql)
+				}
+			}
+		})
+	}
+
+	fake := (&fakeDB{}).onFunc("INSERT INTO urls", func(args []interface{}) fakeResult {
+		return fakeResult{rows: [][]interface{}{{args[1], created}}}
+	})
+	r := testRouter()
+	r.POST("/api/v1/shorten", shortenHandler(testCreator(fake), testSugar.Desugar()))
+	w := serve(r, http.MethodPost, "/api/v1/shorten", testOwnerKey, `{"url": "https://example.com/a"}`)
+	var body struct {
+		Data ShortenURL `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("couldn't decode %s: %s", w.Body, err)
+	}
+	if body.Data.Created == nil || !body.Data.Created.Equal(created) {
+		t.Errorf("response created = %v, want %v: %s", body.Data.Created, created, w.Body)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// csvImportColumns the column names other shorteners export each field
+// under, e.g. YOURLS' keyword,url,title,timestamp,ip,clicks and Bitly's
+// Long URL and Bitlink. Headers are matched case insensitively
+var csvImportColumns = map[string][]string{
+	"url":   {"url", "long_url", "long url", "original_url", "destination"},
+	"code":  {"keyword", "bitlink", "short_code", "short code", "short_url", "short url", "link"},
+	"title": {"title"},
+	"hits":  {"clicks", "hits", "total clicks"},
+}
+
+// csvImportHeader where each field is in an export, -1 when it's missing
+type csvImportHeader map[string]int
+
+// parseCSVImportHeader find our fields among the export's columns. Only a
+// destination column is required
+func parseCSVImportHeader(record []string) (csvImportHeader, error) {
+	header := csvImportHeader{}
+	for field, names := range csvImportColumns {
+		header[field] = -1
+		for i, column := range record {
+			column = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(column, "\ufeff")))
+			for _, name := range names {
+				if column == name && header[field] < 0 {
+					header[field] = i
+				}
+			}
+		}
+	}
+	if header["url"] < 0 {
+		return header, errors.New("the export needs a url or long_url column")
+	}
+
+	return header, nil
+}
+
+// importLink map a row of the export to a link. Short codes are kept where
+// they fit our rules, otherwise the link gets a generated uri
+func (h csvImportHeader) importLink(record []string) (ImportLink, error) {
+	field := func(name string) string {
+		if i := h[name]; i >= 0 && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	link := ImportLink{
+		URL:           field("url"),
+		Title:         field("title"),
+		aliasOptional: true,
+	}
+	// Bitly exports the whole short link, like bit.ly/abc123
+	if code := field("code"); code != "" {
+		link.Alias = code[strings.LastIndex(code, "/")+1:]
+	}
+	if hits := field("hits"); hits != "" {
+		parsed, err := strconv.ParseInt(hits, 10, 64)
+		if err != nil {
+			return link, fmt.Errorf("clicks must be a number, not %q", hits)
+		}
+		link.Hits = parsed
+	}
+
+	return link, nil
+}
+
+// csvImportHandler import links from another shortener's CSV export, sent
+// as a text/csv body. The header row decides which columns are used
+func csvImportHandler(ctx context.Context, importer *linkImporter, cfg ImportConfig, sugar *zap.SugaredLogger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sugar := requestSugar(c, sugar)
+		reader := csv.NewReader(c.Request.Body)
+		reader.FieldsPerRecord = -1
+		reader.TrimLeadingSpace = true
+
+		record, err := reader.Read()
+		if err == io.EOF {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "links are required",
+			})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("couldn't read the export: %s", err),
+			})
+			return
+		}
+		header, err := parseCSVImportHeader(record)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		var links []ImportLink
+		for {
+			record, err := reader.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": fmt.Sprintf("couldn't read the export: %s", err),
+				})
+				return
+			}
+			link, err := header.importLink(record)
+			if err != nil {
+				line, _ := reader.FieldPos(0)
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": fmt.Sprintf("line %d: %s", line, err),
+				})
+				return
+			}
+			links = append(links, link)
+			if len(links) > cfg.MaxLinks {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": fmt.Sprintf("at most %d links can be imported at once", cfg.MaxLinks),
+				})
+				return
+			}
+		}
+		if len(links) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "links are required",
+			})
+			return
+		}
+		if err := cfg.checkAliases(links); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"data": runImport(ctx, importer, cfg, links, actorID(c), sugar),
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseCSVImportHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		record  []string
+		want    csvImportHeader
+		wantErr bool
+	}{
+		{"yourls", []string{"keyword", "url", "title", "timestamp", "ip", "clicks"}, csvImportHeader{"code": 0, "url": 1, "title": 2, "hits": 5}, false},
+		{"bitly", []string{"Bitlink", "Long URL", "Title", "Created", "Total Clicks"}, csvImportHeader{"code": 0, "url": 1, "title": 2, "hits": 4}, false},
+		{"byte order mark", []string{"\ufeffLong URL", " Title "}, csvImportHeader{"code": -1, "url": 0, "title": 1, "hits": -1}, false},
+		{"no destination", []string{"keyword", "title"}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header, err := parseCSVImportHeader(tt.record)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseCSVImportHeader() = %v, want an error %t", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			for field, want := range tt.want {
+				if header[field] != want {
+					t.Errorf("%s is column %d, want %d", field, header[field], want)
+				}
+			}
+		})
+	}
+}
+
+func TestCSVImportLink(t *testing.T) {
+	yourls := csvImportHeader{"code": 0, "url": 1, "title": 2, "hits": 5}
+	bitly := csvImportHeader{"code": 0, "url": 1, "title": 2, "hits": 4}
+	tests := []struct {
+		name    string
+		header  csvImportHeader
+		record  []string
+		want    ImportLink
+		wantErr bool
+	}{
+		{"yourls row", yourls, []string{"launch", "https://example.com/a", "Spring Launch", "2021-03-01 10:00:00", "127.0.0.1", "42"},
+			ImportLink{URL: "https://example.com/a", Alias: "launch", Title: "Spring Launch", Hits: 42, aliasOptional: true}, false},
+		{"bitly row", bitly, []string{"bit.ly/3xYz9", "https://example.com/b", "", "2021-03-01", "7"},
+			ImportLink{URL: "https://example.com/b", Alias: "3xYz9", Hits: 7, aliasOptional: true}, false},
+		{"short row", yourls, []string{"promo", "https://example.com/c"},
+			ImportLink{URL: "https://example.com/c", Alias: "promo", aliasOptional: true}, false},
+		{"clicks not a number", yourls, []string{"launch", "https://example.com/a", "", "", "", "many"}, ImportLink{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			link, err := tt.header.importLink(tt.record)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("importLink() = %v, want an error %t", err, tt.wantErr)
+			}
+			if !tt.wantErr && link != tt.want {
+				t.Errorf("importLink() = %+v, want %+v", link, tt.want)
+			}
+		})
+	}
+}
+
+func TestCSVImportHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		export   string
+		status   int
+		imported int
+		uris     []string
+	}{
+		{"yourls", "keyword,url,title,timestamp,ip,clicks\nlaunch,https://example.com/a,Spring Launch,2021-03-01 10:00:00,127.0.0.1,42\n,https://example.com/b,,2021-03-02 10:00:00,127.0.0.1,0\n", http.StatusOK, 2, []string{"launch", ""}},
+		{"bitly", "Bitlink,Long URL,Title,Created,Total Clicks\nbit.ly/3xYz9,https://example.com/a,,2021-03-01,7\n", http.StatusOK, 1, []string{"3xYz9"}},
+		{"empty", "", http.StatusBadRequest, 0, nil},
+		{"header only", "keyword,url\n", http.StatusBadRequest, 0, nil},
+		{"no destination column", "keyword,title\nlaunch,Spring Launch\n", http.StatusBadRequest, 0, nil},
+		{"aliases at the limit", "keyword,url\none,https://example.com/a\ntwo,https://example.com/b\n,https://example.com/c\n", http.StatusOK, 3, []string{"one", "two", ""}},
+		{"too many aliases", "keyword,url\none,https://example.com/a\ntwo,https://example.com/b\nthree,https://example.com/c\n", http.StatusBadRequest, 0, nil},
+		{"bad clicks", "keyword,url,clicks\nlaunch,https://example.com/a,many\n", http.StatusBadRequest, 0, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := (&fakeDB{}).
+				on("SELECT EXISTS", fakeResult{rows: [][]interface{}{{false}}}).
+				onFunc("INSERT INTO urls", func(args []interface{}) fakeResult {
+					return fakeResult{rows: [][]interface{}{{args[1]}}}
+				})
+			cfg := ImportConfig{Concurrency: 1, MaxLinks: 10, MaxAliases: 2}
+			r := testRouter()
+			r.Use(requireJSON)
+			r.POST("/api/v1/admin/import/csv", requireAdmin, csvImportHandler(context.Background(), &linkImporter{creator: testCreator(fake)}, cfg, testSugar))
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/import/csv", strings.NewReader(tt.export))
+			req.Header.Set("Content-Type", "text/csv")
+			req.Header.Set(apiKeyHeader, testAdminKey.Key)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			var body struct {
+				Data ImportSummary `json:"data"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("couldn't decode %s: %s", w.Body, err)
+			}
+			if body.Data.Imported != tt.imported {
+				t.Fatalf("imported %d, want %d: %+v", body.Data.Imported, tt.imported, body.Data.Results)
+			}
+			for i, uri := range tt.uris {
+				if got := body.Data.Results[i].URI; (uri != "" && got != uri) || got == "" {
+					t.Errorf("link %d has uri %q, want %q", i, got, uri)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgconn"
+	pgx "github.com/jackc/pgx/v4"
+)
+
+// ErrCircuitOpen the database is failing and queries aren't sent to it
+// until the breaker's cooldown has passed
+var ErrCircuitOpen = errors.New("database circuit breaker is open")
+
+// Breaker stop sending queries to a database that keeps failing so
+// requests fail fast instead of each waiting on it. After failures
+// consecutive transient errors or timeouts it opens for cooldown, then lets a single
+// query through to probe for recovery: success closes it again, another
+// failure reopens it. A zero failures threshold never opens
+type Breaker struct {
+	querier  Querier
+	failures int
+	cooldown time.Duration
+
+	mu          sync.Mutex
+	consecutive int
+	openUntil   time.Time
+	probing     bool
+}
+
+// NewBreaker wrap a querier in a circuit breaker
+func NewBreaker(querier Querier, failures int, cooldown time.Duration) *Breaker {
+	return &Breaker{querier: querier, failures: failures, cooldown: cooldown}
+}
+
+// Open whether queries are currently refused, for failing requests before
+// they get as far as the database
+func (b *Breaker) Open() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return time.Now().Before(b.openUntil) || b.probing
+}
+
+// allow whether a query may go to the database, and whether it's the
+// probe. Once the cooldown has passed the first caller becomes the probe
+// and everyone else is refused until it reports back
+func (b *Breaker) allow() (bool, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.openUntil.IsZero() {
+		return true, false
+	}
+	if time.Now().Before(b.openUntil) || b.probing {
+		return false, false
+	}
+	b.probing = true
+	return true, true
+}
+
+// breakerFailure whether an error counts towards opening the breaker. A
+// query running out of time isn't worth retrying, but a database where
+// every query does is down all the same
+func breakerFailure(err error) bool {
+	return IsTransient(err) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// done record the outcome of a query
+func (b *Breaker) done(err error, probe bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if probe {
+		b.probing = false
+	}
+	if !breakerFailure(err) {
+		b.consecutive = 0
+		b.openUntil = time.Time{}
+		return
+	}
+
+	b.consecutive++
+	if b.failures > 0 && b.consecutive >= b.failures {
+		b.openUntil = time.Now().Add(b.cooldown)
+	}
+}
+
+// Exec run a statement unless the breaker is open
+func (b *Breaker) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	allowed, probe := b.allow()
+	if !allowed {
+		return nil, ErrCircuitOpen
+	}
+	tag, err := b.querier.Exec(ctx, sql, args...)
+	b.done(err, probe)
+	return tag, err
+}
+
+// Query run a query unless the breaker is open
+func (b *Breaker) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	allowed, probe := b.allow()
+	if !allowed {
+		return nil, ErrCircuitOpen
+	}
+	rows, err := b.querier.Query(ctx, sql, args...)
+	b.done(err, probe)
+	return rows, err
+}
+
+// QueryRow run a single row query unless the breaker is open
+func (b *Breaker) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	return &breakerRow{breaker: b, ctx: ctx, sql: sql, args: args}
+}
+
+// breakerRow defer the query until Scan, where pgx reports its errors
+type breakerRow struct {
+	breaker *Breaker
+	ctx     context.Context
+	sql     string
+	args    []interface{}
+}
+
+// Scan run the query and scan the row unless the breaker is open
+func (row *breakerRow) Scan(dest ...interface{}) error {
+	b := row.breaker
+	allowed, probe := b.allow()
+	if !allowed {
+		return ErrCircuitOpen
+	}
+	err := b.querier.QueryRow(row.ctx, row.sql, row.args...).Scan(dest...)
+	b.done(err, probe)
+	return err
+}
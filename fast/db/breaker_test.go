@@ -0,0 +1,100 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	pgx "github.com/jackc/pgx/v4"
+)
+
+func TestBreaker(t *testing.T) {
+	const cooldown = 20 * time.Millisecond
+	tests := []struct {
+		name     string
+		failures int
+		errs     []error
+		open     bool
+	}{
+		{"healthy", 3, nil, false},
+		{"under the threshold", 3, []error{io.EOF, io.EOF}, false},
+		{"at the threshold", 3, []error{io.EOF, io.EOF, io.EOF}, true},
+		{"success resets the count", 3, []error{io.EOF, io.EOF, nil, io.EOF, io.EOF}, false},
+		{"permanent errors don't count", 3, []error{pgx.ErrNoRows, pgx.ErrNoRows, pgx.ErrNoRows}, false},
+		{"timeouts", 3, []error{context.DeadlineExceeded, context.DeadlineExceeded, context.DeadlineExceeded}, true},
+		{"wrapped timeouts", 3, []error{fmt.Errorf("query: %w", context.DeadlineExceeded), io.EOF, context.DeadlineExceeded}, true},
+		{"cancelled requests don't count", 3, []error{context.Canceled, context.Canceled, context.Canceled}, false},
+		{"no threshold never opens", 0, []error{io.EOF, io.EOF, io.EOF, io.EOF}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := &stubQuerier{errs: append([]error(nil), tt.errs...)}
+			b := NewBreaker(stub, tt.failures, cooldown)
+			for range tt.errs {
+				b.QueryRow(context.Background(), "SELECT 1").Scan()
+			}
+			if b.Open() != tt.open {
+				t.Fatalf("Open() = %t, want %t", b.Open(), tt.open)
+			}
+			if !tt.open {
+				return
+			}
+
+			calls := stub.calls
+			if err := b.QueryRow(context.Background(), "SELECT 1").Scan(); !errors.Is(err, ErrCircuitOpen) {
+				t.Errorf("Scan() = %v, want %v", err, ErrCircuitOpen)
+			}
+			if _, err := b.Query(context.Background(), "SELECT 1"); !errors.Is(err, ErrCircuitOpen) {
+				t.Errorf("Query() = %v, want %v", err, ErrCircuitOpen)
+			}
+			if _, err := b.Exec(context.Background(), "SELECT 1"); !errors.Is(err, ErrCircuitOpen) {
+				t.Errorf("Exec() = %v, want %v", err, ErrCircuitOpen)
+			}
+			if stub.calls != calls {
+				t.Errorf("an open breaker sent %d queries", stub.calls-calls)
+			}
+		})
+	}
+}
+
+func TestBreakerProbe(t *testing.T) {
+	const cooldown = 20 * time.Millisecond
+	tests := []struct {
+		name  string
+		probe error
+		open  bool
+	}{
+		{"recovered", nil, false},
+		{"still failing", io.EOF, true},
+		{"still timing out", context.DeadlineExceeded, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := &stubQuerier{errs: []error{io.EOF, tt.probe}}
+			b := NewBreaker(stub, 1, cooldown)
+			b.QueryRow(context.Background(), "SELECT 1").Scan()
+			if !b.Open() {
+				t.Fatal("breaker didn't open")
+			}
+
+			time.Sleep(cooldown + 10*time.Millisecond)
+			if b.Open() {
+				t.Fatal("breaker is still open after the cooldown")
+			}
+			if err := b.QueryRow(context.Background(), "SELECT 1").Scan(); err != tt.probe {
+				t.Errorf("probe = %v, want %v", err, tt.probe)
+			}
+			if stub.calls != 2 {
+				t.Errorf("sent %d queries, want the failure and the probe", stub.calls)
+			}
+			if b.Open() != tt.open {
+				t.Errorf("Open() after the probe = %t, want %t", b.Open(), tt.open)
+			}
+		})
+	}
+}
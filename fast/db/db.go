@@ -4,19 +4,78 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/url"
+	"time"
 
-	pgx "github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
 )
 
-// DBConnect connect to a Postgres compatible database.
-func DBConnect(ctx context.Context, dbUser, dbPass, dbHost, dbName, dbParams string) (*pgx.Conn, error) {
+// DSN assemble a connection string from its components
+func DSN(dbUser, dbPass, dbHost, dbName, dbParams string) string {
 	// https://github.com/jackc/pgx/blob/master/batch_test.go#L32
 
-	dsn := fmt.Sprintf("postgresql://%s:%s@%s/%s?%s", dbUser, dbPass, dbHost, dbName, dbParams)
-	conn, err := pgx.Connect(ctx, dsn)
+	return fmt.Sprintf("postgresql://%s:%s@%s/%s?%s", dbUser, dbPass, dbHost, dbName, dbParams)
+}
+
+// ValidateDSN make sure a connection string given verbatim, e.g. from a
+// secret, is a Postgres URL we can use
+func ValidateDSN(dsn string) error {
+	parsed, err := url.Parse(dsn)
+	if err != nil {
+		return errors.New("database url couldn't be parsed")
+	}
+	if parsed.Scheme != "postgres" && parsed.Scheme != "postgresql" {
+		return fmt.Errorf("database url must use the postgres or postgresql scheme, not %q", parsed.Scheme)
+	}
+	if parsed.Host == "" {
+		return errors.New("database url needs a host")
+	}
+
+	return nil
+}
+
+// PoolConfig build the pool configuration for a Postgres compatible database.
+// A zero healthCheckPeriod keeps the pgxpool default
+func PoolConfig(dsn string, healthCheckPeriod time.Duration) (*pgxpool.Config, error) {
+	config, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		return nil, errors.New(fmt.Sprint("failed to parse database config", err))
+	}
+
+	if healthCheckPeriod > 0 {
+		config.HealthCheckPeriod = healthCheckPeriod
+	}
+
+	return config, nil
+}
+
+// DBConnect connect to a Postgres compatible database.
+func DBConnect(ctx context.Context, config *pgxpool.Config) (*pgxpool.Pool, error) {
+	pool, err := pgxpool.ConnectConfig(ctx, config)
 	if err != nil {
 		return nil, errors.New(fmt.Sprint("failed to connect database", err))
 	}
 
-	return conn, nil
+	return pool, nil
+}
+
+// KeepAlive ping the database every period until ctx is done so dead
+// connections are noticed before a user request hits them. onError is
+// called with every failed ping
+func KeepAlive(ctx context.Context, pool *pgxpool.Pool, period time.Duration, onError func(error)) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			pingCtx, cancel := context.WithTimeout(ctx, period)
+			if err := pool.Ping(pingCtx); err != nil {
+				onError(err)
+			}
+			cancel()
+		}
+	}
 }
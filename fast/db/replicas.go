@@ -0,0 +1,55 @@
+package db
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/jackc/pgconn"
+	pgx "github.com/jackc/pgx/v4"
+)
+
+// Querier what handlers need from a database. A *pgxpool.Pool satisfies it,
+// as does a ReplicaSet for read only queries
+type Querier interface {
+	Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+// ReplicaSet spread read queries over read replicas round robin. Only use
+// it for SELECTs, writes belong on the primary
+type ReplicaSet struct {
+	replicas []Querier
+	next     uint32
+}
+
+// NewReplicaSet spread reads over the replicas. With no replicas the
+// fallback, normally the primary, serves the reads
+func NewReplicaSet(fallback Querier, replicas ...Querier) *ReplicaSet {
+	if len(replicas) == 0 {
+		replicas = []Querier{fallback}
+	}
+
+	return &ReplicaSet{replicas: replicas}
+}
+
+// pick the next replica in turn
+func (r *ReplicaSet) pick() Querier {
+	n := atomic.AddUint32(&r.next, 1)
+	return r.replicas[(n-1)%uint32(len(r.replicas))]
+}
+
+// Exec run a statement on the next replica
+func (r *ReplicaSet) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	return r.pick().Exec(ctx, sql, args...)
+}
+
+// Query run a query on the next replica
+func (r *ReplicaSet) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	return r.pick().Query(ctx, sql, args...)
+}
+
+// QueryRow run a single row query on the next replica
+func (r *ReplicaSet) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	return r.pick().QueryRow(ctx, sql, args...)
+}
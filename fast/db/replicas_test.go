@@ -0,0 +1,97 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgconn"
+	pgx "github.com/jackc/pgx/v4"
+)
+
+// stubQuerier a Querier answering with errs in turn, then nil, and
+// counting the calls it got
+type stubQuerier struct {
+	errs  []error
+	calls int
+}
+
+// next the error for the next call
+func (s *stubQuerier) next() error {
+	s.calls++
+	if len(s.errs) == 0 {
+		return nil
+	}
+	err := s.errs[0]
+	s.errs = s.errs[1:]
+	return err
+}
+
+func (s *stubQuerier) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	return nil, s.next()
+}
+
+func (s *stubQuerier) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	return nil, s.next()
+}
+
+func (s *stubQuerier) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	return stubRow{err: s.next()}
+}
+
+// stubRow a row whose Scan reports err
+type stubRow struct {
+	err error
+}
+
+func (r stubRow) Scan(dest ...interface{}) error {
+	return r.err
+}
+
+func TestReplicaSet(t *testing.T) {
+	tests := []struct {
+		name     string
+		replicas int
+		queries  int
+		want     []int
+	}{
+		{"no replicas", 0, 4, nil},
+		{"one replica", 1, 4, []int{4}},
+		{"round robin", 3, 7, []int{3, 2, 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fallback := &stubQuerier{}
+			var replicas []Querier
+			for i := 0; i < tt.replicas; i++ {
+				replicas = append(replicas, &stubQuerier{})
+			}
+			set := NewReplicaSet(fallback, replicas...)
+			for i := 0; i < tt.queries; i++ {
+				switch i % 3 {
+				case 0:
+					set.QueryRow(context.Background(), "SELECT 1").Scan()
+				case 1:
+					set.Query(context.Background(), "SELECT 1")
+				case 2:
+					set.Exec(context.Background(), "SELECT 1")
+				}
+			}
+
+			if tt.replicas == 0 {
+				if fallback.calls != tt.queries {
+					t.Errorf("fallback served %d queries, want %d", fallback.calls, tt.queries)
+				}
+				return
+			}
+			if fallback.calls != 0 {
+				t.Errorf("fallback served %d queries with replicas configured", fallback.calls)
+			}
+			for i, replica := range replicas {
+				if got := replica.(*stubQuerier).calls; got != tt.want[i] {
+					t.Errorf("replica %d served %d queries, want %d", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/jackc/pgconn"
+	pgx "github.com/jackc/pgx/v4"
+)
+
+// Retrying retry read queries once, after a short backoff, when they fail
+// with a transient error like a reset connection or a timeout. Only use it
+// for SELECTs, a retried write could be applied twice
+type Retrying struct {
+	querier Querier
+	backoff time.Duration
+}
+
+// NewRetrying wrap a querier so transient read errors get a single retry
+func NewRetrying(querier Querier, backoff time.Duration) *Retrying {
+	return &Retrying{querier: querier, backoff: backoff}
+}
+
+// IsTransient whether an error is worth retrying. Missing rows, errors
+// reported by the server and cancelled requests are not
+func IsTransient(err error) bool {
+	if err == nil || errors.Is(err, pgx.ErrNoRows) {
+		return false
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	return pgconn.SafeToRetry(err) || pgconn.Timeout(err) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE)
+}
+
+// wait the backoff before a retry, false when the request went away first
+func (r *Retrying) wait(ctx context.Context) bool {
+	timer := time.NewTimer(r.backoff)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// Exec run a statement without retrying it
+func (r *Retrying) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	return r.querier.Exec(ctx, sql, args...)
+}
+
+// Query run a query, retrying once on a transient error
+func (r *Retrying) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	rows, err := r.querier.Query(ctx, sql, args...)
+	if !IsTransient(err) || !r.wait(ctx) {
+		return rows, err
+	}
+
+	return r.querier.Query(ctx, sql, args...)
+}
+
+// QueryRow run a single row query, retrying once when scanning it fails
+// with a transient error
+func (r *Retrying) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	return &retryRow{retrying: r, ctx: ctx, sql: sql, args: args}
+}
+
+// retryRow defer the query until Scan, where pgx reports its errors
+type retryRow struct {
+	retrying *Retrying
+	ctx      context.Context
+	sql      string
+	args     []interface{}
+}
+
+// Scan run the query and scan the row, retrying once on a transient error
+func (row *retryRow) Scan(dest ...interface{}) error {
+	r := row.retrying
+	err := r.querier.QueryRow(row.ctx, row.sql, row.args...).Scan(dest...)
+	if !IsTransient(err) || !r.wait(row.ctx) {
+		return err
+	}
+
+	return r.querier.QueryRow(row.ctx, row.sql, row.args...).Scan(dest...)
+}
@@ -0,0 +1,98 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgconn"
+	pgx "github.com/jackc/pgx/v4"
+)
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"no error", nil, false},
+		{"no rows", pgx.ErrNoRows, false},
+		{"wrapped no rows", fmt.Errorf("loading link: %w", pgx.ErrNoRows), false},
+		{"cancelled", context.Canceled, false},
+		{"deadline", context.DeadlineExceeded, false},
+		{"server error", &pgconn.PgError{Code: "42P01"}, false},
+		{"network timeout", &net.DNSError{IsTimeout: true}, true},
+		{"connection reset", &net.OpError{Op: "read", Err: syscall.ECONNRESET}, true},
+		{"connection refused", syscall.ECONNREFUSED, true},
+		{"broken pipe", syscall.EPIPE, true},
+		{"closed connection", io.EOF, true},
+		{"cut short", io.ErrUnexpectedEOF, true},
+		{"anything else", errors.New("syntax error"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsTransient(tt.err); got != tt.want {
+				t.Errorf("IsTransient(%v) = %t, want %t", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetrying(t *testing.T) {
+	transient := &net.OpError{Op: "read", Err: syscall.ECONNRESET}
+	tests := []struct {
+		name  string
+		errs  []error
+		err   error
+		calls int
+	}{
+		{"succeeds", nil, nil, 1},
+		{"fails once then succeeds", []error{transient}, nil, 2},
+		{"fails twice", []error{transient, transient}, transient, 2},
+		{"not found", []error{pgx.ErrNoRows}, pgx.ErrNoRows, 1},
+		{"server error", []error{&pgconn.PgError{Code: "42P01"}}, &pgconn.PgError{Code: "42P01"}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, query := range []string{"QueryRow", "Query"} {
+				stub := &stubQuerier{errs: append([]error(nil), tt.errs...)}
+				r := NewRetrying(stub, 0)
+				var err error
+				if query == "QueryRow" {
+					err = r.QueryRow(context.Background(), "SELECT 1").Scan()
+				} else {
+					_, err = r.Query(context.Background(), "SELECT 1")
+				}
+				if (err == nil) != (tt.err == nil) || (err != nil && err.Error() != tt.err.Error()) {
+					t.Errorf("%s() = %v, want %v", query, err, tt.err)
+				}
+				if stub.calls != tt.calls {
+					t.Errorf("%s() made %d calls, want %d", query, stub.calls, tt.calls)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryingSkipsWritesAndGoneRequests(t *testing.T) {
+	transient := &net.OpError{Op: "read", Err: syscall.ECONNRESET}
+
+	stub := &stubQuerier{errs: []error{transient}}
+	if _, err := NewRetrying(stub, 0).Exec(context.Background(), "UPDATE urls SET hits = hits + 1"); err == nil || stub.calls != 1 {
+		t.Errorf("Exec() = %v after %d calls, want the first error unretried", err, stub.calls)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	stub = &stubQuerier{errs: []error{transient}}
+	if err := NewRetrying(stub, time.Hour).QueryRow(ctx, "SELECT 1").Scan(); err == nil || stub.calls != 1 {
+		t.Errorf("Scan() = %v after %d calls, want no retry once the request is gone", err, stub.calls)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+const minDedupSweep = 1024 // Clients remembered before stale ones are first swept
+
+// ClickDeduper count repeated redirects of a link from the same client
+// within a window once, so refreshes and prefetches don't inflate clicks.
+// Clients are a hash of address and user agent. A nil deduper counts every click
+type ClickDeduper struct {
+	mu        sync.Mutex
+	window    time.Duration
+	seen      map[string]time.Time
+	nextSweep int
+}
+
+// NewClickDeduper dedup clicks within window, nil when window is zero
+func NewClickDeduper(window time.Duration) *ClickDeduper {
+	if window <= 0 {
+		return nil
+	}
+
+	return &ClickDeduper{window: window, seen: map[string]time.Time{}, nextSweep: minDedupSweep}
+}
+
+// Duplicate whether the client already followed the link within the window.
+// A duplicate doesn't extend the window, so steady refreshing still counts
+// once per window
+func (d *ClickDeduper) Duplicate(uri, clientIP, userAgent string, now time.Time) bool {
+	if d == nil {
+		return false
+	}
+	sum := sha256.Sum256([]byte(uri + "\x00" + clientIP + "\x00" + userAgent))
+	key := hex.EncodeToString(sum[:])
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if last, ok := d.seen[key]; ok && now.Sub(last) < d.window {
+		return true
+	}
+	d.seen[key] = now
+
+	if len(d.seen) >= d.nextSweep {
+		for k, last := range d.seen {
+			if now.Sub(last) >= d.window {
+				delete(d.seen, k)
+			}
+		}
+		d.nextSweep = 2 * len(d.seen)
+		if d.nextSweep < minDedupSweep {
+			d.nextSweep = minDedupSweep
+		}
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestClickDeduperDuplicate(t *testing.T) {
+	start := time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+	type click struct {
+		uri, ip, agent string
+		after          time.Duration
+		duplicate      bool
+	}
+
+	tests := []struct {
+		name   string
+		window time.Duration
+		clicks []click
+	}{
+		{"first click counts", time.Minute, []click{
+			{"launch", "10.0.0.1", "curl", 0, false},
+		}},
+		{"repeat within the window", time.Minute, []click{
+			{"launch", "10.0.0.1", "curl", 0, false},
+			{"launch", "10.0.0.1", "curl", 30 * time.Second, true},
+		}},
+		{"repeat after the window", time.Minute, []click{
+			{"launch", "10.0.0.1", "curl", 0, false},
+			{"launch", "10.0.0.1", "curl", time.Minute, false},
+		}},
+		{"duplicates don't extend the window", time.Minute, []click{
+			{"launch", "10.0.0.1", "curl", 0, false},
+			{"launch", "10.0.0.1", "curl", 40 * time.Second, true},
+			{"launch", "10.0.0.1", "curl", 70 * time.Second, false},
+		}},
+		{"other links, addresses and agents count", time.Minute, []click{
+			{"launch", "10.0.0.1", "curl", 0, false},
+			{"promo", "10.0.0.1", "curl", 0, false},
+			{"launch", "10.0.0.2", "curl", 0, false},
+			{"launch", "10.0.0.1", "firefox", 0, false},
+		}},
+		{"fields don't run together", time.Minute, []click{
+			{"ab", "c", "curl", 0, false},
+			{"a", "bc", "curl", 0, false},
+		}},
+		{"disabled", 0, []click{
+			{"launch", "10.0.0.1", "curl", 0, false},
+			{"launch", "10.0.0.1", "curl", 0, false},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewClickDeduper(tt.window)
+			for i, c := range tt.clicks {
+				if got := d.Duplicate(c.uri, c.ip, c.agent, start.Add(c.after)); got != c.duplicate {
+					t.Errorf("click %d Duplicate() = %t, want %t", i, got, c.duplicate)
+				}
+			}
+		})
+	}
+}
+
+func TestClickDeduperSweepsStaleClients(t *testing.T) {
+	start := time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+	d := NewClickDeduper(time.Minute)
+	for i := 0; i < minDedupSweep-1; i++ {
+		d.Duplicate("launch", fmt.Sprintf("10.0.%d.%d", i/256, i%256), "curl", start)
+	}
+
+	// the click that reaches the sweep threshold drops every stale client
+	d.Duplicate("launch", "192.168.0.1", "curl", start.Add(2*time.Minute))
+	if len(d.seen) != 1 {
+		t.Errorf("remembered %d clients after the sweep, want 1", len(d.seen))
+	}
+	if d.nextSweep != minDedupSweep {
+		t.Errorf("next sweep at %d clients, want %d", d.nextSweep, minDedupSweep)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+// Destination a weighted destination for a short link. A link with several
+// destinations picks one per redirect according to the weights, which is
+// useful for A/B testing
+type Destination struct {
+	URL    string `json:"url" yaml:"url"`
+	Weight int    `json:"weight" yaml:"weight"`
+}
+
+// ValidateDestinations make sure every destination is a valid URL and that
+// the weights can be used for selection
+func ValidateDestinations(destinations []Destination) error {
+	total := 0
+	for _, destination := range destinations {
+		if _, err := url.ParseRequestURI(destination.URL); err != nil {
+			return fmt.Errorf("couldn't parse destination url: %s", err)
+		}
+		if destination.Weight < 0 {
+			return errors.New("destination weight can't be negative")
+		}
+		total += destination.Weight
+	}
+
+	if len(destinations) > 0 && total == 0 {
+		return errors.New("destination weights must add up to more than zero")
+	}
+
+	return nil
+}
+
+// PickDestination select a destination according to the weights. intn should
+// behave like rand.Intn so callers can pass a seeded source. An empty string is
+// returned when there is nothing to pick from
+func PickDestination(destinations []Destination, intn func(n int) int) string {
+	total := 0
+	for _, destination := range destinations {
+		total += destination.Weight
+	}
+	if total <= 0 {
+		return ""
+	}
+
+	pick := intn(total)
+	for _, destination := range destinations {
+		if pick < destination.Weight {
+			return destination.URL
+		}
+		pick -= destination.Weight
+	}
+
+	return ""
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestValidateDestinations(t *testing.T) {
+	tests := []struct {
+		name         string
+		destinations []Destination
+		valid        bool
+	}{
+		{"none", nil, true},
+		{"weighted", []Destination{{"https://example.com/a", 1}, {"https://example.com/b", 3}}, true},
+		{"zero weight alongside others", []Destination{{"https://example.com/a", 0}, {"https://example.com/b", 1}}, true},
+		{"not a url", []Destination{{"example", 1}}, false},
+		{"negative weight", []Destination{{"https://example.com/a", -1}, {"https://example.com/b", 2}}, false},
+		{"all zero", []Destination{{"https://example.com/a", 0}, {"https://example.com/b", 0}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateDestinations(tt.destinations); (err == nil) != tt.valid {
+				t.Errorf("ValidateDestinations() = %v, want valid = %t", err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestPickDestination(t *testing.T) {
+	tests := []struct {
+		name         string
+		destinations []Destination
+		want         map[string]float64
+	}{
+		{"none", nil, map[string]float64{"": 1}},
+		{"all zero", []Destination{{"https://example.com/a", 0}}, map[string]float64{"": 1}},
+		{"single", []Destination{{"https://example.com/a", 5}}, map[string]float64{"https://example.com/a": 1}},
+		{"a/b split", []Destination{{"https://example.com/a", 1}, {"https://example.com/b", 3}}, map[string]float64{"https://example.com/a": 0.25, "https://example.com/b": 0.75}},
+		{"zero weight never picked", []Destination{{"https://example.com/a", 2}, {"https://example.com/b", 0}, {"https://example.com/c", 2}}, map[string]float64{"https://example.com/a": 0.5, "https://example.com/c": 0.5}},
+	}
+
+	const picks = 20000
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// a fixed seed keeps the counts the same from run to run
+			rng := rand.New(rand.NewSource(104))
+			counts := map[string]int{}
+			for i := 0; i < picks; i++ {
+				counts[PickDestination(tt.destinations, rng.Intn)]++
+			}
+
+			for destination := range counts {
+				if _, ok := tt.want[destination]; !ok {
+					t.Errorf("picked %q %d times, want never", destination, counts[destination])
+				}
+			}
+			for destination, share := range tt.want {
+				got := float64(counts[destination]) / picks
+				if math.Abs(got-share) > 0.02 {
+					t.Errorf("picked %q %.3f of the time, want %.3f", destination, got, share)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+const defaultEnv = "dev" // The environment used when ENV isn't set
+
+// envPattern what an environment name like staging or qa-2 may look like.
+// Names are the top level keys of the configuration so they can't have dots
+var envPattern = regexp.MustCompile(`^[a-z][a-z0-9_-]*$`)
+
+// selectEnv the environment whose block of the configuration is used. Any
+// name works, not just dev and prod, as long as the configuration file has
+// a block for it. Without a configuration file every setting falls back to
+// its default so there's nothing to check the name against
+func selectEnv(name string, configRead bool) (string, error) {
+	env := strings.ToLower(strings.TrimSpace(name))
+	if !envPattern.MatchString(env) {
+		return env, fmt.Errorf("environment %q must be letters, digits, '-' or '_', starting with a letter", name)
+	}
+	if configRead && !viper.IsSet(env) {
+		return env, fmt.Errorf("environment %q has no block in the configuration, which has %s", env, strings.Join(configuredEnvs(), ", "))
+	}
+
+	return env, nil
+}
+
+// configuredEnvs the environments the configuration file has blocks for
+func configuredEnvs() []string {
+	var envs []string
+	for key, value := range viper.AllSettings() {
+		if _, ok := value.(map[string]interface{}); ok {
+			envs = append(envs, key)
+		}
+	}
+	if len(envs) == 0 {
+		return []string{"none"}
+	}
+	sort.Strings(envs)
+	return envs
+}
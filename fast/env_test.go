@@ -0,0 +1,51 @@
+package main
+
+import "testing"
+
+func TestSelectEnv(t *testing.T) {
+	tests := []struct {
+		name       string
+		env        string
+		configRead bool
+		want       string
+		valid      bool
+	}{
+		{"configured", "staging", true, "staging", true},
+		{"case and spaces", " Staging ", true, "staging", true},
+		{"dev without a configuration file", "dev", false, "dev", true},
+		{"any name without a configuration file", "qa-2", false, "qa-2", true},
+		{"missing from the configuration", "qa-2", true, "qa-2", false},
+		{"dotted", "prod.db", false, "prod.db", false},
+		{"starts with a digit", "2qa", false, "2qa", false},
+		{"empty", "", false, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withConfig(t, "staging.port", 8080)
+			withConfig(t, "prod.port", 80)
+
+			env, err := selectEnv(tt.env, tt.configRead)
+			if (err == nil) != tt.valid {
+				t.Fatalf("selectEnv(%q) = %v, want valid = %t", tt.env, err, tt.valid)
+			}
+			if env != tt.want {
+				t.Errorf("env = %q, want %q", env, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfiguredEnvs(t *testing.T) {
+	if got := configuredEnvs(); len(got) != 1 || got[0] != "none" {
+		t.Errorf("configuredEnvs() = %v without a configuration, want [none]", got)
+	}
+
+	withConfig(t, "staging.port", 8080)
+	withConfig(t, "dev.port", 8080)
+	withConfig(t, "debug", true)
+	got := configuredEnvs()
+	if len(got) != 2 || got[0] != "dev" || got[1] != "staging" {
+		t.Errorf("configuredEnvs() = %v, want [dev staging]", got)
+	}
+}
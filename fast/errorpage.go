@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+)
+
+const defaultBrandingTitle = "fast" // The name on error pages when none is configured
+
+var errorPageTemplate = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex">
+<title>{{.Status}} - {{.Title}}</title>
+</head>
+<body>
+{{if .LogoURL}}<img src="{{.LogoURL}}" alt="{{.Title}}">{{end}}
+<h1>{{.Title}}</h1>
+<p>{{.Message}}</p>
+{{if .SupportURL}}<p><a href="{{.SupportURL}}">Get help</a></p>{{end}}
+</body>
+</html>
+`))
+
+// BrandingConfig what the error pages browsers get look like. ExpiredURL
+// is a page of the deployment's own that expired links redirect to instead
+type BrandingConfig struct {
+	Title      string `mapstructure:"title" yaml:"title"`
+	LogoURL    string `mapstructure:"logo_url" yaml:"logo_url"`
+	SupportURL string `mapstructure:"support_url" yaml:"support_url"`
+	ExpiredURL string `mapstructure:"expired_url" yaml:"expired_url"`
+}
+
+// loadBrandingConfig read the error page branding for the environment
+func loadBrandingConfig(env string) (BrandingConfig, error) {
+	var cfg BrandingConfig
+	if err := viper.UnmarshalKey(fmt.Sprintf("%s.branding", env), &cfg); err != nil {
+		return cfg, fmt.Errorf("couldn't read branding configuration: %w", err)
+	}
+	if cfg.Title == "" {
+		cfg.Title = defaultBrandingTitle
+	}
+	if cfg.ExpiredURL != "" {
+		if _, err := url.ParseRequestURI(cfg.ExpiredURL); err != nil {
+			return cfg, fmt.Errorf("branding expired_url must be a url: %w", err)
+		}
+	}
+
+	return cfg, nil
+}
+
+// wantsHTML whether the client is a browser asking for a page
+func wantsHTML(c *gin.Context) bool {
+	return strings.Contains(c.GetHeader("Accept"), "text/html")
+}
+
+// linkGone tell the client a link has expired or was disabled, sending
+// them on to the configured expired page when there is one
+func linkGone(c *gin.Context, cfg BrandingConfig, message string) {
+	if cfg.ExpiredURL == "" {
+		redirectError(c, cfg, http.StatusGone, message)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Redirect(http.StatusFound, cfg.ExpiredURL)
+}
+
+// redirectError tell the client a short uri can't be followed: a branded
+// page for browsers, the usual JSON error for everyone else
+func redirectError(c *gin.Context, cfg BrandingConfig, status int, message string) {
+	if !wantsHTML(c) {
+		c.JSON(status, gin.H{
+			"error": message,
+		})
+		return
+	}
+
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(status)
+	err := errorPageTemplate.Execute(c.Writer, struct {
+		BrandingConfig
+		Status  int
+		Message string
+	}{
+		BrandingConfig: cfg,
+		Status:         status,
+		Message:        message,
+	})
+	if err != nil {
+		c.Error(err)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestLoadBrandingConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     map[string]interface{}
+		want    BrandingConfig
+		wantErr bool
+	}{
+		{"defaults", nil, BrandingConfig{Title: defaultBrandingTitle}, false},
+		{"configured", map[string]interface{}{
+			"title":       "Acme Links",
+			"logo_url":    "https://acme.example.com/logo.png",
+			"support_url": "https://acme.example.com/help",
+		}, BrandingConfig{Title: "Acme Links", LogoURL: "https://acme.example.com/logo.png", SupportURL: "https://acme.example.com/help"}, false},
+		{"expired page", map[string]interface{}{"expired_url": "https://acme.example.com/expired"}, BrandingConfig{Title: defaultBrandingTitle, ExpiredURL: "https://acme.example.com/expired"}, false},
+		{"expired page not a url", map[string]interface{}{"expired_url": "expired"}, BrandingConfig{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.cfg != nil {
+				withConfig(t, "test.branding", tt.cfg)
+			}
+			cfg, err := loadBrandingConfig("test")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadBrandingConfig() = %v, want an error %t", err, tt.wantErr)
+			}
+			if !tt.wantErr && cfg != tt.want {
+				t.Errorf("loadBrandingConfig() = %+v, want %+v", cfg, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedirectError(t *testing.T) {
+	branded := BrandingConfig{Title: "Acme <Links>", LogoURL: "https://acme.example.com/logo.png", SupportURL: "https://acme.example.com/help"}
+	tests := []struct {
+		name     string
+		cfg      BrandingConfig
+		accept   string
+		html     bool
+		contains []string
+		missing  []string
+	}{
+		{"json for api clients", branded, "application/json", false, []string{`{"error":"uri not found"}`}, []string{"<html>"}},
+		{"branded page", branded, "text/html,application/xhtml+xml", true, []string{
+			"<title>404 - Acme &lt;Links&gt;</title>",
+			`<img src="https://acme.example.com/logo.png" alt="Acme &lt;Links&gt;">`,
+			"<h1>Acme &lt;Links&gt;</h1>",
+			"<p>uri not found</p>",
+			`<a href="https://acme.example.com/help">Get help</a>`,
+		}, nil},
+		{"unbranded page", BrandingConfig{Title: defaultBrandingTitle}, "text/html", true, []string{
+			"<title>404 - fast</title>",
+			"<h1>fast</h1>",
+		}, []string{"<img", "Get help"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			r.GET("/:short_uri", func(c *gin.Context) {
+				redirectError(c, tt.cfg, http.StatusNotFound, "uri not found")
+			})
+			req := httptest.NewRequest(http.MethodGet, "/missing", nil)
+			req.Header.Set("Accept", tt.accept)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != http.StatusNotFound {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusNotFound)
+			}
+			if got := strings.HasPrefix(w.Header().Get("Content-Type"), "text/html"); got != tt.html {
+				t.Errorf("Content-Type = %s, want html %t", w.Header().Get("Content-Type"), tt.html)
+			}
+			for _, want := range tt.contains {
+				if !strings.Contains(w.Body.String(), want) {
+					t.Errorf("page is missing %s:\n%s", want, w.Body)
+				}
+			}
+			for _, unwanted := range tt.missing {
+				if strings.Contains(w.Body.String(), unwanted) {
+					t.Errorf("page has %s:\n%s", unwanted, w.Body)
+				}
+			}
+		})
+	}
+}
+
+func TestShortURIHandlerExpiredPage(t *testing.T) {
+	tests := []struct {
+		name       string
+		expiredURL string
+		disabled   bool
+		status     int
+		location   string
+	}{
+		{"off", "", false, http.StatusGone, ""},
+		{"on", "https://acme.example.com/expired", false, http.StatusFound, "https://acme.example.com/expired"},
+		{"disabled link, off", "", true, http.StatusGone, ""},
+		{"disabled link, on", "https://acme.example.com/expired", true, http.StatusFound, "https://acme.example.com/expired"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			row := redirectRow("launch", "https://example.com/a")
+			if tt.disabled {
+				row[13] = true
+			} else {
+				expires := time.Now().Add(-time.Hour)
+				row[10] = &expires
+			}
+			fake := (&fakeDB{}).onFunc("SELECT uri, COALESCE(domain", linkRows(map[string][]interface{}{"launch": row}))
+			rd := testRedirector(fake)
+			rd.branding = BrandingConfig{Title: defaultBrandingTitle, ExpiredURL: tt.expiredURL}
+
+			w := serve(redirectRouter(rd), http.MethodGet, "/launch", APIKey{}, "")
+			if w.Code != tt.status || w.Header().Get("Location") != tt.location {
+				t.Fatalf("got %d to %q, want %d to %q", w.Code, w.Header().Get("Location"), tt.status, tt.location)
+			}
+			if tt.location != "" && w.Header().Get("Cache-Control") != "no-store" {
+				t.Errorf("Cache-Control = %q, want the expired redirect uncached", w.Header().Get("Cache-Control"))
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+const defaultMaxTTL = 365 * 24 * time.Hour // The longest a link may be created to live for
+
+// TTL how long a link lives for. In JSON it is either a number of seconds
+// or a duration string like "1h30m"
+type TTL time.Duration
+
+// UnmarshalJSON accept a number of seconds or a duration string
+func (t *TTL) UnmarshalJSON(data []byte) error {
+	var seconds json.Number
+	if err := json.Unmarshal(data, &seconds); err == nil {
+		parsed, err := seconds.Int64()
+		if err != nil {
+			return errors.New("ttl must be a whole number of seconds")
+		}
+		if parsed > math.MaxInt64/int64(time.Second) || parsed < math.MinInt64/int64(time.Second) {
+			return errors.New("ttl is too long")
+		}
+		*t = TTL(time.Duration(parsed) * time.Second)
+		return nil
+	}
+
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return errors.New("ttl must be a number of seconds or a duration like 1h30m")
+	}
+	parsed, err := time.ParseDuration(text)
+	if err != nil {
+		return fmt.Errorf("ttl must be a duration like 1h30m: %s", err)
+	}
+	*t = TTL(parsed)
+	return nil
+}
+
+// MarshalJSON write the ttl as a duration string
+func (t TTL) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(t).String())
+}
+
+// linkExpiry when a link created now with the requested ttl expires, nil
+// for links that don't expire. ttl and ttlSeconds are two ways of asking
+// for the same thing so only one may be given
+func linkExpiry(ttl *TTL, ttlSeconds *int64, maxTTL time.Duration, now time.Time) (*time.Time, error) {
+	if ttl != nil && ttlSeconds != nil {
+		return nil, errors.New("only one of ttl and ttl_seconds may be given")
+	}
+
+	var lifetime time.Duration
+	switch {
+	case ttl != nil:
+		lifetime = time.Duration(*ttl)
+	case ttlSeconds != nil:
+		if *ttlSeconds > int64(maxTTL/time.Second) {
+			return nil, fmt.Errorf("ttl can be at most %s", maxTTL)
+		}
+		lifetime = time.Duration(*ttlSeconds) * time.Second
+	default:
+		return nil, nil
+	}
+
+	if lifetime <= 0 {
+		return nil, errors.New("ttl must be positive")
+	}
+	if lifetime > maxTTL {
+		return nil, fmt.Errorf("ttl can be at most %s", maxTTL)
+	}
+
+	expires := now.Add(lifetime)
+	return &expires, nil
+}
+
+// expired whether a link's expiry has passed. skew is how far apart our
+// clocks may be, links stay up until their expiry has passed on every
+// instance so they don't disappear early on one with a fast clock
+func expired(expires *time.Time, now time.Time, skew time.Duration) bool {
+	return expires != nil && !now.Add(-skew).Before(*expires)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestTTLUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name  string
+		json  string
+		want  time.Duration
+		valid bool
+	}{
+		{"seconds", `3600`, time.Hour, true},
+		{"duration string", `"1h30m"`, 90 * time.Minute, true},
+		{"zero", `0`, 0, true},
+		{"fractional seconds", `1.5`, 0, false},
+		{"too many seconds", `9223372036854775807`, 0, false},
+		{"bad duration", `"soon"`, 0, false},
+		{"wrong type", `true`, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ttl TTL
+			err := json.Unmarshal([]byte(tt.json), &ttl)
+			if (err == nil) != tt.valid {
+				t.Fatalf("Unmarshal(%s) = %v, want valid = %t", tt.json, err, tt.valid)
+			}
+			if tt.valid && time.Duration(ttl) != tt.want {
+				t.Errorf("ttl = %s, want %s", time.Duration(ttl), tt.want)
+			}
+		})
+	}
+}
+
+func TestLinkExpiry(t *testing.T) {
+	now := time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+	ttl := func(d time.Duration) *TTL {
+		t := TTL(d)
+		return &t
+	}
+	seconds := func(n int64) *int64 { return &n }
+
+	tests := []struct {
+		name       string
+		ttl        *TTL
+		ttlSeconds *int64
+		want       time.Duration
+		valid      bool
+	}{
+		{"no expiry", nil, nil, 0, true},
+		{"ttl", ttl(time.Hour), nil, time.Hour, true},
+		{"ttl seconds", nil, seconds(60), time.Minute, true},
+		{"at the maximum", ttl(24 * time.Hour), nil, 24 * time.Hour, true},
+		{"both given", ttl(time.Hour), seconds(60), 0, false},
+		{"zero", ttl(0), nil, 0, false},
+		{"negative seconds", nil, seconds(-1), 0, false},
+		{"over the maximum", ttl(25 * time.Hour), nil, 0, false},
+		{"seconds overflowing the maximum", nil, seconds(1 << 62), 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expires, err := linkExpiry(tt.ttl, tt.ttlSeconds, 24*time.Hour, now)
+			if (err == nil) != tt.valid {
+				t.Fatalf("linkExpiry() = %v, want valid = %t", err, tt.valid)
+			}
+			if !tt.valid {
+				return
+			}
+			if tt.want == 0 {
+				if expires != nil {
+					t.Errorf("expires = %s, want nil", expires)
+				}
+				return
+			}
+			if expires == nil || !expires.Equal(now.Add(tt.want)) {
+				t.Errorf("expires = %v, want %s", expires, now.Add(tt.want))
+			}
+		})
+	}
+}
+
+func TestExpired(t *testing.T) {
+	now := time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) *time.Time {
+		t := now.Add(d)
+		return &t
+	}
+
+	tests := []struct {
+		name    string
+		expires *time.Time
+		skew    time.Duration
+		want    bool
+	}{
+		{"never expires", nil, 0, false},
+		{"in the future", at(time.Minute), 0, false},
+		{"passed", at(-time.Minute), 0, true},
+		{"right now", at(0), 0, true},
+		{"passed within the skew", at(-time.Second), 5 * time.Second, false},
+		{"passed beyond the skew", at(-10 * time.Second), 5 * time.Second, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := expired(tt.expires, now, tt.skew); got != tt.want {
+				t.Errorf("expired() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgproto3/v2"
+	"github.com/jackc/pgx/v4"
+)
+
+// fakeResult what a fakeDB statement answers with. Rows are scanned in
+// column order, tag is the command tag Exec reports
+type fakeResult struct {
+	rows [][]interface{}
+	tag  string
+	err  error
+}
+
+// fakeCall a statement the fakeDB received
+type fakeCall struct {
+	sql  string
+	args []interface{}
+}
+
+type fakeResponder struct {
+	match  string
+	answer func(args []interface{}) fakeResult
+}
+
+// fakeDB an in memory db.Querier. Statements are answered by the first
+// responder whose match is a substring of the SQL, anything else gets an
+// empty result so tests only describe the queries they care about
+type fakeDB struct {
+	mu         sync.Mutex
+	responders []fakeResponder
+	calls      []fakeCall
+}
+
+// on answer statements containing match with a fixed result
+func (f *fakeDB) on(match string, result fakeResult) *fakeDB {
+	return f.onFunc(match, func([]interface{}) fakeResult { return result })
+}
+
+// onFunc answer statements containing match from their arguments
+func (f *fakeDB) onFunc(match string, answer func(args []interface{}) fakeResult) *fakeDB {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.responders = append(f.responders, fakeResponder{match: match, answer: answer})
+	return f
+}
+
+// statements the SQL of every statement containing match, in order
+func (f *fakeDB) statements(match string) []fakeCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var calls []fakeCall
+	for _, call := range f.calls {
+		if strings.Contains(call.sql, match) {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+func (f *fakeDB) answer(sql string, args []interface{}) fakeResult {
+	f.mu.Lock()
+	f.calls = append(f.calls, fakeCall{sql: sql, args: args})
+	var answer func([]interface{}) fakeResult
+	for _, responder := range f.responders {
+		if strings.Contains(sql, responder.match) {
+			answer = responder.answer
+			break
+		}
+	}
+	f.mu.Unlock()
+	if answer == nil {
+		return fakeResult{}
+	}
+	return answer(args)
+}
+
+func (f *fakeDB) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	result := f.answer(sql, args)
+	return pgconn.CommandTag(result.tag), result.err
+}
+
+func (f *fakeDB) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	result := f.answer(sql, args)
+	if result.err != nil {
+		return nil, result.err
+	}
+	return &fakeRows{rows: result.rows, index: -1}, nil
+}
+
+func (f *fakeDB) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	result := f.answer(sql, args)
+	return &fakeRows{rows: result.rows, index: -1, err: result.err, single: true}
+}
+
+// fakeRows pgx.Rows and pgx.Row over canned values
+type fakeRows struct {
+	rows   [][]interface{}
+	index  int
+	err    error
+	single bool
+}
+
+func (r *fakeRows) Close()                                         {}
+func (r *fakeRows) Err() error                                     { return r.err }
+func (r *fakeRows) CommandTag() pgconn.CommandTag                  { return nil }
+func (r *fakeRows) FieldDescriptions() []pgproto3.FieldDescription { return nil }
+func (r *fakeRows) RawValues() [][]byte                            { return nil }
+
+func (r *fakeRows) Next() bool {
+	if r.err != nil || r.index+1 >= len(r.rows) {
+		return false
+	}
+	r.index++
+	return true
+}
+
+func (r *fakeRows) Values() ([]interface{}, error) {
+	return r.rows[r.index], nil
+}
+
+func (r *fakeRows) Scan(dest ...interface{}) error {
+	if r.single {
+		if r.err != nil {
+			return r.err
+		}
+		if !r.Next() {
+			return pgx.ErrNoRows
+		}
+	}
+	row := r.rows[r.index]
+	if len(row) != len(dest) {
+		return fmt.Errorf("fake row has %d columns, scanned into %d", len(row), len(dest))
+	}
+	for i, value := range row {
+		if err := assignFake(dest[i], value); err != nil {
+			return fmt.Errorf("column %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// assignFake copy a canned value into a Scan destination, taking a nil
+// value as SQL NULL
+func assignFake(dest, value interface{}) error {
+	if scanner, ok := dest.(interface{ Scan(interface{}) error }); ok {
+		return scanner.Scan(value)
+	}
+	target := reflect.ValueOf(dest)
+	if target.Kind() != reflect.Ptr || target.IsNil() {
+		return fmt.Errorf("destination %T isn't a pointer", dest)
+	}
+	target = target.Elem()
+	if value == nil {
+		target.Set(reflect.Zero(target.Type()))
+		return nil
+	}
+
+	source := reflect.ValueOf(value)
+	if target.Kind() == reflect.Ptr && source.Kind() != reflect.Ptr {
+		inner := reflect.New(target.Type().Elem())
+		if err := assignFake(inner.Interface(), value); err != nil {
+			return err
+		}
+		target.Set(inner)
+		return nil
+	}
+	switch {
+	case source.Type().AssignableTo(target.Type()):
+		target.Set(source)
+	case source.Type().ConvertibleTo(target.Type()):
+		target.Set(source.Convert(target.Type()))
+	default:
+		return fmt.Errorf("can't scan %T into %T", value, dest)
+	}
+	return nil
+}
+
+// redirectRow the columns loadRedirectLink scans for a plain link
+func redirectRow(uri, originalURL string) []interface{} {
+	return []interface{}{uri, "", originalURL, nil, nil, "", "", false, "", true, nil, nil, false, false}
+}
+
+// ownedLink answer a uri lookup scoped to an owner argument, which is empty
+// for admins, as if the link uri belonged to owner
+func ownedLink(uri, owner string, ownerArg int) func(args []interface{}) fakeResult {
+	return func(args []interface{}) fakeResult {
+		if args[0] != uri || (args[ownerArg] != "" && args[ownerArg] != owner) {
+			return fakeResult{}
+		}
+		return fakeResult{rows: [][]interface{}{{uri}}}
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+//go:generate buf generate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/aeekayy/systems/fast/db"
+	fastv1 "github.com/aeekayy/systems/fast/proto/fast/v1"
+	pgx "github.com/jackc/pgx/v4"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const defaultGRPCPort = 9090 // The default port of the gRPC server
+
+// GRPCConfig the gRPC server internal clients use instead of the REST API.
+// It is off unless enabled
+type GRPCConfig struct {
+	Enabled bool `mapstructure:"enabled" yaml:"enabled"`
+	Port    int  `mapstructure:"port" yaml:"port"`
+}
+
+// loadGRPCConfig read the gRPC server settings for the environment
+func loadGRPCConfig(env string) (GRPCConfig, error) {
+	cfg := GRPCConfig{Port: defaultGRPCPort}
+	if err := viper.UnmarshalKey(fmt.Sprintf("%s.grpc", env), &cfg); err != nil {
+		return cfg, fmt.Errorf("couldn't read grpc configuration: %w", err)
+	}
+	if cfg.Port <= 0 || cfg.Port > 65535 {
+		return cfg, fmt.Errorf("grpc port must be between 1 and 65535, not %d", cfg.Port)
+	}
+
+	return cfg, nil
+}
+
+// shortenerServer the gRPC Shortener service. It creates links through the
+// same linkCreator as POST /api/v1/shorten and reads and deletes them like
+// the REST handlers, so both APIs share one storage path
+type shortenerServer struct {
+	fastv1.UnimplementedShortenerServer
+
+	creator  *linkCreator
+	dbConn   db.Querier
+	dbReader db.Querier
+	cache    *RedirectCache
+	keys     []APIKey
+	sugar    *zap.SugaredLogger
+}
+
+// newGRPCServer a gRPC server with the Shortener service registered
+func newGRPCServer(shortener *shortenerServer) *grpc.Server {
+	server := grpc.NewServer()
+	fastv1.RegisterShortenerServer(server, shortener)
+	return server
+}
+
+// apiKey the API key a call was made with, taken from the x-api-key
+// metadata like the X-API-Key header. Calls without one are anonymous, an
+// unknown key is rejected
+func (s *shortenerServer) apiKey(ctx context.Context) (APIKey, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	presented := md.Get(strings.ToLower(apiKeyHeader))
+	if len(presented) == 0 || presented[0] == "" {
+		return APIKey{}, nil
+	}
+	key, ok := findAPIKey(s.keys, presented[0])
+	if !ok {
+		return APIKey{}, status.Error(codes.Unauthenticated, "invalid api key")
+	}
+	return key, nil
+}
+
+// grpcCode the gRPC code for an HTTP status the REST API would answer with
+func grpcCode(httpStatus int) codes.Code {
+	switch httpStatus {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.AlreadyExists
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusServiceUnavailable:
+		return codes.Unavailable
+	default:
+		return codes.Internal
+	}
+}
+
+// Shorten create a short link, like POST /api/v1/shorten
+func (s *shortenerServer) Shorten(ctx context.Context, req *fastv1.ShortenRequest) (*fastv1.ShortenResponse, error) {
+	key, err := s.apiKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	link, err := s.creator.create(ctx, ShortenURLRequest{
+		URL:          req.GetUrl(),
+		Title:        req.GetTitle(),
+		Description:  req.GetDescription(),
+		Alias:        req.GetAlias(),
+		Source:       req.GetSource(),
+		RedirectType: req.GetRedirectType(),
+	}, creation{Key: key, IdempotencyKey: req.GetIdempotencyKey()}, s.sugar)
+	if err != nil {
+		var cerr *creationError
+		if !errors.As(err, &cerr) {
+			cerr = errCreatingLink
+		}
+		return nil, status.Error(grpcCode(cerr.Status), cerr.Msg)
+	}
+	s.sugar.Infow("created new url", "uri", link.URI, "shorten_url", link.ShortenLongURL, "api", "grpc")
+
+	return &fastv1.ShortenResponse{
+		Uri:            link.URI,
+		ShortenUrl:     link.ShortenURL,
+		ShortenLongUrl: link.ShortenLongURL,
+		Formats: &fastv1.ShortURLFormats{
+			Bare:   link.Formats.Bare,
+			Domain: link.Formats.Domain,
+			Http:   link.Formats.HTTP,
+			Https:  link.Formats.HTTPS,
+			Full:   link.Formats.Full,
+		},
+		Title:       link.Title,
+		Description: link.Description,
+	}, nil
+}
+
+// Resolve where a short link points, like GET /api/v1/urls/:uri
+func (s *shortenerServer) Resolve(ctx context.Context, req *fastv1.ResolveRequest) (*fastv1.ResolveResponse, error) {
+	if _, err := s.apiKey(ctx); err != nil {
+		return nil, err
+	}
+
+	link, err := loadRedirectLink(ctx, s.dbReader, req.GetUri(), s.creator.caseInsensitive)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, status.Error(codes.NotFound, "uri not found")
+	}
+	if err != nil {
+		s.sugar.Errorf("error retrieving URI: %s", err)
+		return nil, status.Error(codes.Internal, "error retrieving URI")
+	}
+
+	return &fastv1.ResolveResponse{
+		Uri:          link.URI,
+		OriginalUrl:  link.OriginalURL,
+		RedirectType: link.RedirectType,
+	}, nil
+}
+
+// Delete remove a short link, like DELETE /api/v1/urls/:uri
+func (s *shortenerServer) Delete(ctx context.Context, req *fastv1.DeleteRequest) (*fastv1.DeleteResponse, error) {
+	key, err := s.apiKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if key.ID == "" {
+		return nil, status.Error(codes.Unauthenticated, "api key required")
+	}
+	owner := key.ID
+	if key.Admin {
+		owner = ""
+	}
+
+	uri, err := deleteLink(ctx, s.dbConn, s.cache, req.GetUri(), owner, key.ID, s.creator.caseInsensitive)
+	if err != nil {
+		s.sugar.Errorf("error deleting URI: %s", err)
+		return nil, status.Error(codes.Internal, "error deleting URI")
+	}
+	if uri == "" {
+		return nil, status.Error(codes.NotFound, "uri not found")
+	}
+
+	return &fastv1.DeleteResponse{Deleted: true}, nil
+}
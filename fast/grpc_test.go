@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	fastv1 "github.com/aeekayy/systems/fast/proto/fast/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// testShortener a Shortener client talking to a server over fake
+func testShortener(t *testing.T, fake *fakeDB) fastv1.ShortenerClient {
+	t.Helper()
+	listener := bufconn.Listen(1 << 20)
+	server := newGRPCServer(&shortenerServer{
+		creator:  testCreator(fake),
+		dbConn:   fake,
+		dbReader: fake,
+		cache:    NewRedirectCache(10),
+		keys:     testAPIKeys,
+		sugar:    testSugar,
+	})
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return listener.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("couldn't dial the grpc server: %s", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return fastv1.NewShortenerClient(conn)
+}
+
+// withKey a context whose calls carry key
+func withKey(key APIKey) context.Context {
+	if key.Key == "" {
+		return context.Background()
+	}
+	return metadata.AppendToOutgoingContext(context.Background(), "x-api-key", key.Key)
+}
+
+func TestGRPCShorten(t *testing.T) {
+	tests := []struct {
+		name  string
+		key   APIKey
+		req   *fastv1.ShortenRequest
+		code  codes.Code
+		owner string
+	}{
+		{"anonymous", APIKey{}, &fastv1.ShortenRequest{Url: "https://example.com/a"}, codes.OK, ""},
+		{"owned", testOwnerKey, &fastv1.ShortenRequest{Url: "https://example.com/a", Alias: "launch"}, codes.OK, testOwnerKey.ID},
+		{"unknown key", APIKey{Key: "nope"}, &fastv1.ShortenRequest{Url: "https://example.com/a"}, codes.Unauthenticated, ""},
+		{"invalid url", testOwnerKey, &fastv1.ShortenRequest{Url: "example"}, codes.InvalidArgument, ""},
+		{"own short link", testOwnerKey, &fastv1.ShortenRequest{Url: "https://fa.st/abc"}, codes.InvalidArgument, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := (&fakeDB{}).on("SELECT EXISTS", fakeResult{rows: [][]interface{}{{false}}}).onFunc("INSERT INTO urls", insertedLinks)
+			client := testShortener(t, fake)
+
+			resp, err := client.Shorten(withKey(tt.key), tt.req)
+			if status.Code(err) != tt.code {
+				t.Fatalf("Shorten() = %v, want %s", err, tt.code)
+			}
+			if tt.code != codes.OK {
+				if inserts := fake.statements("INSERT INTO urls"); len(inserts) != 0 {
+					t.Errorf("rejected request inserted %d links", len(inserts))
+				}
+				return
+			}
+			if tt.req.Alias != "" && resp.Uri != tt.req.Alias {
+				t.Errorf("uri = %s, want %s", resp.Uri, tt.req.Alias)
+			}
+			if resp.ShortenLongUrl != "https://fa.st/"+resp.Uri {
+				t.Errorf("shorten_long_url = %s, want https://fa.st/%s", resp.ShortenLongUrl, resp.Uri)
+			}
+			inserts := fake.statements("INSERT INTO urls")
+			if len(inserts) != 1 || inserts[0].args[18] != tt.owner {
+				t.Errorf("inserts = %v, want one owned by %q", inserts, tt.owner)
+			}
+		})
+	}
+}
+
+func TestGRPCResolve(t *testing.T) {
+	fake := (&fakeDB{}).onFunc("SELECT uri", func(args []interface{}) fakeResult {
+		if args[0] != "launch" {
+			return fakeResult{}
+		}
+		return fakeResult{rows: [][]interface{}{redirectRow("launch", "https://example.com/a")}}
+	})
+	client := testShortener(t, fake)
+
+	resp, err := client.Resolve(context.Background(), &fastv1.ResolveRequest{Uri: "launch"})
+	if err != nil {
+		t.Fatalf("Resolve() = %v", err)
+	}
+	if resp.OriginalUrl != "https://example.com/a" {
+		t.Errorf("original_url = %s, want https://example.com/a", resp.OriginalUrl)
+	}
+	if _, err := client.Resolve(context.Background(), &fastv1.ResolveRequest{Uri: "missing"}); status.Code(err) != codes.NotFound {
+		t.Errorf("Resolve(missing) = %v, want %s", err, codes.NotFound)
+	}
+}
+
+func TestGRPCDelete(t *testing.T) {
+	tests := []struct {
+		name string
+		key  APIKey
+		code codes.Code
+	}{
+		{"no key", APIKey{}, codes.Unauthenticated},
+		{"owner", testOwnerKey, codes.OK},
+		{"admin", testAdminKey, codes.OK},
+		{"someone else", testOtherKey, codes.NotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := (&fakeDB{}).onFunc("WITH deleted AS", ownedLink("launch", testOwnerKey.ID, 1))
+			client := testShortener(t, fake)
+
+			resp, err := client.Delete(withKey(tt.key), &fastv1.DeleteRequest{Uri: "launch"})
+			if status.Code(err) != tt.code {
+				t.Fatalf("Delete() = %v, want %s", err, tt.code)
+			}
+			if tt.code == codes.OK && !resp.Deleted {
+				t.Error("deleted = false, want true")
+			}
+		})
+	}
+}
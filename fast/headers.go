@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+)
+
+// SecurityHeadersConfig the security headers added to every response. An
+// empty value leaves that header out. HSTS is opt in since it only makes
+// sense once the service is always reached over https
+type SecurityHeadersConfig struct {
+	Enabled               bool   `mapstructure:"enabled" yaml:"enabled"`
+	ContentTypeOptions    string `mapstructure:"content_type_options" yaml:"content_type_options"`
+	FrameOptions          string `mapstructure:"frame_options" yaml:"frame_options"`
+	ReferrerPolicy        string `mapstructure:"referrer_policy" yaml:"referrer_policy"`
+	HSTS                  bool   `mapstructure:"hsts" yaml:"hsts"`
+	HSTSMaxAge            int    `mapstructure:"hsts_max_age" yaml:"hsts_max_age"`
+	HSTSIncludeSubdomains bool   `mapstructure:"hsts_include_subdomains" yaml:"hsts_include_subdomains"`
+}
+
+// loadSecurityHeadersConfig read the security header settings for the
+// environment, starting from safe defaults
+func loadSecurityHeadersConfig(env string) (SecurityHeadersConfig, error) {
+	cfg := SecurityHeadersConfig{
+		Enabled:            true,
+		ContentTypeOptions: "nosniff",
+		FrameOptions:       "DENY",
+		ReferrerPolicy:     "no-referrer",
+		HSTSMaxAge:         31536000,
+	}
+	key := fmt.Sprintf("%s.security_headers", env)
+	if viper.IsSet(key) {
+		if err := viper.UnmarshalKey(key, &cfg); err != nil {
+			return cfg, fmt.Errorf("couldn't read security headers configuration: %w", err)
+		}
+	}
+
+	return cfg, nil
+}
+
+// securityHeaders add the configured security headers to every response
+func securityHeaders(cfg SecurityHeadersConfig) gin.HandlerFunc {
+	headers := map[string]string{}
+	if cfg.Enabled {
+		if cfg.ContentTypeOptions != "" {
+			headers["X-Content-Type-Options"] = cfg.ContentTypeOptions
+		}
+		if cfg.FrameOptions != "" {
+			headers["X-Frame-Options"] = cfg.FrameOptions
+		}
+		if cfg.ReferrerPolicy != "" {
+			headers["Referrer-Policy"] = cfg.ReferrerPolicy
+		}
+		if cfg.HSTS {
+			hsts := "max-age=" + strconv.Itoa(cfg.HSTSMaxAge)
+			if cfg.HSTSIncludeSubdomains {
+				hsts += "; includeSubDomains"
+			}
+			headers["Strict-Transport-Security"] = hsts
+		}
+	}
+
+	return func(c *gin.Context) {
+		for name, value := range headers {
+			c.Header(name, value)
+		}
+		c.Next()
+	}
+}
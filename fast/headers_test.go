@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	defaults := map[string]string{
+		"X-Content-Type-Options":    "nosniff",
+		"X-Frame-Options":           "DENY",
+		"Referrer-Policy":           "no-referrer",
+		"Strict-Transport-Security": "",
+	}
+
+	tests := []struct {
+		name string
+		cfg  map[string]interface{}
+		want map[string]string
+	}{
+		{"defaults", nil, defaults},
+		{"hsts", map[string]interface{}{"hsts": true, "hsts_include_subdomains": true}, map[string]string{
+			"X-Frame-Options":           "DENY",
+			"Strict-Transport-Security": "max-age=31536000; includeSubDomains",
+		}},
+		{"header left out", map[string]interface{}{"frame_options": "", "referrer_policy": "same-origin"}, map[string]string{
+			"X-Content-Type-Options": "nosniff",
+			"X-Frame-Options":        "",
+			"Referrer-Policy":        "same-origin",
+		}},
+		{"disabled", map[string]interface{}{"enabled": false, "hsts": true}, map[string]string{
+			"X-Content-Type-Options":    "",
+			"X-Frame-Options":           "",
+			"Referrer-Policy":           "",
+			"Strict-Transport-Security": "",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.cfg != nil {
+				withConfig(t, "test.security_headers", tt.cfg)
+			}
+			cfg, err := loadSecurityHeadersConfig("test")
+			if err != nil {
+				t.Fatalf("loadSecurityHeadersConfig() = %v", err)
+			}
+			r := gin.New()
+			r.Use(securityHeaders(cfg))
+			r.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+			w := serve(r, http.MethodGet, "/", APIKey{}, "")
+
+			for name, want := range tt.want {
+				if got := w.Header().Get(name); got != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+)
+
+const (
+	healthFormatJSON     = "json"
+	healthFormatText     = "text"
+	defaultHealthTimeout = 2 * time.Second // How long /healthz waits on the database
+)
+
+// HealthConfig the shape of the /api/v1/ping and /healthz responses, since
+// monitoring tools disagree on what a healthy response looks like. Text
+// responds with the plain OK or Fail body for the status, JSON with an
+// object
+type HealthConfig struct {
+	Format  string        `mapstructure:"format" yaml:"format"`
+	OK      string        `mapstructure:"ok" yaml:"ok"`
+	Fail    string        `mapstructure:"fail" yaml:"fail"`
+	Timeout time.Duration `mapstructure:"timeout" yaml:"timeout"`
+}
+
+// loadHealthConfig read the health check settings for the environment
+func loadHealthConfig(env string) (HealthConfig, error) {
+	cfg := HealthConfig{Format: healthFormatJSON, OK: "ok", Fail: "unavailable"}
+	if err := viper.UnmarshalKey(fmt.Sprintf("%s.health", env), &cfg); err != nil {
+		return cfg, fmt.Errorf("couldn't read health configuration: %w", err)
+	}
+	if cfg.Format != healthFormatJSON && cfg.Format != healthFormatText {
+		return cfg, fmt.Errorf("health format must be %s or %s, not %s", healthFormatJSON, healthFormatText, cfg.Format)
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultHealthTimeout
+	}
+
+	return cfg, nil
+}
+
+// pingHandler answer liveness checks. Text mode writes the configured OK
+// body, the JSON response stays {"message": "pong"} for the clients that
+// already rely on it
+func pingHandler(cfg HealthConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if cfg.Format == healthFormatText {
+			c.String(http.StatusOK, cfg.OK)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message": "pong",
+		})
+	}
+}
+
+// healthzHandler answer readiness checks, which fail with a 503 while the
+// database can't be reached
+func healthzHandler(cfg HealthConfig, ping func(context.Context) error) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), cfg.Timeout)
+		defer cancel()
+
+		status, body := http.StatusOK, cfg.OK
+		err := ping(ctx)
+		if err != nil {
+			status, body = http.StatusServiceUnavailable, cfg.Fail
+		}
+
+		if cfg.Format == healthFormatText {
+			c.String(status, body)
+			return
+		}
+		response := gin.H{
+			"status": body,
+		}
+		if err != nil {
+			response["error"] = "database unreachable"
+		}
+		c.JSON(status, response)
+	}
+}
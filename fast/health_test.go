@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+func TestPingHandler(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  HealthConfig
+		body string
+	}{
+		{"json", HealthConfig{Format: healthFormatJSON, OK: "ok"}, `{"message":"pong"}`},
+		{"text uses the ok body", HealthConfig{Format: healthFormatText, OK: "healthy"}, "healthy"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			r.GET("/api/v1/ping", pingHandler(tt.cfg))
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/ping", nil))
+
+			if w.Code != http.StatusOK {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusOK)
+			}
+			if got := w.Body.String(); got != tt.body {
+				t.Errorf("body = %q, want %q", got, tt.body)
+			}
+		})
+	}
+}
+
+func TestHealthzHandler(t *testing.T) {
+	up := func(context.Context) error { return nil }
+	down := func(context.Context) error { return errors.New("connection refused") }
+
+	tests := []struct {
+		name   string
+		cfg    HealthConfig
+		ping   func(context.Context) error
+		status int
+		body   string
+	}{
+		{"json up", HealthConfig{Format: healthFormatJSON, OK: "ok", Fail: "unavailable"}, up, http.StatusOK, `{"status":"ok"}`},
+		{"json down", HealthConfig{Format: healthFormatJSON, OK: "ok", Fail: "unavailable"}, down, http.StatusServiceUnavailable, `{"error":"database unreachable","status":"unavailable"}`},
+		{"text up", HealthConfig{Format: healthFormatText, OK: "OK", Fail: "FAIL"}, up, http.StatusOK, "OK"},
+		{"text down", HealthConfig{Format: healthFormatText, OK: "OK", Fail: "FAIL"}, down, http.StatusServiceUnavailable, "FAIL"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.Timeout = time.Second
+			r := gin.New()
+			r.GET("/healthz", healthzHandler(tt.cfg, tt.ping))
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+			if got := w.Body.String(); got != tt.body {
+				t.Errorf("body = %q, want %q", got, tt.body)
+			}
+		})
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/aeekayy/systems/fast/db"
+	"github.com/gin-gonic/gin"
+	pgx "github.com/jackc/pgx/v4"
+	"go.uber.org/zap"
+)
+
+// UpdateURLRequest the changes to an existing short link, its new
+// destination, its notes or both. Notes are private to the owner and an
+// empty string clears them
+type UpdateURLRequest struct {
+	URL   string  `json:"url,omitempty" yaml:"url,omitempty"`
+	Notes *string `json:"notes,omitempty" yaml:"notes,omitempty"`
+}
+
+// DestinationChange a change of a short link's destination
+type DestinationChange struct {
+	OriginalURL string    `json:"original_url" yaml:"original_url"`
+	PreviousURL string    `json:"previous_url,omitempty" yaml:"previous_url,omitempty"`
+	Actor       string    `json:"actor,omitempty" yaml:"actor,omitempty"`
+	Created     time.Time `json:"created" yaml:"created"`
+}
+
+// updateURLHandler point an existing short link at a new destination or
+// change its notes. Admins may update any link, other keys only the links
+// they own. A destination change is written to the link's history and the
+// audit log along with the update so every destination a link had can be
+// looked up, and a link disabled for its dead destination works again. The
+// link is dropped from the redirect cache so the new destination is used
+// right away
+func updateURLHandler(ctx context.Context, dbConn db.Querier, rc *RedirectCache, caseInsensitive bool, ownHost string, blocked []string, idn IDNConfig, sugar *zap.SugaredLogger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sugar := requestSugar(c, sugar)
+		var json UpdateURLRequest
+		if err := c.ShouldBindJSON(&json); err != nil {
+			bindError(c, err)
+			return
+		}
+		if json.URL == "" && json.Notes == nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "error updating URL: url or notes is required",
+			})
+			return
+		}
+		if json.URL != "" {
+			normalized, err := normalizeDestination(idn, json.URL)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": fmt.Sprintf("error updating URL: %s", err),
+				})
+				return
+			}
+			json.URL = normalized
+			if _, err := url.ParseRequestURI(json.URL); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": fmt.Sprintf("error updating URL: couldn't parse url: %s", err),
+				})
+				return
+			}
+			if err := checkShortenerChain(json.URL, ownHost, blocked); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": fmt.Sprintf("error updating URL: %s", err),
+				})
+				return
+			}
+		}
+
+		// someone else's link is reported as not found so keys can't probe for them
+		key, _ := currentAPIKey(c)
+		owner := key.ID
+		if key.Admin {
+			owner = ""
+		}
+		var notes string
+		if json.Notes != nil {
+			notes = *json.Notes
+		}
+
+		var uri, originalURL string
+		err := dbConn.QueryRow(ctx, `WITH old AS (
+				SELECT id, uri, original_url FROM `+urlsTable+` WHERE `+uriCondition(caseInsensitive)+` AND ($5 = '' OR owner = $5) LIMIT 1 FOR UPDATE
+			), updated AS (
+				UPDATE `+urlsTable+` AS u SET original_url = COALESCE(NULLIF($2, ''), u.original_url),
+					notes = CASE WHEN $6 THEN NULLIF($7, '') ELSE u.notes END,
+					health_failures = CASE WHEN $2 <> '' THEN 0 ELSE u.health_failures END,
+					disabled = CASE WHEN $2 <> '' THEN NULL ELSE u.disabled END
+				FROM old WHERE u.id = old.id RETURNING u.uri, u.original_url
+			), history AS (
+				INSERT INTO url_history(uri, original_url, previous_url, actor)
+				SELECT uri, $2, original_url, NULLIF($3, '') FROM old WHERE $2 <> '' AND original_url <> $2
+			), audit AS (
+				INSERT INTO audit_log(action, uri, actor) SELECT $4, uri, NULLIF($3, '') FROM old
+			)
+			SELECT uri, original_url FROM updated;`, c.Param("uri"), json.URL, actorID(c), auditActionUpdate, owner, json.Notes != nil, notes).Scan(&uri, &originalURL)
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "uri not found",
+			})
+			return
+		}
+		if err != nil {
+			sugar.Errorf("error updating URL: %s", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "error updating URL",
+			})
+			return
+		}
+		rc.Delete(cacheKey(uri, caseInsensitive))
+
+		data := gin.H{
+			"uri":          uri,
+			"original_url": originalURL,
+		}
+		if json.Notes != nil {
+			data["notes"] = notes
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"data": data,
+		})
+	}
+}
+
+// urlHistoryHandler list the destination changes of a short link, oldest
+// first. Only the owner of the link or an admin key can see them
+func urlHistoryHandler(ctx context.Context, dbConn db.Querier, caseInsensitive bool, sugar *zap.SugaredLogger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sugar := requestSugar(c, sugar)
+		key, _ := currentAPIKey(c)
+		owner := key.ID
+		if key.Admin {
+			owner = ""
+		}
+
+		var uri string
+		err := dbConn.QueryRow(ctx, "SELECT uri FROM "+urlsTable+" WHERE "+uriCondition(caseInsensitive)+" AND ($2 = '' OR owner = $2) LIMIT 1;", c.Param("uri"), owner).Scan(&uri)
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "uri not found",
+			})
+			return
+		}
+		if err != nil {
+			sugar.Errorf("error retrieving URI: %s", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "error retrieving history",
+			})
+			return
+		}
+
+		rows, err := dbConn.Query(ctx, "SELECT original_url, COALESCE(previous_url, ''), COALESCE(actor, ''), created FROM url_history WHERE uri = $1 ORDER BY created, id;", uri)
+		if err != nil {
+			sugar.Errorf("error retrieving history: %s", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "error retrieving history",
+			})
+			return
+		}
+		defer rows.Close()
+
+		changes := []DestinationChange{}
+		for rows.Next() {
+			var change DestinationChange
+			if err := rows.Scan(&change.OriginalURL, &change.PreviousURL, &change.Actor, &change.Created); err != nil {
+				sugar.Errorf("error reading history: %s", err)
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"error": "error retrieving history",
+				})
+				return
+			}
+			changes = append(changes, change)
+		}
+		if err := rows.Err(); err != nil {
+			sugar.Errorf("error reading history: %s", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "error retrieving history",
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"data": changes,
+		})
+	}
+}
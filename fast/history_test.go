@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestURLHistoryHandler(t *testing.T) {
+	changed := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	fake := (&fakeDB{}).
+		onFunc("SELECT uri FROM", ownedLink("launch", testOwnerKey.ID, 1)).
+		on("FROM url_history", fakeResult{rows: [][]interface{}{{"https://example.com/b", "https://example.com/a", "acme", changed}}})
+
+	r := testRouter()
+	r.GET("/api/v1/urls/:uri/history", requireAPIKey, urlHistoryHandler(context.Background(), fake, false, testSugar))
+
+	tests := []struct {
+		name   string
+		uri    string
+		key    APIKey
+		status int
+	}{
+		{"anonymous", "launch", APIKey{}, http.StatusUnauthorized},
+		{"owner", "launch", testOwnerKey, http.StatusOK},
+		{"admin", "launch", testAdminKey, http.StatusOK},
+		{"another key", "launch", testOtherKey, http.StatusNotFound},
+		{"unknown link", "missing", testOwnerKey, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(r, http.MethodGet, "/api/v1/urls/"+tt.uri+"/history", tt.key, "")
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status == http.StatusOK && !strings.Contains(w.Body.String(), `"previous_url":"https://example.com/a"`) {
+				t.Errorf("body = %s, want the change", w.Body)
+			}
+		})
+	}
+}
+
+func TestUpdateURLHandler(t *testing.T) {
+	tests := []struct {
+		name   string
+		key    APIKey
+		body   string
+		status int
+		notes  interface{}
+		setsTo bool
+	}{
+		{"new destination", testOwnerKey, `{"url": "https://example.com/b"}`, http.StatusOK, nil, false},
+		{"notes", testOwnerKey, `{"notes": "renew the domain in June"}`, http.StatusOK, "renew the domain in June", true},
+		{"clear the notes", testOwnerKey, `{"notes": ""}`, http.StatusOK, "", true},
+		{"admin", testAdminKey, `{"notes": "checked"}`, http.StatusOK, "checked", true},
+		{"another key", testOtherKey, `{"notes": "mine now"}`, http.StatusNotFound, nil, false},
+		{"nothing to change", testOwnerKey, `{}`, http.StatusBadRequest, nil, false},
+		{"bad destination", testOwnerKey, `{"url": "example"}`, http.StatusBadRequest, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := (&fakeDB{}).onFunc("WITH old AS", func(args []interface{}) fakeResult {
+				if args[0] != "launch" || (args[4] != "" && args[4] != testOwnerKey.ID) {
+					return fakeResult{}
+				}
+				destination := "https://example.com/a"
+				if args[1] != "" {
+					destination = args[1].(string)
+				}
+				return fakeResult{rows: [][]interface{}{{"launch", destination}}}
+			})
+			r := testRouter()
+			r.PUT("/api/v1/urls/:uri", requireAPIKey, updateURLHandler(context.Background(), fake, NewRedirectCache(10), false, "fa.st", nil, IDNConfig{}, testSugar))
+			w := serve(r, http.MethodPut, "/api/v1/urls/launch", tt.key, tt.body)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			args := fake.statements("WITH old AS")[0].args
+			if args[5] != tt.setsTo || (tt.setsTo && args[6] != tt.notes) {
+				t.Errorf("set notes = %v to %q, want %t to %v", args[5], args[6], tt.setsTo, tt.notes)
+			}
+			var body struct {
+				Data map[string]interface{} `json:"data"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("couldn't decode %s: %s", w.Body, err)
+			}
+			if got := body.Data["notes"]; got != tt.notes {
+				t.Errorf("notes = %v, want %v", got, tt.notes)
+			}
+			if body.Data["original_url"] == "" {
+				t.Errorf("body = %s, want the destination", w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aeekayy/systems/fast/db"
+	"github.com/gin-gonic/gin"
+	pgx "github.com/jackc/pgx/v4"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultAliasHoldTTL    = time.Hour          // How long an alias is held when no ttl is given
+	defaultAliasHoldMaxTTL = 7 * 24 * time.Hour // The longest an alias may be held for
+	aliasHeldCode          = "alias_held"       // The error code when someone else holds an alias
+	claimedAliasContextKey = "claimed_alias"    // Where the alias being claimed is stored in the gin context
+)
+
+// AliasHoldConfig how long aliases may be held before a link is created
+// with them. TTL is used when a hold doesn't ask for one
+type AliasHoldConfig struct {
+	TTL    time.Duration `mapstructure:"ttl" yaml:"ttl"`
+	MaxTTL time.Duration `mapstructure:"max_ttl" yaml:"max_ttl"`
+}
+
+// AliasHoldRequest the alias to hold and for how long
+type AliasHoldRequest struct {
+	Alias string `json:"alias" yaml:"alias"`
+	TTL   *TTL   `json:"ttl,omitempty" yaml:"ttl,omitempty"`
+}
+
+// AliasHold an alias held for an API key until it expires
+type AliasHold struct {
+	Alias   string    `json:"alias" yaml:"alias"`
+	Owner   string    `json:"owner" yaml:"owner"`
+	Expires time.Time `json:"expires" yaml:"expires"`
+}
+
+// loadAliasHoldConfig read the alias hold settings for the environment
+func loadAliasHoldConfig(env string) (AliasHoldConfig, error) {
+	var cfg AliasHoldConfig
+	if err := viper.UnmarshalKey(fmt.Sprintf("%s.alias.hold", env), &cfg); err != nil {
+		return cfg, fmt.Errorf("couldn't read alias hold configuration: %w", err)
+	}
+	if cfg.MaxTTL <= 0 {
+		cfg.MaxTTL = defaultAliasHoldMaxTTL
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = defaultAliasHoldTTL
+	}
+	if cfg.TTL > cfg.MaxTTL {
+		return cfg, errors.New("alias hold ttl can't be more than max_ttl")
+	}
+
+	return cfg, nil
+}
+
+// holdKey the alias as holds are stored, which matches the way links are
+// looked up
+func holdKey(alias string, caseInsensitive bool) string {
+	if caseInsensitive {
+		return strings.ToLower(alias)
+	}
+	return alias
+}
+
+// aliasHolder the API key holding an alias, empty when nobody holds it or
+// the hold has expired
+func aliasHolder(ctx context.Context, dbConn db.Querier, alias string, caseInsensitive bool) (string, error) {
+	var owner string
+	err := dbConn.QueryRow(ctx, "SELECT owner FROM alias_holds WHERE alias = $1 AND expires > now();", holdKey(alias, caseInsensitive)).Scan(&owner)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+	return owner, err
+}
+
+// releaseHold drop the hold on an alias once a link was created with it
+func releaseHold(ctx context.Context, dbConn db.Querier, alias string, caseInsensitive bool) error {
+	_, err := dbConn.Exec(ctx, "DELETE FROM alias_holds WHERE alias = $1;", holdKey(alias, caseInsensitive))
+	return err
+}
+
+// holdAliasHandler hold an alias for the API key so nobody else can create
+// a link with it until the hold expires, like ahead of a launch. Holding an
+// alias the key already holds extends the hold, and an expired hold of
+// someone else's can be taken over
+func holdAliasHandler(ctx context.Context, dbConn db.Querier, cfg AliasHoldConfig, aliasMinLength int, reserved map[string]ReservedHandler, namespaces, caseInsensitive bool, sugar *zap.SugaredLogger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sugar := requestSugar(c, sugar)
+		var json AliasHoldRequest
+		if err := c.ShouldBindJSON(&json); err != nil {
+			bindError(c, err)
+			return
+		}
+		if json.Alias == "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "alias is required",
+			})
+			return
+		}
+		if err := ValidateAlias(json.Alias, aliasMinLength, reserved, namespaces); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("error holding alias: %s", err),
+			})
+			return
+		}
+		ttl := cfg.TTL
+		if json.TTL != nil {
+			ttl = time.Duration(*json.TTL)
+		}
+		if ttl <= 0 || ttl > cfg.MaxTTL {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "ttl must be positive and at most " + cfg.MaxTTL.String(),
+			})
+			return
+		}
+
+		var taken bool
+		if err := dbConn.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM "+urlsTable+" WHERE "+uriCondition(caseInsensitive)+");", json.Alias).Scan(&taken); err != nil {
+			sugar.Errorf("error checking alias: %s", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "error holding alias",
+			})
+			return
+		}
+		if taken {
+			c.JSON(http.StatusConflict, gin.H{
+				"error": "alias is already in use",
+			})
+			return
+		}
+
+		hold := AliasHold{Alias: json.Alias, Owner: actorID(c)}
+		err := dbConn.QueryRow(ctx, `INSERT INTO alias_holds(alias, owner, expires) VALUES($1, $2, $3)
+			ON CONFLICT (alias) DO UPDATE SET owner = EXCLUDED.owner, expires = EXCLUDED.expires, created = now()
+			WHERE alias_holds.expires <= now() OR alias_holds.owner = EXCLUDED.owner
+			RETURNING expires;`, holdKey(json.Alias, caseInsensitive), hold.Owner, time.Now().Add(ttl)).Scan(&hold.Expires)
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.JSON(http.StatusConflict, gin.H{
+				"error": "alias is held by someone else",
+				"code":  aliasHeldCode,
+			})
+			return
+		}
+		if err != nil {
+			sugar.Errorf("error holding alias: %s", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "error holding alias",
+			})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{
+			"data": hold,
+		})
+	}
+}
+
+// requireHold only let a claim through while the API key holds the alias
+// in the path, handing the alias on to the shorten handler
+func requireHold(ctx context.Context, dbConn db.Querier, caseInsensitive bool, sugar *zap.SugaredLogger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sugar := requestSugar(c, sugar)
+		alias := c.Param("alias")
+		holder, err := aliasHolder(ctx, dbConn, alias, caseInsensitive)
+		if err != nil {
+			sugar.Errorf("error checking alias hold: %s", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+				"error": "error claiming alias",
+			})
+			return
+		}
+		if holder == "" || holder != actorID(c) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+				"error": "no hold on the alias, or it has expired",
+			})
+			return
+		}
+
+		c.Set(claimedAliasContextKey, alias)
+		c.Next()
+	}
+}
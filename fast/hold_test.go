@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// holdStore answer alias hold statements like the database: a hold can be
+// taken over once it expired, or extended by the key holding it
+type holdStore struct {
+	holds map[string]AliasHold
+}
+
+func (s *holdStore) hold(args []interface{}) fakeResult {
+	alias, owner, expires := args[0].(string), args[1].(string), args[2].(time.Time)
+	if existing, ok := s.holds[alias]; ok && existing.Expires.After(time.Now()) && existing.Owner != owner {
+		return fakeResult{}
+	}
+	s.holds[alias] = AliasHold{Alias: alias, Owner: owner, Expires: expires}
+	return fakeResult{rows: [][]interface{}{{expires}}}
+}
+
+func (s *holdStore) holder(args []interface{}) fakeResult {
+	existing, ok := s.holds[args[0].(string)]
+	if !ok || !existing.Expires.After(time.Now()) {
+		return fakeResult{}
+	}
+	return fakeResult{rows: [][]interface{}{{existing.Owner}}}
+}
+
+func TestLoadAliasHoldConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     map[string]interface{}
+		want    AliasHoldConfig
+		wantErr bool
+	}{
+		{"defaults", nil, AliasHoldConfig{TTL: defaultAliasHoldTTL, MaxTTL: defaultAliasHoldMaxTTL}, false},
+		{"configured", map[string]interface{}{"ttl": "30m", "max_ttl": "24h"}, AliasHoldConfig{TTL: 30 * time.Minute, MaxTTL: 24 * time.Hour}, false},
+		{"ttl over the max", map[string]interface{}{"ttl": "48h", "max_ttl": "24h"}, AliasHoldConfig{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.cfg != nil {
+				withConfig(t, "test.alias.hold", tt.cfg)
+			}
+			cfg, err := loadAliasHoldConfig("test")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadAliasHoldConfig() = %v, want error %t", err, tt.wantErr)
+			}
+			if !tt.wantErr && cfg != tt.want {
+				t.Errorf("loadAliasHoldConfig() = %+v, want %+v", cfg, tt.want)
+			}
+		})
+	}
+}
+
+func TestHoldAliasHandler(t *testing.T) {
+	cfg := AliasHoldConfig{TTL: time.Hour, MaxTTL: 24 * time.Hour}
+	tests := []struct {
+		name   string
+		key    APIKey
+		body   string
+		held   *AliasHold
+		taken  bool
+		status int
+		code   string
+		ttl    time.Duration
+	}{
+		{"anonymous", APIKey{}, `{"alias": "launch"}`, nil, false, http.StatusUnauthorized, "", 0},
+		{"default ttl", testOwnerKey, `{"alias": "Launch"}`, nil, false, http.StatusCreated, "", time.Hour},
+		{"own ttl", testOwnerKey, `{"alias": "launch", "ttl": "2h"}`, nil, false, http.StatusCreated, "", 2 * time.Hour},
+		{"ttl over the max", testOwnerKey, `{"alias": "launch", "ttl": "48h"}`, nil, false, http.StatusBadRequest, "", 0},
+		{"no alias", testOwnerKey, `{}`, nil, false, http.StatusBadRequest, "", 0},
+		{"bad alias", testOwnerKey, `{"alias": "a"}`, nil, false, http.StatusBadRequest, "", 0},
+		{"in use", testOwnerKey, `{"alias": "launch"}`, nil, true, http.StatusConflict, "", 0},
+		{"held by someone else", testOwnerKey, `{"alias": "launch"}`, &AliasHold{Owner: testOtherKey.ID, Expires: time.Now().Add(time.Hour)}, false, http.StatusConflict, aliasHeldCode, 0},
+		{"someone else's hold expired", testOwnerKey, `{"alias": "launch"}`, &AliasHold{Owner: testOtherKey.ID, Expires: time.Now().Add(-time.Minute)}, false, http.StatusCreated, "", time.Hour},
+		{"extending own hold", testOwnerKey, `{"alias": "launch", "ttl": "3h"}`, &AliasHold{Owner: testOwnerKey.ID, Expires: time.Now().Add(time.Minute)}, false, http.StatusCreated, "", 3 * time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &holdStore{holds: map[string]AliasHold{}}
+			if tt.held != nil {
+				store.holds["launch"] = *tt.held
+			}
+			fake := (&fakeDB{}).
+				on("SELECT EXISTS", fakeResult{rows: [][]interface{}{{tt.taken}}}).
+				onFunc("INSERT INTO alias_holds", store.hold)
+			r := testRouter()
+			r.POST("/api/v1/holds", requireAPIKey, holdAliasHandler(context.Background(), fake, cfg, 3, nil, false, true, testSugar))
+			w := serve(r, http.MethodPost, "/api/v1/holds", tt.key, tt.body)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+
+			var body struct {
+				Data AliasHold `json:"data"`
+				Code string    `json:"code"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("couldn't decode %s: %s", w.Body, err)
+			}
+			if body.Code != tt.code {
+				t.Errorf("code = %q, want %q", body.Code, tt.code)
+			}
+			if tt.status != http.StatusCreated {
+				return
+			}
+			hold, ok := store.holds["launch"]
+			if !ok || hold.Owner != tt.key.ID {
+				t.Fatalf("holds = %+v, want launch held by %s", store.holds, tt.key.ID)
+			}
+			if until := time.Until(body.Data.Expires); until < tt.ttl-time.Minute || until > tt.ttl {
+				t.Errorf("hold expires in %s, want %s", until, tt.ttl)
+			}
+		})
+	}
+}
+
+func TestClaimHold(t *testing.T) {
+	tests := []struct {
+		name   string
+		key    APIKey
+		alias  string
+		target string
+		held   *AliasHold
+		status int
+	}{
+		{"holder", testOwnerKey, "launch", "/api/v1/holds/launch/claim", &AliasHold{Owner: testOwnerKey.ID, Expires: time.Now().Add(time.Hour)}, http.StatusOK},
+		{"another key", testOtherKey, "launch", "/api/v1/holds/launch/claim", &AliasHold{Owner: testOwnerKey.ID, Expires: time.Now().Add(time.Hour)}, http.StatusNotFound},
+		{"expired", testOwnerKey, "launch", "/api/v1/holds/launch/claim", &AliasHold{Owner: testOwnerKey.ID, Expires: time.Now().Add(-time.Minute)}, http.StatusNotFound},
+		{"no hold", testOwnerKey, "launch", "/api/v1/holds/launch/claim", nil, http.StatusNotFound},
+		{"namespaced", testOwnerKey, "team/launch", "/api/v1/holds/team%2Flaunch/claim", &AliasHold{Owner: testOwnerKey.ID, Expires: time.Now().Add(time.Hour)}, http.StatusOK},
+		{"namespaced another key", testOtherKey, "team/launch", "/api/v1/holds/team%2Flaunch/claim", &AliasHold{Owner: testOwnerKey.ID, Expires: time.Now().Add(time.Hour)}, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &holdStore{holds: map[string]AliasHold{}}
+			if tt.held != nil {
+				store.holds[tt.alias] = *tt.held
+			}
+			fake := (&fakeDB{}).
+				onFunc("FROM alias_holds", store.holder).
+				on("SELECT EXISTS", fakeResult{rows: [][]interface{}{{false}}}).
+				onFunc("INSERT INTO urls", insertedLinks)
+			creator := testCreator(fake)
+			creator.namespaces = true
+			r := testRouter()
+			routeNamespacedURIs(r)
+			r.POST("/api/v1/holds/:alias/claim", requireAPIKey, requireHold(context.Background(), fake, true, testSugar), shortenHandler(creator, testSugar.Desugar()))
+			w := serve(r, http.MethodPost, tt.target, tt.key, `{"url": "https://example.com/a", "alias": "other"}`)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+
+			inserts := fake.statements("INSERT INTO urls")
+			if tt.status != http.StatusOK {
+				if len(inserts) != 0 {
+					t.Errorf("created %d links without holding the alias", len(inserts))
+				}
+				return
+			}
+			if len(inserts) != 1 || inserts[0].args[1] != tt.alias {
+				t.Fatalf("inserts = %+v, want one link with the held alias", inserts)
+			}
+			if releases := fake.statements("DELETE FROM alias_holds"); len(releases) != 1 || releases[0].args[0] != tt.alias {
+				t.Errorf("releases = %+v, want the hold on %s dropped", releases, tt.alias)
+			}
+		})
+	}
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+)
+
+const idempotencyMismatchCode = "idempotency_key_reused" // The error code when a key comes back with a different request
+
+// requestHash a fingerprint of a shorten request, stored with its
+// idempotency key so a retry can be told apart from a different request
+// reusing the key. The decoded request is hashed so whitespace and key
+// order don't matter
+func requestHash(req ShortenURLRequest) string {
+	encoded, err := json.Marshal(req)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:])
+}
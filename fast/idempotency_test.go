@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stringPtr a pointer to s
+func stringPtr(s string) *string {
+	return &s
+}
+
+func TestRequestHash(t *testing.T) {
+	decode := func(body string) ShortenURLRequest {
+		var req ShortenURLRequest
+		if err := json.Unmarshal([]byte(body), &req); err != nil {
+			t.Fatalf("couldn't decode %s: %s", body, err)
+		}
+		return req
+	}
+	first := `{"url": "https://example.com/a", "title": "Launch"}`
+
+	tests := []struct {
+		name  string
+		body  string
+		equal bool
+	}{
+		{"same request", first, true},
+		{"key order and whitespace", `{ "title":"Launch","url":"https://example.com/a" }`, true},
+		{"another url", `{"url": "https://example.com/b", "title": "Launch"}`, false},
+		{"another title", `{"url": "https://example.com/a", "title": "Sale"}`, false},
+		{"an extra field", `{"url": "https://example.com/a", "title": "Launch", "alias": "launch"}`, false},
+	}
+
+	want := requestHash(decode(first))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := requestHash(decode(tt.body))
+			if got == "" || (got == want) != tt.equal {
+				t.Errorf("requestHash(%s) = %s, equal to the first = %t, want %t", tt.body, got, got == want, tt.equal)
+			}
+		})
+	}
+}
+
+func TestShortenHandlerIdempotencyHash(t *testing.T) {
+	first := ShortenURLRequest{URL: "https://example.com/a"}
+	tests := []struct {
+		name        string
+		body        string
+		stored      *string
+		compareBody bool
+		status      int
+		code        string
+	}{
+		{"retry", `{"url": "https://example.com/a"}`, stringPtr(requestHash(first)), true, http.StatusOK, ""},
+		{"retry written differently", `{ "url":"https://example.com/a" }`, stringPtr(requestHash(first)), true, http.StatusOK, ""},
+		{"different request", `{"url": "https://example.com/b"}`, stringPtr(requestHash(first)), true, http.StatusUnprocessableEntity, idempotencyMismatchCode},
+		{"link from before hashes", `{"url": "https://example.com/b"}`, nil, true, http.StatusOK, ""},
+		{"comparison turned off", `{"url": "https://example.com/b"}`, stringPtr(requestHash(first)), false, http.StatusOK, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := (&fakeDB{}).
+				on("idempotency_key = $2 AND created >", fakeResult{rows: [][]interface{}{{"first", "", "", "", false, time.Now(), tt.stored, "https://example.com/a", nil, true}}}).
+				onFunc("INSERT INTO urls", insertedLinks)
+			creator := testCreator(fake)
+			creator.compareBody = tt.compareBody
+			r := testRouter()
+			r.POST("/api/v1/shorten", shortenHandler(creator, testSugar.Desugar()))
+
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/shorten", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set(apiKeyHeader, testOwnerKey.Key)
+			req.Header.Set(idempotencyKeyHeader, "retry-1")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			var body struct {
+				Data ShortenURL `json:"data"`
+				Code string     `json:"code"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("couldn't decode %s: %s", w.Body, err)
+			}
+			if body.Code != tt.code {
+				t.Errorf("code = %q, want %q", body.Code, tt.code)
+			}
+			if tt.status == http.StatusOK && body.Data.URI != "first" {
+				t.Errorf("uri = %s, want the first link", body.Data.URI)
+			}
+			if inserts := fake.statements("INSERT INTO urls"); len(inserts) != 0 {
+				t.Errorf("inserted %d links for a reused key", len(inserts))
+			}
+		})
+	}
+}
+
+func TestLinkCreatorStoresRequestHash(t *testing.T) {
+	req := ShortenURLRequest{URL: "https://example.com/a", Title: "Launch"}
+	tests := []struct {
+		name           string
+		idempotencyKey string
+		want           string
+	}{
+		{"with a key", "retry-1", requestHash(req)},
+		{"without a key", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := (&fakeDB{}).onFunc("INSERT INTO urls", insertedLinks)
+			if _, err := testCreator(fake).create(context.Background(), req, creation{Key: testOwnerKey, IdempotencyKey: tt.idempotencyKey}, testSugar); err != nil {
+				t.Fatalf("create() = %v", err)
+			}
+			var got string
+			if hash := fake.statements("INSERT INTO urls")[0].args[20].(*string); hash != nil {
+				got = *hash
+			}
+			if got != tt.want {
+				t.Errorf("stored hash %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
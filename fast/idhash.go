@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"strings"
+)
+
+const (
+	uriStrategyRandom   = "random"   // Generate uris from random letters
+	uriStrategySequence = "sequence" // Generate uris from a database sequence, obfuscated
+	base62Alphabet      = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	feistelHalfBits     = 24                         // Bits in each half of the permuted id
+	feistelHalfMask     = 1<<feistelHalfBits - 1     // All 1-bits, as many as feistelHalfBits
+	feistelRounds       = 4                          // Rounds of the permutation
+	maxSequenceID       = 1<<(2*feistelHalfBits) - 1 // The largest id the permutation covers
+)
+
+// errSequenceExhausted the sequence has grown past what the permutation covers
+var errSequenceExhausted = errors.New("sequence id is too large to obfuscate")
+
+// IDObfuscator reversibly map sequential ids to ids that don't look
+// sequential so uris built from a counter can't be enumerated
+type IDObfuscator interface {
+	Encode(id uint64) (uint64, error)
+	Decode(id uint64) (uint64, error)
+}
+
+// FeistelObfuscator a keyed Feistel permutation over 48 bit ids. Being a
+// permutation it is collision free, and without the key the order of the
+// ids can't be recovered
+type FeistelObfuscator struct {
+	roundKeys [feistelRounds]uint64
+}
+
+// NewFeistelObfuscator build the permutation for a secret key
+func NewFeistelObfuscator(key string) *FeistelObfuscator {
+	f := &FeistelObfuscator{}
+	for i := range f.roundKeys {
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		h.Write([]byte{byte(i)})
+		f.roundKeys[i] = h.Sum64()
+	}
+
+	return f
+}
+
+// Encode permute a sequential id
+func (f *FeistelObfuscator) Encode(id uint64) (uint64, error) {
+	if id > maxSequenceID {
+		return 0, errSequenceExhausted
+	}
+
+	left, right := id>>feistelHalfBits, id&feistelHalfMask
+	for i := 0; i < feistelRounds; i++ {
+		left, right = right, left^f.round(right, i)
+	}
+
+	return left<<feistelHalfBits | right, nil
+}
+
+// Decode reverse Encode
+func (f *FeistelObfuscator) Decode(id uint64) (uint64, error) {
+	if id > maxSequenceID {
+		return 0, errSequenceExhausted
+	}
+
+	left, right := id>>feistelHalfBits, id&feistelHalfMask
+	for i := feistelRounds - 1; i >= 0; i-- {
+		left, right = right^f.round(left, i), left
+	}
+
+	return left<<feistelHalfBits | right, nil
+}
+
+// round the Feistel round function, mixing one half with the round key
+func (f *FeistelObfuscator) round(half uint64, i int) uint64 {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], half^f.roundKeys[i])
+	h := fnv.New64a()
+	h.Write(b[:])
+	return h.Sum64() & feistelHalfMask
+}
+
+// EncodeBase62 write an id with digits and letters
+func EncodeBase62(id uint64) string {
+	if id == 0 {
+		return base62Alphabet[:1]
+	}
+
+	var sb strings.Builder
+	for ; id > 0; id /= 62 {
+		sb.WriteByte(base62Alphabet[id%62])
+	}
+
+	// digits were written least significant first
+	b := []byte(sb.String())
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+
+	return string(b)
+}
+
+// DecodeBase62 read an id written by EncodeBase62
+func DecodeBase62(s string) (uint64, error) {
+	if s == "" {
+		return 0, errors.New("empty base62 string")
+	}
+
+	var id uint64
+	for i := 0; i < len(s); i++ {
+		digit := strings.IndexByte(base62Alphabet, s[i])
+		if digit < 0 {
+			return 0, errors.New("invalid base62 character")
+		}
+		id = id*62 + uint64(digit)
+	}
+
+	return id, nil
+}
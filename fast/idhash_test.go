@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestFeistelObfuscator(t *testing.T) {
+	f := NewFeistelObfuscator("secret")
+	tests := []struct {
+		name string
+		id   uint64
+		err  error
+	}{
+		{"zero", 0, nil},
+		{"first", 1, nil},
+		{"within a half", feistelHalfMask, nil},
+		{"largest", maxSequenceID, nil},
+		{"too large", maxSequenceID + 1, errSequenceExhausted},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, err := f.Encode(tt.id)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Encode(%d) = %v, want %v", tt.id, err, tt.err)
+			}
+			if tt.err != nil {
+				return
+			}
+			if encoded > maxSequenceID {
+				t.Errorf("Encode(%d) = %d, outside the permuted range", tt.id, encoded)
+			}
+			decoded, err := f.Decode(encoded)
+			if err != nil || decoded != tt.id {
+				t.Errorf("Decode(Encode(%d)) = %d, %v", tt.id, decoded, err)
+			}
+		})
+	}
+	if _, err := f.Decode(maxSequenceID + 1); !errors.Is(err, errSequenceExhausted) {
+		t.Errorf("Decode(too large) = %v, want %v", err, errSequenceExhausted)
+	}
+}
+
+func TestFeistelObfuscatorScatters(t *testing.T) {
+	f := NewFeistelObfuscator("secret")
+	other := NewFeistelObfuscator("other secret")
+	seen := map[uint64]bool{}
+	sequential := 0
+	var previous uint64
+	for id := uint64(1); id <= 10000; id++ {
+		encoded, _ := f.Encode(id)
+		if seen[encoded] {
+			t.Fatalf("Encode(%d) = %d, which another id already mapped to", id, encoded)
+		}
+		seen[encoded] = true
+		if encoded == previous+1 {
+			sequential++
+		}
+		previous = encoded
+
+		if fromOther, _ := other.Encode(id); fromOther == encoded && id > 1 {
+			sequential++
+		}
+	}
+	if sequential > 10 {
+		t.Errorf("%d of the encoded ids follow on from the last or match another key", sequential)
+	}
+}
+
+func TestBase62(t *testing.T) {
+	tests := []struct {
+		id      uint64
+		encoded string
+	}{
+		{0, "0"},
+		{9, "9"},
+		{10, "a"},
+		{61, "Z"},
+		{62, "10"},
+		{maxSequenceID, "1hVwxnaA7"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.encoded, func(t *testing.T) {
+			if got := EncodeBase62(tt.id); got != tt.encoded {
+				t.Errorf("EncodeBase62(%d) = %s, want %s", tt.id, got, tt.encoded)
+			}
+			if got, err := DecodeBase62(tt.encoded); err != nil || got != tt.id {
+				t.Errorf("DecodeBase62(%s) = %d, %v, want %d", tt.encoded, got, err, tt.id)
+			}
+		})
+	}
+
+	for _, s := range []string{"", "ab-c"} {
+		if _, err := DecodeBase62(s); err == nil {
+			t.Errorf("DecodeBase62(%q) = nil, want an error", s)
+		}
+	}
+}
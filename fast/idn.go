@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"unicode"
+
+	"github.com/spf13/viper"
+	"golang.org/x/net/idna"
+)
+
+// IDNConfig how destinations with internationalized hosts are handled.
+// Normalize stores hosts as punycode so the same destination is always
+// stored the same way. RejectMixedScripts refuses hosts whose labels mix
+// scripts the way lookalikes of well known domains do, like a Cyrillic "а"
+// in an otherwise Latin name
+type IDNConfig struct {
+	Normalize          bool `mapstructure:"normalize" yaml:"normalize"`
+	RejectMixedScripts bool `mapstructure:"reject_mixed_scripts" yaml:"reject_mixed_scripts"`
+}
+
+// allowedScriptSets the script combinations a single label may use, per
+// the highly restrictive profile of Unicode TS 39. Japanese, Chinese and
+// Korean names mix scripts of their own and are often written with Latin
+var allowedScriptSets = []map[string]bool{
+	{"Latin": true, "Han": true, "Hiragana": true, "Katakana": true},
+	{"Latin": true, "Han": true, "Bopomofo": true},
+	{"Latin": true, "Han": true, "Hangul": true},
+}
+
+// loadIDNConfig read the internationalized host settings for the
+// environment. Both are on unless turned off
+func loadIDNConfig(env string) (IDNConfig, error) {
+	cfg := IDNConfig{
+		Normalize:          true,
+		RejectMixedScripts: true,
+	}
+	key := fmt.Sprintf("%s.idn", env)
+	if viper.IsSet(key) {
+		if err := viper.UnmarshalKey(key, &cfg); err != nil {
+			return cfg, fmt.Errorf("couldn't read idn configuration: %w", err)
+		}
+	}
+
+	return cfg, nil
+}
+
+// normalizeDestination the destination with its host in punycode, after
+// checking it's a valid internationalized name and, when configured, not a
+// mixed script lookalike. Destinations that can't be parsed are returned
+// unchanged for the usual url validation to report
+func normalizeDestination(cfg IDNConfig, destination string) (string, error) {
+	parsed, err := url.ParseRequestURI(destination)
+	if err != nil || parsed.Host == "" {
+		return destination, nil
+	}
+
+	host, port := parsed.Host, ""
+	if h, p, err := net.SplitHostPort(parsed.Host); err == nil {
+		host, port = h, p
+	}
+	if !isIDN(host) {
+		return destination, nil
+	}
+
+	ascii, err := idna.Lookup.ToASCII(host)
+	if err != nil {
+		return destination, fmt.Errorf("host %q isn't a valid internationalized domain name: %s", host, err)
+	}
+	if cfg.RejectMixedScripts {
+		unicodeHost, err := idna.Lookup.ToUnicode(ascii)
+		if err != nil {
+			return destination, fmt.Errorf("host %q isn't a valid internationalized domain name: %s", host, err)
+		}
+		if label := mixedScriptLabel(unicodeHost); label != "" {
+			return destination, fmt.Errorf("host %q mixes scripts in %q, which lookalike domains do", host, label)
+		}
+	}
+	if !cfg.Normalize {
+		return destination, nil
+	}
+
+	if port != "" {
+		ascii = net.JoinHostPort(ascii, port)
+	}
+	parsed.Host = ascii
+	return parsed.String(), nil
+}
+
+// isIDN whether a host is internationalized, written in Unicode or already
+// as punycode
+func isIDN(host string) bool {
+	for _, r := range host {
+		if r > unicode.MaxASCII {
+			return true
+		}
+	}
+	for _, label := range strings.Split(strings.ToLower(host), ".") {
+		if strings.HasPrefix(label, "xn--") {
+			return true
+		}
+	}
+	return false
+}
+
+// mixedScriptLabel the first label of the host using letters from scripts
+// that don't belong together, empty when there is none
+func mixedScriptLabel(host string) string {
+	for _, label := range strings.Split(host, ".") {
+		scripts := map[string]bool{}
+		for _, r := range label {
+			if name := letterScript(r); name != "" {
+				scripts[name] = true
+			}
+		}
+		if len(scripts) > 1 && !allowedScripts(scripts) {
+			return label
+		}
+	}
+	return ""
+}
+
+// letterScript the script a letter is written in, empty for digits,
+// hyphens and anything else shared between scripts
+func letterScript(r rune) string {
+	if !unicode.IsLetter(r) {
+		return ""
+	}
+	for name, table := range unicode.Scripts {
+		if name == "Common" || name == "Inherited" {
+			continue
+		}
+		if unicode.Is(table, r) {
+			return name
+		}
+	}
+	return ""
+}
+
+// allowedScripts whether the scripts of a label are a combination that's
+// normal for the language it's written in
+func allowedScripts(scripts map[string]bool) bool {
+	for _, allowed := range allowedScriptSets {
+		ok := true
+		for name := range scripts {
+			if !allowed[name] {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+package main
+
+import "testing"
+
+func TestNormalizeDestination(t *testing.T) {
+	strict := IDNConfig{Normalize: true, RejectMixedScripts: true}
+	tests := []struct {
+		name        string
+		cfg         IDNConfig
+		destination string
+		want        string
+		valid       bool
+	}{
+		{"ascii host unchanged", strict, "https://example.com/a?b=c", "https://example.com/a?b=c", true},
+		{"unicode host to punycode", strict, "https://bücher.example/a", "https://xn--bcher-kva.example/a", true},
+		{"keeps the port", strict, "https://bücher.example:8443/a", "https://xn--bcher-kva.example:8443/a", true},
+		{"already punycode", strict, "https://xn--bcher-kva.example/a", "https://xn--bcher-kva.example/a", true},
+		{"uppercase folded", strict, "https://BÜCHER.example/", "https://xn--bcher-kva.example/", true},
+		{"single script label", strict, "https://пример.рф/", "https://xn--e1afmkfd.xn--p1ai/", true},
+		{"japanese mixes its scripts", strict, "https://日本語テキスト.jp/", "https://xn--nckya0bk5909dcvb2w6i.jp/", true},
+		{"cyrillic lookalike", strict, "https://pаypal.com/", "https://pаypal.com/", false},
+		{"punycode lookalike", strict, "https://xn--pypal-4ve.com/", "https://xn--pypal-4ve.com/", false},
+		{"lookalike allowed", IDNConfig{Normalize: true}, "https://pаypal.com/", "https://xn--pypal-4ve.com/", true},
+		{"not normalized", IDNConfig{RejectMixedScripts: true}, "https://bücher.example/a", "https://bücher.example/a", true},
+		{"invalid name", strict, "https://xn--a.example/", "https://xn--a.example/", false},
+		{"not a url left for validation", strict, "bücher", "bücher", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizeDestination(tt.cfg, tt.destination)
+			if (err == nil) != tt.valid {
+				t.Fatalf("normalizeDestination(%q) = %v, want valid = %t", tt.destination, err, tt.valid)
+			}
+			if got != tt.want {
+				t.Errorf("normalizeDestination(%q) = %q, want %q", tt.destination, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMixedScriptLabel(t *testing.T) {
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"example.com", ""},
+		{"bücher.example", ""},
+		{"пример.рф", ""},
+		{"pаypal.com", "pаypal"},
+		{"shop.pаypal.com", "pаypal"},
+		{"日本語テキスト.jp", ""},
+		{"서울abc.kr", ""},
+		{"한국日本テ.kr", "한국日本テ"},
+		{"αβγ-123.gr", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			if got := mixedScriptLabel(tt.host); got != tt.want {
+				t.Errorf("mixedScriptLabel(%q) = %q, want %q", tt.host, got, tt.want)
+			}
+		})
+	}
+}
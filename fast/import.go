@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+	pgx "github.com/jackc/pgx/v4"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultImportConcurrency = 4     // Links inserted at once during an import unless configured otherwise
+	maxImportConcurrency     = 64    // The most links inserted at once, whatever is configured
+	defaultImportMaxLinks    = 10000 // The most links one import may bring in
+	defaultImportMaxAliases  = 1000  // The most links in one import that may ask for a custom alias
+	importProgressEvery      = 1000  // How often, in links, an import logs its progress
+	importSource             = "import"
+)
+
+// ImportConfig how links are bulk imported. Concurrency bounds the inserts
+// running at once so a large import doesn't take every database connection.
+// MaxAliases bounds the links asking for a custom alias, which each need a
+// uniqueness check, separately from MaxLinks
+type ImportConfig struct {
+	Concurrency int `mapstructure:"concurrency" yaml:"concurrency"`
+	MaxLinks    int `mapstructure:"max_links" yaml:"max_links"`
+	MaxAliases  int `mapstructure:"max_aliases" yaml:"max_aliases"`
+}
+
+// loadImportConfig read the import settings for the environment
+func loadImportConfig(env string) (ImportConfig, error) {
+	var cfg ImportConfig
+	if err := viper.UnmarshalKey(fmt.Sprintf("%s.import", env), &cfg); err != nil {
+		return cfg, fmt.Errorf("couldn't read import configuration: %w", err)
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = defaultImportConcurrency
+	}
+	if cfg.Concurrency > maxImportConcurrency {
+		cfg.Concurrency = maxImportConcurrency
+	}
+	if cfg.MaxLinks <= 0 {
+		cfg.MaxLinks = defaultImportMaxLinks
+	}
+	if cfg.MaxAliases <= 0 {
+		cfg.MaxAliases = defaultImportMaxAliases
+	}
+
+	return cfg, nil
+}
+
+// checkAliases make sure an import doesn't ask for more custom aliases
+// than allowed. Links getting a generated uri don't count
+func (cfg ImportConfig) checkAliases(links []ImportLink) error {
+	aliases := 0
+	for _, link := range links {
+		if link.Alias != "" {
+			aliases++
+		}
+	}
+	if aliases > cfg.MaxAliases {
+		return fmt.Errorf("at most %d links in an import can have an alias, this one has %d", cfg.MaxAliases, aliases)
+	}
+
+	return nil
+}
+
+// ImportLink a link to bring in from elsewhere. Links without an alias get
+// a generated uri. Hits carries over the clicks another shortener counted.
+// aliasOptional falls back to a generated uri when the alias can't be used,
+// for short codes from other shorteners that may not fit our rules
+type ImportLink struct {
+	URL         string `json:"url" yaml:"url"`
+	Alias       string `json:"alias,omitempty" yaml:"alias,omitempty"`
+	Title       string `json:"title,omitempty" yaml:"title,omitempty"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+	Hits        int64  `json:"hits,omitempty" yaml:"hits,omitempty"`
+
+	aliasOptional bool
+}
+
+// ImportRequest the links to import in one go
+type ImportRequest struct {
+	Links []ImportLink `json:"links" yaml:"links"`
+}
+
+// ImportResult what became of one imported link, in the order they were sent
+type ImportResult struct {
+	URL   string `json:"url" yaml:"url"`
+	URI   string `json:"uri,omitempty" yaml:"uri,omitempty"`
+	Error string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// ImportSummary the outcome of an import
+type ImportSummary struct {
+	Imported int            `json:"imported" yaml:"imported"`
+	Failed   int            `json:"failed" yaml:"failed"`
+	Results  []ImportResult `json:"results" yaml:"results"`
+}
+
+// linkImporter creates imported links through the same linkCreator as
+// the shorten endpoint, so they are normalized, checked and given uris the
+// same way, minus the features imports don't carry over and the network
+// checks of destinations, which would make large imports crawl
+type linkImporter struct {
+	creator *linkCreator
+}
+
+// importLink validate and insert one link, recording it in the audit log
+func (i *linkImporter) importLink(ctx context.Context, link ImportLink, actor string, sugar *zap.SugaredLogger) ImportResult {
+	lc := i.creator
+	result := ImportResult{URL: link.URL}
+	normalized, err := lc.normalize(link.URL)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	link.URL, result.URL = normalized, normalized
+	if _, err := url.ParseRequestURI(link.URL); err != nil {
+		result.Error = fmt.Sprintf("couldn't parse url: %s", err)
+		return result
+	}
+	if err := lc.checkDestination(link.URL, lc.domain); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	if link.Hits < 0 {
+		result.Error = "hits can't be negative"
+		return result
+	}
+	// aliases follow the alias rules, holds and strict similarity like
+	// the shorten endpoint's, optional ones fall back to a generated uri
+	if link.Alias != "" {
+		if cerr := lc.checkAlias(ctx, link.Alias, actor, sugar); cerr != nil {
+			if !link.aliasOptional || cerr == errCreatingLink {
+				result.Error = cerr.Msg
+				return result
+			}
+			link.Alias = ""
+		}
+	}
+
+	for attempt := 1; attempt <= lc.maxAttempts; attempt++ {
+		uri := link.Alias
+		if uri == "" {
+			var cerr *creationError
+			uri, cerr = lc.nextURI(ctx, attempt, RandStringBytesMaskImprSrcSB(uriLength), slugFor(link.URL, link.Title), sugar)
+			if cerr != nil {
+				result.Error = cerr.Msg
+				return result
+			}
+			if isReserved(uri, lc.reserved) {
+				continue
+			}
+		}
+
+		err := lc.dbConn.QueryRow(ctx, "INSERT INTO "+urlsTable+"(original_url, uri, title, description, lookup_uri, source, owner, hits) VALUES($1, $2, NULLIF($3, ''), NULLIF($4, ''), $5, $6, NULLIF($7, ''), $8) ON CONFLICT DO NOTHING RETURNING uri;",
+			link.URL, uri, link.Title, link.Description, lookupURI(uri, lc.caseInsensitive), importSource, actor, link.Hits).Scan(&result.URI)
+		switch {
+		case err == nil:
+			if err := recordAudit(ctx, lc.dbConn, auditActionCreate, result.URI, actor); err != nil {
+				result.Error = fmt.Sprintf("imported but couldn't be audited: %s", err)
+			}
+			if link.Alias != "" {
+				if err := releaseHold(ctx, lc.dbConn, link.Alias, lc.caseInsensitive); err != nil {
+					sugar.Errorf("error releasing alias hold: %s", err)
+				}
+			}
+			return result
+		case !errors.Is(err, pgx.ErrNoRows):
+			result.Error = fmt.Sprintf("error creating URL: %s", err)
+			return result
+		case link.Alias != "" && link.aliasOptional:
+			// the short code is taken here, so the link gets a new one
+			link.Alias = ""
+		case link.Alias != "":
+			result.Error = "alias is already in use"
+			return result
+		}
+	}
+
+	result.Error = "couldn't generate a unique uri"
+	return result
+}
+
+// importHandler bring in a batch of links, inserting up to the configured
+// concurrency at once. Every link is attempted and reported on in the
+// order it was sent, so a failed link doesn't stop the rest of the import
+func importHandler(ctx context.Context, importer *linkImporter, cfg ImportConfig, sugar *zap.SugaredLogger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sugar := requestSugar(c, sugar)
+		var json ImportRequest
+		if err := c.ShouldBindJSON(&json); err != nil {
+			bindError(c, err)
+			return
+		}
+		if len(json.Links) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "links are required",
+			})
+			return
+		}
+		if len(json.Links) > cfg.MaxLinks {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("at most %d links can be imported at once", cfg.MaxLinks),
+			})
+			return
+		}
+		if err := cfg.checkAliases(json.Links); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"data": runImport(ctx, importer, cfg, json.Links, actorID(c), sugar),
+		})
+	}
+}
+
+// runImport import the links with up to the configured concurrency,
+// logging progress along the way
+func runImport(ctx context.Context, importer *linkImporter, cfg ImportConfig, links []ImportLink, actor string, sugar *zap.SugaredLogger) ImportSummary {
+	results := make([]ImportResult, len(links))
+	indexes := make(chan int)
+	var done int64
+	var wg sync.WaitGroup
+	for w := 0; w < cfg.Concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results[i] = importer.importLink(ctx, links[i], actor, sugar)
+				if n := atomic.AddInt64(&done, 1); n%importProgressEvery == 0 {
+					sugar.Infof("imported %d of %d links", n, len(links))
+				}
+			}
+		}()
+	}
+	for i := range links {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	summary := ImportSummary{Results: results}
+	for _, result := range results {
+		if result.URI != "" {
+			summary.Imported++
+		} else {
+			summary.Failed++
+		}
+	}
+	sugar.Infof("import finished with %d links imported and %d failed", summary.Imported, summary.Failed)
+
+	return summary
+}
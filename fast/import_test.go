@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"testing"
+)
+
+func TestImportLink(t *testing.T) {
+	tests := []struct {
+		name  string
+		link  ImportLink
+		setup func(*fakeDB)
+		uri   string
+		err   string
+	}{
+		{"generated uri", ImportLink{URL: "https://example.com/a"}, nil, "", ""},
+		{"alias", ImportLink{URL: "https://example.com/a", Alias: "launch"}, nil, "launch", ""},
+		{"rewritten to a blocked shortener", ImportLink{URL: "https://bitly.example/abc"}, nil, "", "links to the shortener bit.ly aren't allowed"},
+		{"negative hits", ImportLink{URL: "https://example.com/a", Hits: -1}, nil, "", "hits can't be negative"},
+		{"alias too short", ImportLink{URL: "https://example.com/a", Alias: "ab"}, nil, "", "error creating URL: alias must be at least 3 characters"},
+		{"alias held by someone else", ImportLink{URL: "https://example.com/a", Alias: "launch"}, func(f *fakeDB) {
+			f.on("FROM alias_holds", fakeResult{rows: [][]interface{}{{"globex"}}})
+		}, "", "alias is held by someone else"},
+		{"alias like a brand", ImportLink{URL: "https://example.com/a", Alias: "paypa1"}, nil, "", `error creating URL: alias is too similar to "paypal"`},
+		{"optional alias held", ImportLink{URL: "https://example.com/a", Alias: "launch", aliasOptional: true}, func(f *fakeDB) {
+			f.on("FROM alias_holds", fakeResult{rows: [][]interface{}{{"globex"}}})
+		}, "", ""},
+		{"optional alias taken", ImportLink{URL: "https://example.com/a", Alias: "launch", aliasOptional: true}, func(f *fakeDB) {
+			f.on("SELECT EXISTS", fakeResult{rows: [][]interface{}{{true}}})
+		}, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeDB{}
+			if tt.setup != nil {
+				tt.setup(fake)
+			}
+			fake.on("SELECT EXISTS", fakeResult{rows: [][]interface{}{{false}}}).
+				onFunc("INSERT INTO urls", func(args []interface{}) fakeResult {
+					return fakeResult{rows: [][]interface{}{{args[1]}}}
+				})
+			creator := testCreator(fake)
+			creator.blocked = []string{"bit.ly"}
+			creator.rewrites = []RewriteRule{{Match: `^https://bitly\.example/`, Replace: "https://bit.ly/", pattern: regexp.MustCompile(`^https://bitly\.example/`)}}
+			creator.strictAlias = StrictAliasConfig{Distance: 1, Brands: []string{"paypal"}}
+
+			result := (&linkImporter{creator: creator}).importLink(context.Background(), tt.link, testAdminKey.ID, testSugar)
+			if result.Error != tt.err {
+				t.Fatalf("error = %q, want %q", result.Error, tt.err)
+			}
+			if tt.err != "" {
+				if inserts := fake.statements("INSERT INTO urls"); len(inserts) != 0 {
+					t.Errorf("a failed link was inserted")
+				}
+				return
+			}
+			if result.URI == "" || (tt.uri != "" && result.URI != tt.uri) {
+				t.Errorf("uri = %q, want %q", result.URI, tt.uri)
+			}
+			if tt.link.aliasOptional && result.URI == tt.link.Alias {
+				t.Errorf("kept the alias %s that couldn't be used", tt.link.Alias)
+			}
+		})
+	}
+}
+
+func TestImportHandler(t *testing.T) {
+	fake := (&fakeDB{}).
+		on("SELECT EXISTS", fakeResult{rows: [][]interface{}{{false}}}).
+		onFunc("INSERT INTO urls", func(args []interface{}) fakeResult {
+			return fakeResult{rows: [][]interface{}{{args[1]}}}
+		})
+	cfg := ImportConfig{Concurrency: 2, MaxLinks: 3, MaxAliases: 1}
+	r := testRouter()
+	r.POST("/api/v1/admin/import", requireAdmin, importHandler(context.Background(), &linkImporter{creator: testCreator(fake)}, cfg, testSugar))
+
+	tests := []struct {
+		name     string
+		links    string
+		status   int
+		imported int
+		failed   int
+	}{
+		{"none", `[]`, http.StatusBadRequest, 0, 0},
+		{"too many", `[{"url": "https://a.example"}, {"url": "https://b.example"}, {"url": "https://c.example"}, {"url": "https://d.example"}]`, http.StatusBadRequest, 0, 0},
+		{"too many aliases", `[{"url": "https://a.example", "alias": "one"}, {"url": "https://b.example", "alias": "two"}]`, http.StatusBadRequest, 0, 0},
+		{"mixed", `[{"url": "https://a.example", "alias": "one"}, {"url": "nope"}, {"url": "https://c.example"}]`, http.StatusOK, 2, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(r, http.MethodPost, "/api/v1/admin/import", testAdminKey, fmt.Sprintf(`{"links": %s}`, tt.links))
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			var body struct {
+				Data ImportSummary `json:"data"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("couldn't decode %s: %s", w.Body, err)
+			}
+			if body.Data.Imported != tt.imported || body.Data.Failed != tt.failed {
+				t.Errorf("imported %d and failed %d, want %d and %d", body.Data.Imported, body.Data.Failed, tt.imported, tt.failed)
+			}
+			if body.Data.Results[0].URI != "one" || body.Data.Results[1].Error == "" {
+				t.Errorf("results = %+v, want them in the order sent", body.Data.Results)
+			}
+		})
+	}
+}
+
+func TestImportConfigCheckAliases(t *testing.T) {
+	links := func(aliases, generated int) []ImportLink {
+		var links []ImportLink
+		for i := 0; i < aliases; i++ {
+			links = append(links, ImportLink{URL: "https://example.com/a", Alias: fmt.Sprintf("alias-%d", i)})
+		}
+		for i := 0; i < generated; i++ {
+			links = append(links, ImportLink{URL: "https://example.com/a"})
+		}
+		return links
+	}
+	cfg := ImportConfig{MaxAliases: 3}
+
+	tests := []struct {
+		name    string
+		links   []ImportLink
+		wantErr bool
+	}{
+		{"no aliases", links(0, 10), false},
+		{"under the limit", links(2, 0), false},
+		{"at the limit", links(3, 10), false},
+		{"over the limit", links(4, 0), true},
+		{"generated uris don't count", links(3, 100), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := cfg.checkAliases(tt.links); (err != nil) != tt.wantErr {
+				t.Errorf("checkAliases() = %v, want an error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadImportConfigMaxAliases(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  map[string]interface{}
+		want int
+	}{
+		{"default", nil, defaultImportMaxAliases},
+		{"configured", map[string]interface{}{"max_aliases": 20}, 20},
+		{"not positive", map[string]interface{}{"max_aliases": 0}, defaultImportMaxAliases},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.cfg != nil {
+				withConfig(t, "test.import", tt.cfg)
+			}
+			cfg, err := loadImportConfig("test")
+			if err != nil || cfg.MaxAliases != tt.want {
+				t.Errorf("max aliases = %d, %v, want %d", cfg.MaxAliases, err, tt.want)
+			}
+		})
+	}
+}
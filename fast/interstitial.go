@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+)
+
+const defaultInterstitialDelay = 5 * time.Second // How long the interstitial page waits before redirecting
+
+var interstitialTemplate = template.Must(template.New("interstitial").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex">
+<meta http-equiv="refresh" content="{{.Seconds}}; url={{.URL}}">
+<title>Redirecting</title>
+</head>
+<body>
+<p>You are being redirected to <a href="{{.URL}}">{{.URL}}</a> in <span id="countdown">{{.Seconds}}</span> seconds.</p>
+<script>
+var remaining = {{.Seconds}};
+var countdown = document.getElementById("countdown");
+setInterval(function () {
+	if (remaining > 0) {
+		remaining--;
+		countdown.textContent = remaining;
+	}
+}, 1000);
+</script>
+</body>
+</html>
+`))
+
+// InterstitialConfig whether redirects go through a "you are being
+// redirected" page first. Enabled turns it on for every link, otherwise
+// only links created with interstitial get it
+type InterstitialConfig struct {
+	Enabled bool          `mapstructure:"enabled" yaml:"enabled"`
+	Delay   time.Duration `mapstructure:"delay" yaml:"delay"`
+}
+
+// loadInterstitialConfig read the interstitial settings for the environment
+func loadInterstitialConfig(env string) (InterstitialConfig, error) {
+	var cfg InterstitialConfig
+	if err := viper.UnmarshalKey(fmt.Sprintf("%s.interstitial", env), &cfg); err != nil {
+		return cfg, fmt.Errorf("couldn't read interstitial configuration: %w", err)
+	}
+	if cfg.Delay <= 0 {
+		cfg.Delay = defaultInterstitialDelay
+	}
+
+	return cfg, nil
+}
+
+// renderInterstitial serve the interstitial page counting down to the destination
+func renderInterstitial(c *gin.Context, cfg InterstitialConfig, destination string) {
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(http.StatusOK)
+	err := interstitialTemplate.Execute(c.Writer, struct {
+		URL     string
+		Seconds int
+	}{
+		URL:     destination,
+		Seconds: int(cfg.Delay.Seconds()),
+	})
+	if err != nil {
+		c.Error(err)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestLoadInterstitialConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  map[string]interface{}
+		want InterstitialConfig
+	}{
+		{"defaults", nil, InterstitialConfig{Delay: defaultInterstitialDelay}},
+		{"configured", map[string]interface{}{"enabled": true, "delay": "2s"}, InterstitialConfig{Enabled: true, Delay: 2 * time.Second}},
+		{"no delay", map[string]interface{}{"delay": "0s"}, InterstitialConfig{Delay: defaultInterstitialDelay}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.cfg != nil {
+				withConfig(t, "test.interstitial", tt.cfg)
+			}
+			cfg, err := loadInterstitialConfig("test")
+			if err != nil || cfg != tt.want {
+				t.Errorf("loadInterstitialConfig() = %+v, %v, want %+v", cfg, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderInterstitial(t *testing.T) {
+	r := gin.New()
+	r.GET("/launch", func(c *gin.Context) {
+		renderInterstitial(c, InterstitialConfig{Delay: 3 * time.Second}, `https://example.com/?a=1&b="2"`)
+	})
+	w := serve(r, http.MethodGet, "/launch", APIKey{}, "")
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf("got %d %s, want an html page", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	for _, want := range []string{
+		`<meta http-equiv="refresh" content="3; url=https://example.com/?a=1&amp;b=&#34;2&#34;">`,
+		`<a href="https://example.com/?a=1&amp;b=%222%22">`,
+		`<span id="countdown">3</span>`,
+		`var remaining =  3 ;`,
+		`<meta name="robots" content="noindex">`,
+	} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("page is missing %s:\n%s", want, w.Body)
+		}
+	}
+}
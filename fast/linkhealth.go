@@ -0,0 +1,391 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aeekayy/systems/fast/db"
+	"github.com/gin-gonic/gin"
+	pgx "github.com/jackc/pgx/v4"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultLinkHealthConcurrency = 8                // Destinations probed at once unless configured otherwise
+	maxLinkHealthConcurrency     = 64               // The most destinations probed at once, whatever is configured
+	defaultLinkHealthMaxLinks    = 500              // The most links one health check probes
+	defaultLinkHealthTimeout     = 5 * time.Second  // How long a probe waits on a destination
+	defaultLinkHealthCacheTTL    = 10 * time.Minute // How long a probe result is reused
+)
+
+// LinkHealthConfig how destinations are checked. Concurrency bounds the
+// probes in flight and CacheTTL how long a destination's result is reused,
+// so checking the same links again doesn't hit their destinations again.
+// AutoDisable turns a link off once that many checks in a row found its
+// destination dead, zero never does. Interval checks the links least
+// recently checked every so often so failures add up without anyone
+// calling the endpoint, zero leaves it to the endpoint
+type LinkHealthConfig struct {
+	Concurrency int           `mapstructure:"concurrency" yaml:"concurrency"`
+	MaxLinks    int           `mapstructure:"max_links" yaml:"max_links"`
+	Timeout     time.Duration `mapstructure:"timeout" yaml:"timeout"`
+	CacheTTL    time.Duration `mapstructure:"cache_ttl" yaml:"cache_ttl"`
+	AutoDisable int           `mapstructure:"auto_disable" yaml:"auto_disable"`
+	Interval    time.Duration `mapstructure:"interval" yaml:"interval"`
+}
+
+// LinkHealthRequest the links to check. Without uris every link the key
+// owns is checked, up to max_links
+type LinkHealthRequest struct {
+	URIs []string `json:"uris,omitempty" yaml:"uris,omitempty"`
+}
+
+// LinkHealth what checking a link's destination found. Dead links didn't
+// answer or answered with an error status. With auto disable on, Failures
+// is how many checks in a row found it dead and Disabled whether that
+// turned the link off
+type LinkHealth struct {
+	URI         string `json:"uri" yaml:"uri"`
+	OriginalURL string `json:"original_url" yaml:"original_url"`
+	ProbeResult
+	Dead     bool      `json:"dead" yaml:"dead"`
+	Checked  time.Time `json:"checked" yaml:"checked"`
+	Failures int       `json:"failures,omitempty" yaml:"failures,omitempty"`
+	Disabled bool      `json:"disabled,omitempty" yaml:"disabled,omitempty"`
+}
+
+// LinkHealthSummary the links checked and which of the requested uris
+// don't exist, or aren't the key's
+type LinkHealthSummary struct {
+	Links    []LinkHealth `json:"links" yaml:"links"`
+	Dead     int          `json:"dead" yaml:"dead"`
+	NotFound []string     `json:"not_found,omitempty" yaml:"not_found,omitempty"`
+}
+
+// loadLinkHealthConfig read the link health check settings for the environment
+func loadLinkHealthConfig(env string) (LinkHealthConfig, error) {
+	var cfg LinkHealthConfig
+	if err := viper.UnmarshalKey(fmt.Sprintf("%s.link_health", env), &cfg); err != nil {
+		return cfg, fmt.Errorf("couldn't read link health configuration: %w", err)
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = defaultLinkHealthConcurrency
+	}
+	if cfg.Concurrency > maxLinkHealthConcurrency {
+		cfg.Concurrency = maxLinkHealthConcurrency
+	}
+	if cfg.MaxLinks <= 0 {
+		cfg.MaxLinks = defaultLinkHealthMaxLinks
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultLinkHealthTimeout
+	}
+	if cfg.CacheTTL <= 0 {
+		cfg.CacheTTL = defaultLinkHealthCacheTTL
+	}
+	if cfg.AutoDisable < 0 {
+		return cfg, fmt.Errorf("link health auto_disable can't be negative")
+	}
+	if cfg.Interval < 0 {
+		return cfg, fmt.Errorf("link health interval can't be negative")
+	}
+	if cfg.Interval > 0 && cfg.AutoDisable == 0 {
+		return cfg, fmt.Errorf("link health interval only counts failures, it needs auto_disable")
+	}
+
+	return cfg, nil
+}
+
+// probeEntry a destination's probe result and when it was taken
+type probeEntry struct {
+	result  ProbeResult
+	checked time.Time
+}
+
+// LinkHealthChecker probe destinations, reusing results for the TTL. The
+// client is an outbound one so destinations on internal addresses can't be
+// probed through it
+type LinkHealthChecker struct {
+	client *http.Client
+	ttl    time.Duration
+
+	mu      sync.Mutex
+	results map[string]probeEntry
+}
+
+// NewLinkHealthChecker build a checker probing destinations with the client
+func NewLinkHealthChecker(client *http.Client, ttl time.Duration) *LinkHealthChecker {
+	return &LinkHealthChecker{
+		client:  client,
+		ttl:     ttl,
+		results: map[string]probeEntry{},
+	}
+}
+
+// Check the result of probing the destination, from the cache when it's
+// fresh. The bool is whether the destination was probed just now. A probe
+// cut short by ctx says nothing about the destination, so it isn't cached
+// and the context's error is returned instead
+func (h *LinkHealthChecker) Check(ctx context.Context, destination string) (ProbeResult, time.Time, bool, error) {
+	now := time.Now()
+	h.mu.Lock()
+	entry, ok := h.results[destination]
+	if ok && now.Sub(entry.checked) >= h.ttl {
+		delete(h.results, destination)
+		ok = false
+	}
+	h.mu.Unlock()
+	if ok {
+		return entry.result, entry.checked, false, nil
+	}
+
+	entry = probeEntry{result: probeURL(ctx, h.client, destination), checked: now}
+	if err := ctx.Err(); err != nil {
+		return ProbeResult{Error: err.Error()}, now, true, err
+	}
+	h.mu.Lock()
+	h.results[destination] = entry
+	h.mu.Unlock()
+
+	return entry.result, entry.checked, true, nil
+}
+
+// linkHealthRecord what recording a check did to a link
+type linkHealthRecord struct {
+	failures      int
+	disabled      bool
+	newlyDisabled bool
+	email         string
+}
+
+// recordLinkHealth count a check of a link's destination, resetting the
+// count when it was reachable. The link is disabled, and the audit log
+// told, once threshold checks in a row failed. Each probe result counts
+// once per link however often it's served from the cache, a result
+// already counted returns pgx.ErrNoRows
+func recordLinkHealth(ctx context.Context, dbConn db.Querier, uri string, reachable bool, checked time.Time, threshold int, actor string) (linkHealthRecord, error) {
+	var record linkHealthRecord
+	err := dbConn.QueryRow(ctx, `WITH old AS (
+			SELECT id, disabled FROM `+urlsTable+` WHERE uri = $1 AND (health_checked IS NULL OR health_checked < $6) LIMIT 1 FOR UPDATE
+		), updated AS (
+			UPDATE `+urlsTable+` AS u SET health_checked = $6, health_failures = CASE WHEN $2 THEN 0 ELSE u.health_failures + 1 END,
+				disabled = CASE WHEN NOT $2 AND u.disabled IS NULL AND u.health_failures + 1 >= $3 THEN now() ELSE u.disabled END
+			FROM old WHERE u.id = old.id
+			RETURNING u.uri, u.health_failures, u.disabled IS NOT NULL AS disabled, old.disabled IS NULL AND u.disabled IS NOT NULL AS newly_disabled, COALESCE(u.owner_email, '') AS email
+		), audit AS (
+			INSERT INTO audit_log(action, uri, actor) SELECT $4, uri, NULLIF($5, '') FROM updated WHERE newly_disabled
+		)
+		SELECT health_failures, disabled, newly_disabled, email FROM updated;`, uri, reachable, threshold, auditActionDisable, actor, checked).
+		Scan(&record.failures, &record.disabled, &record.newlyDisabled, &record.email)
+	return record, err
+}
+
+// linkHealthHandler probe the destinations of the requested links, or all
+// of the key's links, and report which are dead. Keys only check links
+// they own, admins any link
+func linkHealthHandler(ctx context.Context, dbReader, dbConn db.Querier, rc *RedirectCache, checker *LinkHealthChecker, cfg LinkHealthConfig, mailer Mailer, caseInsensitive bool, sugar *zap.SugaredLogger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sugar := requestSugar(c, sugar)
+		var json LinkHealthRequest
+		if c.Request.ContentLength != 0 {
+			if err := c.ShouldBindJSON(&json); err != nil {
+				bindError(c, err)
+				return
+			}
+		}
+		if len(json.URIs) > cfg.MaxLinks {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("at most %d links can be checked at once", cfg.MaxLinks),
+			})
+			return
+		}
+
+		key, _ := currentAPIKey(c)
+		owner := key.ID
+		if key.Admin {
+			owner = ""
+		}
+
+		uris := make([]string, len(json.URIs))
+		for i, uri := range json.URIs {
+			uris[i] = uri
+			if caseInsensitive {
+				uris[i] = strings.ToLower(uri)
+			}
+		}
+		column := "uri"
+		if caseInsensitive {
+			column = "lookup_uri"
+		}
+		rows, err := dbReader.Query(ctx, `SELECT uri, original_url FROM `+urlsTable+`
+			WHERE (cardinality($1::varchar[]) = 0 OR `+column+` = ANY($1)) AND ($2 = '' OR owner = $2)
+			ORDER BY created, id LIMIT $3;`, uris, owner, cfg.MaxLinks)
+		if err != nil {
+			sugar.Errorf("error retrieving links to check: %s", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "error checking links",
+			})
+			return
+		}
+		defer rows.Close()
+
+		links := []LinkHealth{}
+		for rows.Next() {
+			var link LinkHealth
+			if err := rows.Scan(&link.URI, &link.OriginalURL); err != nil {
+				sugar.Errorf("error reading links to check: %s", err)
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"error": "error checking links",
+				})
+				return
+			}
+			links = append(links, link)
+		}
+		if err := rows.Err(); err != nil {
+			sugar.Errorf("error reading links to check: %s", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "error checking links",
+			})
+			return
+		}
+		rows.Close()
+
+		summary := LinkHealthSummary{Links: links}
+		found := map[string]bool{}
+		for _, link := range links {
+			found[link.URI] = true
+			if caseInsensitive {
+				found[strings.ToLower(link.URI)] = true
+			}
+		}
+		for i, uri := range uris {
+			if !found[uri] {
+				summary.NotFound = append(summary.NotFound, json.URIs[i])
+			}
+		}
+
+		// the probes outlive the request, so a client giving up can't
+		// make healthy links look dead. Each probe is bounded by the
+		// checker's client timeout
+		summary.Dead = checkLinkHealth(ctx, dbConn, rc, checker, cfg, mailer, caseInsensitive, links, actorID(c), sugar)
+
+		c.JSON(http.StatusOK, gin.H{
+			"data": summary,
+		})
+	}
+}
+
+// checkLinkHealth probe the links' destinations, at most cfg.Concurrency at
+// once, and return how many are dead. With auto disable on, each result is
+// counted against the link, cached ones too, and links that keep failing
+// are disabled, with their owner emailed when the link has an email and a
+// mailer is set up
+func checkLinkHealth(ctx context.Context, dbConn db.Querier, rc *RedirectCache, checker *LinkHealthChecker, cfg LinkHealthConfig, mailer Mailer, caseInsensitive bool, links []LinkHealth, actor string, sugar *zap.SugaredLogger) int {
+	indexes := make(chan int)
+	// probes cut short by ctx are neither dead nor counted
+	aborted := make([]bool, len(links))
+	var wg sync.WaitGroup
+	for w := 0; w < cfg.Concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				var err error
+				links[i].ProbeResult, links[i].Checked, _, err = checker.Check(ctx, links[i].OriginalURL)
+				aborted[i] = err != nil
+				links[i].Dead = !aborted[i] && !links[i].Reachable
+			}
+		}()
+	}
+	for i := range links {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	dead := 0
+	for i, link := range links {
+		if link.Dead {
+			dead++
+		}
+		if cfg.AutoDisable == 0 || aborted[i] {
+			continue
+		}
+		record, err := recordLinkHealth(ctx, dbConn, link.URI, link.Reachable, link.Checked, cfg.AutoDisable, actor)
+		if errors.Is(err, pgx.ErrNoRows) {
+			// an earlier check already counted this result
+			continue
+		}
+		if err != nil {
+			sugar.Errorf("error recording health of %s: %s", link.URI, err)
+			continue
+		}
+		links[i].Failures, links[i].Disabled = record.failures, record.disabled
+		if !record.newlyDisabled {
+			continue
+		}
+
+		sugar.Warnf("disabled %s after %d failed health checks of %s", link.URI, record.failures, link.OriginalURL)
+		rc.Delete(cacheKey(link.URI, caseInsensitive))
+		if mailer != nil && record.email != "" {
+			body := fmt.Sprintf("Your short link %s was disabled because %s failed %d health checks in a row (%s). Point it at a new destination to turn it back on.", link.URI, link.OriginalURL, record.failures, link.Error)
+			if err := mailer.Send(record.email, "Your short link was disabled", body); err != nil {
+				sugar.Errorf("error emailing the owner of %s: %s", link.URI, err)
+			}
+		}
+	}
+
+	return dead
+}
+
+// runLinkHealthChecks check the max_links links that were checked least
+// recently every interval until the context is done
+func runLinkHealthChecks(ctx context.Context, dbReader, dbConn db.Querier, rc *RedirectCache, checker *LinkHealthChecker, cfg LinkHealthConfig, mailer Mailer, caseInsensitive bool, sugar *zap.SugaredLogger) {
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			checked, dead, err := scheduledLinkHealthCheck(ctx, dbReader, dbConn, rc, checker, cfg, mailer, caseInsensitive, sugar)
+			if err != nil {
+				sugar.Errorf("error checking link health: %s", err)
+				continue
+			}
+			sugar.Infow("checked link health", "links", checked, "dead", dead)
+		}
+	}
+}
+
+// scheduledLinkHealthCheck one round of runLinkHealthChecks, returning how
+// many links were checked and how many of them are dead
+func scheduledLinkHealthCheck(ctx context.Context, dbReader, dbConn db.Querier, rc *RedirectCache, checker *LinkHealthChecker, cfg LinkHealthConfig, mailer Mailer, caseInsensitive bool, sugar *zap.SugaredLogger) (int, int, error) {
+	rows, err := dbReader.Query(ctx, `SELECT uri, original_url FROM `+urlsTable+`
+		WHERE disabled IS NULL ORDER BY health_checked NULLS FIRST, created LIMIT $1;`, cfg.MaxLinks)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer rows.Close()
+
+	links := []LinkHealth{}
+	for rows.Next() {
+		var link LinkHealth
+		if err := rows.Scan(&link.URI, &link.OriginalURL); err != nil {
+			return 0, 0, err
+		}
+		links = append(links, link)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+	rows.Close()
+
+	return len(links), checkLinkHealth(ctx, dbConn, rc, checker, cfg, mailer, caseInsensitive, links, "", sugar), nil
+}
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// healthStore answer recordLinkHealth like the database: each probe result
+// counts once per link and links are disabled at the threshold
+type healthStore struct {
+	mu       sync.Mutex
+	checked  map[string]time.Time
+	failures map[string]int
+	disabled map[string]bool
+}
+
+func newHealthStore() *healthStore {
+	return &healthStore{checked: map[string]time.Time{}, failures: map[string]int{}, disabled: map[string]bool{}}
+}
+
+func (s *healthStore) record(args []interface{}) fakeResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	uri, reachable, threshold, checked := args[0].(string), args[1].(bool), args[2].(int), args[5].(time.Time)
+	if last, ok := s.checked[uri]; ok && !last.Before(checked) {
+		return fakeResult{}
+	}
+	s.checked[uri] = checked
+	if reachable {
+		s.failures[uri] = 0
+	} else {
+		s.failures[uri]++
+	}
+	wasDisabled := s.disabled[uri]
+	if !reachable && s.failures[uri] >= threshold {
+		s.disabled[uri] = true
+	}
+	return fakeResult{rows: [][]interface{}{{s.failures[uri], s.disabled[uri], s.disabled[uri] && !wasDisabled, uri + "@example.com"}}}
+}
+
+func TestLoadLinkHealthConfigInterval(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     LinkHealthConfig
+		wantErr bool
+	}{
+		{"off", LinkHealthConfig{}, false},
+		{"scheduled", LinkHealthConfig{Interval: time.Hour, AutoDisable: 3}, false},
+		{"nothing to count", LinkHealthConfig{Interval: time.Hour}, true},
+		{"negative", LinkHealthConfig{Interval: -time.Hour, AutoDisable: 3}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withConfig(t, "test.link_health", map[string]interface{}{"interval": tt.cfg.Interval, "auto_disable": tt.cfg.AutoDisable})
+			if _, err := loadLinkHealthConfig("test"); (err != nil) != tt.wantErr {
+				t.Errorf("loadLinkHealthConfig() = %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckLinkHealthCountsCachedResults(t *testing.T) {
+	dead := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer dead.Close()
+
+	store := newHealthStore()
+	fake := (&fakeDB{}).onFunc("health_failures = CASE", store.record)
+	checker := NewLinkHealthChecker(dead.Client(), time.Hour)
+	cfg := LinkHealthConfig{Concurrency: 2, AutoDisable: 2}
+	rc := NewRedirectCache(10)
+	rc.Set(cacheKey("b", true), RedirectLink{URI: "b"}, time.Hour)
+	mailer := &fakeMailer{}
+
+	// b shares a's destination, so its result comes from the cache
+	check := func(uris ...string) []LinkHealth {
+		links := make([]LinkHealth, len(uris))
+		for i, uri := range uris {
+			links[i] = LinkHealth{URI: uri, OriginalURL: dead.URL}
+		}
+		if got := checkLinkHealth(context.Background(), fake, rc, checker, cfg, mailer, true, links, "", testSugar); got != len(uris) {
+			t.Fatalf("checkLinkHealth() = %d dead, want %d", got, len(uris))
+		}
+		return links
+	}
+
+	links := check("a", "b")
+	for _, link := range links {
+		if link.Failures != 1 {
+			t.Errorf("%s has %d failures, want the cached result counted too", link.URI, link.Failures)
+		}
+	}
+	// the same cached result again isn't another failure
+	check("b")
+	if store.failures["b"] != 1 {
+		t.Errorf("b has %d failures after a repeated result, want 1", store.failures["b"])
+	}
+
+	// a new probe is
+	checker.mu.Lock()
+	checker.results = map[string]probeEntry{}
+	checker.mu.Unlock()
+	links = check("b")
+	if !links[0].Disabled || links[0].Failures != 2 {
+		t.Errorf("b = %+v, want disabled after 2 failures", links[0])
+	}
+	if _, ok := rc.Get(cacheKey("b", true)); ok {
+		t.Error("disabled link is still cached")
+	}
+	if len(mailer.sent) != 1 {
+		t.Errorf("sent %d emails, want one to b's owner", len(mailer.sent))
+	}
+}
+
+func TestScheduledLinkHealthCheck(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer up.Close()
+
+	store := newHealthStore()
+	fake := (&fakeDB{}).
+		on("ORDER BY health_checked NULLS FIRST", fakeResult{rows: [][]interface{}{{"a", up.URL}, {"b", up.URL + "/missing\x7f"}}}).
+		onFunc("health_failures = CASE", store.record)
+	cfg := LinkHealthConfig{Concurrency: 2, AutoDisable: 3, MaxLinks: 50}
+
+	checked, dead, err := scheduledLinkHealthCheck(context.Background(), fake, fake, nil, NewLinkHealthChecker(up.Client(), time.Hour), cfg, nil, true, testSugar)
+	if err != nil {
+		t.Fatalf("scheduledLinkHealthCheck() = %v", err)
+	}
+	if checked != 2 || dead != 1 {
+		t.Errorf("checked %d links with %d dead, want 2 and 1", checked, dead)
+	}
+	if args := fake.statements("ORDER BY health_checked NULLS FIRST")[0].args; args[0] != cfg.MaxLinks {
+		t.Errorf("checked up to %v links, want %d", args[0], cfg.MaxLinks)
+	}
+	if records := fake.statements("health_failures = CASE"); len(records) != 2 {
+		t.Errorf("recorded %d results, want 2", len(records))
+	}
+	if store.failures["b"] != 1 || store.failures["a"] != 0 {
+		t.Errorf("failures = %v, want b to have failed once", store.failures)
+	}
+}
+
+func TestLoadLinkHealthConfig(t *testing.T) {
+	defaults := LinkHealthConfig{Concurrency: defaultLinkHealthConcurrency, MaxLinks: defaultLinkHealthMaxLinks, Timeout: defaultLinkHealthTimeout, CacheTTL: defaultLinkHealthCacheTTL}
+	tests := []struct {
+		name    string
+		cfg     map[string]interface{}
+		want    LinkHealthConfig
+		wantErr bool
+	}{
+		{"defaults", nil, defaults, false},
+		{"configured", map[string]interface{}{"concurrency": 4, "max_links": 20, "timeout": "2s", "cache_ttl": "1m", "auto_disable": 3}, LinkHealthConfig{Concurrency: 4, MaxLinks: 20, Timeout: 2 * time.Second, CacheTTL: time.Minute, AutoDisable: 3}, false},
+		{"concurrency capped", map[string]interface{}{"concurrency": 1000}, LinkHealthConfig{Concurrency: maxLinkHealthConcurrency, MaxLinks: defaultLinkHealthMaxLinks, Timeout: defaultLinkHealthTimeout, CacheTTL: defaultLinkHealthCacheTTL}, false},
+		{"negative auto disable", map[string]interface{}{"auto_disable": -1}, LinkHealthConfig{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.cfg != nil {
+				withConfig(t, "test.link_health", tt.cfg)
+			}
+			cfg, err := loadLinkHealthConfig("test")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadLinkHealthConfig() = %v, want error %t", err, tt.wantErr)
+			}
+			if !tt.wantErr && cfg != tt.want {
+				t.Errorf("loadLinkHealthConfig() = %+v, want %+v", cfg, tt.want)
+			}
+		})
+	}
+}
+
+func TestLinkHealthChecker(t *testing.T) {
+	var probes int32
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&probes, 1)
+	}))
+	defer up.Close()
+
+	tests := []struct {
+		name   string
+		ttl    time.Duration
+		probes int32
+	}{
+		{"reused within the ttl", time.Hour, 1},
+		{"probed again once stale", 0, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			atomic.StoreInt32(&probes, 0)
+			checker := NewLinkHealthChecker(up.Client(), tt.ttl)
+			for i := 0; i < 3; i++ {
+				result, _, fresh, err := checker.Check(context.Background(), up.URL)
+				if err != nil || !result.Reachable {
+					t.Fatalf("Check() = %+v, want reachable", result)
+				}
+				if wantFresh := i == 0 || tt.ttl == 0; fresh != wantFresh {
+					t.Errorf("check %d probed = %t, want %t", i, fresh, wantFresh)
+				}
+			}
+			if got := atomic.LoadInt32(&probes); got != tt.probes {
+				t.Errorf("probed %d times, want %d", got, tt.probes)
+			}
+		})
+	}
+}
+
+func TestLinkHealthHandler(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer up.Close()
+	dead := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer dead.Close()
+
+	owned := map[string]string{"launch": up.URL, "Promo": dead.URL}
+	cfg := LinkHealthConfig{Concurrency: 2, MaxLinks: 3, CacheTTL: time.Hour}
+	tests := []struct {
+		name     string
+		key      APIKey
+		body     string
+		status   int
+		links    int
+		dead     int
+		notFound []string
+		owner    string
+	}{
+		{"anonymous", APIKey{}, "", http.StatusUnauthorized, 0, 0, nil, ""},
+		{"every link", testOwnerKey, "", http.StatusOK, 2, 1, nil, testOwnerKey.ID},
+		{"some links", testOwnerKey, `{"uris": ["launch", "promo", "missing"]}`, http.StatusOK, 2, 1, []string{"missing"}, testOwnerKey.ID},
+		{"another key's links", testOtherKey, `{"uris": ["launch"]}`, http.StatusOK, 0, 0, []string{"launch"}, testOtherKey.ID},
+		{"admin", testAdminKey, `{"uris": ["launch"]}`, http.StatusOK, 1, 0, nil, ""},
+		{"too many", testOwnerKey, `{"uris": ["a", "b", "c", "d"]}`, http.StatusBadRequest, 0, 0, nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := (&fakeDB{}).onFunc("cardinality($1", func(args []interface{}) fakeResult {
+				uris, owner := args[0].([]string), args[1].(string)
+				if owner != "" && owner != testOwnerKey.ID {
+					return fakeResult{}
+				}
+				var rows [][]interface{}
+				for uri, destination := range owned {
+					for _, want := range uris {
+						if strings.ToLower(uri) == want {
+							rows = append(rows, []interface{}{uri, destination})
+						}
+					}
+					if len(uris) == 0 {
+						rows = append(rows, []interface{}{uri, destination})
+					}
+				}
+				return fakeResult{rows: rows}
+			})
+			checker := NewLinkHealthChecker(up.Client(), cfg.CacheTTL)
+			r := testRouter()
+			r.POST("/api/v1/urls/health-check", requireAPIKey, linkHealthHandler(context.Background(), fake, fake, NewRedirectCache(10), checker, cfg, nil, true, testSugar))
+			w := serve(r, http.MethodPost, "/api/v1/urls/health-check", tt.key, tt.body)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			if args := fake.statements("cardinality($1")[0].args; args[1] != tt.owner || args[2] != cfg.MaxLinks {
+				t.Errorf("queried owner %q limit %v, want %q and %d", args[1], args[2], tt.owner, cfg.MaxLinks)
+			}
+			var body struct {
+				Data LinkHealthSummary `json:"data"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("couldn't decode %s: %s", w.Body, err)
+			}
+			summary := body.Data
+			if len(summary.Links) != tt.links || summary.Dead != tt.dead {
+				t.Errorf("checked %d links with %d dead, want %d and %d", len(summary.Links), summary.Dead, tt.links, tt.dead)
+			}
+			if strings.Join(summary.NotFound, ",") != strings.Join(tt.notFound, ",") {
+				t.Errorf("not found = %v, want %v", summary.NotFound, tt.notFound)
+			}
+			for _, link := range summary.Links {
+				if link.Dead != (link.OriginalURL == dead.URL) || link.Checked.IsZero() {
+					t.Errorf("%s = %+v, want dead only for the failing destination", link.URI, link)
+				}
+			}
+			if records := fake.statements("health_failures = CASE"); len(records) != 0 {
+				t.Errorf("recorded %d results with auto disable off", len(records))
+			}
+		})
+	}
+}
+
+func TestLinkHealthCheckerCancelled(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer up.Close()
+	checker := NewLinkHealthChecker(up.Client(), time.Hour)
+
+	tests := []struct {
+		name  string
+		ctx   func() context.Context
+		err   error
+		fresh bool
+	}{
+		{"cancelled", func() context.Context {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			return ctx
+		}, context.Canceled, true},
+		// the cancelled probe wasn't cached, so the destination is probed again
+		{"probed again", context.Background, nil, true},
+		{"cached", context.Background, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, _, fresh, err := checker.Check(tt.ctx(), up.URL)
+			if !errors.Is(err, tt.err) || fresh != tt.fresh {
+				t.Fatalf("Check() = %+v, fresh %t, %v, want fresh %t and %v", result, fresh, err, tt.fresh, tt.err)
+			}
+			if tt.err == nil && !result.Reachable {
+				t.Errorf("Check() = %+v, want reachable", result)
+			}
+		})
+	}
+}
+
+func TestLinkHealthHandlerOutlivesRequest(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+	}))
+	defer slow.Close()
+
+	tests := []struct {
+		name     string
+		checkCtx func() (context.Context, context.CancelFunc)
+		dead     int
+		records  int
+		cached   bool
+	}{
+		{"client gives up mid-check", func() (context.Context, context.CancelFunc) { return context.WithCancel(context.Background()) }, 0, 2, true},
+		{"server shutting down", func() (context.Context, context.CancelFunc) {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			return ctx, cancel
+		}, 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newHealthStore()
+			fake := (&fakeDB{}).
+				on("cardinality($1", fakeResult{rows: [][]interface{}{{"launch", slow.URL}, {"promo", slow.URL + "/promo"}}}).
+				onFunc("health_failures = CASE", store.record)
+			checker := NewLinkHealthChecker(slow.Client(), time.Hour)
+			cfg := LinkHealthConfig{Concurrency: 2, MaxLinks: 10, AutoDisable: 1}
+			checkCtx, stop := tt.checkCtx()
+			defer stop()
+			r := testRouter()
+			r.POST("/api/v1/urls/health-check", requireAPIKey, linkHealthHandler(checkCtx, fake, fake, NewRedirectCache(10), checker, cfg, nil, true, testSugar))
+
+			// the client hangs up while the destinations are still answering
+			reqCtx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(10*time.Millisecond, cancel)
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/urls/health-check", nil).WithContext(reqCtx)
+			req.Header.Set(apiKeyHeader, testOwnerKey.Key)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			var body struct {
+				Data LinkHealthSummary `json:"data"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("couldn't decode %s: %s", w.Body, err)
+			}
+			if body.Data.Dead != tt.dead {
+				t.Errorf("%d links dead, want %d: %s", body.Data.Dead, tt.dead, w.Body)
+			}
+			if records := fake.statements("health_failures = CASE"); len(records) != tt.records {
+				t.Errorf("recorded %d results, want %d", len(records), tt.records)
+			}
+			for uri, failures := range store.failures {
+				if failures != 0 || store.disabled[uri] {
+					t.Errorf("%s has %d failures, disabled %t, want none", uri, failures, store.disabled[uri])
+				}
+			}
+			checker.mu.Lock()
+			_, cached := checker.results[slow.URL]
+			checker.mu.Unlock()
+			if cached != tt.cached {
+				t.Errorf("destination cached = %t, want %t", cached, tt.cached)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+const loggerContextKey = "logger" // Where the request scoped logger is stored in the gin context
+
+// requestLogging give every request a logger carrying its request id,
+// method and path so handler logs can be correlated without repeating them.
+// It has to run after requestIDMiddleware
+func requestLogging(logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(loggerContextKey, logger.With(
+			zap.String("request_id", requestID(c)),
+			zap.String("method", c.Request.Method),
+			zap.String("path", c.Request.URL.Path),
+		))
+		c.Next()
+	}
+}
+
+// requestLogger the logger of the current request, or fallback outside of one
+func requestLogger(c *gin.Context, fallback *zap.Logger) *zap.Logger {
+	if val, ok := c.Get(loggerContextKey); ok {
+		if logger, ok := val.(*zap.Logger); ok {
+			return logger
+		}
+	}
+
+	return fallback
+}
+
+// requestSugar the sugared logger of the current request, or fallback
+// outside of one
+func requestSugar(c *gin.Context, fallback *zap.SugaredLogger) *zap.SugaredLogger {
+	return requestLogger(c, fallback.Desugar()).Sugar()
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestRequestLogging(t *testing.T) {
+	tests := []struct {
+		name      string
+		scoped    bool
+		requestID string
+		fields    map[string]string
+	}{
+		{"request fields", true, "from-the-proxy", map[string]string{
+			"request_id": "from-the-proxy",
+			"method":     http.MethodGet,
+			"path":       "/launch",
+		}},
+		{"outside a request", false, "from-the-proxy", map[string]string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			core, logs := observer.New(zapcore.InfoLevel)
+			logger := zap.New(core)
+
+			r := gin.New()
+			if tt.scoped {
+				r.Use(requestIDMiddleware, requestLogging(logger))
+			}
+			r.GET("/:short_uri", func(c *gin.Context) {
+				requestSugar(c, logger.Sugar()).Infof("serving %s", c.Param("short_uri"))
+			})
+			req := httptest.NewRequest(http.MethodGet, "/launch", nil)
+			req.Header.Set(requestIDHeader, tt.requestID)
+			r.ServeHTTP(httptest.NewRecorder(), req)
+
+			entries := logs.AllUntimed()
+			if len(entries) != 1 || entries[0].Message != "serving launch" {
+				t.Fatalf("logged %v, want the handler's line", entries)
+			}
+			got := map[string]string{}
+			for _, field := range entries[0].Context {
+				got[field.Key] = field.String
+			}
+			if len(got) != len(tt.fields) {
+				t.Errorf("fields = %v, want %v", got, tt.fields)
+			}
+			for key, want := range tt.fields {
+				if got[key] != want {
+					t.Errorf("%s = %q, want %q", key, got[key], want)
+				}
+			}
+		})
+	}
+}
@@ -5,30 +5,86 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math/bits"
 	"math/rand"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/aeekayy/systems/fast/db"
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
 )
 
 // ShortenURL the object that should returned when we return a shorten URL
 type ShortenURL struct {
-	URI            string `json:"uri" yaml:"uri"`
-	ShortenURL     string `json:"shorten_url" yaml:"shorten_url"`
-	ShortenLongURL string `json:"shorten_long_url" yaml:"shorten_long_url"`
+	URI            string          `json:"uri" yaml:"uri"`
+	ShortenURL     string          `json:"shorten_url" yaml:"shorten_url"`
+	ShortenLongURL string          `json:"shorten_long_url" yaml:"shorten_long_url"`
+	Formats        ShortURLFormats `json:"formats" yaml:"formats"`
+	Title          string          `json:"title,omitempty" yaml:"title,omitempty"`
+	Description    string          `json:"description,omitempty" yaml:"description,omitempty"`
+	Unconfirmed    bool            `json:"unconfirmed,omitempty" yaml:"unconfirmed,omitempty"`
+	Expires        *time.Time      `json:"expires,omitempty" yaml:"expires,omitempty"`
+	Probe          *ProbeResult    `json:"probe,omitempty" yaml:"probe,omitempty"`
+	OriginalURL    string          `json:"original_url,omitempty" yaml:"original_url,omitempty"`
+	Created        *time.Time      `json:"created,omitempty" yaml:"created,omitempty"`
+}
+
+// ShortURLFormats the ways a short link can be written. Full uses the
+// configured scheme, HTTP and HTTPS are there for clients that need a
+// specific one
+type ShortURLFormats struct {
+	Bare   string `json:"bare" yaml:"bare"`
+	Domain string `json:"domain" yaml:"domain"`
+	HTTP   string `json:"http" yaml:"http"`
+	HTTPS  string `json:"https" yaml:"https"`
+	Full   string `json:"full" yaml:"full"`
+}
+
+// LinkDomain where short links are served from
+type LinkDomain struct {
+	Host   string
+	Scheme string
 }
 
 // ShortenURLRequest web request for shorten URL. All we need is the
-// url that we want to shorten
+// url that we want to shorten. Destinations optionally spreads redirects
+// across several weighted urls, with url kept as the primary destination.
+// Rules send clients to a destination based on their country or device.
+// Title and Description are shown in metadata and link previews. Alias
+// asks for a custom uri instead of a generated one. Interstitial shows a
+// "you are being redirected" page before sending the client on. Source
+// tags where the link was created, e.g. "ios-app", for analytics.
+// RedirectType is permanent (301, cached) or temporary (302, not cached).
+// Email makes the link wait for its owner to confirm it before redirecting.
+// TTL, as seconds or a duration string, or TTLSeconds make the link expire.
+// CacheTTL overrides how long the link's redirect is cached. Signed links
+// only resolve with the signature the service issued them with. Campaign
+// groups the link with others whose stats are aggregated together
 type ShortenURLRequest struct {
-	URL string `json:"url" yaml:"url"`
+	URL          string         `json:"url" yaml:"url"`
+	Destinations []Destination  `json:"destinations,omitempty" yaml:"destinations,omitempty"`
+	Rules        []RedirectRule `json:"rules,omitempty" yaml:"rules,omitempty"`
+	Title        string         `json:"title,omitempty" yaml:"title,omitempty"`
+	Description  string         `json:"description,omitempty" yaml:"description,omitempty"`
+	Alias        string         `json:"alias,omitempty" yaml:"alias,omitempty"`
+	Interstitial bool           `json:"interstitial,omitempty" yaml:"interstitial,omitempty"`
+	Source       string         `json:"source,omitempty" yaml:"source,omitempty"`
+	RedirectType string         `json:"redirect_type,omitempty" yaml:"redirect_type,omitempty"`
+	Email        string         `json:"email,omitempty" yaml:"email,omitempty"`
+	TTL          *TTL           `json:"ttl,omitempty" yaml:"ttl,omitempty"`
+	TTLSeconds   *int64         `json:"ttl_seconds,omitempty" yaml:"ttl_seconds,omitempty"`
+	CacheTTL     *TTL           `json:"cache_ttl,omitempty" yaml:"cache_ttl,omitempty"`
+	Signed       bool           `json:"signed,omitempty" yaml:"signed,omitempty"`
+	Campaign     string         `json:"campaign,omitempty" yaml:"campaign,omitempty"`
 }
 
 // URLJSON JSON object for database entries. This should be used to track requests to
@@ -39,22 +95,44 @@ type URLJSON struct {
 }
 
 const (
-	defaultHTTPPort       = 8080                     // The default web port. This should move to the configuration file
-	defaultDomainName     = "fast.aeekay.co"         // The default domain name
-	defaultLongDomainName = "https://fast.aeekay.co" // The full host with protocol
-	letterBytes           = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
-	letterIdxBits         = 6                    // 6 bits to represent a letter index
-	letterIdxMask         = 1<<letterIdxBits - 1 // All 1-bits, as many as letterIdxBits
-	letterIdxMax          = 63 / letterIdxBits   // # of letter indices fitting in 63 bits
-	uriStringCnt          = 8                    // The number of characters in the uri
+	defaultHTTPPort             = 8080             // The default web port. This should move to the configuration file
+	defaultDomainName           = "fast.aeekay.co" // The default domain name
+	defaultScheme               = "https"          // The protocol of the full short URL
+	letterBytes                 = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	lowercaseLetterBytes        = "abcdefghijklmnopqrstuvwxyz0123456789" // Letters for uris that can't be mistyped by case
+	defaultURILength            = 8                                      // The number of characters in a random uri
+	idempotencyKeyHeader        = "Idempotency-Key"                      // The header clients use to make creation retries safe
+	defaultIdempotencyTTL       = 24 * time.Hour                         // How long an idempotency key maps to the same short URL
+	defaultGeoIPHeader          = "CF-IPCountry"                         // The header our CDN sets with the client country
+	defaultDBHealthCheckPeriod  = time.Minute                            // How often idle database connections are checked
+	defaultRawJSONMaxBytes      = 2048                                   // The largest raw_json stored with a link
+	defaultDBRetryBackoff       = 25 * time.Millisecond                  // The wait before retrying a read after a transient error
+	defaultDBBreakerFailures    = 5                                      // Consecutive database failures that open the circuit breaker
+	defaultDBBreakerCooldown    = 10 * time.Second                       // How long the circuit breaker stays open before probing
+	defaultLastAccessedThrottle = time.Minute                            // How often last accessed is written for a busy link
+)
+
+// build information, injected at build time with
+// -ldflags "-X main.version=... -X main.gitCommit=... -X main.buildTime=..."
+var (
+	version   string
+	gitCommit string
+	buildTime string
 )
 
 var (
 	defaultReservedList = map[string]bool{
-		"ping":  true,
-		"error": true,
+		"ping":    true,
+		"error":   true,
+		"metrics": true,
+		"healthz": true,
 	}
-	src = rand.NewSource(time.Now().UnixNano())
+	// imports generate uris from several goroutines, which a plain source can't take
+	src = &lockedSource{src: rand.NewSource(time.Now().UnixNano())}
+	// uriLetters the letters random uris are made of
+	uriLetters = letterBytes
+	// uriLength the number of characters in a random uri
+	uriLength = defaultURILength
 )
 
 func main() {
@@ -64,12 +142,17 @@ func main() {
 	viper.AddConfigPath("$HOME")
 	viper.AddConfigPath(".")
 
+	configRead := false
 	if err := viper.ReadInConfig(); err != nil {
 		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
 			fmt.Println("the configuration file was not found")
 		} else {
 			panic(fmt.Errorf("fatal error config file: %w", err))
 		}
+	} else {
+		configRead = true
+		// pick up operator changes such as feature flags without a restart
+		viper.WatchConfig()
 	}
 
 	// start the logger
@@ -79,111 +162,632 @@ func main() {
 
 	// start the db connection
 	ctx := context.Background()
-	env := getenv("ENV", "dev")
+	env, err := selectEnv(getenv("ENV", defaultEnv), configRead)
+	if err != nil {
+		sugar.Fatalf("invalid configuration: %s", err)
+	}
+	sugar.Infof("using the %s environment", env)
+	if table := viper.GetString(fmt.Sprintf("%s.db.table", env)); table != "" {
+		if err := setURLsTable(table); err != nil {
+			sugar.Fatalf("invalid database configuration: %s", err)
+		}
+	}
 	dbUser := viper.GetString(fmt.Sprintf("%s.db.user", env))
 	dbPass := viper.GetString(fmt.Sprintf("%s.db.pass", env))
 	dbHost := viper.GetString(fmt.Sprintf("%s.db.host", env))
 	dbName := viper.GetString(fmt.Sprintf("%s.db.name", env))
 	dbParams := viper.GetString(fmt.Sprintf("%s.db.params", env))
-	dbConn, err := db.DBConnect(ctx, dbUser, dbPass, dbHost, dbName, dbParams)
+	dbHealthCheckPeriod := viper.GetDuration(fmt.Sprintf("%s.db.health_check_period", env))
+	if dbHealthCheckPeriod <= 0 {
+		dbHealthCheckPeriod = defaultDBHealthCheckPeriod
+	}
+
+	// a full connection string, e.g. from a secret, wins over the components
+	dsn := getenv("DATABASE_URL", viper.GetString(fmt.Sprintf("%s.db.url", env)))
+	if dsn != "" {
+		if err := db.ValidateDSN(dsn); err != nil {
+			sugar.Fatalf("invalid database configuration: %s", err)
+		}
+	} else {
+		dsn = db.DSN(dbUser, dbPass, dbHost, dbName, dbParams)
+	}
+	dbConfig, err := db.PoolConfig(dsn, dbHealthCheckPeriod)
+	if err != nil {
+		sugar.Fatalf("invalid database configuration: %s", err)
+	}
+	dbPool, err := db.DBConnect(ctx, dbConfig)
 
 	if err != nil {
 		sugar.Fatalf("couldn't connect to the database: %w", err)
 	}
 
-	defer dbConn.Close(ctx)
+	defer dbPool.Close()
 
-	r := gin.Default()
+	keepAliveCtx, stopKeepAlive := context.WithCancel(ctx)
+	defer stopKeepAlive()
+	go db.KeepAlive(keepAliveCtx, dbPool, dbHealthCheckPeriod, func(err error) {
+		sugar.Warnf("database ping failed: %s", err)
+	})
 
-	r.GET("/api/v1/ping", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{
-			"message": "pong",
+	// redirect lookups and other reads go to the replicas. Entries are either
+	// a host sharing the primary's credentials or a full connection string
+	var replicas []db.Querier
+	for _, replica := range viper.GetStringSlice(fmt.Sprintf("%s.db.replicas", env)) {
+		replicaDSN := replica
+		if strings.Contains(replica, "://") {
+			if err := db.ValidateDSN(replicaDSN); err != nil {
+				sugar.Fatalf("invalid database replica configuration: %s", err)
+			}
+		} else {
+			replicaDSN = db.DSN(dbUser, dbPass, replica, dbName, dbParams)
+		}
+		replicaConfig, err := db.PoolConfig(replicaDSN, dbHealthCheckPeriod)
+		if err != nil {
+			sugar.Fatalf("invalid database replica configuration: %s", err)
+		}
+		replicaConn, err := db.DBConnect(ctx, replicaConfig)
+		if err != nil {
+			sugar.Fatalf("couldn't connect to the database replica: %s", err)
+		}
+		defer replicaConn.Close()
+
+		go db.KeepAlive(keepAliveCtx, replicaConn, dbHealthCheckPeriod, func(err error) {
+			sugar.Warnf("database replica ping failed: %s", err)
 		})
-	})
+		replicas = append(replicas, replicaConn)
+	}
+	// reads get one retry on a transient error, e.g. a dropped connection
+	retryBackoffKey := fmt.Sprintf("%s.db.retry_backoff", env)
+	viper.SetDefault(retryBackoffKey, defaultDBRetryBackoff)
+	// during an outage the breakers fail queries straight away instead of
+	// every request waiting on the database
+	breakerFailuresKey := fmt.Sprintf("%s.db.breaker.failures", env)
+	viper.SetDefault(breakerFailuresKey, defaultDBBreakerFailures)
+	breakerCooldownKey := fmt.Sprintf("%s.db.breaker.cooldown", env)
+	viper.SetDefault(breakerCooldownKey, defaultDBBreakerCooldown)
+	breakerFailures, breakerCooldown := viper.GetInt(breakerFailuresKey), viper.GetDuration(breakerCooldownKey)
+	dbConn := db.NewBreaker(dbPool, breakerFailures, breakerCooldown)
+	dbReader := db.NewBreaker(db.NewRetrying(db.NewReplicaSet(dbPool, replicas...), viper.GetDuration(retryBackoffKey)), breakerFailures, breakerCooldown)
+
+	creationEnabledKey := fmt.Sprintf("%s.features.creation_enabled", env)
+	viper.SetDefault(creationEnabledKey, true)
+
+	idempotencyCompareBodyKey := fmt.Sprintf("%s.idempotency.compare_body", env)
+	viper.SetDefault(idempotencyCompareBodyKey, true)
+	idempotencyCompareBody := viper.GetBool(idempotencyCompareBodyKey)
+
+	idempotencyTTL := viper.GetDuration(fmt.Sprintf("%s.idempotency.ttl", env))
+	if idempotencyTTL <= 0 {
+		idempotencyTTL = defaultIdempotencyTTL
+	}
 
-	r.GET("/:short_uri", func(c *gin.Context) {
-		shortenURI := c.Param("short_uri")
+	// all lowercase uris survive being read out or retyped
+	if viper.GetBool(fmt.Sprintf("%s.uri.lowercase", env)) {
+		uriLetters = lowercaseLetterBytes
+	}
 
-		val, isPresent := defaultReservedList[shortenURI]
-		if isPresent && val {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": "invalid key for uri",
-			})
-			return
+	if key := fmt.Sprintf("%s.uri.length", env); viper.IsSet(key) {
+		uriLength = viper.GetInt(key)
+		if uriLength <= 0 {
+			sugar.Fatalf("invalid configuration: uri length must be positive, not %d", uriLength)
 		}
+	}
 
-		var originalURL string
-		err = dbConn.QueryRow(ctx, "SELECT original_url FROM urls WHERE uri = $1 LIMIT 1;", shortenURI).Scan(&originalURL)
-		if err != nil {
-			sugar.Errorf("error retrieving URI: %w", err)
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": fmt.Sprintf("error retrieve URI: %s", err),
-			})
-			return
+	uriStrategy := viper.GetString(fmt.Sprintf("%s.uri.strategy", env))
+	var uriObfuscator IDObfuscator
+	switch uriStrategy {
+	case "", uriStrategyRandom:
+		uriStrategy = uriStrategyRandom
+	case uriStrategySlug:
+		// slugs come from the destination and title and need no configuration
+	case uriStrategySequence:
+		sequenceKey := viper.GetString(fmt.Sprintf("%s.uri.sequence_key", env))
+		if sequenceKey == "" {
+			sugar.Fatalf("invalid configuration: the sequence uri strategy needs a sequence_key")
 		}
+		uriObfuscator = NewFeistelObfuscator(sequenceKey)
+	default:
+		sugar.Fatalf("invalid configuration: unknown uri strategy %s", uriStrategy)
+	}
 
-		c.Redirect(http.StatusMovedPermanently, originalURL)
-	})
+	linkDomain := LinkDomain{
+		Host:   viper.GetString(fmt.Sprintf("%s.domain", env)),
+		Scheme: viper.GetString(fmt.Sprintf("%s.scheme", env)),
+	}
+	if linkDomain.Host == "" {
+		linkDomain.Host = defaultDomainName
+	}
+	switch linkDomain.Scheme {
+	case "":
+		linkDomain.Scheme = defaultScheme
+	case "http", "https":
+	default:
+		sugar.Fatalf("invalid configuration: scheme must be http or https, not %s", linkDomain.Scheme)
+	}
 
-	r.POST("/api/v1/shorten", func(c *gin.Context) {
-		var json ShortenURLRequest
-		if err := c.ShouldBindJSON(&json); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "unable to retrieve data"})
-			return
-		}
+	blockedShorteners := defaultBlockedShorteners
+	if key := fmt.Sprintf("%s.blocked_shorteners", env); viper.IsSet(key) {
+		blockedShorteners = viper.GetStringSlice(key)
+	}
 
-		generatedURL, err := GenerateURL(json.URL)
-		if err != nil {
-			sugar.Errorf("error creating URL: %s", err)
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": fmt.Sprintf("error creating URL: %s", err),
-			})
-			return
+	maxURIAttempts := viper.GetInt(fmt.Sprintf("%s.uri.max_attempts", env))
+	if maxURIAttempts <= 0 {
+		maxURIAttempts = defaultMaxURIAttempts
+	}
+
+	uriNamespaces := viper.GetBool(fmt.Sprintf("%s.uri.namespaces", env))
+
+	caseInsensitiveURIs := viper.GetBool(fmt.Sprintf("%s.alias.case_insensitive", env))
+	legacyURIFallback := viper.GetBool(fmt.Sprintf("%s.alias.legacy_fallback", env))
+	strictAliasConfig, err := loadStrictAliasConfig(env)
+	if err != nil {
+		sugar.Fatalf("invalid configuration: %s", err)
+	}
+
+	aliasMinLength := defaultAliasMinLength
+	if key := fmt.Sprintf("%s.alias.min_length", env); viper.IsSet(key) {
+		aliasMinLength = viper.GetInt(key)
+	}
+
+	lastAccessedThrottle := viper.GetDuration(fmt.Sprintf("%s.last_accessed.throttle", env))
+	if lastAccessedThrottle <= 0 {
+		lastAccessedThrottle = defaultLastAccessedThrottle
+	}
+
+	permanentMaxAge := viper.GetDuration(fmt.Sprintf("%s.redirect.permanent_max_age", env))
+	if permanentMaxAge <= 0 {
+		permanentMaxAge = defaultPermanentMaxAge
+	}
+
+	rawJSONMaxBytes := viper.GetInt(fmt.Sprintf("%s.raw_json.max_bytes", env))
+	if rawJSONMaxBytes <= 0 {
+		rawJSONMaxBytes = defaultRawJSONMaxBytes
+	}
+
+	maxTTL := viper.GetDuration(fmt.Sprintf("%s.expiry.max_ttl", env))
+	if maxTTL <= 0 {
+		maxTTL = defaultMaxTTL
+	}
+
+	// reverse lookups scan by destination so each client only gets a few
+	reverseRateLimitKey := fmt.Sprintf("%s.reverse_lookup.rate_limit", env)
+	viper.SetDefault(reverseRateLimitKey, defaultReverseRateLimit)
+	reverseWindowKey := fmt.Sprintf("%s.reverse_lookup.window", env)
+	viper.SetDefault(reverseWindowKey, time.Minute)
+	reverseLimiter := NewRateLimiter(viper.GetInt(reverseRateLimitKey), viper.GetDuration(reverseWindowKey))
+	reverseCacheTTLKey := fmt.Sprintf("%s.reverse_lookup.cache_ttl", env)
+	viper.SetDefault(reverseCacheTTLKey, defaultReverseCacheTTL)
+	reverseCacheSizeKey := fmt.Sprintf("%s.reverse_lookup.cache_size", env)
+	viper.SetDefault(reverseCacheSizeKey, defaultReverseCacheSize)
+	reverseCache := NewReverseCache(viper.GetDuration(reverseCacheTTLKey), viper.GetInt(reverseCacheSizeKey))
+
+	// reachability checks of POST /api/v1/validate share the outbound client
+	validateConcurrency := viper.GetInt(fmt.Sprintf("%s.validate.concurrency", env))
+	if validateConcurrency <= 0 {
+		validateConcurrency = defaultValidateConcurrency
+	}
+	if validateConcurrency > maxValidateConcurrency {
+		validateConcurrency = maxValidateConcurrency
+	}
+
+	clickDeduper := NewClickDeduper(viper.GetDuration(fmt.Sprintf("%s.clicks.dedup_window", env)))
+
+	signingSecret := []byte(viper.GetString(fmt.Sprintf("%s.signing.secret", env)))
+
+	headCountsHits := viper.GetBool(fmt.Sprintf("%s.redirect.head_counts_hits", env))
+
+	clockSkew := viper.GetDuration(fmt.Sprintf("%s.expiry.clock_skew", env))
+
+	rewriteRules, err := loadRewriteRules(env)
+	if err != nil {
+		sugar.Fatalf("invalid configuration: %s", err)
+	}
+	idnConfig, err := loadIDNConfig(env)
+	if err != nil {
+		sugar.Fatalf("invalid configuration: %s", err)
+	}
+
+	importConfig, err := loadImportConfig(env)
+	if err != nil {
+		sugar.Fatalf("invalid configuration: %s", err)
+	}
+
+	queryPolicy := viper.GetString(fmt.Sprintf("%s.redirect.query", env))
+	if err := ValidateQueryPolicy(queryPolicy); err != nil {
+		sugar.Fatalf("invalid configuration: %s", err)
+	}
+
+	trackingParams := defaultTrackingParams
+	if key := fmt.Sprintf("%s.redirect.strip_params", env); viper.IsSet(key) {
+		trackingParams = viper.GetStringSlice(key)
+	}
+
+	maxHops := viper.GetInt(fmt.Sprintf("%s.redirect.max_hops", env))
+	if maxHops <= 0 {
+		maxHops = defaultMaxHops
+	}
+	// following destinations contacts their hosts and slows creation down,
+	// so it's opt in like verify_on_create
+	checkLoops := viper.GetBool(fmt.Sprintf("%s.redirect.check_loops", env))
+
+	geoIPHeader := viper.GetString(fmt.Sprintf("%s.geoip.header", env))
+	if geoIPHeader == "" {
+		geoIPHeader = defaultGeoIPHeader
+	}
+
+	reservedHandlers, err := loadReservedHandlers(env)
+	if err != nil {
+		sugar.Fatalf("invalid configuration: %s", err)
+	}
+
+	aliasHoldConfig, err := loadAliasHoldConfig(env)
+	if err != nil {
+		sugar.Fatalf("invalid configuration: %s", err)
+	}
+
+	recentConfig, err := loadRecentConfig(env)
+	if err != nil {
+		sugar.Fatalf("invalid configuration: %s", err)
+	}
+
+	previewConfig, err := loadPreviewConfig(env)
+	if err != nil {
+		sugar.Fatalf("invalid configuration: %s", err)
+	}
+	outboundConfig, err := loadOutboundConfig(env)
+	if err != nil {
+		sugar.Fatalf("invalid configuration: %s", err)
+	}
+	previewClient := newOutboundClient(outboundConfig, previewConfig.Timeout)
+	previewFetches := newPreviewSlots(previewConfig)
+	outboundClient := newOutboundClient(outboundConfig, outboundConfig.Timeout)
+
+	// destinations can be checked when links are created
+	verifyOnCreate := viper.GetString(fmt.Sprintf("%s.verify_on_create", env))
+	if err := ValidateVerifyMode(verifyOnCreate); err != nil {
+		sugar.Fatalf("invalid configuration: %s", err)
+	}
+
+	httpsUpgradeConfig, err := loadHTTPSUpgradeConfig(env)
+	if err != nil {
+		sugar.Fatalf("invalid configuration: %s", err)
+	}
+	linkHealthConfig, err := loadLinkHealthConfig(env)
+	if err != nil {
+		sugar.Fatalf("invalid configuration: %s", err)
+	}
+	linkHealthChecker := NewLinkHealthChecker(newOutboundClient(outboundConfig, linkHealthConfig.Timeout), linkHealthConfig.CacheTTL)
+
+	httpsUpgrader := NewHTTPSUpgrader(newOutboundClient(outboundConfig, httpsUpgradeConfig.Timeout), httpsUpgradeConfig.TTL, httpsUpgradeConfig.MaxHosts)
+
+	interstitialConfig, err := loadInterstitialConfig(env)
+	if err != nil {
+		sugar.Fatalf("invalid configuration: %s", err)
+	}
+
+	confirmationConfig, err := loadConfirmationConfig(env)
+	if err != nil {
+		sugar.Fatalf("invalid configuration: %s", err)
+	}
+	mailer := newMailer(confirmationConfig)
+	confirmationResends := NewRateLimiter(confirmationConfig.ResendLimit, confirmationConfig.ResendWindow)
+
+	serverConfig, err := loadServerConfig(env)
+	if err != nil {
+		sugar.Fatalf("invalid configuration: %s", err)
+	}
+	grpcConfig, err := loadGRPCConfig(env)
+	if err != nil {
+		sugar.Fatalf("invalid configuration: %s", err)
+	}
+
+	// busy links are served from memory instead of the database
+	cacheConfig, err := loadCacheConfig(env)
+	if err != nil {
+		sugar.Fatalf("invalid configuration: %s", err)
+	}
+	var redirectCache *RedirectCache
+	if cacheConfig.Enabled {
+		redirectCache = NewRedirectCache(cacheConfig.MaxEntries)
+	}
+
+	// click counts are optionally pushed to a time series database as well
+	metricsPushConfig, err := loadMetricsPushConfig(env)
+	if err != nil {
+		sugar.Fatalf("invalid configuration: %s", err)
+	}
+	var clickCounter *ClickCounter
+	if metricsPushConfig.URL != "" {
+		clickCounter = NewClickCounter()
+		pushCtx, stopPush := context.WithCancel(ctx)
+		defer stopPush()
+		go NewMetricsPusher(metricsPushConfig, clickCounter, func(err error) {
+			sugar.Warnf("metrics push failed: %s", err)
+		}).Run(pushCtx)
+	}
+
+	brandingConfig, err := loadBrandingConfig(env)
+	if err != nil {
+		sugar.Fatalf("invalid configuration: %s", err)
+	}
+
+	apiKeys, err := loadAPIKeys(env)
+	if err != nil {
+		sugar.Fatalf("invalid configuration: %s", err)
+	}
+	vanityHosts := vanityDomains(apiKeys)
+
+	healthConfig, err := loadHealthConfig(env)
+	if err != nil {
+		sugar.Fatalf("invalid configuration: %s", err)
+	}
+
+	securityHeadersConfig, err := loadSecurityHeadersConfig(env)
+	if err != nil {
+		sugar.Fatalf("invalid configuration: %s", err)
+	}
+
+	// an optional smoke test of the database before taking traffic
+	if viper.GetBool(fmt.Sprintf("%s.self_check.enabled", env)) {
+		if err := selfCheck(ctx, dbConn, caseInsensitiveURIs); err != nil {
+			sugar.Fatalf("self check failed: %s", err)
 		}
+		sugar.Info("self check passed")
+	}
 
-		details := URLJSON{}
-		details.Referer = c.Request.Header.Get("referer")
-		details.Agent = "test"
+	if err := configureGinMode(env); err != nil {
+		sugar.Fatalf("invalid configuration: %s", err)
+	}
 
-		_, err = dbConn.Query(ctx, "INSERT INTO urls(original_url, uri,raw_json) VALUES($1, $2, $3);", json.URL, generatedURL.URI, details)
+	r := gin.Default()
+	if uriNamespaces {
+		routeNamespacedURIs(r)
+	}
+	r.Use(securityHeaders(securityHeadersConfig))
+	r.Use(requireHTTPS(viper.GetBool(fmt.Sprintf("%s.server.force_https", env))))
+	prettyJSONKey := fmt.Sprintf("%s.pretty_json", env)
+	r.Use(maxInFlight(viper.GetInt(fmt.Sprintf("%s.server.max_in_flight", env))))
+	r.Use(requestIDMiddleware, requestLogging(logger), prettyJSON(func() bool { return viper.GetBool(prettyJSONKey) }), problemDetails, authenticate(apiKeys), requireJSON, failFast(dbReader, dbConn, breakerCooldown))
+
+	r.GET("/api/v1/ping", pingHandler(healthConfig))
+	r.GET("/healthz", healthzHandler(healthConfig, dbPool.Ping))
+
+	r.GET("/api/v1/version", versionHandler(BuildInfo{Version: version, GitCommit: gitCommit, BuildTime: buildTime}))
+
+	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
+
+	shortURI := shortURIHandler(ctx, &redirector{
+		dbConn:               dbConn,
+		dbReader:             dbReader,
+		cache:                redirectCache,
+		cacheConfig:          cacheConfig,
+		reserved:             reservedHandlers,
+		branding:             brandingConfig,
+		maxHops:              maxHops,
+		caseInsensitive:      caseInsensitiveURIs,
+		legacyFallback:       legacyURIFallback,
+		vanityHosts:          vanityHosts,
+		signingSecret:        signingSecret,
+		clockSkew:            clockSkew,
+		geoIPHeader:          geoIPHeader,
+		headCountsHits:       headCountsHits,
+		dedup:                clickDeduper,
+		clicks:               clickCounter,
+		lastAccessedThrottle: lastAccessedThrottle,
+		intn:                 rand.Intn,
+		queryPolicy:          queryPolicy,
+		trackingParams:       trackingParams,
+		upgradeHTTPS:         httpsUpgradeConfig.Enabled,
+		upgrader:             httpsUpgrader,
+		preview:              previewConfig,
+		previewFetches:       previewFetches,
+		previewClient:        previewClient,
+		interstitial:         interstitialConfig,
+		permanentMaxAge:      permanentMaxAge,
+	}, sugar)
+	r.GET("/:short_uri", shortURI)
+	r.HEAD("/:short_uri", shortURI)
+	if uriNamespaces {
+		r.GET("/:short_uri/*rest", shortURI)
+		r.HEAD("/:short_uri/*rest", shortURI)
+	}
+
+	creator := &linkCreator{
+		dbConn:          dbConn,
+		domain:          linkDomain,
+		enabled:         func() bool { return viper.GetBool(creationEnabledKey) },
+		signingSecret:   signingSecret,
+		rewrites:        rewriteRules,
+		idn:             idnConfig,
+		blocked:         blockedShorteners,
+		reserved:        reservedHandlers,
+		aliasMinLength:  aliasMinLength,
+		namespaces:      uriNamespaces,
+		caseInsensitive: caseInsensitiveURIs,
+		strictAlias:     strictAliasConfig,
+		uriStrategy:     uriStrategy,
+		obfuscator:      uriObfuscator,
+		maxAttempts:     maxURIAttempts,
+		maxTTL:          maxTTL,
+		rawJSONMaxBytes: rawJSONMaxBytes,
+		idempotencyTTL:  idempotencyTTL,
+		compareBody:     idempotencyCompareBody,
+		verify:          verifyOnCreate,
+		loopCheck:       checkLoops,
+		vanityHosts:     vanityHosts,
+		client:          outboundClient,
+	}
+	shorten := shortenHandler(creator, logger)
+	r.POST("/api/v1/shorten", shorten)
+	r.POST("/api/v1/holds", requireAPIKey, holdAliasHandler(ctx, dbConn, aliasHoldConfig, aliasMinLength, reservedHandlers, uriNamespaces, caseInsensitiveURIs, sugar))
+	// claiming creates the link like shorten, with the held alias
+	r.POST("/api/v1/holds/:alias/claim", requireAPIKey, requireHold(ctx, dbConn, caseInsensitiveURIs, sugar), shorten)
+
+	r.POST("/api/v1/validate", requireAPIKey, validateURLsHandler(creator, validateConcurrency))
+	r.GET("/api/v1/reverse", requireAPIKey, rateLimit(reverseLimiter), reverseLookupHandler(ctx, dbReader, creator, reverseCache, sugar))
+	r.POST("/api/v1/urls/health-check", requireAPIKey, linkHealthHandler(ctx, dbReader, dbConn, redirectCache, linkHealthChecker, linkHealthConfig, mailer, caseInsensitiveURIs, sugar))
+	r.GET("/api/v1/urls/recent", requireAPIKey, recentLinksHandler(ctx, dbReader, recentConfig, sugar))
+	r.GET("/api/v1/urls/:uri", urlMetadataHandler(ctx, dbReader, linkDomain, signingSecret, caseInsensitiveURIs, sugar))
+	r.GET("/api/v1/urls/:uri/qr", qrHandler(ctx, dbReader, linkDomain, signingSecret, caseInsensitiveURIs, sugar))
+	r.PUT("/api/v1/urls/:uri", requireAPIKey, updateURLHandler(ctx, dbConn, redirectCache, caseInsensitiveURIs, linkDomain.Host, blockedShorteners, idnConfig, sugar))
+	r.GET("/api/v1/urls/:uri/history", requireAPIKey, urlHistoryHandler(ctx, dbReader, caseInsensitiveURIs, sugar))
+	r.GET("/api/v1/urls/:uri/clicks/count", requireAPIKey, clickCountHandler(ctx, dbReader, caseInsensitiveURIs, sugar))
+	r.GET("/api/v1/urls/:uri/report", requireAPIKey, reportHandler(ctx, dbReader, caseInsensitiveURIs, sugar))
+	r.GET("/api/v1/urls/:uri/unwrap", unwrapHandler(ctx, dbReader, caseInsensitiveURIs, outboundClient, sugar))
+	r.POST("/api/v1/urls/:uri/preview-token", requireAPIKey, previewTokenHandler(ctx, dbReader, linkDomain, signingSecret, caseInsensitiveURIs, sugar))
+	r.POST("/api/v1/urls/:uri/confirmation", requireAPIKey, sendConfirmationHandler(ctx, dbReader, mailer, confirmationResends, linkDomain, caseInsensitiveURIs, sugar))
+	r.GET("/api/v1/confirm/:token", confirmHandler(ctx, dbConn, redirectCache, caseInsensitiveURIs, sugar))
+	r.DELETE("/api/v1/urls/:uri", requireAPIKey, deleteURLHandler(ctx, dbConn, redirectCache, caseInsensitiveURIs, sugar))
+	r.DELETE("/api/v1/urls", requireAdmin, bulkDeleteHandler(ctx, dbConn, redirectCache, caseInsensitiveURIs, sugar))
+	r.GET("/api/v1/stats", requireAdmin, statsHandler(ctx, dbReader, sugar))
+	r.GET("/api/v1/campaigns/:id/stats", requireAPIKey, campaignStatsHandler(ctx, dbReader, sugar))
+	r.GET("/api/v1/search", requireAdmin, searchHandler(ctx, dbReader, sugar))
+
+	admin := r.Group("/api/v1/admin", requireAdmin)
+	admin.GET("/audit", auditLogHandler(ctx, dbReader, sugar))
+	importer := &linkImporter{creator: creator}
+	admin.POST("/import", importHandler(ctx, importer, importConfig, sugar))
+	admin.POST("/import/csv", csvImportHandler(ctx, importer, importConfig, sugar))
+	admin.POST("/urls/:uri/owner", transferOwnerHandler(ctx, dbConn, apiKeys, caseInsensitiveURIs, sugar))
+	admin.GET("/duplicates", duplicatesHandler(ctx, dbReader, sugar))
+	admin.POST("/duplicates/merge", mergeDuplicatesHandler(ctx, dbConn, redirectCache, caseInsensitiveURIs, sugar))
+	admin.POST("/cache/warm", cacheWarmHandler(ctx, dbReader, redirectCache, cacheConfig, caseInsensitiveURIs, sugar))
+	admin.POST("/cache/invalidate/:uri", cacheInvalidateHandler(redirectCache, caseInsensitiveURIs))
+
+	// dead destinations are counted on a schedule too, not only when asked
+	if linkHealthConfig.Interval > 0 {
+		healthCtx, stopHealth := context.WithCancel(ctx)
+		defer stopHealth()
+		go runLinkHealthChecks(healthCtx, dbReader, dbConn, redirectCache, linkHealthChecker, linkHealthConfig, mailer, caseInsensitiveURIs, sugar)
+	}
+
+	// internal clients can create, resolve and delete links over gRPC too
+	if grpcConfig.Enabled {
+		listener, err := net.Listen("tcp", fmt.Sprintf(":%d", grpcConfig.Port))
 		if err != nil {
-			sugar.Errorf("error creating URL: %w", err)
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": fmt.Sprintf("error creating URL: %s", err),
-			})
-			return
+			sugar.Fatalf("couldn't listen for grpc: %s", err)
 		}
-
-		sugar.Infof("created new url: %s", generatedURL.ShortenLongURL)
-		c.JSON(http.StatusOK, gin.H{
-			"data": generatedURL,
+		grpcServer := newGRPCServer(&shortenerServer{
+			creator:  creator,
+			dbConn:   dbConn,
+			dbReader: dbReader,
+			cache:    redirectCache,
+			keys:     apiKeys,
+			sugar:    sugar,
 		})
-	})
+		defer grpcServer.GracefulStop()
+		sugar.Infof("starting grpc server on port %d", grpcConfig.Port)
+		go func() {
+			if err := grpcServer.Serve(listener); err != nil {
+				sugar.Fatalf("grpc server stopped: %s", err)
+			}
+		}()
+	}
 
 	sugar.Info("starting web server")
-	r.Run(fmt.Sprintf(":%d", defaultHTTPPort))
+	server := newHTTPServer(fmt.Sprintf(":%d", defaultHTTPPort), r, serverConfig)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		sugar.Fatalf("web server stopped: %s", err)
+	}
 }
 
 // GenerateURL generate a shorten URL.
-func GenerateURL(originalURL string) (*ShortenURL, error) {
+func GenerateURL(originalURL string, domain LinkDomain) (*ShortenURL, error) {
 	_, err := url.ParseRequestURI(originalURL)
 	if err != nil {
 		return nil, errors.New(fmt.Sprintf("couldn't parse url: %s", err))
 	}
 
-	uri := RandStringBytesMaskImprSrcSB(uriStringCnt)
+	uri := RandStringBytesMaskImprSrcSB(uriLength)
+
+	return domain.NewShortenURL(uri), nil
+}
+
+const (
+	defaultMaxURIAttempts = 5                     // How many uris are tried when generated ones collide
+	uriSpaceExhaustedCode = "uri_space_exhausted" // The error code when every generated uri collided
+	uriMisconfiguredCode  = "uri_misconfigured"   // The error code when the uri configuration can't generate usable uris
+)
+
+// generateURI generate a uri for a link without a custom alias using the
+// configured strategy. slug is only used by the slug strategy, which falls
+// back to a random uri when the link has nothing to build a slug from
+func generateURI(ctx context.Context, dbConn db.Querier, strategy string, obfuscator IDObfuscator, slug string) (string, error) {
+	switch strategy {
+	case uriStrategySlug:
+		if slug != "" {
+			return nextSlugURI(ctx, dbConn, slug)
+		}
+		return RandStringBytesMaskImprSrcSB(uriLength), nil
+	case uriStrategySequence:
+	default:
+		return RandStringBytesMaskImprSrcSB(uriLength), nil
+	}
+
+	var id int64
+	if err := dbConn.QueryRow(ctx, "SELECT nextval('urls_uri_seq');").Scan(&id); err != nil {
+		return "", fmt.Errorf("couldn't retrieve uri sequence: %w", err)
+	}
+	obfuscated, err := obfuscator.Encode(uint64(id))
+	if err != nil {
+		return "", err
+	}
+
+	return EncodeBase62(obfuscated), nil
+}
+
+// NewShortenURL build the shorten URL response for an existing uri
+func (d LinkDomain) NewShortenURL(uri string) *ShortenURL {
+	formats := ShortURLFormats{
+		Bare:   uri,
+		Domain: fmt.Sprintf("%s/%s", d.Host, uri),
+		HTTP:   fmt.Sprintf("http://%s/%s", d.Host, uri),
+		HTTPS:  fmt.Sprintf("https://%s/%s", d.Host, uri),
+		Full:   fmt.Sprintf("%s://%s/%s", d.Scheme, d.Host, uri),
+	}
 
 	return &ShortenURL{
-		ShortenURL:     fmt.Sprintf("%s/%s", defaultDomainName, uri),
-		ShortenLongURL: fmt.Sprintf("%s/%s", defaultLongDomainName, uri),
+		ShortenURL:     formats.Domain,
+		ShortenLongURL: formats.Full,
 		URI:            uri,
-	}, nil
+		Formats:        formats,
+	}
 }
 
 // RandStringBytesMaskImprSrcSB generate a random character string. The
-// string should be a alpha string with capitalized and lowercase characters
+// string should be a alpha string with capitalized and lowercase characters,
+// or lowercase letters and digits when uris are lowercase only
 // n is the number of characters in the string
 func RandStringBytesMaskImprSrcSB(n int) string {
+	return randString(n, uriLetters)
+}
+
+// lockedSource a rand.Source that is safe to share between goroutines
+type lockedSource struct {
+	mu  sync.Mutex
+	src rand.Source
+}
+
+// Int63 the next random number from the wrapped source
+func (s *lockedSource) Int63() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.src.Int63()
+}
+
+// Seed reseed the wrapped source
+func (s *lockedSource) Seed(seed int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.src.Seed(seed)
+}
+
+// randString generate a random string of n characters from letters
+func randString(n int, letters string) string {
+	letterIdxBits := bits.Len(uint(len(letters) - 1)) // bits to represent a letter index
+	letterIdxMask := int64(1)<<letterIdxBits - 1      // All 1-bits, as many as letterIdxBits
+	letterIdxMax := 63 / letterIdxBits                // # of letter indices fitting in 63 bits
+
 	sb := strings.Builder{}
 	sb.Grow(n)
 	// A src.Int63() generates 63 random bits, enough for letterIdxMax characters!
@@ -191,8 +795,8 @@ func RandStringBytesMaskImprSrcSB(n int) string {
 		if remain == 0 {
 			cache, remain = src.Int63(), letterIdxMax
 		}
-		if idx := int(cache & letterIdxMask); idx < len(letterBytes) {
-			sb.WriteByte(letterBytes[idx])
+		if idx := int(cache & letterIdxMask); idx < len(letters) {
+			sb.WriteByte(letters[idx])
 			i--
 		}
 		cache >>= letterIdxBits
@@ -213,6 +817,32 @@ func (a *URLJSON) Scan(value interface{}) error {
 	return json.Unmarshal(b, &a)
 }
 
+// Truncate shorten the agent and referer, longest first, until the object
+// serializes to at most maxBytes so oversized headers can't bloat rows
+func (a URLJSON) Truncate(maxBytes int) URLJSON {
+	for {
+		b, err := json.Marshal(a)
+		over := len(b) - maxBytes
+		if err != nil || over <= 0 || (a.Agent == "" && a.Referer == "") {
+			return a
+		}
+
+		longest := &a.Referer
+		if len(a.Agent) > len(a.Referer) {
+			longest = &a.Agent
+		}
+		cut := len(*longest) - over
+		if cut < 0 {
+			cut = 0
+		}
+		// don't split a multi byte character
+		for cut > 0 && !utf8.RuneStart((*longest)[cut]) {
+			cut--
+		}
+		*longest = (*longest)[:cut]
+	}
+}
+
 // getenv get the desired environment variable or get the default
 // which is the fallback
 func getenv(key, fallback string) string {
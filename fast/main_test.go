@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestNewShortenURL(t *testing.T) {
+	tests := []struct {
+		name   string
+		domain LinkDomain
+		want   ShortURLFormats
+	}{
+		{"https", LinkDomain{Host: "fa.st", Scheme: "https"}, ShortURLFormats{
+			Bare:   "launch",
+			Domain: "fa.st/launch",
+			HTTP:   "http://fa.st/launch",
+			HTTPS:  "https://fa.st/launch",
+			Full:   "https://fa.st/launch",
+		}},
+		{"http", LinkDomain{Host: "fa.st", Scheme: "http"}, ShortURLFormats{
+			Bare:   "launch",
+			Domain: "fa.st/launch",
+			HTTP:   "http://fa.st/launch",
+			HTTPS:  "https://fa.st/launch",
+			Full:   "http://fa.st/launch",
+		}},
+		{"port", LinkDomain{Host: "localhost:8080", Scheme: "http"}, ShortURLFormats{
+			Bare:   "launch",
+			Domain: "localhost:8080/launch",
+			HTTP:   "http://localhost:8080/launch",
+			HTTPS:  "https://localhost:8080/launch",
+			Full:   "http://localhost:8080/launch",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.domain.NewShortenURL("launch")
+			if got.Formats != tt.want {
+				t.Errorf("formats = %+v, want %+v", got.Formats, tt.want)
+			}
+			if got.URI != "launch" || got.ShortenURL != tt.want.Domain || got.ShortenLongURL != tt.want.Full {
+				t.Errorf("short url = %+v, want the bare, domain and full formats", got)
+			}
+		})
+	}
+}
+
+func TestRandString(t *testing.T) {
+	tests := []struct {
+		name    string
+		letters string
+	}{
+		{"mixed case", letterBytes},
+		{"lowercase and digits", lowercaseLetterBytes},
+		{"not a power of two", "abc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seen := map[rune]bool{}
+			for i := 0; i < 200; i++ {
+				s := randString(defaultURILength, tt.letters)
+				if len(s) != defaultURILength {
+					t.Fatalf("randString() = %q, want %d characters", s, defaultURILength)
+				}
+				for _, r := range s {
+					if !strings.ContainsRune(tt.letters, r) {
+						t.Fatalf("randString() = %q, which has %q outside %s", s, r, tt.letters)
+					}
+					seen[r] = true
+				}
+			}
+			if len(seen) != len(tt.letters) {
+				t.Errorf("used %d of the %d letters", len(seen), len(tt.letters))
+			}
+		})
+	}
+}
+
+func TestURLJSONTruncate(t *testing.T) {
+	long := strings.Repeat("a", 3000)
+	tests := []struct {
+		name     string
+		in       URLJSON
+		maxBytes int
+		agent    string
+		referer  string
+	}{
+		{"under the limit", URLJSON{Agent: "curl/7.79.1", Referer: "https://example.com/"}, 2048, "curl/7.79.1", "https://example.com/"},
+		{"long agent", URLJSON{Agent: long, Referer: "https://example.com/"}, 100, strings.Repeat("a", 100-len(`{"agent":"","referer":"https://example.com/"}`)), "https://example.com/"},
+		{"long referer", URLJSON{Agent: "curl/7.79.1", Referer: long}, 100, "curl/7.79.1", strings.Repeat("a", 100-len(`{"agent":"curl/7.79.1","referer":""}`))},
+		{"multi byte", URLJSON{Agent: strings.Repeat("é", 100)}, 21, strings.Repeat("é", 4), ""},
+		{"nothing fits", URLJSON{Agent: long, Referer: long}, 5, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.in.Truncate(tt.maxBytes)
+			if got.Agent != tt.agent || got.Referer != tt.referer {
+				t.Errorf("Truncate(%d) = %d byte agent, %d byte referer, want %d and %d", tt.maxBytes, len(got.Agent), len(got.Referer), len(tt.agent), len(tt.referer))
+			}
+			if b, _ := json.Marshal(got); len(b) > tt.maxBytes && (got.Agent != "" || got.Referer != "") {
+				t.Errorf("serializes to %d bytes, over the %d limit", len(b), tt.maxBytes)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/aeekayy/systems/fast/db"
+	"github.com/gin-gonic/gin"
+	pgx "github.com/jackc/pgx/v4"
+	"go.uber.org/zap"
+)
+
+// DuplicateURI a link sharing its uri with at least one other link
+type DuplicateURI struct {
+	ID          string    `json:"id" yaml:"id"`
+	URI         string    `json:"uri" yaml:"uri"`
+	OriginalURL string    `json:"original_url" yaml:"original_url"`
+	Created     time.Time `json:"created" yaml:"created"`
+}
+
+// duplicatesHandler list links whose uri is used more than once, grouped by
+// uri and oldest first, so operators can clean up data from before the
+// unique index. ?case_insensitive=true also reports uris that only differ in
+// case, which have to go before case insensitive uris are turned on
+func duplicatesHandler(ctx context.Context, dbConn db.Querier, sugar *zap.SugaredLogger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sugar := requestSugar(c, sugar)
+		key := "uri"
+		if c.Query("case_insensitive") == "true" {
+			key = "lower(uri)"
+		}
+
+		rows, err := dbConn.Query(ctx, `SELECT id::text, uri, original_url, created FROM `+urlsTable+`
+			WHERE `+key+` IN (SELECT `+key+` FROM `+urlsTable+` GROUP BY 1 HAVING count(*) > 1)
+			ORDER BY `+key+`, created;`)
+		if err != nil {
+			sugar.Errorf("error finding duplicate uris: %s", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "error finding duplicate uris",
+			})
+			return
+		}
+		defer rows.Close()
+
+		duplicates := []DuplicateURI{}
+		for rows.Next() {
+			var duplicate DuplicateURI
+			if err := rows.Scan(&duplicate.ID, &duplicate.URI, &duplicate.OriginalURL, &duplicate.Created); err != nil {
+				sugar.Errorf("error reading duplicate uris: %s", err)
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"error": "error finding duplicate uris",
+				})
+				return
+			}
+			duplicates = append(duplicates, duplicate)
+		}
+		if err := rows.Err(); err != nil {
+			sugar.Errorf("error reading duplicate uris: %s", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "error finding duplicate uris",
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"data": duplicates,
+		})
+	}
+}
+
+// MergeRequest the links to fold into one. Every link pointing at
+// OriginalURL is merged into Canonical, or the oldest of them when it's
+// left out. RemoveOthers deletes the merged links instead of leaving them
+// to keep redirecting to the same destination
+type MergeRequest struct {
+	OriginalURL  string `json:"original_url" yaml:"original_url"`
+	Canonical    string `json:"canonical,omitempty" yaml:"canonical,omitempty"`
+	RemoveOthers bool   `json:"remove_others,omitempty" yaml:"remove_others,omitempty"`
+}
+
+// MergeResult the canonical link after a merge and the links folded into it
+type MergeResult struct {
+	URI    string   `json:"uri" yaml:"uri"`
+	Hits   int64    `json:"hits" yaml:"hits"`
+	Merged []string `json:"merged" yaml:"merged"`
+}
+
+// mergeDuplicatesHandler fold links sharing a destination into one, moving
+// their hits and clicks to the canonical link. Links that were created
+// before deduplication are the usual case. Everything happens in one
+// statement so the stats can't be counted twice or lost half way
+func mergeDuplicatesHandler(ctx context.Context, dbConn db.Querier, rc *RedirectCache, caseInsensitive bool, sugar *zap.SugaredLogger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sugar := requestSugar(c, sugar)
+		var json MergeRequest
+		if err := c.ShouldBindJSON(&json); err != nil {
+			bindError(c, err)
+			return
+		}
+		if json.OriginalURL == "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "original_url is required",
+			})
+			return
+		}
+
+		var result MergeResult
+		err := dbConn.QueryRow(ctx, `WITH links AS (
+				SELECT id, uri, hits, created FROM `+urlsTable+` WHERE original_url = $1 FOR UPDATE
+			), canonical AS (
+				SELECT id, uri FROM links WHERE ($2 = '' OR uri = $2) ORDER BY created LIMIT 1
+			), others AS (
+				SELECT links.id, links.uri, links.hits FROM links, canonical WHERE links.id <> canonical.id
+			), moved AS (
+				UPDATE clicks SET uri = (SELECT uri FROM canonical) WHERE uri IN (SELECT uri FROM others)
+			), merged AS (
+				UPDATE `+urlsTable+` AS u SET hits = u.hits + COALESCE((SELECT sum(hits) FROM others), 0)
+				FROM canonical WHERE u.id = canonical.id RETURNING u.uri, u.hits
+			), removed AS (
+				DELETE FROM `+urlsTable+` WHERE $3::boolean AND id IN (SELECT id FROM others) RETURNING uri
+			), emptied AS (
+				UPDATE `+urlsTable+` SET hits = 0 WHERE NOT $3::boolean AND id IN (SELECT id FROM others)
+			), audited AS (
+				INSERT INTO audit_log(action, uri, actor)
+				SELECT $4, uri, NULLIF($6, '') FROM merged
+				UNION ALL SELECT $5, uri, NULLIF($6, '') FROM removed
+			)
+			SELECT merged.uri, merged.hits, COALESCE((SELECT array_agg(uri ORDER BY uri) FROM others), '{}') FROM merged;`,
+			json.OriginalURL, json.Canonical, json.RemoveOthers, auditActionMerge, auditActionDelete, actorID(c)).
+			Scan(&result.URI, &result.Hits, &result.Merged)
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "no link with that original_url and canonical uri",
+			})
+			return
+		}
+		if err != nil {
+			sugar.Errorf("error merging duplicate links: %s", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "error merging duplicate links",
+			})
+			return
+		}
+
+		// merged links now redirect without hits of their own, or not at all
+		for _, uri := range result.Merged {
+			rc.Delete(cacheKey(uri, caseInsensitive))
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"data": result,
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDuplicatesHandler(t *testing.T) {
+	created := time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+	seeded := [][]interface{}{
+		{"1", "launch", "https://example.com/a", created},
+		{"7", "launch", "https://example.com/b", created.Add(time.Hour)},
+		{"3", "Promo", "https://example.com/c", created},
+		{"9", "promo", "https://example.com/d", created.Add(time.Hour)},
+	}
+	tests := []struct {
+		name  string
+		query string
+		key   string
+		rows  [][]interface{}
+	}{
+		{"exact duplicates", "", "WHERE uri IN (SELECT uri", seeded[:2]},
+		{"differing in case", "?case_insensitive=true", "WHERE lower(uri) IN (SELECT lower(uri)", seeded},
+		{"none", "", "WHERE uri IN (SELECT uri", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := (&fakeDB{}).on("HAVING count(*) > 1", fakeResult{rows: tt.rows})
+			r := testRouter()
+			r.GET("/api/v1/admin/duplicates", requireAdmin, duplicatesHandler(context.Background(), fake, testSugar))
+			w := serve(r, http.MethodGet, "/api/v1/admin/duplicates"+tt.query, testAdminKey, "")
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", w.Code, w.Body)
+			}
+			if sql := fake.statements("HAVING count(*) > 1")[0].sql; !strings.Contains(sql, tt.key) {
+				t.Errorf("query doesn't group on %s: %s", tt.key, sql)
+			}
+
+			var body struct {
+				Data []DuplicateURI `json:"data"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("couldn't decode %s: %s", w.Body, err)
+			}
+			if body.Data == nil || len(body.Data) != len(tt.rows) {
+				t.Fatalf("duplicates = %+v, want %d", body.Data, len(tt.rows))
+			}
+			for i, row := range tt.rows {
+				want := DuplicateURI{ID: row[0].(string), URI: row[1].(string), OriginalURL: row[2].(string), Created: row[3].(time.Time)}
+				if got := body.Data[i]; got.ID != want.ID || got.URI != want.URI || got.OriginalURL != want.OriginalURL || !got.Created.Equal(want.Created) {
+					t.Errorf("duplicate %d = %+v, want %+v", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestMergeDuplicatesHandler(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		result    []interface{}
+		status    int
+		canonical string
+		remove    bool
+	}{
+		{"oldest is canonical", `{"original_url": "https://example.com/a"}`, []interface{}{"launch", int64(12), []string{"promo", "spring"}}, http.StatusOK, "", false},
+		{"chosen canonical", `{"original_url": "https://example.com/a", "canonical": "spring"}`, []interface{}{"spring", int64(12), []string{"launch", "promo"}}, http.StatusOK, "spring", false},
+		{"remove the others", `{"original_url": "https://example.com/a", "remove_others": true}`, []interface{}{"launch", int64(12), []string{"promo", "spring"}}, http.StatusOK, "", true},
+		{"no such links", `{"original_url": "https://example.com/z"}`, nil, http.StatusNotFound, "", false},
+		{"no original_url", `{"canonical": "spring"}`, nil, http.StatusBadRequest, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeDB{}
+			if tt.result != nil {
+				fake.on("WITH links AS", fakeResult{rows: [][]interface{}{tt.result}})
+			}
+			rc := NewRedirectCache(10)
+			for _, uri := range []string{"launch", "promo", "spring"} {
+				rc.Set(cacheKey(uri, true), RedirectLink{URI: uri}, time.Hour)
+			}
+
+			r := testRouter()
+			r.POST("/api/v1/admin/merge", requireAdmin, mergeDuplicatesHandler(context.Background(), fake, rc, true, testSugar))
+			w := serve(r, http.MethodPost, "/api/v1/admin/merge", testAdminKey, tt.body)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status == http.StatusBadRequest {
+				if len(fake.calls) != 0 {
+					t.Errorf("a rejected merge reached the database")
+				}
+				return
+			}
+
+			args := fake.statements("WITH links AS")[0].args
+			if args[1] != tt.canonical || args[2] != tt.remove || args[5] != testAdminKey.ID {
+				t.Errorf("merge args = %v, want canonical %q, remove %t by %s", args, tt.canonical, tt.remove, testAdminKey.ID)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			var body struct {
+				Data MergeResult `json:"data"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("couldn't decode %s: %s", w.Body, err)
+			}
+			if body.Data.URI != tt.result[0] || body.Data.Hits != 12 || len(body.Data.Merged) != 2 {
+				t.Errorf("result = %+v, want %v", body.Data, tt.result)
+			}
+			for _, uri := range body.Data.Merged {
+				if _, ok := rc.Get(cacheKey(uri, true)); ok {
+					t.Errorf("merged link %s is still cached", uri)
+				}
+			}
+			if _, ok := rc.Get(cacheKey(body.Data.URI, true)); !ok {
+				t.Errorf("the canonical link was dropped from the cache")
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// redirectsTotal the redirects served by response status so operators
+	// can see the mix of successful and failed redirects
+	redirectsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "fast",
+		Name:      "redirects_total",
+		Help:      "Requests for short URIs by response status.",
+	}, []string{"status"})
+
+	// cacheLookupsTotal redirect cache lookups by whether they hit, for
+	// tuning the cache size and ttl
+	cacheLookupsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "fast",
+		Name:      "redirect_cache_lookups_total",
+		Help:      "Redirect cache lookups by result, hit or miss.",
+	}, []string{"result"})
+)
+
+// observeRedirect count the outcome of a short uri request once the
+// handler has written its status
+func observeRedirect(c *gin.Context) {
+	redirectsTotal.WithLabelValues(strconv.Itoa(c.Writer.Status())).Inc()
+}
+
+// observeCacheLookup count a redirect cache lookup as a hit or a miss
+func observeCacheLookup(hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	cacheLookupsTotal.WithLabelValues(result).Inc()
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestObserveRedirect(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		label  string
+	}{
+		{"redirected", http.StatusFound, "302"},
+		{"not found", http.StatusNotFound, "404"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := testutil.ToFloat64(redirectsTotal.WithLabelValues(tt.label))
+			r := gin.New()
+			r.GET("/:uri", func(c *gin.Context) {
+				c.Status(tt.status)
+				observeRedirect(c)
+			})
+			serve(r, http.MethodGet, "/launch", APIKey{}, "")
+			if got := testutil.ToFloat64(redirectsTotal.WithLabelValues(tt.label)) - before; got != 1 {
+				t.Errorf("counted %v redirects with status %s, want 1", got, tt.label)
+			}
+		})
+	}
+}
+
+func TestObserveCacheLookup(t *testing.T) {
+	tests := []struct {
+		hit   bool
+		label string
+	}{
+		{true, "hit"},
+		{false, "miss"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.label, func(t *testing.T) {
+			before := testutil.ToFloat64(cacheLookupsTotal.WithLabelValues(tt.label))
+			observeCacheLookup(tt.hit)
+			if got := testutil.ToFloat64(cacheLookupsTotal.WithLabelValues(tt.label)) - before; got != 1 {
+				t.Errorf("counted %v lookups as %s, want 1", got, tt.label)
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"math"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aeekayy/systems/fast/db"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	requestIDHeader     = "X-Request-ID" // The header carrying the request id to and from clients
+	requestIDContextKey = "request_id"   // Where the request id is stored in the gin context
+)
+
+// requestIDMiddleware give every request an id for correlating logs. An id
+// sent by the client or a proxy is kept, otherwise a new one is generated
+func requestIDMiddleware(c *gin.Context) {
+	id := c.GetHeader(requestIDHeader)
+	if id == "" {
+		id = newRequestID()
+	}
+
+	c.Set(requestIDContextKey, id)
+	c.Header(requestIDHeader, id)
+	c.Next()
+}
+
+// requestID the id of the current request
+func requestID(c *gin.Context) string {
+	return c.GetString(requestIDContextKey)
+}
+
+// newRequestID generate a random request id
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// maxInFlight limit how many requests are handled at once. Requests beyond
+// the limit get a 503 right away rather than queueing up on the database.
+// A limit of zero or less turns the check off
+func maxInFlight(limit int) gin.HandlerFunc {
+	if limit <= 0 {
+		return func(c *gin.Context) {
+			c.Next()
+		}
+	}
+
+	sem := make(chan struct{}, limit)
+	return func(c *gin.Context) {
+		select {
+		case sem <- struct{}{}:
+			defer func() { <-sem }()
+			c.Next()
+		default:
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+				"error": "server is busy, try again later",
+			})
+		}
+	}
+}
+
+// requireHTTPS send requests that arrived over plain http to the same URL
+// over https, since short links can carry signatures and other secrets.
+// Behind a proxy the scheme comes from X-Forwarded-Proto. GETs and HEADs
+// get a 301, anything else a 308 so the method and body survive. Health
+// checks are answered over either so load balancers probing over http work
+func requireHTTPS(enabled bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !enabled || isHTTPS(c.Request) || healthRoutes[c.FullPath()] {
+			c.Next()
+			return
+		}
+
+		status := http.StatusPermanentRedirect
+		if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+			status = http.StatusMovedPermanently
+		}
+		target := url.URL{
+			Scheme:   "https",
+			Host:     c.Request.Host,
+			Path:     c.Request.URL.Path,
+			RawPath:  c.Request.URL.RawPath,
+			RawQuery: c.Request.URL.RawQuery,
+		}
+		c.Redirect(status, target.String())
+		c.Abort()
+	}
+}
+
+// isHTTPS whether the client reached us over https, directly or through a
+// proxy. Proxies in a chain each add their scheme, the first is the client's
+func isHTTPS(req *http.Request) bool {
+	if req.TLS != nil {
+		return true
+	}
+	proto := req.Header.Get("X-Forwarded-Proto")
+	if i := strings.IndexByte(proto, ','); i >= 0 {
+		proto = proto[:i]
+	}
+	return strings.EqualFold(strings.TrimSpace(proto), "https")
+}
+
+// csvRoutes the routes that take a CSV body instead of JSON
+var csvRoutes = map[string]bool{
+	"/api/v1/admin/import/csv": true,
+}
+
+// requireJSON reject POST bodies that aren't JSON with a 415 so a client
+// sending form data gets a clear answer. POSTs without a body, like
+// actions on a uri in the path, are let through, and routes in csvRoutes
+// also take text/csv
+func requireJSON(c *gin.Context) {
+	if c.Request.Method != http.MethodPost || c.Request.ContentLength == 0 {
+		c.Next()
+		return
+	}
+
+	mediaType, _, err := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	if err == nil && mediaType == "text/csv" && csvRoutes[c.FullPath()] {
+		c.Next()
+		return
+	}
+	if err != nil || mediaType != "application/json" {
+		c.AbortWithStatusJSON(http.StatusUnsupportedMediaType, gin.H{
+			"error": "content type must be application/json",
+		})
+		return
+	}
+
+	c.Next()
+}
+
+// healthRoutes the routes that answer without the database
+var healthRoutes = map[string]bool{
+	"/api/v1/ping":    true,
+	"/api/v1/version": true,
+	"/healthz":        true,
+	"/metrics":        true,
+}
+
+// failFast respond with a 503 straight away while the database a request
+// needs is behind an open circuit breaker. Reads only need the reader,
+// everything else needs the primary. Clients are told to come back once
+// the breaker's cooldown has passed
+func failFast(reader, writer *db.Breaker, cooldown time.Duration) gin.HandlerFunc {
+	retryAfter := strconv.Itoa(int(math.Ceil(cooldown.Seconds())))
+	return func(c *gin.Context) {
+		breaker := writer
+		if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+			breaker = reader
+		}
+		if healthRoutes[c.FullPath()] || !breaker.Open() {
+			c.Next()
+			return
+		}
+
+		c.Header("Retry-After", retryAfter)
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+			"error": "the database is unavailable, try again later",
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aeekayy/systems/fast/db"
+	"github.com/gin-gonic/gin"
+)
+
+func TestRequestIDMiddleware(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+	}{
+		{"generated", ""},
+		{"kept from the client", "from-the-proxy"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen string
+			r := gin.New()
+			r.Use(requestIDMiddleware)
+			r.GET("/", func(c *gin.Context) { seen = requestID(c) })
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header.Set(requestIDHeader, tt.header)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			id := w.Header().Get(requestIDHeader)
+			if id != seen || id == "" {
+				t.Fatalf("responded with id %q, handler saw %q", id, seen)
+			}
+			if tt.header != "" && id != tt.header {
+				t.Errorf("id = %q, want the client's %q", id, tt.header)
+			}
+			if tt.header == "" && len(id) != 32 {
+				t.Errorf("generated id %q, want 32 hex characters", id)
+			}
+		})
+	}
+}
+
+func TestMaxInFlight(t *testing.T) {
+	tests := []struct {
+		name     string
+		limit    int
+		inFlight int
+		status   int
+	}{
+		{"under the limit", 2, 1, http.StatusOK},
+		{"at the limit", 2, 2, http.StatusServiceUnavailable},
+		{"turned off", 0, 5, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			started, release := make(chan struct{}), make(chan struct{})
+			r := gin.New()
+			r.Use(maxInFlight(tt.limit))
+			r.GET("/slow", func(c *gin.Context) {
+				started <- struct{}{}
+				<-release
+			})
+			r.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+			var wg sync.WaitGroup
+			for i := 0; i < tt.inFlight; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					serve(r, http.MethodGet, "/slow", APIKey{}, "")
+				}()
+				<-started
+			}
+			w := serve(r, http.MethodGet, "/", APIKey{}, "")
+			close(release)
+			wg.Wait()
+
+			if w.Code != tt.status {
+				t.Errorf("status = %d with %d in flight, want %d", w.Code, tt.inFlight, tt.status)
+			}
+		})
+	}
+}
+
+func TestRequireJSON(t *testing.T) {
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		contentType string
+		body        string
+		status      int
+	}{
+		{"json", http.MethodPost, "/api/v1/shorten", "application/json", `{}`, http.StatusOK},
+		{"json with charset", http.MethodPost, "/api/v1/shorten", "application/json; charset=utf-8", `{}`, http.StatusOK},
+		{"form", http.MethodPost, "/api/v1/shorten", "application/x-www-form-urlencoded", "url=x", http.StatusUnsupportedMediaType},
+		{"text", http.MethodPost, "/api/v1/shorten", "text/plain", "x", http.StatusUnsupportedMediaType},
+		{"no content type", http.MethodPost, "/api/v1/shorten", "", `{}`, http.StatusUnsupportedMediaType},
+		{"no body", http.MethodPost, "/api/v1/shorten", "", "", http.StatusOK},
+		{"csv import", http.MethodPost, "/api/v1/admin/import/csv", "text/csv", "url\nhttps://example.com/a\n", http.StatusOK},
+		{"csv elsewhere", http.MethodPost, "/api/v1/shorten", "text/csv", "url\nhttps://example.com/a\n", http.StatusUnsupportedMediaType},
+		{"not a post", http.MethodPut, "/api/v1/shorten", "text/plain", "x", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			r.Use(requireJSON)
+			r.Handle(tt.method, tt.path, func(c *gin.Context) { c.Status(http.StatusOK) })
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+}
+
+func TestFailFast(t *testing.T) {
+	tests := []struct {
+		name       string
+		readerOpen bool
+		writerOpen bool
+		method     string
+		path       string
+		status     int
+	}{
+		{"closed", false, false, http.MethodGet, "/launch", http.StatusOK},
+		{"read with the reader open", true, false, http.MethodGet, "/launch", http.StatusServiceUnavailable},
+		{"head with the reader open", true, false, http.MethodHead, "/launch", http.StatusServiceUnavailable},
+		{"write with the reader open", true, false, http.MethodPost, "/launch", http.StatusOK},
+		{"write with the writer open", false, true, http.MethodPost, "/launch", http.StatusServiceUnavailable},
+		{"read with the writer open", false, true, http.MethodGet, "/launch", http.StatusOK},
+		{"health check", true, true, http.MethodGet, "/healthz", http.StatusOK},
+	}
+
+	// breaker a breaker that is open or closed
+	breaker := func(open bool) *db.Breaker {
+		b := db.NewBreaker((&fakeDB{}).on("SELECT 1", fakeResult{err: io.EOF}), 1, time.Minute)
+		if open {
+			b.QueryRow(context.Background(), "SELECT 1").Scan()
+		}
+		return b
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			r.Use(failFast(breaker(tt.readerOpen), breaker(tt.writerOpen), 1500*time.Millisecond))
+			r.Handle(tt.method, "/launch", func(c *gin.Context) { c.Status(http.StatusOK) })
+			r.GET("/healthz", func(c *gin.Context) { c.Status(http.StatusOK) })
+			w := serve(r, tt.method, tt.path, APIKey{}, "")
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status == http.StatusServiceUnavailable && w.Header().Get("Retry-After") != "2" {
+				t.Errorf("Retry-After = %q, want the cooldown rounded up", w.Header().Get("Retry-After"))
+			}
+		})
+	}
+}
+
+func TestRequireHTTPS(t *testing.T) {
+	tests := []struct {
+		name      string
+		enabled   bool
+		method    string
+		target    string
+		tls       bool
+		forwarded string
+		status    int
+		location  string
+	}{
+		{"disabled", false, http.MethodGet, "http://fa.st/launch", false, "", http.StatusOK, ""},
+		{"get", true, http.MethodGet, "http://fa.st/launch?utm_source=mail", false, "", http.StatusMovedPermanently, "https://fa.st/launch?utm_source=mail"},
+		{"head", true, http.MethodHead, "http://fa.st/launch", false, "", http.StatusMovedPermanently, "https://fa.st/launch"},
+		{"post keeps its method", true, http.MethodPost, "http://fa.st/api/v1/shorten", false, "", http.StatusPermanentRedirect, "https://fa.st/api/v1/shorten"},
+		{"escaped path", true, http.MethodGet, "http://fa.st/a%2Fb", false, "", http.StatusMovedPermanently, "https://fa.st/a%2Fb"},
+		{"tls", true, http.MethodGet, "https://fa.st/launch", true, "", http.StatusOK, ""},
+		{"behind a proxy", true, http.MethodGet, "http://fa.st/launch", false, "https", http.StatusOK, ""},
+		{"proxy chain", true, http.MethodGet, "http://fa.st/launch", false, "HTTPS, http", http.StatusOK, ""},
+		{"client over http behind a proxy", true, http.MethodGet, "http://fa.st/launch", false, "http, https", http.StatusMovedPermanently, "https://fa.st/launch"},
+		{"health check", true, http.MethodGet, "http://fa.st/healthz", false, "", http.StatusOK, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			r.Use(requireHTTPS(tt.enabled))
+			r.GET("/healthz", func(c *gin.Context) { c.Status(http.StatusOK) })
+			r.Handle(tt.method, "/:uri", func(c *gin.Context) { c.Status(http.StatusOK) })
+			r.POST("/api/v1/shorten", func(c *gin.Context) { c.Status(http.StatusOK) })
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			if !tt.tls {
+				req.TLS = nil
+			}
+			if tt.forwarded != "" {
+				req.Header.Set("X-Forwarded-Proto", tt.forwarded)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.status || w.Header().Get("Location") != tt.location {
+				t.Errorf("got %d to %q, want %d to %q", w.Code, w.Header().Get("Location"), tt.status, tt.location)
+			}
+		})
+	}
+}
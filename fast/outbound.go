@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	defaultMaxRedirects    = 5               // The most redirects followed when fetching a destination
+	defaultOutboundTimeout = 5 * time.Second // How long we wait on a destination unless told otherwise
+	defaultMinTLSVersion   = "1.2"           // The oldest TLS version destinations may negotiate
+)
+
+// tlsVersions the TLS versions min_tls_version may name
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var (
+	// errTooManyRedirects a destination redirected more times than allowed
+	errTooManyRedirects = errors.New("stopped after too many redirects")
+	// errBlockedAddress a destination resolved to an address we won't connect to
+	errBlockedAddress = errors.New("destination address is not allowed")
+
+	// sharedAddressSpace carrier grade NAT addresses, which net.IP doesn't treat as private
+	sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+)
+
+// OutboundConfig how we fetch destinations for previews and validation.
+// AllowPrivate lets fetches reach private and loopback addresses, which
+// should only be turned on for local development, like InsecureSkipVerify
+// which accepts any certificate
+type OutboundConfig struct {
+	MaxRedirects       int           `mapstructure:"max_redirects" yaml:"max_redirects"`
+	Timeout            time.Duration `mapstructure:"timeout" yaml:"timeout"`
+	AllowPrivate       bool          `mapstructure:"allow_private" yaml:"allow_private"`
+	MinTLSVersion      string        `mapstructure:"min_tls_version" yaml:"min_tls_version"`
+	InsecureSkipVerify bool          `mapstructure:"insecure_skip_verify" yaml:"insecure_skip_verify"`
+}
+
+// loadOutboundConfig read the outbound HTTP settings for the environment
+func loadOutboundConfig(env string) (OutboundConfig, error) {
+	cfg := OutboundConfig{MaxRedirects: defaultMaxRedirects, MinTLSVersion: defaultMinTLSVersion}
+	key := fmt.Sprintf("%s.outbound", env)
+	if viper.IsSet(key) {
+		if err := viper.UnmarshalKey(key, &cfg); err != nil {
+			return cfg, fmt.Errorf("couldn't read outbound configuration: %w", err)
+		}
+	}
+	if cfg.MaxRedirects < 0 {
+		return cfg, errors.New("outbound max_redirects can't be negative")
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultOutboundTimeout
+	}
+	if cfg.MinTLSVersion == "" {
+		cfg.MinTLSVersion = defaultMinTLSVersion
+	}
+	if _, ok := tlsVersions[cfg.MinTLSVersion]; !ok {
+		return cfg, fmt.Errorf("outbound min_tls_version must be 1.0, 1.1, 1.2 or 1.3, not %s", cfg.MinTLSVersion)
+	}
+
+	return cfg, nil
+}
+
+// newOutboundClient an HTTP client for fetching destinations. Redirect
+// chains longer than the configured max fail with errTooManyRedirects so a
+// destination can't keep us busy following it around. Unless private
+// addresses are allowed, connections to internal addresses are refused so
+// a destination can't be used to reach our own network. Destinations only
+// offering TLS older than the configured minimum fail the handshake
+func newOutboundClient(cfg OutboundConfig, timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout}
+	if !cfg.AllowPrivate {
+		dialer.Control = blockPrivateAddresses
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	transport.TLSClientConfig = &tls.Config{
+		MinVersion:         tlsVersions[cfg.MinTLSVersion],
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > cfg.MaxRedirects {
+				return errTooManyRedirects
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("can't follow redirect to %s", req.URL.Scheme)
+			}
+			return nil
+		},
+	}
+}
+
+// blockPrivateAddresses refuse connections to addresses that aren't
+// publicly routable. This runs after DNS resolution so a public name
+// pointing at an internal address is caught too
+func blockPrivateAddresses(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !isPublicIP(ip) {
+		return errBlockedAddress
+	}
+
+	return nil
+}
+
+// isPublicIP whether the address is publicly routable
+func isPublicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() ||
+		sharedAddressSpace.Contains(ip))
+}
+
+// unwrapURL follow the destination's own redirects and return where it
+// finally lands
+func unwrapURL(ctx context.Context, client *http.Client, destination string) (string, error) {
+	parsed, err := url.ParseRequestURI(destination)
+	if err != nil {
+		return "", fmt.Errorf("couldn't parse url: %w", err)
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return "", fmt.Errorf("can't unwrap %s urls", parsed.Scheme)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, destination, nil)
+	if err != nil {
+		return "", fmt.Errorf("couldn't build request: %w", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxPreviewBodyBytes))
+
+	return resp.Request.URL.String(), nil
+}
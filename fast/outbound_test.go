@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// redirectingServer a server where /hop/N redirects to /hop/N-1 and /hop/0 lands
+func redirectingServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, err := strconv.Atoi(r.URL.Path[len("/hop/"):])
+		if err != nil || n == 0 {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		http.Redirect(w, r, "/hop/"+strconv.Itoa(n-1), http.StatusFound)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestLoadOutboundConfig(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   map[string]interface{}
+		want  OutboundConfig
+		valid bool
+	}{
+		{"defaults", nil, OutboundConfig{MaxRedirects: defaultMaxRedirects, Timeout: defaultOutboundTimeout, MinTLSVersion: defaultMinTLSVersion}, true},
+		{"configured", map[string]interface{}{"max_redirects": 2, "timeout": "1s"}, OutboundConfig{MaxRedirects: 2, Timeout: time.Second, MinTLSVersion: defaultMinTLSVersion}, true},
+		{"no redirects", map[string]interface{}{"max_redirects": 0}, OutboundConfig{Timeout: defaultOutboundTimeout, MinTLSVersion: defaultMinTLSVersion}, true},
+		{"negative redirects", map[string]interface{}{"max_redirects": -1}, OutboundConfig{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.cfg != nil {
+				withConfig(t, "test.outbound", tt.cfg)
+			}
+			cfg, err := loadOutboundConfig("test")
+			if (err == nil) != tt.valid {
+				t.Fatalf("loadOutboundConfig() = %v, want valid = %t", err, tt.valid)
+			}
+			if tt.valid && cfg != tt.want {
+				t.Errorf("loadOutboundConfig() = %+v, want %+v", cfg, tt.want)
+			}
+		})
+	}
+}
+
+func TestOutboundClientRedirects(t *testing.T) {
+	server := redirectingServer(t)
+	client := newOutboundClient(OutboundConfig{MaxRedirects: 2, AllowPrivate: true, MinTLSVersion: defaultMinTLSVersion}, time.Second)
+
+	tests := []struct {
+		name string
+		hops int
+		err  error
+	}{
+		{"no redirect", 0, nil},
+		{"within the limit", 2, nil},
+		{"too many", 4, errTooManyRedirects},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := client.Get(server.URL + "/hop/" + strconv.Itoa(tt.hops))
+			if err == nil {
+				resp.Body.Close()
+			}
+			if !errors.Is(err, tt.err) {
+				t.Errorf("Get() = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestUnwrapURL(t *testing.T) {
+	server := redirectingServer(t)
+	client := newOutboundClient(OutboundConfig{MaxRedirects: 5, AllowPrivate: true, MinTLSVersion: defaultMinTLSVersion}, time.Second)
+
+	tests := []struct {
+		name        string
+		destination string
+		want        string
+		valid       bool
+	}{
+		{"follows to the end", server.URL + "/hop/3", server.URL + "/hop/0", true},
+		{"already final", server.URL + "/hop/0", server.URL + "/hop/0", true},
+		{"not a url", "example", "", false},
+		{"not http", "ftp://example.com/file", "", false},
+		{"too many redirects", server.URL + "/hop/9", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := unwrapURL(context.Background(), client, tt.destination)
+			if (err == nil) != tt.valid {
+				t.Fatalf("unwrapURL() = %v, want valid = %t", err, tt.valid)
+			}
+			if got != tt.want {
+				t.Errorf("unwrapURL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBlockPrivateAddresses(t *testing.T) {
+	tests := []struct {
+		address string
+		blocked bool
+	}{
+		{"93.184.216.34:443", false},
+		{"[2606:2800:220:1:248:1893:25c8:1946]:443", false},
+		{"127.0.0.1:80", true},
+		{"10.1.2.3:80", true},
+		{"192.168.0.10:80", true},
+		{"169.254.169.254:80", true},
+		{"100.64.0.1:80", true},
+		{"0.0.0.0:80", true},
+		{"[::1]:80", true},
+		{"[fe80::1]:80", true},
+		{"[fd00::1]:80", true},
+		{"not-an-address", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			if err := blockPrivateAddresses("tcp", tt.address, nil); (err != nil) != tt.blocked {
+				t.Errorf("blockPrivateAddresses(%s) = %v, want blocked = %t", tt.address, err, tt.blocked)
+			}
+		})
+	}
+
+	// the loopback test server is refused unless private addresses are allowed
+	server := redirectingServer(t)
+	client := newOutboundClient(OutboundConfig{MinTLSVersion: defaultMinTLSVersion}, time.Second)
+	if _, err := client.Get(server.URL + "/hop/0"); !errors.Is(err, errBlockedAddress) {
+		t.Errorf("Get(loopback) = %v, want %v", err, errBlockedAddress)
+	}
+}
+
+func TestOutboundClientMinTLSVersion(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	server.StartTLS()
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		version string
+		valid   bool
+	}{
+		{"older than the server offers", "1.0", true},
+		{"what the server offers", "1.2", true},
+		{"newer than the server offers", "1.3", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withConfig(t, "test.outbound", map[string]interface{}{"min_tls_version": tt.version, "allow_private": true, "insecure_skip_verify": true})
+			cfg, err := loadOutboundConfig("test")
+			if err != nil {
+				t.Fatalf("loadOutboundConfig() = %v", err)
+			}
+			resp, err := newOutboundClient(cfg, time.Second).Get(server.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if (err == nil) != tt.valid {
+				t.Errorf("Get() = %v, want valid = %t", err, tt.valid)
+			}
+		})
+	}
+
+	withConfig(t, "test.outbound", map[string]interface{}{"min_tls_version": "1.4"})
+	if _, err := loadOutboundConfig("test"); err == nil {
+		t.Error("loadOutboundConfig() = nil, want an error for an unknown tls version")
+	}
+}
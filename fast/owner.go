@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/aeekayy/systems/fast/db"
+	"github.com/gin-gonic/gin"
+	pgx "github.com/jackc/pgx/v4"
+	"go.uber.org/zap"
+)
+
+// TransferRequest the API key id a link is handed over to
+type TransferRequest struct {
+	Owner string `json:"owner" yaml:"owner"`
+}
+
+// transferOwnerHandler hand a link over to another API key, e.g. when an
+// account is reassigned, and audit the change. The new owner has to be a
+// configured key so links can't be given to nobody. The link keeps the
+// domain it was created under, but not its idempotency key: keys are only
+// unique per owner and belong to the old owner's retries
+func transferOwnerHandler(ctx context.Context, dbConn db.Querier, apiKeys []APIKey, caseInsensitive bool, sugar *zap.SugaredLogger) gin.HandlerFunc {
+	owners := map[string]bool{}
+	for _, key := range apiKeys {
+		owners[key.ID] = true
+	}
+
+	return func(c *gin.Context) {
+		sugar := requestSugar(c, sugar)
+		var json TransferRequest
+		if err := c.ShouldBindJSON(&json); err != nil {
+			bindError(c, err)
+			return
+		}
+		if !owners[json.Owner] {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("owner %q isn't a configured api key", json.Owner),
+			})
+			return
+		}
+
+		var uri, previousOwner string
+		err := dbConn.QueryRow(ctx, `WITH old AS (
+				SELECT id, uri, COALESCE(owner, '') AS owner FROM `+urlsTable+` WHERE `+uriCondition(caseInsensitive)+` LIMIT 1 FOR UPDATE
+			), updated AS (
+				UPDATE `+urlsTable+` AS u SET owner = $2, idempotency_key = NULL FROM old WHERE u.id = old.id RETURNING u.uri
+			), audited AS (
+				INSERT INTO audit_log(action, uri, actor) SELECT $3, uri, NULLIF($4, '') FROM updated
+			)
+			SELECT uri, owner FROM old;`, c.Param("uri"), json.Owner, auditActionTransfer, actorID(c)).Scan(&uri, &previousOwner)
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "uri not found",
+			})
+			return
+		}
+		if err != nil {
+			sugar.Errorf("error transferring URI: %s", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "error transferring URI",
+			})
+			return
+		}
+
+		response := gin.H{
+			"uri":   uri,
+			"owner": json.Owner,
+		}
+		if previousOwner != "" {
+			response["previous_owner"] = previousOwner
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"data": response,
+		})
+	}
+}
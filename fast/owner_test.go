@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/jackc/pgconn"
+)
+
+func TestTransferOwnerHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		status   int
+		previous string
+	}{
+		{"to another key", `{"owner": "globex"}`, http.StatusOK, "acme"},
+		{"unknown owner", `{"owner": "initech"}`, http.StatusBadRequest, ""},
+		{"no owner", `{}`, http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := (&fakeDB{}).on("WITH old AS", fakeResult{rows: [][]interface{}{{"launch", testOwnerKey.ID}}})
+			r := testRouter()
+			r.POST("/api/v1/admin/urls/:uri/transfer", requireAdmin, transferOwnerHandler(context.Background(), fake, testAPIKeys, true, testSugar))
+			w := serve(r, http.MethodPost, "/api/v1/admin/urls/launch/transfer", testAdminKey, tt.body)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status != http.StatusOK {
+				if len(fake.calls) != 0 {
+					t.Errorf("a rejected transfer reached the database")
+				}
+				return
+			}
+
+			args := fake.statements("WITH old AS")[0].args
+			if args[0] != "launch" || args[1] != testOtherKey.ID || args[2] != auditActionTransfer || args[3] != testAdminKey.ID {
+				t.Errorf("transfer args = %v, want launch to %s audited by %s", args, testOtherKey.ID, testAdminKey.ID)
+			}
+			var body struct {
+				Data map[string]string `json:"data"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("couldn't decode %s: %s", w.Body, err)
+			}
+			if body.Data["owner"] != testOtherKey.ID || body.Data["previous_owner"] != tt.previous {
+				t.Errorf("body = %v, want owned by %s, previously %s", body.Data, testOtherKey.ID, tt.previous)
+			}
+		})
+	}
+}
+
+func TestTransferOwnerHandlerHandsOverManagement(t *testing.T) {
+	// the link's owner as the database has it
+	owner := testOwnerKey.ID
+	fake := (&fakeDB{}).
+		onFunc("WITH old AS", func(args []interface{}) fakeResult {
+			previous := owner
+			owner = args[1].(string)
+			return fakeResult{rows: [][]interface{}{{"launch", previous}}}
+		}).
+		onFunc("WITH deleted AS", func(args []interface{}) fakeResult {
+			return ownedLink("launch", owner, 1)(args)
+		})
+
+	r := testRouter()
+	r.POST("/api/v1/admin/urls/:uri/transfer", requireAdmin, transferOwnerHandler(context.Background(), fake, testAPIKeys, true, testSugar))
+	r.DELETE("/api/v1/urls/:uri", requireAPIKey, deleteURLHandler(context.Background(), fake, NewRedirectCache(10), true, testSugar))
+	if w := serve(r, http.MethodPost, "/api/v1/admin/urls/launch/transfer", testAdminKey, `{"owner": "globex"}`); w.Code != http.StatusOK {
+		t.Fatalf("transfer status = %d: %s", w.Code, w.Body)
+	}
+
+	tests := []struct {
+		name   string
+		key    APIKey
+		status int
+	}{
+		{"old owner", testOwnerKey, http.StatusNotFound},
+		{"new owner", testOtherKey, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := serve(r, http.MethodDelete, "/api/v1/urls/launch", tt.key, ""); w.Code != tt.status {
+				t.Errorf("delete status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+}
+
+func TestTransferOwnerHandlerIdempotencyKeys(t *testing.T) {
+	// globex already used the key the link was created with, so moving the
+	// link with its key would break the per owner unique index
+	uniqueViolation := &pgconn.PgError{Code: "23505", ConstraintName: "idx_urls_owner_idempotency_key"}
+	fake := (&fakeDB{}).
+		on("idempotency_key = NULL", fakeResult{rows: [][]interface{}{{"launch", testOwnerKey.ID}}}).
+		on("WITH old AS", fakeResult{err: uniqueViolation})
+
+	r := testRouter()
+	r.POST("/api/v1/admin/urls/:uri/transfer", requireAdmin, transferOwnerHandler(context.Background(), fake, testAPIKeys, true, testSugar))
+	w := serve(r, http.MethodPost, "/api/v1/admin/urls/launch/transfer", testAdminKey, `{"owner": "globex"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want the key dropped and the link moved: %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// prettyWriter hold on to the response body so it can be indented once the
+// handler is done
+type prettyWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+// Write buffer the body instead of sending it
+func (w *prettyWriter) Write(b []byte) (int, error) {
+	return w.body.Write(b)
+}
+
+// WriteString buffer the body instead of sending it
+func (w *prettyWriter) WriteString(s string) (int, error) {
+	return w.body.WriteString(s)
+}
+
+// prettyJSON indent JSON responses when the client asks with ?pretty=true
+// or the environment turns it on for everyone. Responses stay compact
+// otherwise
+func prettyJSON(enabled func() bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		pretty := enabled()
+		if val := c.Query("pretty"); val != "" {
+			pretty, _ = strconv.ParseBool(val)
+		}
+		if !pretty {
+			c.Next()
+			return
+		}
+
+		writer := &prettyWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Next()
+		c.Writer = writer.ResponseWriter
+
+		body := writer.body.Bytes()
+		contentType := writer.Header().Get("Content-Type")
+		if strings.HasPrefix(contentType, "application/json") || strings.HasPrefix(contentType, problemContentType) {
+			var indented bytes.Buffer
+			if err := json.Indent(&indented, body, "", "  "); err == nil {
+				indented.WriteByte('\n')
+				body = indented.Bytes()
+			}
+		}
+		writer.ResponseWriter.Write(body)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestPrettyJSON(t *testing.T) {
+	indented := "{\n  \"data\": {\n    \"uri\": \"launch\"\n  }\n}\n"
+	compact := `{"data":{"uri":"launch"}}`
+
+	tests := []struct {
+		name    string
+		enabled bool
+		query   string
+		path    string
+		want    string
+	}{
+		{"compact by default", false, "", "/json", compact},
+		{"asked for", false, "?pretty=true", "/json", indented},
+		{"on for everyone", true, "", "/json", indented},
+		{"turned off by the client", true, "?pretty=false", "/json", compact},
+		{"not json", true, "", "/text", "plain text"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := testRouter()
+			r.Use(prettyJSON(func() bool { return tt.enabled }))
+			r.GET("/json", func(c *gin.Context) {
+				c.JSON(http.StatusOK, gin.H{"data": gin.H{"uri": "launch"}})
+			})
+			r.GET("/text", func(c *gin.Context) {
+				c.String(http.StatusOK, "plain text")
+			})
+			w := serve(r, http.MethodGet, tt.path+tt.query, APIKey{}, "")
+			if w.Code != http.StatusOK || w.Body.String() != tt.want {
+				t.Errorf("got %d %q, want %q", w.Code, w.Body, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"golang.org/x/net/html"
+)
+
+const (
+	defaultPreviewTimeout       = 3 * time.Second // How long we wait on a destination when fetching its preview
+	defaultPreviewMaxConcurrent = 16              // Preview fetches made at once before crawlers get the defaults
+	maxPreviewBodyBytes         = 1 << 20         // The most of a destination page we read looking for meta tags
+)
+
+// errPreviewBusy every preview fetch slot was taken, so the defaults were used
+var errPreviewBusy = errors.New("too many preview fetches in flight")
+
+var (
+	// crawlerAgents User-Agent fragments of the social media crawlers that
+	// want Open Graph tags instead of a redirect
+	crawlerAgents = []string{
+		"facebookexternalhit",
+		"facebot",
+		"twitterbot",
+		"slackbot",
+		"linkedinbot",
+		"discordbot",
+		"whatsapp",
+		"telegrambot",
+		"skypeuripreview",
+		"embedly",
+		"pinterest",
+	}
+
+	previewTemplate = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<meta property="og:url" content="{{.URL}}">
+{{- if .Title}}
+<meta property="og:title" content="{{.Title}}">
+{{- end}}
+{{- if .Description}}
+<meta property="og:description" content="{{.Description}}">
+{{- end}}
+{{- if .Image}}
+<meta property="og:image" content="{{.Image}}">
+{{- end}}
+<meta http-equiv="refresh" content="0; url={{.URL}}">
+</head>
+<body>
+<a href="{{.URL}}">{{.URL}}</a>
+</body>
+</html>
+`))
+)
+
+// PreviewConfig how short links are previewed for crawlers. Title,
+// Description and Image are the defaults used when nothing better is known.
+// With Fetch the destination is fetched and its own tags are preferred. At
+// most MaxConcurrent fetches run at once, a crawler waits QueueTimeout for
+// one to finish before getting the defaults instead
+type PreviewConfig struct {
+	Enabled       bool          `mapstructure:"enabled" yaml:"enabled"`
+	Fetch         bool          `mapstructure:"fetch" yaml:"fetch"`
+	Timeout       time.Duration `mapstructure:"timeout" yaml:"timeout"`
+	MaxConcurrent int           `mapstructure:"max_concurrent" yaml:"max_concurrent"`
+	QueueTimeout  time.Duration `mapstructure:"queue_timeout" yaml:"queue_timeout"`
+	Title         string        `mapstructure:"title" yaml:"title"`
+	Description   string        `mapstructure:"description" yaml:"description"`
+	Image         string        `mapstructure:"image" yaml:"image"`
+}
+
+// Preview the Open Graph details rendered for a short link
+type Preview struct {
+	URL         string
+	Title       string
+	Description string
+	Image       string
+}
+
+// loadPreviewConfig read the preview settings for the environment
+func loadPreviewConfig(env string) (PreviewConfig, error) {
+	var cfg PreviewConfig
+	if err := viper.UnmarshalKey(fmt.Sprintf("%s.preview", env), &cfg); err != nil {
+		return cfg, fmt.Errorf("couldn't read preview configuration: %w", err)
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultPreviewTimeout
+	}
+	if cfg.MaxConcurrent <= 0 {
+		cfg.MaxConcurrent = defaultPreviewMaxConcurrent
+	}
+	if cfg.QueueTimeout < 0 {
+		return cfg, fmt.Errorf("preview queue_timeout can't be negative")
+	}
+
+	return cfg, nil
+}
+
+// previewSlots a semaphore bounding the preview fetches in flight so a
+// burst of crawlers can't use up the outbound sockets
+type previewSlots chan struct{}
+
+// newPreviewSlots the slots for the configured number of fetches
+func newPreviewSlots(cfg PreviewConfig) previewSlots {
+	return make(previewSlots, cfg.MaxConcurrent)
+}
+
+// acquire take a slot, waiting up to wait for one to free up. A false
+// return means none did and nothing needs releasing
+func (s previewSlots) acquire(ctx context.Context, wait time.Duration) bool {
+	select {
+	case s <- struct{}{}:
+		return true
+	default:
+	}
+	if wait <= 0 {
+		return false
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case s <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// release give back a slot taken with acquire
+func (s previewSlots) release() {
+	<-s
+}
+
+// isCrawler whether the User-Agent belongs to a link preview crawler
+func isCrawler(userAgent string) bool {
+	ua := strings.ToLower(userAgent)
+	for _, agent := range crawlerAgents {
+		if strings.Contains(ua, agent) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// buildPreview the preview for a destination, starting from the configured
+// defaults and preferring the destination's own tags when fetching is on.
+// A failed fetch, or one there was no slot for, still returns the defaults
+// along with the error
+func buildPreview(ctx context.Context, cfg PreviewConfig, slots previewSlots, client *http.Client, destination string) (Preview, error) {
+	preview := Preview{
+		URL:         destination,
+		Title:       cfg.Title,
+		Description: cfg.Description,
+		Image:       cfg.Image,
+	}
+	if !cfg.Fetch {
+		return preview, nil
+	}
+	if !slots.acquire(ctx, cfg.QueueTimeout) {
+		return preview, errPreviewBusy
+	}
+	defer slots.release()
+
+	fetched, err := fetchPreview(ctx, client, destination)
+	if err != nil {
+		return preview, err
+	}
+	if fetched.Title != "" {
+		preview.Title = fetched.Title
+	}
+	if fetched.Description != "" {
+		preview.Description = fetched.Description
+	}
+	if fetched.Image != "" {
+		preview.Image = fetched.Image
+	}
+
+	return preview, nil
+}
+
+// fetchPreview retrieve the destination page and read its title and Open Graph tags
+func fetchPreview(ctx context.Context, client *http.Client, destination string) (Preview, error) {
+	preview := Preview{URL: destination}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, destination, nil)
+	if err != nil {
+		return preview, fmt.Errorf("couldn't build preview request: %w", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return preview, fmt.Errorf("couldn't fetch preview: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return preview, fmt.Errorf("couldn't fetch preview: destination returned %d", resp.StatusCode)
+	}
+
+	return parsePreview(io.LimitReader(resp.Body, maxPreviewBodyBytes), preview), nil
+}
+
+// parsePreview pull the title and Open Graph tags out of an HTML document.
+// Open Graph tags win over the plain title and description
+func parsePreview(r io.Reader, preview Preview) Preview {
+	var title, description string
+	tokenizer := html.NewTokenizer(r)
+
+	// the interesting tags all live in the head so stop once it's closed
+	for done := false; !done; {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			done = true
+		case html.EndTagToken:
+			done = tokenizer.Token().Data == "head"
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokenizer.Token()
+			switch token.Data {
+			case "title":
+				if tokenizer.Next() == html.TextToken {
+					title = strings.TrimSpace(tokenizer.Token().Data)
+				}
+			case "meta":
+				var name, content string
+				for _, attr := range token.Attr {
+					switch attr.Key {
+					case "property", "name":
+						name = strings.ToLower(attr.Val)
+					case "content":
+						content = strings.TrimSpace(attr.Val)
+					}
+				}
+				switch name {
+				case "og:title":
+					preview.Title = content
+				case "og:description":
+					preview.Description = content
+				case "og:image":
+					preview.Image = content
+				case "description":
+					description = content
+				}
+			}
+		}
+	}
+
+	if preview.Title == "" {
+		preview.Title = title
+	}
+	if preview.Description == "" {
+		preview.Description = description
+	}
+
+	return preview
+}
+
+// renderPreview serve the Open Graph page with a meta refresh for anything
+// that follows it
+func renderPreview(c *gin.Context, preview Preview) {
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(http.StatusOK)
+	if err := previewTemplate.Execute(c.Writer, preview); err != nil {
+		c.Error(err)
+	}
+}
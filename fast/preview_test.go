@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestIsCrawler(t *testing.T) {
+	tests := []struct {
+		userAgent string
+		want      bool
+	}{
+		{"facebookexternalhit/1.1 (+http://www.facebook.com/externalhit_uatext.php)", true},
+		{"Twitterbot/1.0", true},
+		{"Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)", true},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) Chrome/100.0", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.userAgent, func(t *testing.T) {
+			if got := isCrawler(tt.userAgent); got != tt.want {
+				t.Errorf("isCrawler(%q) = %t, want %t", tt.userAgent, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePreview(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		want Preview
+	}{
+		{"open graph", `<html><head><title>Plain</title><meta property="og:title" content="OG Title"><meta property="og:description" content=" OG description "><meta property="og:image" content="https://example.com/i.png"></head></html>`,
+			Preview{URL: "https://example.com/", Title: "OG Title", Description: "OG description", Image: "https://example.com/i.png"}},
+		{"plain title and description", `<html><head><title> Plain </title><meta name="description" content="About"></head></html>`,
+			Preview{URL: "https://example.com/", Title: "Plain", Description: "About"}},
+		{"stops at the end of the head", `<html><head></head><body><meta property="og:title" content="Body"></body></html>`,
+			Preview{URL: "https://example.com/"}},
+		{"not html", `just text`, Preview{URL: "https://example.com/"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parsePreview(strings.NewReader(tt.html), Preview{URL: "https://example.com/"}); got != tt.want {
+				t.Errorf("parsePreview() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildPreview(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `<html><head><meta property="og:title" content="Fetched"></head></html>`)
+	}))
+	defer server.Close()
+	defaults := PreviewConfig{Title: "fa.st", Description: "A short link", Image: "https://fa.st/logo.png", MaxConcurrent: 1}
+	fetching := defaults
+	fetching.Fetch = true
+
+	tests := []struct {
+		name        string
+		cfg         PreviewConfig
+		destination string
+		want        Preview
+		fails       bool
+	}{
+		{"defaults", defaults, server.URL + "/a", Preview{URL: server.URL + "/a", Title: "fa.st", Description: "A short link", Image: "https://fa.st/logo.png"}, false},
+		{"fetched tags win", fetching, server.URL + "/a", Preview{URL: server.URL + "/a", Title: "Fetched", Description: "A short link", Image: "https://fa.st/logo.png"}, false},
+		{"failed fetch keeps the defaults", fetching, server.URL + "/missing", Preview{URL: server.URL + "/missing", Title: "fa.st", Description: "A short link", Image: "https://fa.st/logo.png"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := buildPreview(context.Background(), tt.cfg, newPreviewSlots(tt.cfg), server.Client(), tt.destination)
+			if (err != nil) != tt.fails {
+				t.Fatalf("buildPreview() = %v, want failed = %t", err, tt.fails)
+			}
+			if got != tt.want {
+				t.Errorf("buildPreview() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderPreview(t *testing.T) {
+	r := testRouter()
+	r.GET("/launch", func(c *gin.Context) {
+		renderPreview(c, Preview{URL: "https://example.com/?a=1&b=2", Title: `Launch "day"`})
+	})
+	w := serve(r, http.MethodGet, "/launch", APIKey{}, "")
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf("got %d %s, want an html page", w.Code, w.Header().Get("Content-Type"))
+	}
+	for _, want := range []string{`<meta property="og:title" content="Launch &#34;day&#34;">`, `content="0; url=https://example.com/?a=1&amp;b=2"`} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("page is missing %s:\n%s", want, w.Body)
+		}
+	}
+	if strings.Contains(w.Body.String(), "og:image") {
+		t.Errorf("page has an og:image without an image:\n%s", w.Body)
+	}
+}
+
+func TestPreviewSlots(t *testing.T) {
+	cfg := PreviewConfig{MaxConcurrent: 1, Fetch: true, Title: "fa.st"}
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		wait time.Duration
+		free bool
+		want bool
+	}{
+		{"free slot", context.Background(), 0, true, true},
+		{"busy without waiting", context.Background(), 0, false, false},
+		{"busy until the wait runs out", context.Background(), 10 * time.Millisecond, false, false},
+		{"freed while waiting", context.Background(), time.Second, false, true},
+		{"request gone while waiting", canceled, time.Second, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			slots := newPreviewSlots(cfg)
+			if !tt.free {
+				slots.acquire(context.Background(), 0)
+				if tt.want {
+					time.AfterFunc(10*time.Millisecond, slots.release)
+				}
+			}
+			if got := slots.acquire(tt.ctx, tt.wait); got != tt.want {
+				t.Errorf("acquire() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+
+	// a crawler that gets no slot still gets the defaults
+	slots := newPreviewSlots(cfg)
+	slots.acquire(context.Background(), 0)
+	preview, err := buildPreview(context.Background(), cfg, slots, http.DefaultClient, "https://example.com/")
+	if !errors.Is(err, errPreviewBusy) || preview.Title != "fa.st" {
+		t.Errorf("buildPreview() = %+v, %v, want the defaults and %v", preview, err, errPreviewBusy)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aeekayy/systems/fast/db"
+	"github.com/gin-gonic/gin"
+	pgx "github.com/jackc/pgx/v4"
+	"go.uber.org/zap"
+)
+
+const (
+	previewTokenParam      = "preview_token"    // The query parameter a preview token is passed in
+	defaultPreviewTokenTTL = time.Hour          // How long a preview token works when no ttl is given
+	maxPreviewTokenTTL     = 7 * 24 * time.Hour // The longest a preview token may work for
+)
+
+// PreviewTokenRequest how long a preview token should work for
+type PreviewTokenRequest struct {
+	TTL *TTL `json:"ttl,omitempty" yaml:"ttl,omitempty"`
+}
+
+// previewTokenMAC the signature of a preview token. It's kept apart from
+// link signatures so neither can stand in for the other
+func previewTokenMAC(secret []byte, uri, expires string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("preview:" + uri + ":" + expires))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:signatureBytes])
+}
+
+// issuePreviewToken a token that opens the link until expires, its expiry
+// in unix seconds and the signature over it
+func issuePreviewToken(secret []byte, uri string, expires time.Time) string {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	return exp + signatureSeparator + previewTokenMAC(secret, uri, exp)
+}
+
+// validPreviewToken whether the token was issued for the uri and hasn't
+// expired yet
+func validPreviewToken(secret []byte, uri, token string, now time.Time) bool {
+	if len(secret) == 0 || token == "" {
+		return false
+	}
+	i := strings.Index(token, signatureSeparator)
+	if i < 0 {
+		return false
+	}
+	exp, signature := token[:i], token[i+len(signatureSeparator):]
+	if !hmac.Equal([]byte(signature), []byte(previewTokenMAC(secret, uri, exp))) {
+		return false
+	}
+	expires, err := strconv.ParseInt(exp, 10, 64)
+	return err == nil && now.Unix() < expires
+}
+
+// previewTokenHandler issue a preview token for a signed or unconfirmed
+// link, so its owner can share a temporary link for review without giving
+// away the link itself. Only the owner, or an admin, may ask for one
+func previewTokenHandler(ctx context.Context, dbConn db.Querier, linkDomain LinkDomain, signingSecret []byte, caseInsensitive bool, sugar *zap.SugaredLogger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sugar := requestSugar(c, sugar)
+		if len(signingSecret) == 0 {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "preview tokens aren't configured",
+			})
+			return
+		}
+
+		var json PreviewTokenRequest
+		if c.Request.ContentLength != 0 {
+			if err := c.ShouldBindJSON(&json); err != nil {
+				bindError(c, err)
+				return
+			}
+		}
+		ttl := defaultPreviewTokenTTL
+		if json.TTL != nil {
+			ttl = time.Duration(*json.TTL)
+		}
+		if ttl <= 0 || ttl > maxPreviewTokenTTL {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "ttl must be positive and at most " + maxPreviewTokenTTL.String(),
+			})
+			return
+		}
+
+		// someone else's link is reported as not found so keys can't probe for them
+		key, _ := currentAPIKey(c)
+		owner := key.ID
+		if key.Admin {
+			owner = ""
+		}
+
+		var uri, host string
+		var signed bool
+		err := dbConn.QueryRow(ctx, "SELECT uri, COALESCE(domain, ''), signed FROM "+urlsTable+" WHERE "+uriCondition(caseInsensitive)+" AND ($2 = '' OR owner = $2) LIMIT 1;", c.Param("uri"), owner).Scan(&uri, &host, &signed)
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "uri not found",
+			})
+			return
+		}
+		if err != nil {
+			sugar.Errorf("error retrieving URI: %s", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "error issuing preview token",
+			})
+			return
+		}
+
+		expires := time.Now().Add(ttl)
+		link := shortLink(linkDomain, host, uri, false, nil) + "?" + url.Values{
+			previewTokenParam: []string{issuePreviewToken(signingSecret, uri, expires)},
+		}.Encode()
+		c.JSON(http.StatusOK, gin.H{
+			"data": gin.H{
+				"url":     link,
+				"expires": expires.UTC().Truncate(time.Second),
+			},
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidPreviewToken(t *testing.T) {
+	secret := []byte("secret")
+	now := time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+	token := issuePreviewToken(secret, "launch", now.Add(time.Hour))
+
+	tests := []struct {
+		name   string
+		secret []byte
+		uri    string
+		token  string
+		now    time.Time
+		want   bool
+	}{
+		{"issued", secret, "launch", token, now, true},
+		{"expired", secret, "launch", token, now.Add(time.Hour), false},
+		{"another uri", secret, "promo", token, now, false},
+		{"another secret", []byte("other"), "launch", token, now, false},
+		{"expiry moved", secret, "launch", "9999999999" + token[strings.Index(token, signatureSeparator):], now, false},
+		{"link signature instead", secret, "launch", signURI(secret, "launch"), now, false},
+		{"no separator", secret, "launch", "1651410000", now, false},
+		{"missing", secret, "launch", "", now, false},
+		{"no secret configured", nil, "launch", issuePreviewToken(nil, "launch", now.Add(time.Hour)), now, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := validPreviewToken(tt.secret, tt.uri, tt.token, tt.now); got != tt.want {
+				t.Errorf("validPreviewToken(%q) = %t, want %t", tt.token, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPreviewTokenHandler(t *testing.T) {
+	secret := []byte("secret")
+	tests := []struct {
+		name   string
+		secret []byte
+		key    APIKey
+		body   string
+		status int
+		ttl    time.Duration
+	}{
+		{"owner", secret, testOwnerKey, "", http.StatusOK, defaultPreviewTokenTTL},
+		{"admin with a ttl", secret, testAdminKey, `{"ttl": "30m"}`, http.StatusOK, 30 * time.Minute},
+		{"someone else", secret, testOtherKey, "", http.StatusNotFound, 0},
+		{"ttl too long", secret, testOwnerKey, `{"ttl": "200h"}`, http.StatusBadRequest, 0},
+		{"negative ttl", secret, testOwnerKey, `{"ttl": -60}`, http.StatusBadRequest, 0},
+		{"not configured", nil, testOwnerKey, "", http.StatusServiceUnavailable, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := (&fakeDB{}).onFunc("signed FROM", func(args []interface{}) fakeResult {
+				if args[0] != "launch" || (args[1] != "" && args[1] != testOwnerKey.ID) {
+					return fakeResult{}
+				}
+				return fakeResult{rows: [][]interface{}{{"launch", "", true}}}
+			})
+			r := testRouter()
+			r.POST("/api/v1/urls/:uri/preview-token", requireAPIKey, previewTokenHandler(context.Background(), fake, LinkDomain{Host: "fa.st", Scheme: "https"}, tt.secret, true, testSugar))
+			before := time.Now()
+			w := serve(r, http.MethodPost, "/api/v1/urls/launch/preview-token", tt.key, tt.body)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			var body struct {
+				Data struct {
+					URL     string    `json:"url"`
+					Expires time.Time `json:"expires"`
+				} `json:"data"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("couldn't decode %s: %s", w.Body, err)
+			}
+			link, err := url.Parse(body.Data.URL)
+			if err != nil || link.Host != "fa.st" || link.Path != "/launch" {
+				t.Fatalf("url = %s, want a preview of https://fa.st/launch", body.Data.URL)
+			}
+			token := link.Query().Get(previewTokenParam)
+			if !validPreviewToken(secret, "launch", token, time.Now()) {
+				t.Errorf("preview token %q doesn't open the link", token)
+			}
+			if body.Data.Expires.Before(before.Add(tt.ttl).Truncate(time.Second)) || body.Data.Expires.After(time.Now().Add(tt.ttl)) {
+				t.Errorf("expires = %s, want %s from now", body.Data.Expires, tt.ttl)
+			}
+		})
+	}
+}
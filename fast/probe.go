@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+const (
+	verifyOff    = ""       // Destinations aren't checked at creation
+	verifyWarn   = "warn"   // Broken destinations are reported but the link is created
+	verifyReject = "reject" // Links to broken destinations aren't created
+)
+
+// ProbeResult what checking a destination at creation found
+type ProbeResult struct {
+	Reachable bool   `json:"reachable" yaml:"reachable"`
+	Status    int    `json:"status,omitempty" yaml:"status,omitempty"`
+	Error     string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// ValidateVerifyMode make sure the configured verify_on_create is known
+func ValidateVerifyMode(mode string) error {
+	switch mode {
+	case verifyOff, verifyWarn, verifyReject:
+		return nil
+	default:
+		return fmt.Errorf("verify_on_create must be %s or %s, not %q", verifyWarn, verifyReject, mode)
+	}
+}
+
+// probeURL check a destination answers without an error status. HEAD is
+// tried first and GET for servers that don't allow HEAD
+func probeURL(ctx context.Context, client *http.Client, destination string) ProbeResult {
+	status, err := probeStatus(ctx, client, http.MethodHead, destination)
+	if err == nil && status == http.StatusMethodNotAllowed {
+		status, err = probeStatus(ctx, client, http.MethodGet, destination)
+	}
+	if err != nil {
+		return ProbeResult{Error: err.Error()}
+	}
+
+	result := ProbeResult{Reachable: status < http.StatusBadRequest, Status: status}
+	if !result.Reachable {
+		result.Error = fmt.Sprintf("destination returned %d", status)
+	}
+	return result
+}
+
+// probeStatus the status a request to the destination ends with
+func probeStatus(ctx context.Context, client *http.Client, method, destination string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, destination, nil)
+	if err != nil {
+		return 0, fmt.Errorf("couldn't build request: %w", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxPreviewBodyBytes))
+
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestValidateVerifyMode(t *testing.T) {
+	for _, tt := range []struct {
+		mode    string
+		wantErr bool
+	}{
+		{verifyOff, false},
+		{verifyWarn, false},
+		{verifyReject, false},
+		{"strict", true},
+	} {
+		t.Run(tt.mode, func(t *testing.T) {
+			if err := ValidateVerifyMode(tt.mode); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateVerifyMode(%q) = %v, want an error %t", tt.mode, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// probeTarget a destination answering every path with a status, /no-head
+// refusing HEAD like some servers do
+func probeTarget(t *testing.T) (*httptest.Server, string) {
+	t.Helper()
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/no-head" && r.Method == http.MethodHead:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		case r.URL.Path == "/missing":
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(target.Close)
+
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	return target, closed.URL
+}
+
+func TestProbeURL(t *testing.T) {
+	target, unreachable := probeTarget(t)
+	tests := []struct {
+		name      string
+		url       string
+		reachable bool
+		status    int
+	}{
+		{"ok", target.URL + "/ok", true, http.StatusOK},
+		{"not found", target.URL + "/missing", false, http.StatusNotFound},
+		{"head not allowed", target.URL + "/no-head", true, http.StatusOK},
+		{"connection error", unreachable + "/ok", false, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := probeURL(context.Background(), target.Client(), tt.url)
+			if result.Reachable != tt.reachable || result.Status != tt.status {
+				t.Errorf("probeURL() = %+v, want reachable %t with %d", result, tt.reachable, tt.status)
+			}
+			if (result.Error == "") != tt.reachable {
+				t.Errorf("error = %q, want one only when unreachable", result.Error)
+			}
+		})
+	}
+}
+
+func TestLinkCreatorVerify(t *testing.T) {
+	target, unreachable := probeTarget(t)
+	tests := []struct {
+		name   string
+		verify string
+		url    string
+		status int
+		probe  bool
+	}{
+		{"off", verifyOff, target.URL + "/missing", 0, false},
+		{"warn ok", verifyWarn, target.URL + "/ok", 0, true},
+		{"warn not found", verifyWarn, target.URL + "/missing", 0, true},
+		{"warn connection error", verifyWarn, unreachable + "/ok", 0, true},
+		{"reject ok", verifyReject, target.URL + "/ok", 0, true},
+		{"reject not found", verifyReject, target.URL + "/missing", http.StatusBadRequest, false},
+		{"reject connection error", verifyReject, unreachable + "/ok", http.StatusBadRequest, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := (&fakeDB{}).onFunc("INSERT INTO urls", insertedLinks)
+			creator := testCreator(fake)
+			creator.client = target.Client()
+			creator.verify = tt.verify
+
+			link, err := creator.create(context.Background(), ShortenURLRequest{URL: tt.url}, creation{}, testSugar)
+			if tt.status != 0 {
+				var cerr *creationError
+				if !errors.As(err, &cerr) || cerr.Status != tt.status {
+					t.Fatalf("create() = %v, want a %d", err, tt.status)
+				}
+				if inserts := fake.statements("INSERT INTO urls"); len(inserts) != 0 {
+					t.Errorf("inserted a link to an unreachable destination")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("create() = %v", err)
+			}
+			if (link.Probe != nil) != tt.probe {
+				t.Errorf("probe = %+v, want a probe result %t", link.Probe, tt.probe)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const problemContentType = "application/problem+json" // RFC 7807 Problem Details
+
+// problemTypes the problem type for each error status we return. Statuses
+// without one use about:blank, which RFC 7807 says means the status says it all
+var problemTypes = map[int]string{
+	http.StatusBadRequest:           "urn:fast:problem:invalid-request",
+	http.StatusUnauthorized:         "urn:fast:problem:unauthorized",
+	http.StatusForbidden:            "urn:fast:problem:forbidden",
+	http.StatusNotFound:             "urn:fast:problem:not-found",
+	http.StatusConflict:             "urn:fast:problem:conflict",
+	http.StatusGone:                 "urn:fast:problem:expired",
+	http.StatusUnsupportedMediaType: "urn:fast:problem:unsupported-media-type",
+	http.StatusTooManyRequests:      "urn:fast:problem:rate-limited",
+	http.StatusServiceUnavailable:   "urn:fast:problem:unavailable",
+	http.StatusBadGateway:           "urn:fast:problem:upstream-failed",
+	http.StatusLoopDetected:         "urn:fast:problem:redirect-loop",
+}
+
+// Problem an RFC 7807 Problem Details error. Code is an extension member
+// carrying the error code of errors that have one
+type Problem struct {
+	Type   string `json:"type" yaml:"type"`
+	Title  string `json:"title" yaml:"title"`
+	Status int    `json:"status" yaml:"status"`
+	Detail string `json:"detail,omitempty" yaml:"detail,omitempty"`
+	Code   string `json:"code,omitempty" yaml:"code,omitempty"`
+}
+
+// newProblem the problem for an error status and message
+func newProblem(status int, detail string) Problem {
+	problemType, ok := problemTypes[status]
+	if !ok {
+		problemType = "about:blank"
+	}
+
+	return Problem{
+		Type:   problemType,
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	}
+}
+
+// problemWriter hold on to the response body so an error can be rewritten
+// once the handler is done
+type problemWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+// Write buffer the body instead of sending it
+func (w *problemWriter) Write(b []byte) (int, error) {
+	return w.body.Write(b)
+}
+
+// WriteString buffer the body instead of sending it
+func (w *problemWriter) WriteString(s string) (int, error) {
+	return w.body.WriteString(s)
+}
+
+// problemDetails answer clients that accept application/problem+json with
+// Problem Details instead of the usual {"error": ...} body. Successful
+// responses and everyone else are left alone
+func problemDetails(c *gin.Context) {
+	if !strings.Contains(c.GetHeader("Accept"), problemContentType) {
+		c.Next()
+		return
+	}
+
+	writer := &problemWriter{ResponseWriter: c.Writer}
+	c.Writer = writer
+	c.Next()
+	c.Writer = writer.ResponseWriter
+
+	body := writer.body.Bytes()
+	status := writer.Status()
+	if status >= http.StatusBadRequest && strings.HasPrefix(writer.Header().Get("Content-Type"), "application/json") {
+		var response struct {
+			Error string `json:"error"`
+			Code  string `json:"code"`
+		}
+		if err := json.Unmarshal(body, &response); err == nil && response.Error != "" {
+			problem := newProblem(status, response.Error)
+			problem.Code = response.Code
+			if problem, err := json.Marshal(problem); err == nil {
+				writer.Header().Set("Content-Type", problemContentType)
+				body = problem
+			}
+		}
+	}
+	writer.ResponseWriter.Write(body)
+}
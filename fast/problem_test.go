@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestProblemDetails(t *testing.T) {
+	tests := []struct {
+		name    string
+		accept  string
+		status  int
+		body    gin.H
+		problem *Problem
+	}{
+		{"asked for", problemContentType, http.StatusNotFound, gin.H{"error": "uri not found"}, &Problem{
+			Type: "urn:fast:problem:not-found", Title: "Not Found", Status: http.StatusNotFound, Detail: "uri not found",
+		}},
+		{"asked for among others", "application/json, " + problemContentType, http.StatusGone, gin.H{"error": "link has expired"}, &Problem{
+			Type: "urn:fast:problem:expired", Title: "Gone", Status: http.StatusGone, Detail: "link has expired",
+		}},
+		{"status without a type", problemContentType, http.StatusTeapot, gin.H{"error": "short and stout"}, &Problem{
+			Type: "about:blank", Title: "I'm a teapot", Status: http.StatusTeapot, Detail: "short and stout",
+		}},
+		{"not asked for", "application/json", http.StatusNotFound, gin.H{"error": "uri not found"}, nil},
+		{"success left alone", problemContentType, http.StatusOK, gin.H{"data": "ok"}, nil},
+		{"no error message", problemContentType, http.StatusNotFound, gin.H{"data": "ok"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			r.Use(problemDetails)
+			r.GET("/launch", func(c *gin.Context) { c.JSON(tt.status, tt.body) })
+			req := httptest.NewRequest(http.MethodGet, "/launch", nil)
+			req.Header.Set("Accept", tt.accept)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+			if got := w.Header().Get("Content-Type"); (got == problemContentType) != (tt.problem != nil) {
+				t.Errorf("Content-Type = %s, want problem details %t", got, tt.problem != nil)
+			}
+			if tt.problem == nil {
+				var body gin.H
+				if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || len(body) != len(tt.body) {
+					t.Errorf("body = %s, want it unchanged", w.Body)
+				}
+				return
+			}
+			var problem Problem
+			if err := json.Unmarshal(w.Body.Bytes(), &problem); err != nil {
+				t.Fatalf("couldn't decode %s: %s", w.Body, err)
+			}
+			if problem != *tt.problem {
+				t.Errorf("problem = %+v, want %+v", problem, *tt.problem)
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	defaultMetricsPushInterval    = time.Minute     // How often click counts are pushed
+	defaultMetricsPushTimeout     = 5 * time.Second // How long a push may take
+	defaultMetricsPushMeasurement = "fast_clicks"   // The line protocol measurement clicks are pushed as
+)
+
+// lineProtocolEscaper escape the characters that are special in line
+// protocol tag values
+var lineProtocolEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+// MetricsPushConfig where aggregated click counts are pushed, in InfluxDB
+// line protocol, on top of the Prometheus metrics. An empty URL turns it off
+type MetricsPushConfig struct {
+	URL         string        `mapstructure:"url" yaml:"url"`
+	Interval    time.Duration `mapstructure:"interval" yaml:"interval"`
+	Timeout     time.Duration `mapstructure:"timeout" yaml:"timeout"`
+	Measurement string        `mapstructure:"measurement" yaml:"measurement"`
+}
+
+// loadMetricsPushConfig read the metrics push settings for the environment
+func loadMetricsPushConfig(env string) (MetricsPushConfig, error) {
+	var cfg MetricsPushConfig
+	if err := viper.UnmarshalKey(fmt.Sprintf("%s.metrics_push", env), &cfg); err != nil {
+		return cfg, fmt.Errorf("couldn't read metrics push configuration: %w", err)
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaultMetricsPushInterval
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultMetricsPushTimeout
+	}
+	if cfg.Measurement == "" {
+		cfg.Measurement = defaultMetricsPushMeasurement
+	}
+
+	return cfg, nil
+}
+
+// ClickCounter count clicks per uri between pushes. A nil counter ignores
+// clicks so redirects don't need to know whether pushing is on
+type ClickCounter struct {
+	mu     sync.Mutex
+	counts map[string]int64
+}
+
+// NewClickCounter an empty counter
+func NewClickCounter() *ClickCounter {
+	return &ClickCounter{counts: map[string]int64{}}
+}
+
+// Add count clicks for a uri
+func (c *ClickCounter) Add(uri string, clicks int64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts[uri] += clicks
+}
+
+// drain the counts so far, starting again from zero
+func (c *ClickCounter) drain() map[string]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	counts := c.counts
+	c.counts = map[string]int64{}
+	return counts
+}
+
+// MetricsPusher push the click counts on an interval
+type MetricsPusher struct {
+	cfg     MetricsPushConfig
+	client  *http.Client
+	counter *ClickCounter
+	onError func(error)
+}
+
+// NewMetricsPusher push the counter's clicks to the configured endpoint
+func NewMetricsPusher(cfg MetricsPushConfig, counter *ClickCounter, onError func(error)) *MetricsPusher {
+	return &MetricsPusher{
+		cfg:     cfg,
+		client:  &http.Client{Timeout: cfg.Timeout},
+		counter: counter,
+		onError: onError,
+	}
+}
+
+// Run push every interval until the context is done, then push whatever
+// was counted since the last push
+func (p *MetricsPusher) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), p.cfg.Timeout)
+			if err := p.flush(flushCtx); err != nil {
+				p.onError(err)
+			}
+			cancel()
+			return
+		case <-ticker.C:
+			if err := p.flush(ctx); err != nil {
+				p.onError(err)
+			}
+		}
+	}
+}
+
+// flush push the counts so far. Counts that couldn't be pushed are kept
+// for the next push
+func (p *MetricsPusher) flush(ctx context.Context) error {
+	counts := p.counter.drain()
+	if len(counts) == 0 {
+		return nil
+	}
+
+	err := p.push(ctx, lineProtocol(p.cfg.Measurement, counts, time.Now()))
+	if err != nil {
+		for uri, clicks := range counts {
+			p.counter.Add(uri, clicks)
+		}
+	}
+	return err
+}
+
+// push send a line protocol payload
+func (p *MetricsPusher) push(ctx context.Context, payload []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.cfg.URL, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("couldn't build metrics push: %w", err)
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("couldn't push metrics: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("metrics push returned %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// lineProtocol one line per uri, e.g. "fast_clicks,uri=abc clicks=3i 1657000000000000000"
+func lineProtocol(measurement string, counts map[string]int64, at time.Time) []byte {
+	uris := make([]string, 0, len(counts))
+	for uri := range counts {
+		uris = append(uris, uri)
+	}
+	sort.Strings(uris)
+
+	var buf bytes.Buffer
+	for _, uri := range uris {
+		fmt.Fprintf(&buf, "%s,uri=%s clicks=%di %d\n", lineProtocolEscaper.Replace(measurement), lineProtocolEscaper.Replace(uri), counts[uri], at.UnixNano())
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLineProtocol(t *testing.T) {
+	at := time.Unix(1657000000, 0)
+	tests := []struct {
+		name        string
+		measurement string
+		counts      map[string]int64
+		want        string
+	}{
+		{"nothing", "fast_clicks", nil, ""},
+		{"sorted by uri", "fast_clicks", map[string]int64{"promo": 1, "launch": 3}, "fast_clicks,uri=launch clicks=3i 1657000000000000000\nfast_clicks,uri=promo clicks=1i 1657000000000000000\n"},
+		{"escaped", "fast clicks", map[string]int64{"a,b=c d": 2}, `fast\ clicks,uri=a\,b\=c\ d clicks=2i 1657000000000000000` + "\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(lineProtocol(tt.measurement, tt.counts, at)); got != tt.want {
+				t.Errorf("lineProtocol() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMetricsPusherFlush(t *testing.T) {
+	tests := []struct {
+		name     string
+		clicks   map[string]int64
+		status   int
+		pushes   int
+		wantErr  bool
+		leftover map[string]int64
+	}{
+		{"nothing counted", nil, http.StatusNoContent, 0, false, map[string]int64{}},
+		{"pushed", map[string]int64{"launch": 3}, http.StatusNoContent, 1, false, map[string]int64{}},
+		{"push refused", map[string]int64{"launch": 3}, http.StatusServiceUnavailable, 1, true, map[string]int64{"launch": 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var payloads []string
+			receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				payloads = append(payloads, string(body))
+				w.WriteHeader(tt.status)
+			}))
+			defer receiver.Close()
+
+			counter := NewClickCounter()
+			for uri, clicks := range tt.clicks {
+				counter.Add(uri, clicks)
+			}
+			pusher := NewMetricsPusher(MetricsPushConfig{URL: receiver.URL, Interval: time.Hour, Timeout: time.Second, Measurement: "fast_clicks"}, counter, nil)
+			err := pusher.flush(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("flush() = %v, want an error %t", err, tt.wantErr)
+			}
+			if len(payloads) != tt.pushes {
+				t.Fatalf("pushed %d times, want %d", len(payloads), tt.pushes)
+			}
+			if tt.pushes > 0 && !strings.HasPrefix(payloads[0], "fast_clicks,uri=launch clicks=3i ") {
+				t.Errorf("payload = %q, want the launch clicks", payloads[0])
+			}
+			if left := counter.drain(); len(left) != len(tt.leftover) || left["launch"] != tt.leftover["launch"] {
+				t.Errorf("left counted %v, want %v", left, tt.leftover)
+			}
+		})
+	}
+}
+
+func TestMetricsPusherFlushesOnStop(t *testing.T) {
+	pushed := make(chan string, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		pushed <- string(body)
+	}))
+	defer receiver.Close()
+
+	counter := NewClickCounter()
+	counter.Add("launch", 2)
+	pusher := NewMetricsPusher(MetricsPushConfig{URL: receiver.URL, Interval: time.Hour, Timeout: time.Second, Measurement: "fast_clicks"}, counter, func(err error) { t.Errorf("push failed: %s", err) })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		pusher.Run(ctx)
+		close(done)
+	}()
+	cancel()
+	<-done
+
+	select {
+	case payload := <-pushed:
+		if !strings.HasPrefix(payload, "fast_clicks,uri=launch clicks=2i ") {
+			t.Errorf("payload = %q, want the launch clicks", payload)
+		}
+	default:
+		t.Error("stopping didn't push the clicks counted since the last push")
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"image/png"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestEncodeQR(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		size int
+		err  error
+	}{
+		{"fits version 1", strings.Repeat("a", 14), 21, nil},
+		{"needs version 2", strings.Repeat("a", 15), 25, nil},
+		{"short link", "https://fa.st/launch", 25, nil},
+		{"largest", strings.Repeat("a", 2331), 177, nil},
+		{"too long", strings.Repeat("a", 2332), 0, errQRTooLong},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			qr, err := encodeQR(tt.text)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("encodeQR() = %v, want %v", err, tt.err)
+			}
+			if tt.err != nil {
+				return
+			}
+			if qr.size != tt.size {
+				t.Errorf("size = %d, want %d", qr.size, tt.size)
+			}
+			// the finder patterns scanners look for in three corners
+			for _, corner := range [][2]int{{3, 3}, {qr.size - 4, 3}, {3, qr.size - 4}} {
+				x, y := corner[0], corner[1]
+				if !qr.modules[y][x] || qr.modules[y][x+2] || !qr.modules[y][x+3] {
+					t.Errorf("no finder pattern around %d,%d", x, y)
+				}
+			}
+		})
+	}
+}
+
+func TestQRReedSolomon(t *testing.T) {
+	for _, degree := range []int{10, 16, 26, 28} {
+		divisor := qrReedSolomonDivisor(degree)
+		data := []byte("https://fa.st/launch")
+		ecc := qrReedSolomonRemainder(data, divisor)
+		if len(ecc) != degree {
+			t.Fatalf("degree %d: %d error correction codewords", degree, len(ecc))
+		}
+		// a codeword with its error correction is a multiple of the generator
+		for i, b := range qrReedSolomonRemainder(append(data, ecc...), divisor) {
+			if b != 0 {
+				t.Errorf("degree %d: remainder %d of the codeword is %d, want 0", degree, i, b)
+			}
+		}
+	}
+}
+
+// decodeQRDataURI the PNG a QR data URI holds
+func decodeQRDataURI(t *testing.T, uri string) (width int) {
+	t.Helper()
+	const prefix = "data:image/png;base64,"
+	if !strings.HasPrefix(uri, prefix) {
+		t.Fatalf("%.40s... isn't a PNG data URI", uri)
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(uri, prefix))
+	if err != nil {
+		t.Fatalf("couldn't decode the base64: %s", err)
+	}
+	img, err := png.Decode(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("not a valid PNG: %s", err)
+	}
+	bounds := img.Bounds()
+	if bounds.Dx() != bounds.Dy() {
+		t.Errorf("image is %dx%d, want a square", bounds.Dx(), bounds.Dy())
+	}
+	return bounds.Dx()
+}
+
+func TestQRDataURI(t *testing.T) {
+	uri, err := qrDataURI("https://fa.st/launch")
+	if err != nil {
+		t.Fatalf("qrDataURI() = %v", err)
+	}
+	if width := decodeQRDataURI(t, uri); width != (25+2*qrQuietZone)*qrModulePixels {
+		t.Errorf("width = %d, want %d", width, (25+2*qrQuietZone)*qrModulePixels)
+	}
+	if _, err := qrDataURI(strings.Repeat("a", 3000)); !errors.Is(err, errQRTooLong) {
+		t.Errorf("qrDataURI(too long) = %v, want %v", err, errQRTooLong)
+	}
+}
+
+func TestURLMetadataHandlerQR(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		qr    bool
+	}{
+		{"left out by default", "", false},
+		{"asked for", "?qr=true", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := (&fakeDB{}).onFunc("COALESCE(notes", linkRows(map[string][]interface{}{
+				"launch": metadataRow("launch", "", "", "", ""),
+			}))
+			r := testRouter()
+			r.GET("/api/v1/urls/:uri", urlMetadataHandler(context.Background(), fake, LinkDomain{Host: "fa.st", Scheme: "https"}, nil, true, testSugar))
+			w := serve(r, http.MethodGet, "/api/v1/urls/launch"+tt.query, APIKey{}, "")
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", w.Code, w.Body)
+			}
+
+			var body struct {
+				Data URLMetadata `json:"data"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("couldn't decode %s: %s", w.Body, err)
+			}
+			if (body.Data.QR != "") != tt.qr {
+				t.Fatalf("qr = %.40q, want one %t", body.Data.QR, tt.qr)
+			}
+			if tt.qr {
+				decodeQRDataURI(t, body.Data.QR)
+			}
+		})
+	}
+}
+
+func TestQRSignatureVisibility(t *testing.T) {
+	secret := []byte("secret")
+	domain := LinkDomain{Host: "fa.st", Scheme: "https"}
+	bare := "https://fa.st/launch"
+	signed := "https://fa.st/" + signedURI(secret, "launch")
+
+	tests := []struct {
+		name string
+		key  APIKey
+		want string
+	}{
+		{"anonymous", APIKey{}, bare},
+		{"another key", testOtherKey, bare},
+		{"owner", testOwnerKey, signed},
+		{"admin", testAdminKey, signed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			row := metadataRow("launch", "", "", testOwnerKey.ID, "")
+			row[11] = true
+			fake := (&fakeDB{}).
+				on("COALESCE(notes", fakeResult{rows: [][]interface{}{row}}).
+				on("signed, COALESCE(owner", fakeResult{rows: [][]interface{}{{"launch", "", true, testOwnerKey.ID}}})
+			r := testRouter()
+			r.GET("/api/v1/urls/:uri", urlMetadataHandler(context.Background(), fake, domain, secret, true, testSugar))
+			r.GET("/api/v1/urls/:uri/qr", qrHandler(context.Background(), fake, domain, secret, true, testSugar))
+
+			w := serve(r, http.MethodGet, "/api/v1/urls/launch?qr=true", tt.key, "")
+			var body struct {
+				Data URLMetadata `json:"data"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("couldn't decode %s: %s", w.Body, err)
+			}
+			want, err := qrDataURI(tt.want)
+			if err != nil {
+				t.Fatalf("qrDataURI(%s) = %v", tt.want, err)
+			}
+			if body.Data.QR != want {
+				t.Errorf("metadata qr doesn't encode %s", tt.want)
+			}
+
+			w = serve(r, http.MethodGet, "/api/v1/urls/launch/qr", tt.key, "")
+			qr, err := encodeQR(tt.want)
+			if err != nil {
+				t.Fatalf("encodeQR(%s) = %v", tt.want, err)
+			}
+			wantPNG, err := qr.PNG()
+			if err != nil {
+				t.Fatalf("PNG() = %v", err)
+			}
+			if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), wantPNG) {
+				t.Errorf("qr image (%d) doesn't encode %s", w.Code, tt.want)
+			}
+		})
+	}
+}
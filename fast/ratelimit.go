@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimiter allow each client a number of requests per window
+type RateLimiter struct {
+	mu      sync.Mutex
+	limit   int
+	window  time.Duration
+	clients map[string]rateWindow
+}
+
+// rateWindow the requests a client made in its current window
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+// NewRateLimiter allow limit requests per window per client
+func NewRateLimiter(limit int, window time.Duration) *RateLimiter {
+	return &RateLimiter{limit: limit, window: window, clients: map[string]rateWindow{}}
+}
+
+// Allow count a request from the client, false once it is over the limit.
+// The second value is how long until the client's window resets
+func (l *RateLimiter) Allow(client string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	current, ok := l.clients[client]
+	if !ok || now.Sub(current.start) >= l.window {
+		// forget clients whose window is over so the map doesn't grow forever
+		if !ok {
+			for key, w := range l.clients {
+				if now.Sub(w.start) >= l.window {
+					delete(l.clients, key)
+				}
+			}
+		}
+		current = rateWindow{start: now}
+	}
+	current.count++
+	l.clients[client] = current
+
+	return current.count <= l.limit, current.start.Add(l.window).Sub(now)
+}
+
+// rateLimit reject requests over the limit with a 429. Clients are told
+// apart by API key, or by address for anonymous requests
+func rateLimit(l *RateLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		client := actorID(c)
+		if client == "" {
+			client = "ip:" + c.ClientIP()
+		}
+
+		allowed, reset := l.Allow(client, time.Now())
+		if !allowed {
+			c.Header("Retry-After", strconv.Itoa(int(reset.Seconds())+1))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+				"error": "too many requests",
+			})
+			return
+		}
+
+		c.Next()
+	}
+}
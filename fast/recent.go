@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aeekayy/systems/fast/db"
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultRecentLimit = 100  // The number of links returned when no limit is given
+	maxRecentLimit     = 1000 // The most links returned in one page
+)
+
+// RecentConfig how long pages of recent links are. Limit is used when a
+// request doesn't ask, MaxLimit caps what it may ask for
+type RecentConfig struct {
+	Limit    int `mapstructure:"limit" yaml:"limit"`
+	MaxLimit int `mapstructure:"max_limit" yaml:"max_limit"`
+}
+
+// loadRecentConfig read the recent links settings for the environment
+func loadRecentConfig(env string) (RecentConfig, error) {
+	var cfg RecentConfig
+	if err := viper.UnmarshalKey(fmt.Sprintf("%s.recent", env), &cfg); err != nil {
+		return cfg, fmt.Errorf("couldn't read recent configuration: %w", err)
+	}
+	if cfg.MaxLimit <= 0 {
+		cfg.MaxLimit = maxRecentLimit
+	}
+	if cfg.Limit <= 0 {
+		cfg.Limit = defaultRecentLimit
+	}
+	if cfg.Limit > cfg.MaxLimit {
+		return cfg, fmt.Errorf("recent limit can't be more than max_limit")
+	}
+
+	return cfg, nil
+}
+
+// recentCursor where a page of recent links left off, the created time and
+// id of its last link. It's handed to clients as an opaque string
+type recentCursor struct {
+	Created time.Time
+	ID      string
+}
+
+// encode the cursor as the string clients send back
+func (rc recentCursor) encode() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(rc.Created.Format(time.RFC3339Nano) + "|" + rc.ID))
+}
+
+// parseRecentCursor read a cursor from a previous page
+func parseRecentCursor(val string) (recentCursor, error) {
+	var rc recentCursor
+	decoded, err := base64.RawURLEncoding.DecodeString(val)
+	if err != nil {
+		return rc, err
+	}
+	i := strings.IndexByte(string(decoded), '|')
+	if i < 0 {
+		return rc, fmt.Errorf("cursor is missing its id")
+	}
+	if rc.Created, err = time.Parse(time.RFC3339Nano, string(decoded[:i])); err != nil {
+		return rc, err
+	}
+	// ids are uuids, anything else would only fail in the query
+	rc.ID = string(decoded[i+1:])
+	if len(rc.ID) != 36 {
+		return rc, fmt.Errorf("cursor id isn't a uuid")
+	}
+	return rc, nil
+}
+
+// recentLinksHandler list the links created between ?since= and ?until=,
+// oldest first. until defaults to now. API keys only see their own links,
+// admins see everyone's. Pages are ?limit= links long and ?cursor= carries
+// on after the previous one, which the response includes as next_cursor
+// while there are more. Pages are read from the (created, id) index rather
+// than skipped over so late pages cost the same as the first
+func recentLinksHandler(ctx context.Context, dbConn db.Querier, cfg RecentConfig, sugar *zap.SugaredLogger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sugar := requestSugar(c, sugar)
+		since, err := time.Parse(time.RFC3339, c.Query("since"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "since must be an RFC 3339 time",
+			})
+			return
+		}
+
+		until := time.Now()
+		if val := c.Query("until"); val != "" {
+			until, err = time.Parse(time.RFC3339, val)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": "until must be an RFC 3339 time",
+				})
+				return
+			}
+		}
+		if !since.Before(until) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "since must be before until",
+			})
+			return
+		}
+
+		limit := cfg.Limit
+		if val := c.Query("limit"); val != "" {
+			parsed, err := strconv.Atoi(val)
+			if err != nil || parsed <= 0 {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": "limit must be a positive number",
+				})
+				return
+			}
+			limit = parsed
+		}
+		if limit > cfg.MaxLimit {
+			limit = cfg.MaxLimit
+		}
+
+		var after *time.Time
+		var afterID *string
+		if val := c.Query("cursor"); val != "" {
+			cursor, err := parseRecentCursor(val)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": "cursor must come from a previous page",
+				})
+				return
+			}
+			after, afterID = &cursor.Created, &cursor.ID
+		}
+
+		// admins see every link, everyone else only the ones they created
+		key, _ := currentAPIKey(c)
+		owner := key.ID
+		if key.Admin {
+			owner = ""
+		}
+
+		// one extra row tells whether there's another page
+		rows, err := dbConn.Query(ctx, `SELECT id::text, uri, original_url, COALESCE(title, ''), COALESCE(description, ''), created, last_accessed, hits, COALESCE(source, ''), redirect_type, expires FROM `+urlsTable+`
+			WHERE created >= $1 AND created < $2 AND ($3 = '' OR owner = $3)
+			AND ($5::timestamptz IS NULL OR (created, id) > ($5, $6::uuid))
+			ORDER BY created, id LIMIT $4;`, since, until, owner, limit+1, after, afterID)
+		if err != nil {
+			sugar.Errorf("error retrieving recent URLs: %s", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "error retrieving recent URLs",
+			})
+			return
+		}
+		defer rows.Close()
+
+		links := []URLMetadata{}
+		var ids []string
+		for rows.Next() {
+			var link URLMetadata
+			var id string
+			if err := rows.Scan(&id, &link.URI, &link.OriginalURL, &link.Title, &link.Description, &link.Created, &link.LastAccessed, &link.Hits, &link.Source, &link.RedirectType, &link.Expires); err != nil {
+				sugar.Errorf("error reading recent URLs: %s", err)
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"error": "error retrieving recent URLs",
+				})
+				return
+			}
+			links = append(links, link)
+			ids = append(ids, id)
+		}
+		if err := rows.Err(); err != nil {
+			sugar.Errorf("error reading recent URLs: %s", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "error retrieving recent URLs",
+			})
+			return
+		}
+
+		response := gin.H{
+			"data": links,
+		}
+		if len(links) > limit {
+			response["data"] = links[:limit]
+			last := recentCursor{Created: links[limit-1].Created, ID: ids[limit-1]}
+			response["next_cursor"] = last.encode()
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRecentCursor(t *testing.T) {
+	cursor := recentCursor{Created: time.Date(2022, 5, 1, 12, 0, 0, 123, time.UTC), ID: "5f0c6a0e-6a3a-4d6e-9d0e-2b1c3f4a5b6c"}
+	parsed, err := parseRecentCursor(cursor.encode())
+	if err != nil {
+		t.Fatalf("parseRecentCursor() = %v", err)
+	}
+	if !parsed.Created.Equal(cursor.Created) || parsed.ID != cursor.ID {
+		t.Errorf("parseRecentCursor() = %+v, want %+v", parsed, cursor)
+	}
+
+	for _, val := range []string{"not base64!", "bm8gc2VwYXJhdG9y", recentCursor{Created: cursor.Created, ID: "42"}.encode()} {
+		if _, err := parseRecentCursor(val); err == nil {
+			t.Errorf("parseRecentCursor(%q) = nil, want an error", val)
+		}
+	}
+}
+
+func TestRecentLinksHandler(t *testing.T) {
+	created := time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+	recentRow := func(n int) []interface{} {
+		return []interface{}{fmt.Sprintf("00000000-0000-0000-0000-%012d", n), fmt.Sprintf("u%d", n), "https://example.com", "", "", created.Add(time.Duration(n) * time.Minute), nil, int64(0), "", redirectPermanent, nil}
+	}
+	cfg := RecentConfig{Limit: 2, MaxLimit: 3}
+
+	tests := []struct {
+		name   string
+		query  string
+		key    APIKey
+		rows   int
+		status int
+		links  int
+		more   bool
+		owner  string
+		limit  int
+	}{
+		{"missing since", "", testOwnerKey, 0, http.StatusBadRequest, 0, false, "", 0},
+		{"since after until", "?since=2022-05-02T00:00:00Z&until=2022-05-01T00:00:00Z", testOwnerKey, 0, http.StatusBadRequest, 0, false, "", 0},
+		{"bad limit", "?since=2022-05-01T00:00:00Z&limit=0", testOwnerKey, 0, http.StatusBadRequest, 0, false, "", 0},
+		{"bad cursor", "?since=2022-05-01T00:00:00Z&cursor=nope", testOwnerKey, 0, http.StatusBadRequest, 0, false, "", 0},
+		{"last page", "?since=2022-05-01T00:00:00Z", testOwnerKey, 1, http.StatusOK, 1, false, testOwnerKey.ID, 3},
+		{"more pages", "?since=2022-05-01T00:00:00Z", testOwnerKey, 3, http.StatusOK, 2, true, testOwnerKey.ID, 3},
+		{"limit capped", "?since=2022-05-01T00:00:00Z&limit=50", testAdminKey, 0, http.StatusOK, 0, false, "", 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var rows [][]interface{}
+			for i := 1; i <= tt.rows; i++ {
+				rows = append(rows, recentRow(i))
+			}
+			fake := (&fakeDB{}).on("ORDER BY created, id", fakeResult{rows: rows})
+			r := testRouter()
+			r.GET("/api/v1/urls/recent", requireAPIKey, recentLinksHandler(context.Background(), fake, cfg, testSugar))
+			w := serve(r, http.MethodGet, "/api/v1/urls/recent"+tt.query, tt.key, "")
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			var body struct {
+				Data       []URLMetadata `json:"data"`
+				NextCursor string        `json:"next_cursor"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("couldn't decode %s: %s", w.Body, err)
+			}
+			if len(body.Data) != tt.links || (body.NextCursor != "") != tt.more {
+				t.Errorf("got %d links and next_cursor %q, want %d and more = %t", len(body.Data), body.NextCursor, tt.links, tt.more)
+			}
+			args := fake.statements("ORDER BY created, id")[0].args
+			if args[2] != tt.owner || args[3] != tt.limit {
+				t.Errorf("queried owner %q limit %v, want %q and %d", args[2], args[3], tt.owner, tt.limit)
+			}
+			if tt.more {
+				cursor, err := parseRecentCursor(body.NextCursor)
+				if err != nil || cursor.ID != recentRow(tt.links)[0] {
+					t.Errorf("next_cursor = %+v, %v, want one after link %d", cursor, err, tt.links)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadRecentConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     map[string]interface{}
+		want    RecentConfig
+		wantErr bool
+	}{
+		{"defaults", nil, RecentConfig{Limit: defaultRecentLimit, MaxLimit: maxRecentLimit}, false},
+		{"configured", map[string]interface{}{"limit": 20, "max_limit": 50}, RecentConfig{Limit: 20, MaxLimit: 50}, false},
+		{"limit only", map[string]interface{}{"limit": 20}, RecentConfig{Limit: 20, MaxLimit: maxRecentLimit}, false},
+		{"limit over the max", map[string]interface{}{"limit": 60, "max_limit": 50}, RecentConfig{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.cfg != nil {
+				withConfig(t, "test.recent", tt.cfg)
+			}
+			cfg, err := loadRecentConfig("test")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadRecentConfig() = %v, want an error %t", err, tt.wantErr)
+			}
+			if !tt.wantErr && cfg != tt.want {
+				t.Errorf("loadRecentConfig() = %+v, want %+v", cfg, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecentLinksHandlerCursor(t *testing.T) {
+	cursor := recentCursor{Created: time.Date(2022, 5, 1, 12, 3, 0, 0, time.UTC), ID: "00000000-0000-0000-0000-000000000003"}
+	tests := []struct {
+		name  string
+		query string
+		after *time.Time
+		id    *string
+	}{
+		{"first page", "", nil, nil},
+		{"next page", "&cursor=" + cursor.encode(), &cursor.Created, &cursor.ID},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := (&fakeDB{}).on("ORDER BY created, id", fakeResult{})
+			r := testRouter()
+			r.GET("/api/v1/urls/recent", requireAPIKey, recentLinksHandler(context.Background(), fake, RecentConfig{Limit: 2, MaxLimit: 3}, testSugar))
+			w := serve(r, http.MethodGet, "/api/v1/urls/recent?since=2022-05-01T00:00:00Z"+tt.query, testOwnerKey, "")
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", w.Code, w.Body)
+			}
+
+			args := fake.statements("ORDER BY created, id")[0].args
+			after, id := args[4].(*time.Time), args[5].(*string)
+			if (after == nil) != (tt.after == nil) || (after != nil && (!after.Equal(*tt.after) || *id != *tt.id)) {
+				t.Errorf("queried after %v %v, want %v %v", after, id, tt.after, tt.id)
+			}
+		})
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aeekayy/systems/fast/db"
+	"github.com/gin-gonic/gin"
+	pgx "github.com/jackc/pgx/v4"
+	"go.uber.org/zap"
+)
+
+const (
+	redirectPermanent      = "permanent"        // 301, cacheable for a long time
+	redirectTemporary      = "temporary"        // 302, never cached so edits take effect
+	defaultPermanentMaxAge = 24 * time.Hour     // How long clients may cache a permanent redirect
+	hopsHeader             = "X-Shortener-Hops" // Counts the short links a request has been through
+	defaultMaxHops         = 5                  // Hops allowed before a redirect is refused as a loop
+	queryStrip             = "strip"            // The query on the short URL is dropped
+	queryForward           = "forward"          // The query on the short URL is merged into the destination's
+)
+
+// ValidateRedirectType make sure the requested redirect type is known
+func ValidateRedirectType(redirectType string) error {
+	switch redirectType {
+	case "", redirectPermanent, redirectTemporary:
+		return nil
+	default:
+		return fmt.Errorf("redirect type must be %s or %s", redirectPermanent, redirectTemporary)
+	}
+}
+
+// ValidateQueryPolicy make sure the configured query string policy is known
+func ValidateQueryPolicy(policy string) error {
+	switch policy {
+	case "", queryStrip, queryForward:
+		return nil
+	default:
+		return fmt.Errorf("query policy must be %s or %s", queryStrip, queryForward)
+	}
+}
+
+// forwardQuery add the query the short URL was followed with to the
+// destination. Parameters the destination already has keep its values so a
+// client can't override what the link was created with. Fragments never
+// reach the server so there's nothing to forward for them; browsers carry
+// the short URL's fragment over to the destination themselves
+func forwardQuery(destination string, incoming url.Values) string {
+	if len(incoming) == 0 {
+		return destination
+	}
+	parsed, err := url.Parse(destination)
+	if err != nil {
+		return destination
+	}
+
+	query := parsed.Query()
+	for key, values := range incoming {
+		if _, ok := query[key]; ok {
+			continue
+		}
+		query[key] = values
+	}
+	parsed.RawQuery = query.Encode()
+	return parsed.String()
+}
+
+// defaultTrackingParams query parameters forwarding drops unless configured
+// otherwise, so click ids from ad networks don't follow users around
+var defaultTrackingParams = []string{"fbclid", "gclid"}
+
+// stripParams the query without the named parameters
+func stripParams(query url.Values, params []string) url.Values {
+	stripped := url.Values{}
+	for key, values := range query {
+		stripped[key] = values
+	}
+	for _, param := range params {
+		stripped.Del(param)
+	}
+	return stripped
+}
+
+// countHop report requests that have already been through maxHops short
+// links, which points at a loop between shorteners. Otherwise pass the
+// incremented count on so the next shortener can check it
+func countHop(c *gin.Context, maxHops int) bool {
+	hops, err := strconv.Atoi(c.GetHeader(hopsHeader))
+	if err != nil || hops < 0 {
+		hops = 0
+	}
+	if hops >= maxHops {
+		return false
+	}
+
+	c.Header(hopsHeader, strconv.Itoa(hops+1))
+	return true
+}
+
+// redirect send the client on with the status and caching headers for the
+// link's redirect type. Links that pick their destination per request are
+// always temporary since a cached redirect would pin one destination
+func redirect(c *gin.Context, redirectType string, dynamic bool, maxAge time.Duration, destination string) {
+	if redirectType == redirectTemporary || dynamic {
+		c.Header("Cache-Control", "no-store")
+		c.Redirect(http.StatusFound, destination)
+		return
+	}
+
+	c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", int(maxAge.Seconds())))
+	c.Redirect(http.StatusMovedPermanently, destination)
+}
+
+// redirector what following a short link needs. GET and HEAD of every
+// short uri share it, main fills it in from the configuration
+type redirector struct {
+	dbConn               db.Querier
+	dbReader             db.Querier
+	cache                *RedirectCache
+	cacheConfig          CacheConfig
+	reserved             map[string]ReservedHandler
+	branding             BrandingConfig
+	maxHops              int
+	caseInsensitive      bool
+	legacyFallback       bool
+	vanityHosts          map[string]bool
+	signingSecret        []byte
+	clockSkew            time.Duration
+	geoIPHeader          string
+	headCountsHits       bool
+	dedup                *ClickDeduper
+	clicks               *ClickCounter
+	lastAccessedThrottle time.Duration
+	intn                 func(n int) int
+	queryPolicy          string
+	trackingParams       []string
+	upgradeHTTPS         bool
+	upgrader             *HTTPSUpgrader
+	preview              PreviewConfig
+	previewFetches       previewSlots
+	previewClient        *http.Client
+	interstitial         InterstitialConfig
+	permanentMaxAge      time.Duration
+}
+
+// shortURIHandler follow a short link. HEAD gets the same status and
+// Location as GET without a body, for link checkers. It skips the preview
+// and interstitial pages and only counts as a hit when configured to
+func shortURIHandler(ctx context.Context, rd *redirector, sugar *zap.SugaredLogger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sugar := requestSugar(c, sugar)
+		head := c.Request.Method == http.MethodHead
+		defer observeRedirect(c)
+		// namespaced links like /team/launch come in with the rest of the path
+		shortenURI := c.Param("short_uri") + strings.TrimSuffix(c.Param("rest"), namespaceSeparator)
+		shortenURI, signature := splitSignature(shortenURI)
+
+		if isReserved(c.Param("short_uri"), rd.reserved) {
+			serveReserved(c, c.Param("short_uri"), rd.reserved)
+			return
+		}
+
+		if !countHop(c, rd.maxHops) {
+			redirectError(c, rd.branding, http.StatusLoopDetected, "too many short link redirects")
+			return
+		}
+
+		link, err := resolveRedirectLink(ctx, rd.dbReader, rd.cache, rd.cacheConfig, shortenURI, rd.caseInsensitive, rd.legacyFallback, sugar)
+		if errors.Is(err, pgx.ErrNoRows) {
+			redirectError(c, rd.branding, http.StatusNotFound, "uri not found")
+			return
+		}
+		if err != nil {
+			sugar.Errorf("error retrieving URI: %w", err)
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("error retrieve URI: %s", err),
+			})
+			return
+		}
+
+		// links only resolve on the domain they were created under
+		if link.Domain != vanityHost(c.Request.Host, rd.vanityHosts) {
+			redirectError(c, rd.branding, http.StatusNotFound, "uri not found")
+			return
+		}
+
+		// a preview token opens a signed or unconfirmed link until it expires,
+		// a token that doesn't check out is refused rather than ignored
+		previewToken := c.Query(previewTokenParam)
+		previewed := validPreviewToken(rd.signingSecret, link.URI, previewToken, time.Now())
+		if previewToken != "" && !previewed {
+			redirectError(c, rd.branding, http.StatusForbidden, "invalid or expired preview token")
+			return
+		}
+
+		// signed links only resolve with the signature we issued
+		switch {
+		case link.Signed && !previewed && !validSignature(rd.signingSecret, link.URI, signature):
+			redirectError(c, rd.branding, http.StatusForbidden, "invalid link signature")
+			return
+		case !link.Signed && signature != "":
+			redirectError(c, rd.branding, http.StatusNotFound, "uri not found")
+			return
+		}
+		storedURI, originalURL := link.URI, link.OriginalURL
+		destinations, rules := link.Destinations, link.Rules
+		title, description := link.Title, link.Description
+
+		if expired(link.Expires, time.Now(), rd.clockSkew) {
+			linkGone(c, rd.branding, "link has expired")
+			return
+		}
+
+		if link.Disabled {
+			linkGone(c, rd.branding, "link was disabled because its destination stopped working")
+			return
+		}
+
+		if !link.Confirmed && !previewed {
+			redirectError(c, rd.branding, http.StatusForbidden, "link hasn't been confirmed by its owner")
+			return
+		}
+
+		client := ClientInfo{
+			Country: c.GetHeader(rd.geoIPHeader),
+			Device:  DeviceType(c.Request.UserAgent()),
+		}
+
+		click := Click{
+			URI:     storedURI,
+			Referer: c.Request.Referer(),
+			Country: client.Country,
+			Device:  client.Device,
+		}
+		if (!head || rd.headCountsHits) && !rd.dedup.Duplicate(storedURI, c.ClientIP(), c.Request.UserAgent(), time.Now()) {
+			rd.clicks.Add(storedURI, 1)
+			go func() {
+				if err := recordHit(ctx, rd.dbConn, storedURI, rd.lastAccessedThrottle); err != nil {
+					sugar.Errorf("error recording hit: %s", err)
+				}
+				if err := recordClick(ctx, rd.dbConn, click); err != nil {
+					sugar.Errorf("error recording click: %s", err)
+				}
+			}()
+		}
+		if destination := MatchRule(rules, client); destination != "" {
+			originalURL = destination
+		} else if destination := PickDestination(destinations, rd.intn); destination != "" {
+			originalURL = destination
+		}
+
+		if rd.queryPolicy == queryForward {
+			originalURL = forwardQuery(originalURL, stripParams(c.Request.URL.Query(), append([]string{previewTokenParam}, rd.trackingParams...)))
+		}
+
+		if rd.upgradeHTTPS {
+			originalURL = rd.upgrader.Upgrade(originalURL)
+		}
+
+		// crawlers building a link preview get the Open Graph tags instead of the redirect
+		if rd.preview.Enabled && !head && isCrawler(c.Request.UserAgent()) {
+			preview, err := buildPreview(c.Request.Context(), rd.preview, rd.previewFetches, rd.previewClient, originalURL)
+			if err != nil {
+				sugar.Warnf("error fetching preview for %s: %s", shortenURI, err)
+			}
+			// what the creator gave the link wins over defaults and the destination tags
+			if title != "" {
+				preview.Title = title
+			}
+			if description != "" {
+				preview.Description = description
+			}
+			renderPreview(c, preview)
+			return
+		}
+
+		if (rd.interstitial.Enabled || link.Interstitial) && !head {
+			renderInterstitial(c, rd.interstitial, originalURL)
+			return
+		}
+
+		// previews aren't cached so the redirect stops working with the token
+		redirect(c, link.RedirectType, len(destinations) > 0 || len(rules) > 0 || previewed, rd.permanentMaxAge, originalURL)
+	}
+}
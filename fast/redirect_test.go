@@ -0,0 +1,393 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// testRedirector a redirector over fake with the defaults main uses
+func testRedirector(fake *fakeDB) *redirector {
+	return &redirector{
+		dbConn:               fake,
+		dbReader:             fake,
+		maxHops:              defaultMaxHops,
+		lastAccessedThrottle: defaultLastAccessedThrottle,
+		intn:                 func(n int) int { return 0 },
+		interstitial:         InterstitialConfig{Delay: defaultInterstitialDelay},
+		permanentMaxAge:      defaultPermanentMaxAge,
+	}
+}
+
+// redirectRouter a router serving short links through rd
+func redirectRouter(rd *redirector) *gin.Engine {
+	r := testRouter()
+	handler := shortURIHandler(context.Background(), rd, testSugar)
+	r.GET("/:short_uri", handler)
+	r.HEAD("/:short_uri", handler)
+	return r
+}
+
+// linkRows answer short link lookups with the links in rows, by uri
+func linkRows(rows map[string][]interface{}) func(args []interface{}) fakeResult {
+	return func(args []interface{}) fakeResult {
+		row, ok := rows[args[0].(string)]
+		if !ok {
+			return fakeResult{}
+		}
+		return fakeResult{rows: [][]interface{}{row}}
+	}
+}
+
+// waitForStatements the statements containing match once there are n,
+// since hits and clicks are recorded in the background
+func waitForStatements(t *testing.T, fake *fakeDB, match string, n int) []fakeCall {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		calls := fake.statements(match)
+		if len(calls) >= n || time.Now().After(deadline) {
+			return calls
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestShortURIHandlerRecordsHits(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		countsHead bool
+		hits       int
+	}{
+		{"get", http.MethodGet, false, 1},
+		{"head", http.MethodHead, false, 0},
+		{"head counted", http.MethodHead, true, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := (&fakeDB{}).onFunc("SELECT uri, COALESCE(domain", linkRows(map[string][]interface{}{
+				"launch": redirectRow("launch", "https://example.com/a"),
+			}))
+			rd := testRedirector(fake)
+			rd.headCountsHits = tt.countsHead
+			w := serve(redirectRouter(rd), tt.method, "/launch", APIKey{}, "")
+			if w.Code != http.StatusMovedPermanently {
+				t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusMovedPermanently, w.Body)
+			}
+
+			hits := waitForStatements(t, fake, "last_accessed", tt.hits)
+			if tt.hits == 0 {
+				// give a stray hit the chance to show up
+				time.Sleep(20 * time.Millisecond)
+				hits = fake.statements("last_accessed")
+			}
+			if len(hits) != tt.hits {
+				t.Fatalf("recorded %d hits, want %d", len(hits), tt.hits)
+			}
+			if tt.hits > 0 && (hits[0].args[0] != "launch" || hits[0].args[1] != defaultLastAccessedThrottle.Seconds()) {
+				t.Errorf("hit args = %v, want launch throttled by %s", hits[0].args, defaultLastAccessedThrottle)
+			}
+		})
+	}
+}
+
+func TestShortURIHandlerNotFound(t *testing.T) {
+	fake := &fakeDB{}
+	w := serve(redirectRouter(testRedirector(fake)), http.MethodGet, "/missing", APIKey{}, "")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d: %s", w.Code, http.StatusNotFound, w.Body)
+	}
+	if hits := fake.statements("last_accessed"); len(hits) != 0 {
+		t.Errorf("recorded %d hits for a missing link", len(hits))
+	}
+}
+
+func TestShortURIHandlerCacheHeaders(t *testing.T) {
+	permanent := redirectRow("permanent", "https://example.com/a")
+	permanent[8] = redirectPermanent
+	temporary := redirectRow("temporary", "https://example.com/a")
+	temporary[8] = redirectTemporary
+	weighted := redirectRow("weighted", "https://example.com/a")
+	weighted[3] = []Destination{{URL: "https://example.com/a", Weight: 1}, {URL: "https://example.com/b", Weight: 1}}
+	rows := map[string][]interface{}{
+		"default":   redirectRow("default", "https://example.com/a"),
+		"permanent": permanent,
+		"temporary": temporary,
+		"weighted":  weighted,
+	}
+
+	tests := []struct {
+		uri          string
+		status       int
+		cacheControl string
+	}{
+		{"default", http.StatusMovedPermanently, fmt.Sprintf("public, max-age=%d", int(defaultPermanentMaxAge.Seconds()))},
+		{"permanent", http.StatusMovedPermanently, fmt.Sprintf("public, max-age=%d", int(defaultPermanentMaxAge.Seconds()))},
+		{"temporary", http.StatusFound, "no-store"},
+		{"weighted", http.StatusFound, "no-store"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.uri, func(t *testing.T) {
+			fake := (&fakeDB{}).onFunc("SELECT uri, COALESCE(domain", linkRows(rows))
+			w := serve(redirectRouter(testRedirector(fake)), http.MethodGet, "/"+tt.uri, APIKey{}, "")
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if got := w.Header().Get("Cache-Control"); got != tt.cacheControl {
+				t.Errorf("Cache-Control = %q, want %q", got, tt.cacheControl)
+			}
+		})
+	}
+}
+
+func TestShortURIHandlerHead(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		body   bool
+	}{
+		{"get", http.MethodGet, true},
+		{"head", http.MethodHead, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := (&fakeDB{}).onFunc("SELECT uri, COALESCE(domain", linkRows(map[string][]interface{}{
+				"launch": redirectRow("launch", "https://example.com/a"),
+			}))
+			w := serve(redirectRouter(testRedirector(fake)), tt.method, "/launch", APIKey{}, "")
+			if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "https://example.com/a" {
+				t.Fatalf("got %d to %q, want a %d to https://example.com/a", w.Code, w.Header().Get("Location"), http.StatusMovedPermanently)
+			}
+			if got := w.Body.Len() > 0; got != tt.body {
+				t.Errorf("body = %q, want a body %t", w.Body, tt.body)
+			}
+			if lookups := fake.statements("SELECT uri, COALESCE(domain"); len(lookups) != 1 {
+				t.Errorf("looked the link up %d times, want once", len(lookups))
+			}
+		})
+	}
+}
+
+func TestShortURIHandlerClockSkew(t *testing.T) {
+	tests := []struct {
+		name    string
+		expires time.Duration
+		skew    time.Duration
+		status  int
+	}{
+		{"not expired", time.Minute, 0, http.StatusMovedPermanently},
+		{"expired without skew", -2 * time.Second, 0, http.StatusGone},
+		{"expired within the skew", -2 * time.Second, 30 * time.Second, http.StatusMovedPermanently},
+		{"expired beyond the skew", -time.Minute, 30 * time.Second, http.StatusGone},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			row := redirectRow("launch", "https://example.com/a")
+			expires := time.Now().Add(tt.expires)
+			row[10] = &expires
+			fake := (&fakeDB{}).onFunc("SELECT uri, COALESCE(domain", linkRows(map[string][]interface{}{"launch": row}))
+			rd := testRedirector(fake)
+			rd.clockSkew = tt.skew
+
+			w := serve(redirectRouter(rd), http.MethodGet, "/launch", APIKey{}, "")
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+}
+
+func TestValidateQueryPolicy(t *testing.T) {
+	for _, tt := range []struct {
+		policy  string
+		wantErr bool
+	}{
+		{"", false},
+		{queryStrip, false},
+		{queryForward, false},
+		{"merge", true},
+	} {
+		t.Run(tt.policy, func(t *testing.T) {
+			if err := ValidateQueryPolicy(tt.policy); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateQueryPolicy(%q) = %v, want an error %t", tt.policy, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestForwardQuery(t *testing.T) {
+	tests := []struct {
+		name        string
+		destination string
+		incoming    url.Values
+		want        string
+	}{
+		{"nothing to forward", "https://example.com/a?ref=link", nil, "https://example.com/a?ref=link"},
+		{"added", "https://example.com/a", url.Values{"utm_source": {"mail"}}, "https://example.com/a?utm_source=mail"},
+		{"merged", "https://example.com/a?ref=link", url.Values{"utm_source": {"mail"}}, "https://example.com/a?ref=link&utm_source=mail"},
+		{"destination wins", "https://example.com/a?ref=link", url.Values{"ref": {"evil"}}, "https://example.com/a?ref=link"},
+		{"fragment kept", "https://example.com/a#top", url.Values{"utm_source": {"mail"}}, "https://example.com/a?utm_source=mail#top"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := forwardQuery(tt.destination, tt.incoming); got != tt.want {
+				t.Errorf("forwardQuery() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestShortURIHandlerQueryPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   string
+		location string
+	}{
+		{"default strips", "", "https://example.com/a?ref=link"},
+		{"strip", queryStrip, "https://example.com/a?ref=link"},
+		{"forward", queryForward, "https://example.com/a?ref=link&utm_source=mail"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := (&fakeDB{}).onFunc("SELECT uri, COALESCE(domain", linkRows(map[string][]interface{}{
+				"launch": redirectRow("launch", "https://example.com/a?ref=link"),
+			}))
+			rd := testRedirector(fake)
+			rd.queryPolicy = tt.policy
+
+			w := serve(redirectRouter(rd), http.MethodGet, "/launch?utm_source=mail&ref=evil", APIKey{}, "")
+			if got := w.Header().Get("Location"); w.Code != http.StatusMovedPermanently || got != tt.location {
+				t.Errorf("got %d to %s, want a %d to %s", w.Code, got, http.StatusMovedPermanently, tt.location)
+			}
+		})
+	}
+}
+
+func TestStripParams(t *testing.T) {
+	query := url.Values{"utm_source": {"mail"}, "fbclid": {"abc"}, "gclid": {"def"}}
+	tests := []struct {
+		name   string
+		params []string
+		want   url.Values
+	}{
+		{"nothing to strip", nil, query},
+		{"default tracking params", defaultTrackingParams, url.Values{"utm_source": {"mail"}}},
+		{"configured", []string{"utm_source"}, url.Values{"fbclid": {"abc"}, "gclid": {"def"}}},
+		{"not present", []string{"msclkid"}, query},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := stripParams(query, tt.params)
+			if got.Encode() != tt.want.Encode() {
+				t.Errorf("stripParams() = %s, want %s", got.Encode(), tt.want.Encode())
+			}
+			if len(query) != 3 {
+				t.Errorf("stripParams() changed the query it was given")
+			}
+		})
+	}
+}
+
+func TestShortURIHandlerStripsTrackingParams(t *testing.T) {
+	tests := []struct {
+		name     string
+		params   []string
+		location string
+	}{
+		{"default", defaultTrackingParams, "https://example.com/a?ref=link&utm_source=mail"},
+		{"configured", []string{"utm_source"}, "https://example.com/a?fbclid=abc&gclid=def&ref=link"},
+		{"none", nil, "https://example.com/a?fbclid=abc&gclid=def&ref=link&utm_source=mail"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := (&fakeDB{}).onFunc("SELECT uri, COALESCE(domain", linkRows(map[string][]interface{}{
+				"launch": redirectRow("launch", "https://example.com/a?ref=link"),
+			}))
+			rd := testRedirector(fake)
+			rd.queryPolicy = queryForward
+			rd.trackingParams = tt.params
+
+			w := serve(redirectRouter(rd), http.MethodGet, "/launch?utm_source=mail&fbclid=abc&gclid=def", APIKey{}, "")
+			if got := w.Header().Get("Location"); got != tt.location {
+				t.Errorf("redirected to %s, want %s", got, tt.location)
+			}
+		})
+	}
+}
+
+func TestShortURIHandlerLegacyFallback(t *testing.T) {
+	tests := []struct {
+		name            string
+		path            string
+		caseInsensitive bool
+		fallback        bool
+		status          int
+		location        string
+		legacyLookups   int
+	}{
+		{"new link", "/Promo", true, true, http.StatusMovedPermanently, "https://example.com/promo", 0},
+		{"legacy link", "/Launch", true, true, http.StatusMovedPermanently, "https://example.com/launch", 1},
+		{"legacy link in another case", "/LAUNCH", true, true, http.StatusMovedPermanently, "https://example.com/launch", 1},
+		{"exact legacy match wins", "/launch", true, true, http.StatusMovedPermanently, "https://example.com/lower", 1},
+		{"fallback off", "/Launch", true, false, http.StatusNotFound, "", 0},
+		{"case sensitive", "/LAUNCH", false, true, http.StatusNotFound, "", 0},
+		{"unknown", "/missing", true, true, http.StatusNotFound, "", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Launch and launch were created before case insensitive mode
+			// and have no lookup_uri, promo was created after
+			legacy := map[string]string{"Launch": "https://example.com/launch", "launch": "https://example.com/lower"}
+			fake := (&fakeDB{}).
+				onFunc("lookup_uri IS NULL", func(args []interface{}) fakeResult {
+					uri := args[0].(string)
+					if destination, ok := legacy[uri]; ok {
+						return fakeResult{rows: [][]interface{}{redirectRow(uri, destination)}}
+					}
+					for stored, destination := range legacy {
+						if strings.EqualFold(stored, uri) && stored != "launch" {
+							return fakeResult{rows: [][]interface{}{redirectRow(stored, destination)}}
+						}
+					}
+					return fakeResult{}
+				}).
+				onFunc("lookup_uri = lower", func(args []interface{}) fakeResult {
+					if strings.ToLower(args[0].(string)) != "promo" {
+						return fakeResult{}
+					}
+					return fakeResult{rows: [][]interface{}{redirectRow("Promo", "https://example.com/promo")}}
+				}).
+				onFunc("WHERE uri = $1", func(args []interface{}) fakeResult {
+					if args[0] != "Launch" {
+						return fakeResult{}
+					}
+					return fakeResult{rows: [][]interface{}{redirectRow("Launch", "https://example.com/launch")}}
+				})
+			rd := testRedirector(fake)
+			rd.caseInsensitive, rd.legacyFallback = tt.caseInsensitive, tt.fallback
+			w := serve(redirectRouter(rd), http.MethodGet, tt.path, APIKey{}, "")
+			if w.Code != tt.status || w.Header().Get("Location") != tt.location {
+				t.Errorf("got %d to %q, want %d to %q", w.Code, w.Header().Get("Location"), tt.status, tt.location)
+			}
+			if lookups := fake.statements("lookup_uri IS NULL"); len(lookups) != tt.legacyLookups {
+				t.Errorf("ran %d legacy lookups, want %d", len(lookups), tt.legacyLookups)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/aeekayy/systems/fast/db"
+	"github.com/gin-gonic/gin"
+	pgx "github.com/jackc/pgx/v4"
+	"go.uber.org/zap"
+)
+
+const (
+	reportFormatCSV  = "csv"
+	reportFormatJSON = "json"
+)
+
+// ReportRow the clicks of a short link on one day from one referer and
+// country. Clicks without a referer or country have it left out
+type ReportRow struct {
+	Day     string `json:"day" yaml:"day"`
+	Referer string `json:"referer,omitempty" yaml:"referer,omitempty"`
+	Country string `json:"country,omitempty" yaml:"country,omitempty"`
+	Clicks  int64  `json:"clicks" yaml:"clicks"`
+}
+
+// reportHandler a downloadable report of a short link's clicks between from
+// and to, grouped by day, referer and country. ?format= is csv, the
+// default, or json. Only the owner of the link or an admin key can download
+// it. Rows are written as they are read so a busy link's report never has to
+// fit in memory
+func reportHandler(ctx context.Context, dbConn db.Querier, caseInsensitive bool, sugar *zap.SugaredLogger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sugar := requestSugar(c, sugar)
+		format := c.DefaultQuery("format", reportFormatCSV)
+		if format != reportFormatCSV && format != reportFormatJSON {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("format must be %s or %s", reportFormatCSV, reportFormatJSON),
+			})
+			return
+		}
+
+		from, to, err := parseTimeRange(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		key, _ := currentAPIKey(c)
+		owner := key.ID
+		if key.Admin {
+			owner = ""
+		}
+
+		var uri string
+		err = dbConn.QueryRow(ctx, "SELECT uri FROM "+urlsTable+" WHERE "+uriCondition(caseInsensitive)+" AND ($2 = '' OR owner = $2) LIMIT 1;", c.Param("uri"), owner).Scan(&uri)
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "uri not found",
+			})
+			return
+		}
+		if err != nil {
+			sugar.Errorf("error retrieving URI: %s", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "error retrieving URI",
+			})
+			return
+		}
+
+		rows, err := dbConn.Query(ctx, `SELECT to_char(date_trunc('day', created AT TIME ZONE 'UTC'), 'YYYY-MM-DD'), COALESCE(referer, ''), COALESCE(country, ''), count(*)
+			FROM clicks WHERE uri = $1 AND created >= $2 AND created < $3
+			GROUP BY 1, 2, 3 ORDER BY 1, 2, 3;`, uri, from, to)
+		if err != nil {
+			sugar.Errorf("error building report: %s", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "error building report",
+			})
+			return
+		}
+		defer rows.Close()
+
+		// the status is sent with the first row, so errors after this can
+		// only cut the report short
+		filename := strings.ReplaceAll(uri, namespaceSeparator, "-") + "-report." + format
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+		var write func(ReportRow) error
+		var finish func() error
+		switch format {
+		case reportFormatCSV:
+			c.Header("Content-Type", "text/csv; charset=utf-8")
+			writer := csv.NewWriter(c.Writer)
+			writer.Write([]string{"day", "referer", "country", "clicks"})
+			write = func(row ReportRow) error {
+				return writer.Write([]string{row.Day, row.Referer, row.Country, strconv.FormatInt(row.Clicks, 10)})
+			}
+			finish = func() error {
+				writer.Flush()
+				return writer.Error()
+			}
+		case reportFormatJSON:
+			c.Header("Content-Type", "application/json; charset=utf-8")
+			// everything but the rows is known up front, so it's written
+			// around them
+			header, _ := json.Marshal(gin.H{"uri": uri, "from": from, "to": to})
+			c.Writer.WriteString(`{"data":`)
+			c.Writer.Write(header[:len(header)-1])
+			c.Writer.WriteString(`,"rows":[`)
+			encoder := json.NewEncoder(c.Writer)
+			first := true
+			write = func(row ReportRow) error {
+				if !first {
+					c.Writer.WriteString(",")
+				}
+				first = false
+				return encoder.Encode(row)
+			}
+			finish = func() error {
+				_, err := c.Writer.WriteString("]}}")
+				return err
+			}
+		}
+		c.Status(http.StatusOK)
+
+		for rows.Next() {
+			var row ReportRow
+			if err := rows.Scan(&row.Day, &row.Referer, &row.Country, &row.Clicks); err != nil {
+				sugar.Errorf("error reading report: %s", err)
+				return
+			}
+			if err := write(row); err != nil {
+				sugar.Warnf("error writing report: %s", err)
+				return
+			}
+		}
+		if err := rows.Err(); err != nil {
+			sugar.Errorf("error reading report: %s", err)
+			return
+		}
+		if err := finish(); err != nil {
+			sugar.Warnf("error writing report: %s", err)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestReportHandler(t *testing.T) {
+	fake := (&fakeDB{}).
+		onFunc("SELECT uri FROM", ownedLink("launch", testOwnerKey.ID, 1)).
+		on("FROM clicks", fakeResult{rows: [][]interface{}{
+			{"2022-06-01", "", "US", int64(3)},
+			{"2022-06-02", "https://news.example.com", "", int64(1)},
+		}})
+
+	r := testRouter()
+	r.GET("/api/v1/urls/:uri/report", requireAPIKey, reportHandler(context.Background(), fake, false, testSugar))
+
+	tests := []struct {
+		name   string
+		query  string
+		key    APIKey
+		status int
+		body   string
+	}{
+		{"anonymous", "", APIKey{}, http.StatusUnauthorized, ""},
+		{"another key", "", testOtherKey, http.StatusNotFound, ""},
+		{"bad format", "?format=xml", testOwnerKey, http.StatusBadRequest, ""},
+		{"bad range", "?from=yesterday", testOwnerKey, http.StatusBadRequest, ""},
+		{
+			"csv", "", testOwnerKey, http.StatusOK,
+			"day,referer,country,clicks\n2022-06-01,,US,3\n2022-06-02,https://news.example.com,,1\n",
+		},
+		{
+			"json for an admin", "?format=json&from=2022-06-01T00:00:00Z&to=2022-07-01T00:00:00Z", testAdminKey, http.StatusOK,
+			`{"data":{"from":"2022-06-01T00:00:00Z","to":"2022-07-01T00:00:00Z","uri":"launch","rows":[{"day":"2022-06-01","country":"US","clicks":3}` + "\n" +
+				`,{"day":"2022-06-02","referer":"https://news.example.com","clicks":1}` + "\n" + `]}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(r, http.MethodGet, "/api/v1/urls/launch/report"+tt.query, tt.key, "")
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.body != "" && w.Body.String() != tt.body {
+				t.Errorf("body = %q, want %q", w.Body, tt.body)
+			}
+		})
+	}
+}
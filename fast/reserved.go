@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+)
+
+// ReservedHandler the configured response for a reserved word. Operators
+// use these to give reserved paths like /error a meaningful response, e.g.
+// a test error page for health-check tooling
+type ReservedHandler struct {
+	Status      int    `mapstructure:"status" yaml:"status"`
+	ContentType string `mapstructure:"content_type" yaml:"content_type"`
+	Body        string `mapstructure:"body" yaml:"body"`
+}
+
+// loadReservedHandlers read the per reserved word handlers for the environment.
+// Any configured word is treated as reserved even if it isn't in the default list
+func loadReservedHandlers(env string) (map[string]ReservedHandler, error) {
+	handlers := map[string]ReservedHandler{}
+	if err := viper.UnmarshalKey(fmt.Sprintf("%s.reserved", env), &handlers); err != nil {
+		return nil, fmt.Errorf("couldn't read reserved handlers: %w", err)
+	}
+
+	for word, handler := range handlers {
+		if handler.Status == 0 {
+			handler.Status = http.StatusOK
+		}
+		if handler.ContentType == "" {
+			handler.ContentType = "text/plain; charset=utf-8"
+		}
+		handlers[word] = handler
+	}
+
+	return handlers, nil
+}
+
+// isReserved whether the uri is a reserved word and can't be used as a short uri
+func isReserved(uri string, handlers map[string]ReservedHandler) bool {
+	if val, isPresent := defaultReservedList[uri]; isPresent && val {
+		return true
+	}
+	_, isPresent := handlers[uri]
+	return isPresent
+}
+
+// serveReserved respond to a request for a reserved word. Words without a
+// configured handler keep returning a bad request
+func serveReserved(c *gin.Context, uri string, handlers map[string]ReservedHandler) {
+	handler, isPresent := handlers[uri]
+	if !isPresent {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid key for uri",
+		})
+		return
+	}
+
+	c.Data(handler.Status, handler.ContentType, []byte(handler.Body))
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestLoadReservedHandlers(t *testing.T) {
+	withConfig(t, "test.reserved", map[string]interface{}{
+		"error":  map[string]interface{}{"status": 500, "body": "boom"},
+		"status": map[string]interface{}{"content_type": "application/json", "body": `{"ok":true}`},
+	})
+
+	handlers, err := loadReservedHandlers("test")
+	if err != nil {
+		t.Fatalf("loadReservedHandlers() = %v", err)
+	}
+	tests := []struct {
+		word string
+		want ReservedHandler
+	}{
+		{"error", ReservedHandler{Status: http.StatusInternalServerError, ContentType: "text/plain; charset=utf-8", Body: "boom"}},
+		{"status", ReservedHandler{Status: http.StatusOK, ContentType: "application/json", Body: `{"ok":true}`}},
+	}
+	for _, tt := range tests {
+		if got := handlers[tt.word]; got != tt.want {
+			t.Errorf("handlers[%s] = %+v, want %+v", tt.word, got, tt.want)
+		}
+	}
+}
+
+func TestIsReserved(t *testing.T) {
+	handlers := map[string]ReservedHandler{"status": {}}
+	tests := []struct {
+		uri  string
+		want bool
+	}{
+		{"ping", true},
+		{"status", true},
+		{"launch", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.uri, func(t *testing.T) {
+			if got := isReserved(tt.uri, handlers); got != tt.want {
+				t.Errorf("isReserved(%q) = %t, want %t", tt.uri, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServeReserved(t *testing.T) {
+	handlers := map[string]ReservedHandler{"error": {Status: http.StatusInternalServerError, ContentType: "text/plain; charset=utf-8", Body: "boom"}}
+	tests := []struct {
+		name   string
+		uri    string
+		status int
+		body   string
+	}{
+		{"configured", "error", http.StatusInternalServerError, "boom"},
+		{"not configured", "ping", http.StatusBadRequest, `{"error":"invalid key for uri"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := testRouter()
+			r.GET("/:uri", func(c *gin.Context) { serveReserved(c, c.Param("uri"), handlers) })
+			w := serve(r, http.MethodGet, "/"+tt.uri, APIKey{}, "")
+			if w.Code != tt.status || w.Body.String() != tt.body {
+				t.Errorf("got %d %s, want %d %s", w.Code, w.Body, tt.status, tt.body)
+			}
+		})
+	}
+}
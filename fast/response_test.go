@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// assertGolden compare JSON with testdata/name, indented so the files
+// read well. go test -update rewrites them
+func assertGolden(t *testing.T, name string, body []byte) {
+	t.Helper()
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, body, "", "  "); err != nil {
+		t.Fatalf("%s isn't JSON: %s", body, err)
+	}
+	indented.WriteByte('\n')
+
+	path := filepath.Join("testdata", name)
+	if *updateGolden {
+		if err := os.WriteFile(path, indented.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("couldn't read %s, run go test -update to create it: %s", path, err)
+	}
+	if !bytes.Equal(indented.Bytes(), want) {
+		t.Errorf("%s changed, got:\n%s\nwant:\n%s", path, indented.Bytes(), want)
+	}
+}
+
+func TestResponseShapes(t *testing.T) {
+	created := time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+	expires := created.Add(24 * time.Hour)
+	domain := LinkDomain{Host: "fa.st", Scheme: "https"}
+
+	full := storedShortURL(domain, "go.acme.example", "launch", false, nil)
+	full.Title = "Launch"
+	full.Description = "The spring launch"
+	full.Unconfirmed = true
+	full.Expires = &expires
+	full.Probe = &ProbeResult{Reachable: true, Status: http.StatusOK}
+	full.OriginalURL = "https://example.com/launch"
+	full.Created = &created
+
+	lastAccessed := created.Add(time.Hour)
+	tests := []struct {
+		name  string
+		value interface{}
+	}{
+		{"shorten_url.json", full},
+		{"shorten_url_minimal.json", storedShortURL(domain, "", "abc1234", false, nil)},
+		{"url_metadata.json", URLMetadata{URI: "launch", OriginalURL: "https://example.com/launch", Created: created, LastAccessed: &lastAccessed, Hits: 42, Source: "api", RedirectType: redirectTemporary}},
+		{"url_metadata_minimal.json", URLMetadata{URI: "abc1234", OriginalURL: "https://example.com", Created: created, RedirectType: redirectPermanent}},
+		{"stats_summary.json", StatsSummary{
+			TotalLinks: 3, TotalClicks: 7, LinksLast24Hours: 1,
+			TopLinks: []LinkHits{{URI: "launch", Hits: 7}, {URI: "abc1234", Hits: 0}},
+			Sources:  []SourceStats{{Source: "api", Links: 2, Clicks: 7}, {Links: 1}},
+		}},
+		{"stats_summary_empty.json", StatsSummary{TopLinks: []LinkHits{}, Sources: []SourceStats{}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := json.Marshal(tt.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertGolden(t, tt.name, body)
+		})
+	}
+}
+
+func TestErrorResponseShapes(t *testing.T) {
+	fake := (&fakeDB{}).
+		on("SELECT EXISTS", fakeResult{rows: [][]interface{}{{false}}}).
+		on("FROM alias_holds", fakeResult{rows: [][]interface{}{{"globex"}}})
+	r := gin.New()
+	r.Use(problemDetails, authenticate(testAPIKeys))
+	r.POST("/api/v1/shorten", shortenHandler(testCreator(fake), testSugar.Desugar()))
+
+	tests := []struct {
+		name    string
+		body    string
+		problem bool
+		status  int
+	}{
+		{"error.json", `{"url": "https://fa.st/abc"}`, false, http.StatusBadRequest},
+		{"error_code.json", `{"url": "https://example.com", "alias": "launch"}`, false, http.StatusConflict},
+		{"error_malformed.json", `{"url": `, false, http.StatusBadRequest},
+		{"error_field.json", `{"url": 42}`, false, http.StatusUnprocessableEntity},
+		{"problem.json", `{"url": "https://example.com", "alias": "launch"}`, true, http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/shorten", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set(apiKeyHeader, testOwnerKey.Key)
+			if tt.problem {
+				req.Header.Set("Accept", problemContentType)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			assertGolden(t, tt.name, w.Body.Bytes())
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aeekayy/systems/fast/db"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultReverseRateLimit = 10          // Reverse lookups a client may make per window
+	defaultReverseCacheTTL  = time.Minute // How long a reverse lookup is answered from memory
+	defaultReverseCacheSize = 1000        // How many reverse lookups are kept in memory
+	maxReverseResults       = 100         // The most short links returned for one destination
+)
+
+// reverseEntry the short links found for a destination and when they go
+// stale
+type reverseEntry struct {
+	links   []*ShortenURL
+	expires time.Time
+}
+
+// ReverseCache keep recent reverse lookups in memory, since clients tend
+// to repeat them and each one scans by destination. A nil cache caches
+// nothing
+type ReverseCache struct {
+	ttl        time.Duration
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]reverseEntry
+}
+
+// NewReverseCache an empty cache holding up to maxEntries lookups for ttl
+func NewReverseCache(ttl time.Duration, maxEntries int) *ReverseCache {
+	return &ReverseCache{ttl: ttl, maxEntries: maxEntries, entries: map[string]reverseEntry{}}
+}
+
+// reverseKey the key a lookup is cached under. Keys see different links,
+// so each one has its own answers
+func reverseKey(key APIKey, destination string) string {
+	if key.Admin {
+		return "*|" + destination
+	}
+	return fmt.Sprintf("%s|%s", key.ID, destination)
+}
+
+// Get the cached links for a key if they haven't gone stale
+func (rc *ReverseCache) Get(key string) ([]*ShortenURL, bool) {
+	if rc == nil {
+		return nil, false
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	entry, ok := rc.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if !time.Now().Before(entry.expires) {
+		delete(rc.entries, key)
+		return nil, false
+	}
+	return entry.links, true
+}
+
+// Set cache the links for a key. When the cache is full stale entries are
+// dropped first, then arbitrary ones
+func (rc *ReverseCache) Set(key string, links []*ShortenURL) {
+	if rc == nil || rc.ttl <= 0 || rc.maxEntries <= 0 {
+		return
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	now := time.Now()
+	if _, ok := rc.entries[key]; !ok && len(rc.entries) >= rc.maxEntries {
+		for k, entry := range rc.entries {
+			if !now.Before(entry.expires) {
+				delete(rc.entries, k)
+			}
+		}
+		for k := range rc.entries {
+			if len(rc.entries) < rc.maxEntries {
+				break
+			}
+			delete(rc.entries, k)
+		}
+	}
+	rc.entries[key] = reverseEntry{links: links, expires: now.Add(rc.ttl)}
+}
+
+// reverseLookupHandler list the short links pointing at ?url=. Keys only
+// see the links they created, admin keys see every link. The url is
+// normalized like destinations are when links are created, so it matches
+// what was stored, and repeated lookups are answered from the cache
+func reverseLookupHandler(ctx context.Context, dbConn db.Querier, creator *linkCreator, cache *ReverseCache, sugar *zap.SugaredLogger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sugar := requestSugar(c, sugar)
+		key, _ := currentAPIKey(c)
+		if c.Query("url") == "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "url is required",
+			})
+			return
+		}
+		destination, err := creator.normalize(c.Query("url"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("invalid url: %s", err),
+			})
+			return
+		}
+
+		cacheKey := reverseKey(key, destination)
+		if links, ok := cache.Get(cacheKey); ok {
+			c.Header("Cache-Control", "private, max-age=60")
+			c.JSON(http.StatusOK, gin.H{
+				"data": links,
+			})
+			return
+		}
+
+		rows, err := dbConn.Query(ctx, "SELECT uri, COALESCE(domain, ''), signed FROM "+urlsTable+" WHERE original_url = $1 AND ($2 OR owner = $3) ORDER BY created LIMIT $4;", destination, key.Admin, key.ID, maxReverseResults)
+		if err != nil {
+			sugar.Errorf("error looking up destination: %s", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "error looking up destination",
+			})
+			return
+		}
+		defer rows.Close()
+
+		links := []*ShortenURL{}
+		for rows.Next() {
+			var uri, host string
+			var signed bool
+			if err := rows.Scan(&uri, &host, &signed); err != nil {
+				sugar.Errorf("error reading destination lookup: %s", err)
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"error": "error looking up destination",
+				})
+				return
+			}
+			links = append(links, storedShortURL(creator.domain, host, uri, signed, creator.signingSecret))
+		}
+		if err := rows.Err(); err != nil {
+			sugar.Errorf("error reading destination lookup: %s", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "error looking up destination",
+			})
+			return
+		}
+		cache.Set(cacheKey, links)
+
+		// the same lookup is usually repeated, let the client keep it briefly
+		c.Header("Cache-Control", "private, max-age=60")
+		c.JSON(http.StatusOK, gin.H{
+			"data": links,
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestReverseLookupHandler(t *testing.T) {
+	creator := testCreator(nil)
+	creator.signingSecret = []byte("secret")
+	creator.idn = IDNConfig{Normalize: true}
+	creator.rewrites = []RewriteRule{{Match: `^http://`, Replace: "https://", pattern: regexp.MustCompile(`^http://`)}}
+
+	tests := []struct {
+		name        string
+		url         string
+		key         APIKey
+		status      int
+		destination string
+		owner       string
+	}{
+		{"anonymous", "https://example.com/a", APIKey{}, http.StatusUnauthorized, "", ""},
+		{"missing url", "", testOwnerKey, http.StatusBadRequest, "", ""},
+		{"rewritten", "http://example.com/a", testOwnerKey, http.StatusOK, "https://example.com/a", testOwnerKey.ID},
+		{"internationalized host", "https://bücher.example/a", testOwnerKey, http.StatusOK, "https://xn--bcher-kva.example/a", testOwnerKey.ID},
+		{"admin", "https://example.com/a", testAdminKey, http.StatusOK, "https://example.com/a", testAdminKey.ID},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := (&fakeDB{}).on("WHERE original_url = $1", fakeResult{rows: [][]interface{}{
+				{"plain", "", false},
+				{"private", "go.acme.example", true},
+			}})
+			r := testRouter()
+			r.GET("/api/v1/reverse", requireAPIKey, reverseLookupHandler(context.Background(), fake, creator, NewReverseCache(time.Minute, 10), testSugar))
+			w := serve(r, http.MethodGet, "/api/v1/reverse?url="+url.QueryEscape(tt.url), tt.key, "")
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			args := fake.statements("WHERE original_url = $1")[0].args
+			if args[0] != tt.destination || args[1] != tt.key.Admin || args[2] != tt.owner {
+				t.Errorf("looked up %v, want %s for %s", args, tt.destination, tt.owner)
+			}
+			var body struct {
+				Data []ShortenURL `json:"data"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("couldn't decode %s: %s", w.Body, err)
+			}
+			want := []string{"https://fa.st/plain", "https://go.acme.example/" + signedURI(creator.signingSecret, "private")}
+			if len(body.Data) != len(want) {
+				t.Fatalf("got %d links, want %d", len(body.Data), len(want))
+			}
+			for i, link := range body.Data {
+				if link.ShortenLongURL != want[i] {
+					t.Errorf("link %d = %s, want %s", i, link.ShortenLongURL, want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestReverseLookupCache(t *testing.T) {
+	fake := (&fakeDB{}).on("WHERE original_url = $1", fakeResult{rows: [][]interface{}{{"plain", "", false}}})
+	r := testRouter()
+	r.GET("/api/v1/reverse", requireAPIKey, reverseLookupHandler(context.Background(), fake, testCreator(nil), NewReverseCache(time.Minute, 10), testSugar))
+
+	lookups := []struct {
+		key     APIKey
+		url     string
+		queries int
+	}{
+		{testOwnerKey, "https://example.com/a", 1},
+		{testOwnerKey, "https://example.com/a", 1},
+		{testOtherKey, "https://example.com/a", 2},
+		{testOwnerKey, "https://example.com/b", 3},
+	}
+	for _, lookup := range lookups {
+		w := serve(r, http.MethodGet, "/api/v1/reverse?url="+url.QueryEscape(lookup.url), lookup.key, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+		}
+		if got := len(fake.statements("WHERE original_url = $1")); got != lookup.queries {
+			t.Errorf("%s looking up %s: %d queries, want %d", lookup.key.ID, lookup.url, got, lookup.queries)
+		}
+	}
+}
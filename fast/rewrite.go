@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/spf13/viper"
+)
+
+// RewriteRule a destination rewrite operators configure, e.g. to add a
+// tracking parameter or canonicalize a domain. Match is a regular
+// expression and Replace its replacement, which may refer to groups as $1
+type RewriteRule struct {
+	Match   string `mapstructure:"match" yaml:"match"`
+	Replace string `mapstructure:"replace" yaml:"replace"`
+
+	pattern *regexp.Regexp
+}
+
+// loadRewriteRules read and compile the destination rewrites for the
+// environment
+func loadRewriteRules(env string) ([]RewriteRule, error) {
+	var rules []RewriteRule
+	if err := viper.UnmarshalKey(fmt.Sprintf("%s.rewrites", env), &rules); err != nil {
+		return nil, fmt.Errorf("couldn't read rewrite rules: %w", err)
+	}
+
+	for i := range rules {
+		pattern, err := regexp.Compile(rules[i].Match)
+		if err != nil {
+			return nil, fmt.Errorf("rewrite rule %d has an invalid match: %w", i+1, err)
+		}
+		rules[i].pattern = pattern
+	}
+
+	return rules, nil
+}
+
+// rewriteDestination apply every rewrite rule to the destination in order,
+// leaving it as is when no rule matches
+func rewriteDestination(rules []RewriteRule, destination string) string {
+	for _, rule := range rules {
+		destination = rule.pattern.ReplaceAllString(destination, rule.Replace)
+	}
+
+	return destination
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestLoadRewriteRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   []map[string]interface{}
+		count   int
+		wantErr bool
+	}{
+		{"none", nil, 0, false},
+		{"compiled", []map[string]interface{}{
+			{"match": `^https?://(www\.)?acme\.com/`, "replace": "https://acme.com/"},
+			{"match": `^(https://shop\.acme\.com/[^?]*)$`, "replace": "${1}?ref=fast"},
+		}, 2, false},
+		{"invalid match", []map[string]interface{}{{"match": "([a-z", "replace": ""}}, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.rules != nil {
+				withConfig(t, "test.rewrites", tt.rules)
+			}
+			rules, err := loadRewriteRules("test")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadRewriteRules() = %v, want an error %t", err, tt.wantErr)
+			}
+			if len(rules) != tt.count {
+				t.Fatalf("loaded %d rules, want %d", len(rules), tt.count)
+			}
+			for i, rule := range rules {
+				if rule.pattern == nil {
+					t.Errorf("rule %d wasn't compiled", i+1)
+				}
+			}
+		})
+	}
+}
+
+func TestRewriteDestination(t *testing.T) {
+	withConfig(t, "test.rewrites", []map[string]interface{}{
+		{"match": `^https?://(www\.)?acme\.com/`, "replace": "https://acme.com/"},
+		{"match": `^(https://shop\.acme\.com/[^?]*)$`, "replace": "${1}?ref=fast"},
+	})
+	rules, err := loadRewriteRules("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		destination string
+		want        string
+	}{
+		{"canonical domain", "http://www.acme.com/pricing", "https://acme.com/pricing"},
+		{"tracking param", "https://shop.acme.com/boots", "https://shop.acme.com/boots?ref=fast"},
+		{"already has a query", "https://shop.acme.com/boots?size=9", "https://shop.acme.com/boots?size=9"},
+		{"unchanged", "https://example.com/a", "https://example.com/a"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rewriteDestination(rules, tt.destination); got != tt.want {
+				t.Errorf("rewriteDestination(%s) = %s, want %s", tt.destination, got, tt.want)
+			}
+
+			fake := (&fakeDB{}).onFunc("INSERT INTO urls", insertedLinks)
+			creator := testCreator(fake)
+			creator.rewrites = rules
+			link, err := creator.create(context.Background(), ShortenURLRequest{URL: tt.destination}, creation{}, testSugar)
+			if err != nil {
+				t.Fatalf("create() = %v", err)
+			}
+			if link.OriginalURL != tt.want {
+				t.Errorf("response has %s, want %s", link.OriginalURL, tt.want)
+			}
+			if stored := fake.statements("INSERT INTO urls")[0].args[0]; stored != tt.want {
+				t.Errorf("stored %s, want %s", stored, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const (
+	deviceMobile  = "mobile"
+	deviceTablet  = "tablet"
+	deviceDesktop = "desktop"
+)
+
+// RedirectRule a conditional destination for a short link. A rule matches
+// when every condition it sets matches the client; empty conditions match
+// anything
+type RedirectRule struct {
+	Country string `json:"country,omitempty" yaml:"country,omitempty"`
+	Device  string `json:"device,omitempty" yaml:"device,omitempty"`
+	URL     string `json:"url" yaml:"url"`
+}
+
+// ClientInfo what we know about the client when evaluating redirect rules
+type ClientInfo struct {
+	Country string
+	Device  string
+}
+
+// ValidateRules make sure every rule has a valid destination and a known device
+func ValidateRules(rules []RedirectRule) error {
+	for _, rule := range rules {
+		if _, err := url.ParseRequestURI(rule.URL); err != nil {
+			return fmt.Errorf("couldn't parse rule url: %s", err)
+		}
+		switch strings.ToLower(rule.Device) {
+		case "", deviceMobile, deviceTablet, deviceDesktop:
+		default:
+			return fmt.Errorf("unknown device type: %s", rule.Device)
+		}
+		if rule.Country == "" && rule.Device == "" {
+			return errors.New("a rule needs a country or device condition")
+		}
+	}
+
+	return nil
+}
+
+// MatchRule return the destination of the first rule matching the client.
+// An empty string is returned when no rule matches so the caller can fall
+// back to the default destination
+func MatchRule(rules []RedirectRule, client ClientInfo) string {
+	for _, rule := range rules {
+		if rule.Country != "" && !strings.EqualFold(rule.Country, client.Country) {
+			continue
+		}
+		if rule.Device != "" && !strings.EqualFold(rule.Device, client.Device) {
+			continue
+		}
+		return rule.URL
+	}
+
+	return ""
+}
+
+// DeviceType classify the client device from its User-Agent
+func DeviceType(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+	switch {
+	case strings.Contains(ua, "ipad") || strings.Contains(ua, "tablet") ||
+		(strings.Contains(ua, "android") && !strings.Contains(ua, "mobile")):
+		return deviceTablet
+	case strings.Contains(ua, "mobi") || strings.Contains(ua, "iphone"):
+		return deviceMobile
+	default:
+		return deviceDesktop
+	}
+}
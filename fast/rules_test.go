@@ -0,0 +1,77 @@
+package main
+
+import "testing"
+
+func TestValidateRules(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []RedirectRule
+		valid bool
+	}{
+		{"none", nil, true},
+		{"country", []RedirectRule{{Country: "DE", URL: "https://example.de/"}}, true},
+		{"device in any case", []RedirectRule{{Device: "Mobile", URL: "https://m.example.com/"}}, true},
+		{"both conditions", []RedirectRule{{Country: "US", Device: deviceTablet, URL: "https://example.com/tab"}}, true},
+		{"not a url", []RedirectRule{{Country: "DE", URL: "example"}}, false},
+		{"unknown device", []RedirectRule{{Device: "watch", URL: "https://example.com/"}}, false},
+		{"no condition", []RedirectRule{{URL: "https://example.com/"}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateRules(tt.rules); (err == nil) != tt.valid {
+				t.Errorf("ValidateRules() = %v, want valid = %t", err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestMatchRule(t *testing.T) {
+	rules := []RedirectRule{
+		{Country: "DE", Device: deviceMobile, URL: "https://m.example.de/"},
+		{Country: "DE", URL: "https://example.de/"},
+		{Device: deviceTablet, URL: "https://example.com/tab"},
+	}
+	tests := []struct {
+		name   string
+		client ClientInfo
+		want   string
+	}{
+		{"first match wins", ClientInfo{Country: "DE", Device: deviceMobile}, "https://m.example.de/"},
+		{"country case", ClientInfo{Country: "de", Device: deviceDesktop}, "https://example.de/"},
+		{"device only", ClientInfo{Country: "FR", Device: deviceTablet}, "https://example.com/tab"},
+		{"no match", ClientInfo{Country: "FR", Device: deviceDesktop}, ""},
+		{"unknown country", ClientInfo{Device: deviceMobile}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MatchRule(rules, tt.client); got != tt.want {
+				t.Errorf("MatchRule(%+v) = %q, want %q", tt.client, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeviceType(t *testing.T) {
+	tests := []struct {
+		name      string
+		userAgent string
+		want      string
+	}{
+		{"iphone", "Mozilla/5.0 (iPhone; CPU iPhone OS 15_0 like Mac OS X) Mobile/15E148", deviceMobile},
+		{"android phone", "Mozilla/5.0 (Linux; Android 12; Pixel 6) Mobile Safari/537.36", deviceMobile},
+		{"android tablet", "Mozilla/5.0 (Linux; Android 12; SM-X700) Safari/537.36", deviceTablet},
+		{"ipad", "Mozilla/5.0 (iPad; CPU OS 15_0 like Mac OS X)", deviceTablet},
+		{"desktop", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) Chrome/100.0", deviceDesktop},
+		{"empty", "", deviceDesktop},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DeviceType(tt.userAgent); got != tt.want {
+				t.Errorf("DeviceType(%q) = %s, want %s", tt.userAgent, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aeekayy/systems/fast/db"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultSearchLimit = 100  // The number of links returned when no limit is given
+	maxSearchLimit     = 1000 // The most links returned in one search
+)
+
+// SearchResult a link matching a search
+type SearchResult struct {
+	URI         string    `json:"uri" yaml:"uri"`
+	OriginalURL string    `json:"original_url" yaml:"original_url"`
+	Created     time.Time `json:"created" yaml:"created"`
+	Details     URLJSON   `json:"details" yaml:"details"`
+}
+
+// searchHandler find links by the request details stored with them, newest
+// first. ?referer= and ?agent= match raw_json exactly through containment so
+// the GIN index on raw_json serves the query
+func searchHandler(ctx context.Context, dbConn db.Querier, sugar *zap.SugaredLogger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sugar := requestSugar(c, sugar)
+		limit := defaultSearchLimit
+		if val := c.Query("limit"); val != "" {
+			parsed, err := strconv.Atoi(val)
+			if err != nil || parsed <= 0 {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": "limit must be a positive number",
+				})
+				return
+			}
+			limit = parsed
+		}
+		if limit > maxSearchLimit {
+			limit = maxSearchLimit
+		}
+
+		// empty fields are left out so they don't have to match
+		filter, err := json.Marshal(URLJSON{Referer: c.Query("referer"), Agent: c.Query("agent")})
+		if err != nil {
+			sugar.Errorf("error building search filter: %s", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "error searching URLs",
+			})
+			return
+		}
+
+		rows, err := dbConn.Query(ctx, "SELECT uri, original_url, created, COALESCE(raw_json, '{}')::text FROM "+urlsTable+" WHERE ($1 = '{}' OR raw_json @> $1::jsonb) ORDER BY created DESC LIMIT $2;", string(filter), limit)
+		if err != nil {
+			sugar.Errorf("error searching URLs: %s", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "error searching URLs",
+			})
+			return
+		}
+		defer rows.Close()
+
+		results := []SearchResult{}
+		for rows.Next() {
+			var result SearchResult
+			var details []byte
+			if err := rows.Scan(&result.URI, &result.OriginalURL, &result.Created, &details); err != nil {
+				sugar.Errorf("error reading search results: %s", err)
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"error": "error searching URLs",
+				})
+				return
+			}
+			if err := json.Unmarshal(details, &result.Details); err != nil {
+				sugar.Errorf("error reading search results: %s", err)
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"error": "error searching URLs",
+				})
+				return
+			}
+			results = append(results, result)
+		}
+		if err := rows.Err(); err != nil {
+			sugar.Errorf("error reading search results: %s", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "error searching URLs",
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"data": results,
+		})
+	}
+}
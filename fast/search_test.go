@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestSearchHandler(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  string
+		status int
+		filter string
+		limit  int
+	}{
+		{"everything", "", http.StatusOK, "{}", defaultSearchLimit},
+		{"by referer", "?referer=https://news.example", http.StatusOK, `{"referer":"https://news.example"}`, defaultSearchLimit},
+		{"by referer and agent", "?referer=r&agent=curl", http.StatusOK, `{"agent":"curl","referer":"r"}`, defaultSearchLimit},
+		{"limit capped", "?limit=5000", http.StatusOK, "{}", maxSearchLimit},
+		{"bad limit", "?limit=-1", http.StatusBadRequest, "", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := (&fakeDB{}).on("raw_json @>", fakeResult{rows: [][]interface{}{
+				{"launch", "https://example.com", time.Now(), []byte(`{"referer":"https://news.example"}`)},
+			}})
+			r := testRouter()
+			r.GET("/api/v1/search", requireAdmin, searchHandler(context.Background(), fake, testSugar))
+			w := serve(r, http.MethodGet, "/api/v1/search"+tt.query, testAdminKey, "")
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			args := fake.statements("raw_json @>")[0].args
+			if args[0] != tt.filter || args[1] != tt.limit {
+				t.Errorf("searched %v limit %v, want %s and %d", args[0], args[1], tt.filter, tt.limit)
+			}
+			var body struct {
+				Data []SearchResult `json:"data"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("couldn't decode %s: %s", w.Body, err)
+			}
+			if len(body.Data) != 1 || body.Data[0].Details.Referer != "https://news.example" {
+				t.Errorf("data = %+v, want the stored referer", body.Data)
+			}
+		})
+	}
+}
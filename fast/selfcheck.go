@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aeekayy/systems/fast/db"
+)
+
+const (
+	selfCheckDestination = "https://example.com/fast-self-check" // Where the self check link points
+	selfCheckSource      = "self-check"                          // The source the self check link is tagged with
+)
+
+// selfCheck create a throwaway link, resolve it and delete it again so a
+// deployment with broken database wiring fails on boot instead of on the
+// first request. The link is removed even when resolving it fails
+func selfCheck(ctx context.Context, dbConn db.Querier, caseInsensitive bool) (err error) {
+	uri := "self-check-" + RandStringBytesMaskImprSrcSB(uriLength)
+	if _, err := dbConn.Exec(ctx, "INSERT INTO "+urlsTable+"(original_url, uri, lookup_uri, source) VALUES($1, $2, $3, $4);", selfCheckDestination, uri, lookupURI(uri, caseInsensitive), selfCheckSource); err != nil {
+		return fmt.Errorf("couldn't create the self check link: %w", err)
+	}
+	defer func() {
+		if _, deleteErr := dbConn.Exec(ctx, "DELETE FROM "+urlsTable+" WHERE uri = $1;", uri); deleteErr != nil && err == nil {
+			err = fmt.Errorf("couldn't delete the self check link: %w", deleteErr)
+		}
+	}()
+
+	link, err := loadRedirectLink(ctx, dbConn, uri, caseInsensitive)
+	if err != nil {
+		return fmt.Errorf("couldn't resolve the self check link: %w", err)
+	}
+	if link.OriginalURL != selfCheckDestination {
+		return fmt.Errorf("the self check link resolved to %s instead of %s", link.OriginalURL, selfCheckDestination)
+	}
+
+	return nil
+}
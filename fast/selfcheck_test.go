@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSelfCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		db      func() *fakeDB
+		wantErr string
+	}{
+		{
+			name: "passes and cleans up",
+			db: func() *fakeDB {
+				return (&fakeDB{}).onFunc("SELECT uri", func(args []interface{}) fakeResult {
+					return fakeResult{rows: [][]interface{}{redirectRow(args[0].(string), selfCheckDestination)}}
+				})
+			},
+		},
+		{
+			name: "insert fails",
+			db: func() *fakeDB {
+				return (&fakeDB{}).on("INSERT", fakeResult{err: errors.New("permission denied")})
+			},
+			wantErr: "couldn't create the self check link",
+		},
+		{
+			name: "link doesn't resolve",
+			db: func() *fakeDB {
+				return &fakeDB{}
+			},
+			wantErr: "couldn't resolve the self check link",
+		},
+		{
+			name: "wrong destination",
+			db: func() *fakeDB {
+				return (&fakeDB{}).on("SELECT uri", fakeResult{rows: [][]interface{}{redirectRow("self-check-x", "https://example.org")}})
+			},
+			wantErr: "resolved to https://example.org",
+		},
+		{
+			name: "delete fails",
+			db: func() *fakeDB {
+				return (&fakeDB{}).
+					onFunc("SELECT uri", func(args []interface{}) fakeResult {
+						return fakeResult{rows: [][]interface{}{redirectRow(args[0].(string), selfCheckDestination)}}
+					}).
+					on("DELETE", fakeResult{err: errors.New("connection reset")})
+			},
+			wantErr: "couldn't delete the self check link",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := tt.db()
+			err := selfCheck(context.Background(), fake, false)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("selfCheck() = %v, want nil", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("selfCheck() = %v, want an error containing %q", err, tt.wantErr)
+			}
+			if tt.name != "insert fails" && len(fake.statements("DELETE")) != 1 {
+				t.Errorf("the self check link wasn't deleted")
+			}
+		})
+	}
+}
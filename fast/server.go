@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+)
+
+const (
+	defaultReadTimeout       = 10 * time.Second // How long a client has to send a whole request
+	defaultReadHeaderTimeout = 5 * time.Second  // How long a client has to send the request headers
+	defaultWriteTimeout      = 30 * time.Second // How long writing a response may take
+	defaultIdleTimeout       = 2 * time.Minute  // How long an idle keep-alive connection is kept open
+)
+
+// ServerConfig the timeouts of the HTTP server. Without them slow clients
+// can hold connections open forever
+type ServerConfig struct {
+	ReadTimeout       time.Duration `mapstructure:"read_timeout" yaml:"read_timeout"`
+	ReadHeaderTimeout time.Duration `mapstructure:"read_header_timeout" yaml:"read_header_timeout"`
+	WriteTimeout      time.Duration `mapstructure:"write_timeout" yaml:"write_timeout"`
+	IdleTimeout       time.Duration `mapstructure:"idle_timeout" yaml:"idle_timeout"`
+}
+
+// loadServerConfig read the HTTP server settings for the environment
+func loadServerConfig(env string) (ServerConfig, error) {
+	var cfg ServerConfig
+	if err := viper.UnmarshalKey(fmt.Sprintf("%s.server", env), &cfg); err != nil {
+		return cfg, fmt.Errorf("couldn't read server configuration: %w", err)
+	}
+	if cfg.ReadTimeout <= 0 {
+		cfg.ReadTimeout = defaultReadTimeout
+	}
+	if cfg.ReadHeaderTimeout <= 0 {
+		cfg.ReadHeaderTimeout = defaultReadHeaderTimeout
+	}
+	if cfg.WriteTimeout <= 0 {
+		cfg.WriteTimeout = defaultWriteTimeout
+	}
+	if cfg.IdleTimeout <= 0 {
+		cfg.IdleTimeout = defaultIdleTimeout
+	}
+
+	return cfg, nil
+}
+
+// configureGinMode set the gin mode from the environment's gin_mode. prod
+// runs gin in release mode unless told otherwise, other environments keep
+// whatever GIN_MODE says
+func configureGinMode(env string) error {
+	mode := viper.GetString(fmt.Sprintf("%s.gin_mode", env))
+	if mode == "" && env == "prod" {
+		mode = gin.ReleaseMode
+	}
+	switch mode {
+	case "":
+	case gin.DebugMode, gin.ReleaseMode, gin.TestMode:
+		gin.SetMode(mode)
+	default:
+		return fmt.Errorf("unknown gin mode %s", mode)
+	}
+	return nil
+}
+
+// newHTTPServer the server for the handler with the configured timeouts
+func newHTTPServer(addr string, handler http.Handler, cfg ServerConfig) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadTimeout:       cfg.ReadTimeout,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+	}
+}
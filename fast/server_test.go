@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestLoadServerConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  map[string]interface{}
+		want ServerConfig
+	}{
+		{"defaults", nil, ServerConfig{
+			ReadTimeout:       defaultReadTimeout,
+			ReadHeaderTimeout: defaultReadHeaderTimeout,
+			WriteTimeout:      defaultWriteTimeout,
+			IdleTimeout:       defaultIdleTimeout,
+		}},
+		{"configured", map[string]interface{}{
+			"read_timeout":        "3s",
+			"read_header_timeout": "1s",
+			"write_timeout":       "7s",
+			"idle_timeout":        "1m",
+		}, ServerConfig{
+			ReadTimeout:       3 * time.Second,
+			ReadHeaderTimeout: time.Second,
+			WriteTimeout:      7 * time.Second,
+			IdleTimeout:       time.Minute,
+		}},
+		{"partly configured", map[string]interface{}{"write_timeout": "7s", "idle_timeout": "0s"}, ServerConfig{
+			ReadTimeout:       defaultReadTimeout,
+			ReadHeaderTimeout: defaultReadHeaderTimeout,
+			WriteTimeout:      7 * time.Second,
+			IdleTimeout:       defaultIdleTimeout,
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.cfg != nil {
+				withConfig(t, "test.server", tt.cfg)
+			}
+			cfg, err := loadServerConfig("test")
+			if err != nil || cfg != tt.want {
+				t.Errorf("loadServerConfig() = %+v, %v, want %+v", cfg, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewHTTPServer(t *testing.T) {
+	cfg := ServerConfig{
+		ReadTimeout:       3 * time.Second,
+		ReadHeaderTimeout: time.Second,
+		WriteTimeout:      7 * time.Second,
+		IdleTimeout:       time.Minute,
+	}
+	handler := http.NewServeMux()
+	srv := newHTTPServer(":8080", handler, cfg)
+	if srv.Addr != ":8080" || srv.Handler != handler {
+		t.Errorf("server serves %s with %v, want :8080 with the handler", srv.Addr, srv.Handler)
+	}
+	got := ServerConfig{
+		ReadTimeout:       srv.ReadTimeout,
+		ReadHeaderTimeout: srv.ReadHeaderTimeout,
+		WriteTimeout:      srv.WriteTimeout,
+		IdleTimeout:       srv.IdleTimeout,
+	}
+	if got != cfg {
+		t.Errorf("server timeouts = %+v, want %+v", got, cfg)
+	}
+}
+
+func TestConfigureGinMode(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     string
+		mode    string
+		want    string
+		wantErr bool
+	}{
+		{"left to GIN_MODE", "test", "", gin.TestMode, false},
+		{"prod defaults to release", "prod", "", gin.ReleaseMode, false},
+		{"prod told otherwise", "prod", gin.DebugMode, gin.DebugMode, false},
+		{"configured", "test", gin.ReleaseMode, gin.ReleaseMode, false},
+		{"unknown", "test", "verbose", gin.TestMode, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Cleanup(func() { gin.SetMode(gin.TestMode) })
+			if tt.mode != "" {
+				withConfig(t, tt.env+".gin_mode", tt.mode)
+			}
+			err := configureGinMode(tt.env)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("configureGinMode() = %v, want an error %t", err, tt.wantErr)
+			}
+			if got := gin.Mode(); got != tt.want {
+				t.Errorf("gin mode = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
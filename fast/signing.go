@@ -0,0 +1,53 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	signatureSeparator = "." // Separates a signed link's uri from its signature, aliases can't contain it
+	signatureBytes     = 12  // The bytes of the HMAC kept in a signature
+)
+
+// signURI the signature of a uri, so only short URLs we issued resolve
+func signURI(secret []byte, uri string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(uri))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:signatureBytes])
+}
+
+// signedURI the path of a signed link, its uri and signature
+func signedURI(secret []byte, uri string) string {
+	return uri + signatureSeparator + signURI(secret, uri)
+}
+
+// splitSignature split a requested path into the uri and the signature,
+// which is empty when the path isn't signed
+func splitSignature(path string) (string, string) {
+	i := strings.LastIndex(path, signatureSeparator)
+	if i < 0 {
+		return path, ""
+	}
+	return path[:i], path[i+len(signatureSeparator):]
+}
+
+// validSignature whether the signature was issued for the uri
+func validSignature(secret []byte, uri, signature string) bool {
+	if len(secret) == 0 || signature == "" {
+		return false
+	}
+	return hmac.Equal([]byte(signature), []byte(signURI(secret, uri)))
+}
+
+// mayHaveSignature whether the caller may see the signature of a link that
+// owner created. Only the owner and admin keys get it, anyone else knowing
+// the bare uri could otherwise turn it into a link that resolves
+func mayHaveSignature(c *gin.Context, owner string) bool {
+	key, ok := currentAPIKey(c)
+	return ok && (key.Admin || (owner != "" && key.ID == owner))
+}
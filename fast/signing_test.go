@@ -0,0 +1,58 @@
+package main
+
+import "testing"
+
+func TestSplitSignature(t *testing.T) {
+	tests := []struct {
+		name      string
+		path      string
+		uri       string
+		signature string
+	}{
+		{"unsigned", "launch", "launch", ""},
+		{"signed", "launch.c2lnbmF0dXJl", "launch", "c2lnbmF0dXJl"},
+		{"last separator splits", "a.b.sig", "a.b", "sig"},
+		{"empty signature", "launch.", "launch", ""},
+		{"empty", "", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uri, signature := splitSignature(tt.path)
+			if uri != tt.uri || signature != tt.signature {
+				t.Errorf("splitSignature(%q) = %q, %q, want %q, %q", tt.path, uri, signature, tt.uri, tt.signature)
+			}
+		})
+	}
+}
+
+func TestValidSignature(t *testing.T) {
+	secret := []byte("secret")
+	uri, signature := splitSignature(signedURI(secret, "launch"))
+	if uri != "launch" {
+		t.Fatalf("signedURI() split into uri %q, want launch", uri)
+	}
+
+	tests := []struct {
+		name      string
+		secret    []byte
+		uri       string
+		signature string
+		want      bool
+	}{
+		{"issued", secret, "launch", signature, true},
+		{"another uri", secret, "promo", signature, false},
+		{"another secret", []byte("other"), "launch", signature, false},
+		{"tampered", secret, "launch", signature[1:], false},
+		{"missing", secret, "launch", "", false},
+		{"no secret configured", nil, "launch", signURI(nil, "launch"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := validSignature(tt.secret, tt.uri, tt.signature); got != tt.want {
+				t.Errorf("validSignature() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
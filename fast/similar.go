@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aeekayy/systems/fast/db"
+	pgx "github.com/jackc/pgx/v4"
+	"github.com/spf13/viper"
+)
+
+// StrictAliasConfig reject aliases within Distance edits of an existing
+// uri or one of the Brands, ignoring case, so lookalikes like paypa1 can't
+// be used for phishing. A zero Distance turns the check off
+type StrictAliasConfig struct {
+	Distance int      `mapstructure:"distance" yaml:"distance"`
+	Brands   []string `mapstructure:"brands" yaml:"brands"`
+}
+
+// loadStrictAliasConfig read the strict alias settings for the environment
+func loadStrictAliasConfig(env string) (StrictAliasConfig, error) {
+	var cfg StrictAliasConfig
+	if err := viper.UnmarshalKey(fmt.Sprintf("%s.alias.strict", env), &cfg); err != nil {
+		return cfg, fmt.Errorf("couldn't read strict alias configuration: %w", err)
+	}
+	if cfg.Distance < 0 {
+		return cfg, errors.New("strict alias distance can't be negative")
+	}
+
+	return cfg, nil
+}
+
+// similarAlias what an alias is too close to, a brand or an existing link,
+// or nothing when it's distinct enough. The database only compares uris of
+// a length that could be close, and stops counting edits past the distance
+func similarAlias(ctx context.Context, dbConn db.Querier, cfg StrictAliasConfig, alias string) (string, error) {
+	if cfg.Distance == 0 {
+		return "", nil
+	}
+	for _, brand := range cfg.Brands {
+		if withinEditDistance(strings.ToLower(alias), strings.ToLower(brand), cfg.Distance) {
+			return fmt.Sprintf("%q", brand), nil
+		}
+	}
+
+	var similar string
+	err := dbConn.QueryRow(ctx, `SELECT uri FROM `+urlsTable+`
+		WHERE length(uri) BETWEEN $2 AND $3 AND levenshtein_less_equal(lower(uri), lower($1), $4) <= $4 LIMIT 1;`,
+		alias, len(alias)-cfg.Distance, len(alias)+cfg.Distance, cfg.Distance).Scan(&similar)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("couldn't compare alias: %w", err)
+	}
+
+	return "an existing link", nil
+}
+
+// withinEditDistance whether a can be turned into b with at most max
+// single character insertions, deletions or substitutions. Rows stop being
+// computed once every cell is past max
+func withinEditDistance(a, b string, max int) bool {
+	if len(a)-len(b) > max || len(b)-len(a) > max {
+		return false
+	}
+
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		best := current[0]
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = minOf(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+			if current[j] < best {
+				best = current[j]
+			}
+		}
+		if best > max {
+			return false
+		}
+		previous, current = current, previous
+	}
+
+	return previous[len(b)] <= max
+}
+
+// minOf the smallest of the numbers
+func minOf(first int, rest ...int) int {
+	for _, n := range rest {
+		if n < first {
+			first = n
+		}
+	}
+	return first
+}
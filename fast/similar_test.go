@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestWithinEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		max  int
+		want bool
+	}{
+		{"paypal", "paypal", 0, true},
+		{"paypa1", "paypal", 1, true},
+		{"paypal", "paypall", 1, true},
+		{"paypl", "paypal", 1, true},
+		{"pyapal", "paypal", 1, false},
+		{"pyapal", "paypal", 2, true},
+		{"launch", "paypal", 2, false},
+		{"pay", "paypal", 2, false},
+		{"", "ab", 2, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.a+" "+tt.b, func(t *testing.T) {
+			if got := withinEditDistance(tt.a, tt.b, tt.max); got != tt.want {
+				t.Errorf("withinEditDistance(%q, %q, %d) = %t, want %t", tt.a, tt.b, tt.max, got, tt.want)
+			}
+			if got := withinEditDistance(tt.b, tt.a, tt.max); got != tt.want {
+				t.Errorf("withinEditDistance(%q, %q, %d) = %t, want it symmetric", tt.b, tt.a, tt.max, got)
+			}
+		})
+	}
+}
+
+func TestLoadStrictAliasConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      map[string]interface{}
+		distance int
+		wantErr  bool
+	}{
+		{"off", nil, 0, false},
+		{"configured", map[string]interface{}{"distance": 2, "brands": []string{"paypal"}}, 2, false},
+		{"negative", map[string]interface{}{"distance": -1}, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.cfg != nil {
+				withConfig(t, "test.alias.strict", tt.cfg)
+			}
+			cfg, err := loadStrictAliasConfig("test")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadStrictAliasConfig() = %v, want an error %t", err, tt.wantErr)
+			}
+			if !tt.wantErr && cfg.Distance != tt.distance {
+				t.Errorf("distance = %d, want %d", cfg.Distance, tt.distance)
+			}
+		})
+	}
+}
+
+func TestLinkCreatorStrictAlias(t *testing.T) {
+	tests := []struct {
+		name     string
+		distance int
+		alias    string
+		existing bool
+		status   int
+	}{
+		{"off", 0, "paypa1", true, 0},
+		{"distinct", 1, "launch", false, 0},
+		{"near a brand", 1, "PayPa1", false, http.StatusBadRequest},
+		{"near an existing link", 1, "launcj", true, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := (&fakeDB{}).on("SELECT EXISTS", fakeResult{rows: [][]interface{}{{false}}}).onFunc("INSERT INTO urls", insertedLinks)
+			if tt.existing {
+				fake.on("levenshtein_less_equal", fakeResult{rows: [][]interface{}{{"launch"}}})
+			}
+			creator := testCreator(fake)
+			creator.strictAlias = StrictAliasConfig{Distance: tt.distance, Brands: []string{"paypal"}}
+
+			_, err := creator.create(context.Background(), ShortenURLRequest{URL: "https://example.com/a", Alias: tt.alias}, creation{}, testSugar)
+			if tt.status == 0 {
+				if err != nil {
+					t.Errorf("create() = %v", err)
+				}
+				if comparisons := fake.statements("levenshtein_less_equal"); (len(comparisons) == 1) != (tt.distance > 0) {
+					t.Errorf("compared against existing links %d times", len(comparisons))
+				}
+				return
+			}
+			var cerr *creationError
+			if !errors.As(err, &cerr) || cerr.Status != tt.status {
+				t.Fatalf("create() = %v, want a %d", err, tt.status)
+			}
+			if inserts := fake.statements("INSERT INTO urls"); len(inserts) != 0 {
+				t.Errorf("inserted a lookalike alias")
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/aeekayy/systems/fast/db"
+)
+
+const (
+	uriStrategySlug = "slug" // Generate readable uris from the destination or title
+	maxSlugLength   = 40     // The longest slug before a collision suffix
+)
+
+// Slugify turn text into a lowercase slug of letters, digits and single
+// dashes, e.g. "Hello, World!" becomes "hello-world"
+func Slugify(text string) string {
+	var sb strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9'):
+			sb.WriteRune(r)
+			dash = false
+		case !dash && sb.Len() > 0:
+			sb.WriteByte('-')
+			dash = true
+		}
+		if sb.Len() >= maxSlugLength {
+			break
+		}
+	}
+
+	return strings.Trim(sb.String(), "-")
+}
+
+// slugFor the slug of a link, from its title when it has one, otherwise
+// from the last segment of the destination path or the host
+func slugFor(originalURL, title string) string {
+	if slug := Slugify(title); slug != "" {
+		return slug
+	}
+
+	parsed, err := url.Parse(originalURL)
+	if err != nil {
+		return ""
+	}
+	segment := path.Base(strings.TrimSuffix(parsed.Path, "/"))
+	segment = strings.TrimSuffix(segment, path.Ext(segment))
+	if slug := Slugify(segment); slug != "" {
+		return slug
+	}
+
+	return Slugify(strings.TrimPrefix(parsed.Hostname(), "www."))
+}
+
+// nextSlugURI the slug itself when it's free, otherwise the slug with the
+// next free numeric suffix, e.g. "launch-2"
+func nextSlugURI(ctx context.Context, dbConn db.Querier, slug string) (string, error) {
+	// slugs only contain letters, digits and dashes so they are safe in the pattern
+	var taken bool
+	var maxSuffix int
+	err := dbConn.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM `+urlsTable+` WHERE uri = $1),
+		COALESCE(max(substring(uri from '-([0-9]+)$')::int), 1) FROM `+urlsTable+` WHERE uri ~ ('^' || $1 || '-[0-9]+$');`, slug).
+		Scan(&taken, &maxSuffix)
+	if err != nil {
+		return "", fmt.Errorf("couldn't check slug: %w", err)
+	}
+	if !taken {
+		return slug, nil
+	}
+
+	return fmt.Sprintf("%s-%d", slug, maxSuffix+1), nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"words", "Hello, World!", "hello-world"},
+		{"digits", "Release 2.0", "release-2-0"},
+		{"leading and trailing punctuation", "  --Launch--  ", "launch"},
+		{"runs of separators", "a   b___c", "a-b-c"},
+		{"non ascii dropped", "Café déjà vu", "caf-d-j-vu"},
+		{"nothing usable", "!!!", ""},
+		{"empty", "", ""},
+		{"cut at the maximum", strings.Repeat("ab ", 30), strings.Repeat("ab-", 13) + "a"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Slugify(tt.text)
+			if got != tt.want {
+				t.Errorf("Slugify(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+			if len(got) > maxSlugLength {
+				t.Errorf("Slugify(%q) is %d long, want at most %d", tt.text, len(got), maxSlugLength)
+			}
+		})
+	}
+}
+
+func TestSlugFor(t *testing.T) {
+	tests := []struct {
+		name  string
+		url   string
+		title string
+		want  string
+	}{
+		{"title", "https://example.com/a", "Spring Launch", "spring-launch"},
+		{"last path segment", "https://example.com/blog/spring-launch.html", "", "spring-launch"},
+		{"trailing slash", "https://example.com/blog/spring-launch/", "", "spring-launch"},
+		{"host", "https://www.example.com/", "", "example-com"},
+		{"unusable title", "https://example.com/pricing", "!!!", "pricing"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := slugFor(tt.url, tt.title); got != tt.want {
+				t.Errorf("slugFor(%q, %q) = %q, want %q", tt.url, tt.title, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextSlugURI(t *testing.T) {
+	tests := []struct {
+		name      string
+		taken     bool
+		maxSuffix int
+		want      string
+	}{
+		{"free", false, 1, "launch"},
+		{"taken", true, 1, "launch-2"},
+		{"taken with suffixes", true, 4, "launch-5"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := (&fakeDB{}).on("SELECT EXISTS", fakeResult{rows: [][]interface{}{{tt.taken, tt.maxSuffix}}})
+			got, err := nextSlugURI(context.Background(), fake, "launch")
+			if err != nil {
+				t.Fatalf("nextSlugURI() = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("nextSlugURI() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
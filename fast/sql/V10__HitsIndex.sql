@@ -0,0 +1 @@
+CREATE INDEX idx_urls_hits on urls(hits DESC);
@@ -0,0 +1 @@
+CREATE SEQUENCE IF NOT EXISTS urls_uri_seq START 1;
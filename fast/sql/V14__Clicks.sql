@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS clicks(
+    id      uuid DEFAULT uuid_generate_v4 (),
+    uri     varchar NOT NULL,
+    referer varchar,
+    country varchar,
+    device  varchar,
+    created timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY (id)
+);
+
+CREATE INDEX idx_clicks_uri_created on clicks(uri, created);
@@ -0,0 +1,3 @@
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS source varchar;
+
+CREATE INDEX idx_urls_source on urls(source);
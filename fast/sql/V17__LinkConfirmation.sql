@@ -0,0 +1,4 @@
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS owner_email varchar;
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS confirmed boolean NOT NULL DEFAULT true;
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS confirmation_token varchar;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_urls_confirmation_token ON urls (confirmation_token) WHERE confirmation_token IS NOT NULL;
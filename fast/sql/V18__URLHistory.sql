@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS url_history(
+    id           uuid DEFAULT uuid_generate_v4 (),
+    uri          varchar NOT NULL,
+    original_url varchar NOT NULL,
+    previous_url varchar,
+    actor        varchar,
+    created      timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY (id)
+);
+
+CREATE INDEX idx_url_history_uri_created on url_history(uri, created);
@@ -0,0 +1,2 @@
+-- raw_json is jsonb since V1. The index is built without locking writes
+CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_urls_raw_json on urls USING GIN (raw_json jsonb_path_ops);
@@ -0,0 +1,4 @@
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS owner varchar;
+
+CREATE INDEX IF NOT EXISTS idx_urls_owner on urls(owner);
+CREATE INDEX IF NOT EXISTS idx_urls_original_url on urls USING HASH (original_url);
@@ -0,0 +1 @@
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS idempotency_hash varchar;
@@ -0,0 +1,3 @@
+-- keyset pages of recent links walk (created, id), owners' links through their own index
+CREATE INDEX IF NOT EXISTS idx_urls_created_id on urls(created, id);
+CREATE INDEX IF NOT EXISTS idx_urls_owner_created_id on urls(owner, created, id);
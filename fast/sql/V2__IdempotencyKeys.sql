@@ -0,0 +1,3 @@
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS idempotency_key varchar;
+
+CREATE INDEX idx_urls_idempotency_key on urls(idempotency_key);
@@ -0,0 +1,3 @@
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS campaign varchar;
+
+CREATE INDEX IF NOT EXISTS idx_urls_campaign on urls(campaign);
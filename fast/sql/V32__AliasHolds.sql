@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS alias_holds(
+    alias   varchar NOT NULL,
+    owner   varchar NOT NULL,
+    expires timestamptz NOT NULL,
+    created timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY (alias)
+);
+
+CREATE INDEX IF NOT EXISTS idx_alias_holds_expires on alias_holds(expires);
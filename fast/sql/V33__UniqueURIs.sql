@@ -0,0 +1,9 @@
+-- uris were never unique, so duplicates have to be cleaned up with
+-- GET /api/v1/admin/duplicates first or the index can't be built. It is
+-- built without locking writes, then replaces the plain index from V1. A
+-- failed build leaves an invalid index behind, which is dropped on retry
+DROP INDEX CONCURRENTLY IF EXISTS idx_urls_uri_unique;
+
+CREATE UNIQUE INDEX CONCURRENTLY idx_urls_uri_unique on urls(uri);
+
+DROP INDEX CONCURRENTLY IF EXISTS idx_urls_uri;
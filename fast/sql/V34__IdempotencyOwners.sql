@@ -0,0 +1,9 @@
+-- idempotency keys are scoped to the API key that sent them. Only the newest
+-- link of an owner keeps a key that was used more than once, so V35 can
+-- make them unique
+UPDATE urls SET idempotency_key = NULL WHERE id IN (
+    SELECT id FROM (
+        SELECT id, row_number() OVER (PARTITION BY COALESCE(owner, ''), idempotency_key ORDER BY created DESC) AS n
+        FROM urls WHERE idempotency_key IS NOT NULL
+    ) ranked WHERE n > 1
+);
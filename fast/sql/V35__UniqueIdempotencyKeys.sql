@@ -0,0 +1,7 @@
+-- two requests racing with the same key can't both insert a link. Built
+-- without locking writes, it replaces the plain index from V2
+DROP INDEX CONCURRENTLY IF EXISTS idx_urls_owner_idempotency_key;
+
+CREATE UNIQUE INDEX CONCURRENTLY idx_urls_owner_idempotency_key on urls((COALESCE(owner, '')), idempotency_key) WHERE idempotency_key IS NOT NULL;
+
+DROP INDEX CONCURRENTLY IF EXISTS idx_urls_idempotency_key;
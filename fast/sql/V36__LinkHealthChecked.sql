@@ -0,0 +1,3 @@
+-- when the probe result last counted against a link was taken, so a cached
+-- result isn't counted twice and scheduled checks start with the oldest
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS health_checked timestamptz;
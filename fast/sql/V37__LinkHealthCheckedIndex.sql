@@ -0,0 +1 @@
+CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_urls_health_checked on urls(health_checked NULLS FIRST, created) WHERE disabled IS NULL;
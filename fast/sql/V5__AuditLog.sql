@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS audit_log(
+    id      uuid DEFAULT uuid_generate_v4 (),
+    action  varchar NOT NULL,
+    uri     varchar NOT NULL,
+    actor   varchar,
+    created timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY (id)
+);
+
+CREATE INDEX idx_audit_log_uri on audit_log(uri);
+CREATE INDEX idx_audit_log_created on audit_log(created);
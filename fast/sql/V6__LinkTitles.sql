@@ -0,0 +1,2 @@
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS title varchar;
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS description varchar;
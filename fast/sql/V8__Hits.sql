@@ -0,0 +1,3 @@
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS hits bigint NOT NULL DEFAULT 0;
+
+CREATE INDEX idx_urls_created on urls(created);
@@ -0,0 +1,3 @@
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS lookup_uri varchar;
+
+CREATE UNIQUE INDEX idx_urls_lookup_uri on urls(lookup_uri) WHERE lookup_uri IS NOT NULL;
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/aeekayy/systems/fast/db"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultTopLinks = 10  // The number of top links in the summary when no count is given
+	maxTopLinks     = 100 // The most top links returned in the summary
+)
+
+// LinkHits a short link and how often it was followed
+type LinkHits struct {
+	URI  string `json:"uri" yaml:"uri"`
+	Hits int64  `json:"hits" yaml:"hits"`
+}
+
+// SourceStats totals for the links created by one source. Links created
+// without a source are grouped with the source left out, like in URLMetadata
+type SourceStats struct {
+	Source string `json:"source,omitempty" yaml:"source,omitempty"`
+	Links  int64  `json:"links" yaml:"links"`
+	Clicks int64  `json:"clicks" yaml:"clicks"`
+}
+
+// StatsSummary totals for the whole service, or for one source when filtered
+type StatsSummary struct {
+	TotalLinks       int64         `json:"total_links" yaml:"total_links"`
+	TotalClicks      int64         `json:"total_clicks" yaml:"total_clicks"`
+	LinksLast24Hours int64         `json:"links_last_24h" yaml:"links_last_24h"`
+	TopLinks         []LinkHits    `json:"top_links" yaml:"top_links"`
+	Sources          []SourceStats `json:"sources" yaml:"sources"`
+}
+
+// statsHandler summarize the service: total links and clicks, links created
+// in the last day, the most followed links and a break down by creation
+// source. ?source= limits everything to links from that source
+func statsHandler(ctx context.Context, dbConn db.Querier, sugar *zap.SugaredLogger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sugar := requestSugar(c, sugar)
+		top := defaultTopLinks
+		if val := c.Query("top"); val != "" {
+			parsed, err := strconv.Atoi(val)
+			if err != nil || parsed < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": "top must be a non-negative number",
+				})
+				return
+			}
+			top = parsed
+		}
+		if top > maxTopLinks {
+			top = maxTopLinks
+		}
+
+		source := c.Query("source")
+		summary := StatsSummary{TopLinks: []LinkHits{}, Sources: []SourceStats{}}
+		err := dbConn.QueryRow(ctx, `SELECT count(*), COALESCE(sum(hits), 0)::bigint,
+			count(*) FILTER (WHERE created > now() - interval '24 hours') FROM `+urlsTable+`
+			WHERE ($1 = '' OR source = $1);`, source).
+			Scan(&summary.TotalLinks, &summary.TotalClicks, &summary.LinksLast24Hours)
+		if err != nil {
+			sugar.Errorf("error retrieving stats: %s", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "error retrieving stats",
+			})
+			return
+		}
+
+		rows, err := dbConn.Query(ctx, "SELECT uri, hits FROM "+urlsTable+" WHERE ($1 = '' OR source = $1) ORDER BY hits DESC LIMIT $2;", source, top)
+		if err != nil {
+			sugar.Errorf("error retrieving top links: %s", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "error retrieving stats",
+			})
+			return
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var link LinkHits
+			if err := rows.Scan(&link.URI, &link.Hits); err != nil {
+				sugar.Errorf("error reading top links: %s", err)
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"error": "error retrieving stats",
+				})
+				return
+			}
+			summary.TopLinks = append(summary.TopLinks, link)
+		}
+		if err := rows.Err(); err != nil {
+			sugar.Errorf("error reading top links: %s", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "error retrieving stats",
+			})
+			return
+		}
+		rows.Close()
+
+		rows, err = dbConn.Query(ctx, `SELECT COALESCE(source, ''), count(*), COALESCE(sum(hits), 0)::bigint FROM `+urlsTable+`
+			WHERE ($1 = '' OR source = $1) GROUP BY 1 ORDER BY 2 DESC;`, source)
+		if err != nil {
+			sugar.Errorf("error retrieving source stats: %s", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "error retrieving stats",
+			})
+			return
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var stats SourceStats
+			if err := rows.Scan(&stats.Source, &stats.Links, &stats.Clicks); err != nil {
+				sugar.Errorf("error reading source stats: %s", err)
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"error": "error retrieving stats",
+				})
+				return
+			}
+			summary.Sources = append(summary.Sources, stats)
+		}
+		if err := rows.Err(); err != nil {
+			sugar.Errorf("error reading source stats: %s", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "error retrieving stats",
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"data": summary,
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestStatsHandler(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  string
+		status int
+		top    int
+	}{
+		{"defaults", "", http.StatusOK, defaultTopLinks},
+		{"top", "?top=3", http.StatusOK, 3},
+		{"no top links", "?top=0", http.StatusOK, 0},
+		{"top capped", "?top=500", http.StatusOK, maxTopLinks},
+		{"bad top", "?top=-1", http.StatusBadRequest, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := (&fakeDB{}).
+				on("count(*) FILTER", fakeResult{rows: [][]interface{}{{int64(3), int64(12), int64(1)}}}).
+				on("ORDER BY hits DESC", fakeResult{rows: [][]interface{}{{"launch", int64(10)}, {"promo", int64(2)}}})
+			r := testRouter()
+			r.GET("/api/v1/stats", statsHandler(context.Background(), fake, testSugar))
+			w := serve(r, http.MethodGet, "/api/v1/stats"+tt.query, testAdminKey, "")
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			if args := fake.statements("ORDER BY hits DESC")[0].args; args[1] != tt.top {
+				t.Errorf("asked for %v top links, want %d", args[1], tt.top)
+			}
+			var body struct {
+				Data StatsSummary `json:"data"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("couldn't decode %s: %s", w.Body, err)
+			}
+			summary := body.Data
+			if summary.TotalLinks != 3 || summary.TotalClicks != 12 || summary.LinksLast24Hours != 1 {
+				t.Errorf("totals = %+v, want 3 links, 12 clicks and 1 in the last day", summary)
+			}
+			if len(summary.TopLinks) != 2 || summary.TopLinks[0] != (LinkHits{URI: "launch", Hits: 10}) {
+				t.Errorf("top links = %+v, want launch first", summary.TopLinks)
+			}
+		})
+	}
+}
+
+func TestStatsHandlerSources(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  string
+		source string
+	}{
+		{"every source", "", ""},
+		{"one source", "?source=ios-app", "ios-app"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := (&fakeDB{}).
+				on("count(*) FILTER", fakeResult{rows: [][]interface{}{{int64(3), int64(12), int64(1)}}}).
+				on("GROUP BY 1", fakeResult{rows: [][]interface{}{{"ios-app", int64(2), int64(10)}, {"", int64(1), int64(2)}}})
+			r := testRouter()
+			r.GET("/api/v1/stats", statsHandler(context.Background(), fake, testSugar))
+			w := serve(r, http.MethodGet, "/api/v1/stats"+tt.query, testAdminKey, "")
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", w.Code, w.Body)
+			}
+
+			for _, match := range []string{"count(*) FILTER", "ORDER BY hits DESC", "GROUP BY 1"} {
+				if args := fake.statements(match)[0].args; args[0] != tt.source {
+					t.Errorf("%s filtered on source %q, want %q", match, args[0], tt.source)
+				}
+			}
+			var body struct {
+				Data StatsSummary `json:"data"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("couldn't decode %s: %s", w.Body, err)
+			}
+			want := []SourceStats{{Source: "ios-app", Links: 2, Clicks: 10}, {Links: 1, Clicks: 2}}
+			if len(body.Data.Sources) != 2 || body.Data.Sources[0] != want[0] || body.Data.Sources[1] != want[1] {
+				t.Errorf("sources = %+v, want %+v", body.Data.Sources, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// urlsTable the table short links are stored in. It can be schema qualified
+// for namespaced deployments and is set once at startup
+var urlsTable = "urls"
+
+// tableNamePattern a plain or schema qualified identifier. Table names are
+// put into queries directly so anything else is refused
+var tableNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// setURLsTable use the table for every query against short links
+func setURLsTable(name string) error {
+	if !tableNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid table name %q", name)
+	}
+	urlsTable = name
+
+	return nil
+}
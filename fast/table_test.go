@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestSetURLsTable(t *testing.T) {
+	tests := []struct {
+		name    string
+		table   string
+		wantErr bool
+	}{
+		{"plain", "links", false},
+		{"schema qualified", "tenant_a.urls", false},
+		{"empty", "", true},
+		{"leading digit", "1urls", true},
+		{"too many parts", "db.tenant.urls", true},
+		{"quoted", `"urls"`, true},
+		{"injection", "urls; DROP TABLE urls", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Cleanup(func() { urlsTable = "urls" })
+			err := setURLsTable(tt.table)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("setURLsTable(%q) = %v, want an error %t", tt.table, err, tt.wantErr)
+			}
+			want := tt.table
+			if tt.wantErr {
+				want = "urls"
+			}
+			if urlsTable != want {
+				t.Errorf("table = %s, want %s", urlsTable, want)
+			}
+		})
+	}
+}
+
+func TestQueriesUseURLsTable(t *testing.T) {
+	t.Cleanup(func() { urlsTable = "urls" })
+	if err := setURLsTable("tenant_a.links"); err != nil {
+		t.Fatal(err)
+	}
+
+	fake := (&fakeDB{}).onFunc("INSERT INTO tenant_a.links", insertedLinks)
+	ctx := context.Background()
+	if _, err := testCreator(fake).create(ctx, ShortenURLRequest{URL: "https://example.com/a"}, creation{}, testSugar); err != nil {
+		t.Fatalf("create() = %v", err)
+	}
+	if _, err := deleteLink(ctx, fake, NewRedirectCache(10), "launch", "", "", true); err != nil {
+		t.Fatalf("deleteLink() = %v", err)
+	}
+
+	if len(fake.calls) == 0 {
+		t.Fatal("no queries were made")
+	}
+	for _, call := range fake.calls {
+		if strings.Contains(call.sql, " urls") {
+			t.Errorf("query uses the default table: %s", call.sql)
+		}
+	}
+	for _, match := range []string{"INSERT INTO tenant_a.links", "DELETE FROM tenant_a.links"} {
+		if len(fake.statements(match)) == 0 {
+			t.Errorf("no query ran %s", match)
+		}
+	}
+}
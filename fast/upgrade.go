@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	defaultHTTPSUpgradeTTL     = 24 * time.Hour  // How long a probe result for a host is trusted
+	defaultHTTPSUpgradeTimeout = 2 * time.Second // How long a probe waits on the host
+	defaultHTTPSUpgradeHosts   = 10000           // How many hosts' answers are kept
+)
+
+// HTTPSUpgradeConfig whether http destinations are sent to https instead
+// when the host supports it
+type HTTPSUpgradeConfig struct {
+	Enabled  bool          `mapstructure:"enabled" yaml:"enabled"`
+	TTL      time.Duration `mapstructure:"ttl" yaml:"ttl"`
+	Timeout  time.Duration `mapstructure:"timeout" yaml:"timeout"`
+	MaxHosts int           `mapstructure:"max_hosts" yaml:"max_hosts"`
+}
+
+// loadHTTPSUpgradeConfig read the https upgrade settings for the environment
+func loadHTTPSUpgradeConfig(env string) (HTTPSUpgradeConfig, error) {
+	var cfg HTTPSUpgradeConfig
+	if err := viper.UnmarshalKey(fmt.Sprintf("%s.https_upgrade", env), &cfg); err != nil {
+		return cfg, fmt.Errorf("couldn't read https upgrade configuration: %w", err)
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = defaultHTTPSUpgradeTTL
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultHTTPSUpgradeTimeout
+	}
+	if cfg.MaxHosts <= 0 {
+		cfg.MaxHosts = defaultHTTPSUpgradeHosts
+	}
+
+	return cfg, nil
+}
+
+// httpsSupport whether a host answered over https and when we checked
+type httpsSupport struct {
+	supported bool
+	checked   time.Time
+}
+
+// HTTPSUpgrader rewrite http destinations to https for hosts that answer
+// over https. Hosts are probed in the background, one probe per host at a
+// time, so redirects never wait on a dial. Answers are cached for the TTL
+// and up to maxHosts hosts are remembered
+type HTTPSUpgrader struct {
+	client   *http.Client
+	ttl      time.Duration
+	maxHosts int
+
+	mu      sync.Mutex
+	hosts   map[string]httpsSupport
+	probing map[string]bool
+}
+
+// NewHTTPSUpgrader build an upgrader probing hosts with the client
+func NewHTTPSUpgrader(client *http.Client, ttl time.Duration, maxHosts int) *HTTPSUpgrader {
+	return &HTTPSUpgrader{
+		client:   client,
+		ttl:      ttl,
+		maxHosts: maxHosts,
+		hosts:    map[string]httpsSupport{},
+		probing:  map[string]bool{},
+	}
+}
+
+// Upgrade the https version of the destination if its host supports it,
+// otherwise the destination unchanged
+func (u *HTTPSUpgrader) Upgrade(destination string) string {
+	parsed, err := url.Parse(destination)
+	if err != nil || parsed.Scheme != "http" || parsed.Host == "" {
+		return destination
+	}
+	// an explicit port is for plain http, https won't be listening on it
+	if parsed.Port() != "" {
+		return destination
+	}
+
+	if !u.supportsHTTPS(parsed.Host) {
+		return destination
+	}
+
+	parsed.Scheme = "https"
+	return parsed.String()
+}
+
+// supportsHTTPS whether the host is known to answer over https. Hosts
+// without a fresh answer are probed in the background, until then the last
+// answer stands and unknown hosts stay on http
+func (u *HTTPSUpgrader) supportsHTTPS(host string) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	support, ok := u.hosts[host]
+	if (!ok || time.Since(support.checked) >= u.ttl) && !u.probing[host] {
+		u.probing[host] = true
+		go u.refresh(host)
+	}
+
+	return support.supported
+}
+
+// refresh probe the host and remember the answer
+func (u *HTTPSUpgrader) refresh(host string) {
+	// the probe outlives the redirect that asked for it
+	supported := u.probe(context.Background(), host)
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	delete(u.probing, host)
+	now := time.Now()
+	if _, ok := u.hosts[host]; !ok && len(u.hosts) >= u.maxHosts {
+		for h, support := range u.hosts {
+			if now.Sub(support.checked) >= u.ttl {
+				delete(u.hosts, h)
+			}
+		}
+		for h := range u.hosts {
+			if len(u.hosts) < u.maxHosts {
+				break
+			}
+			delete(u.hosts, h)
+		}
+	}
+	u.hosts[host] = httpsSupport{supported: supported, checked: now}
+}
+
+// probe make a request to the host over https. Any response means https
+// works, whatever the status
+func (u *HTTPSUpgrader) probe(ctx context.Context, host string) bool {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, "https://"+host+"/", nil)
+	if err != nil {
+		return false
+	}
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+
+	return true
+}
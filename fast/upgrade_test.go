@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitForProbes block until the upgrader has no probes in flight
+func waitForProbes(t *testing.T, u *HTTPSUpgrader) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		u.mu.Lock()
+		pending := len(u.probing)
+		u.mu.Unlock()
+		if pending == 0 {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("probes didn't finish")
+}
+
+func TestHTTPSUpgraderUpgrade(t *testing.T) {
+	u := NewHTTPSUpgrader(http.DefaultClient, time.Hour, 10)
+	u.hosts["secure.example"] = httpsSupport{supported: true, checked: time.Now()}
+	u.hosts["plain.example"] = httpsSupport{supported: false, checked: time.Now()}
+
+	tests := []struct {
+		destination string
+		want        string
+	}{
+		{"http://secure.example/a?b=c", "https://secure.example/a?b=c"},
+		{"http://plain.example/a", "http://plain.example/a"},
+		{"http://secure.example:8080/a", "http://secure.example:8080/a"},
+		{"https://plain.example/a", "https://plain.example/a"},
+		{"not a url\x7f", "not a url\x7f"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.destination, func(t *testing.T) {
+			if got := u.Upgrade(tt.destination); got != tt.want {
+				t.Errorf("Upgrade(%q) = %q, want %q", tt.destination, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTTPSUpgraderProbesInTheBackground(t *testing.T) {
+	var probes int32
+	release := make(chan struct{})
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&probes, 1)
+		<-release
+	}))
+	defer server.Close()
+	host := mustHost(t, server.URL)
+
+	u := NewHTTPSUpgrader(server.Client(), time.Hour, 10)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// the probe is stuck, so nobody waits for it
+			if u.supportsHTTPS(host) {
+				t.Error("supportsHTTPS() = true before the probe answered")
+			}
+		}()
+	}
+	wg.Wait()
+	close(release)
+	waitForProbes(t, u)
+
+	if got := atomic.LoadInt32(&probes); got != 1 {
+		t.Errorf("probed %d times, want once", got)
+	}
+	if !u.supportsHTTPS(host) {
+		t.Error("supportsHTTPS() = false after the probe answered")
+	}
+}
+
+func TestHTTPSUpgraderBoundsHosts(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	u := NewHTTPSUpgrader(server.Client(), time.Hour, 2)
+	u.hosts["stale.example"] = httpsSupport{checked: time.Now().Add(-2 * time.Hour)}
+	u.hosts["fresh.example"] = httpsSupport{checked: time.Now()}
+	host := mustHost(t, server.URL)
+	u.supportsHTTPS(host)
+	waitForProbes(t, u)
+
+	if len(u.hosts) != 2 {
+		t.Errorf("remembered %d hosts, want 2", len(u.hosts))
+	}
+	if _, ok := u.hosts["stale.example"]; ok {
+		t.Error("kept the stale host over a fresh one")
+	}
+	if !u.hosts[host].supported {
+		t.Errorf("%s isn't remembered as supporting https", host)
+	}
+}
+
+func mustHost(t *testing.T, raw string) string {
+	t.Helper()
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed.Host
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aeekayy/systems/fast/db"
+	"github.com/gin-gonic/gin"
+	pgx "github.com/jackc/pgx/v4"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultBulkDeleteLimit = 1000  // The most links a bulk delete removes when no limit is given
+	maxBulkDeleteLimit     = 10000 // The most links a bulk delete removes in one request
+)
+
+// URLMetadata details about an existing short link
+type URLMetadata struct {
+	URI          string     `json:"uri" yaml:"uri"`
+	OriginalURL  string     `json:"original_url" yaml:"original_url"`
+	Title        string     `json:"title,omitempty" yaml:"title,omitempty"`
+	Description  string     `json:"description,omitempty" yaml:"description,omitempty"`
+	Created      time.Time  `json:"created" yaml:"created"`
+	LastAccessed *time.Time `json:"last_accessed,omitempty" yaml:"last_accessed,omitempty"`
+	Hits         int64      `json:"hits" yaml:"hits"`
+	Source       string     `json:"source,omitempty" yaml:"source,omitempty"`
+	RedirectType string     `json:"redirect_type" yaml:"redirect_type"`
+	Expires      *time.Time `json:"expires,omitempty" yaml:"expires,omitempty"`
+	QR           string     `json:"qr,omitempty" yaml:"qr,omitempty"`
+	Notes        string     `json:"notes,omitempty" yaml:"notes,omitempty"`
+}
+
+// urlMetadataHandler return the metadata of a short link. ?qr=true adds
+// a QR code of the short URL as a PNG data URI, so pages can show it
+// without another request. Notes are only included for the link's owner,
+// and the QR code only carries a signed link's signature for its owner or
+// an admin
+func urlMetadataHandler(ctx context.Context, dbConn db.Querier, linkDomain LinkDomain, signingSecret []byte, caseInsensitive bool, sugar *zap.SugaredLogger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sugar := requestSugar(c, sugar)
+		var metadata URLMetadata
+		var host, owner, notes string
+		var signed bool
+		err := dbConn.QueryRow(ctx, "SELECT uri, original_url, COALESCE(title, ''), COALESCE(description, ''), created, last_accessed, hits, COALESCE(source, ''), redirect_type, expires, COALESCE(domain, ''), signed, COALESCE(owner, ''), COALESCE(notes, '') FROM "+urlsTable+" WHERE "+uriCondition(caseInsensitive)+" LIMIT 1;", c.Param("uri")).
+			Scan(&metadata.URI, &metadata.OriginalURL, &metadata.Title, &metadata.Description, &metadata.Created, &metadata.LastAccessed, &metadata.Hits, &metadata.Source, &metadata.RedirectType, &metadata.Expires, &host, &signed, &owner, &notes)
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "uri not found",
+			})
+			return
+		}
+		if err != nil {
+			sugar.Errorf("error retrieving URI: %s", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "error retrieving URI",
+			})
+			return
+		}
+
+		if key, ok := currentAPIKey(c); ok && owner != "" && key.ID == owner {
+			metadata.Notes = notes
+		}
+
+		if c.Query("qr") == "true" {
+			metadata.QR, err = qrDataURI(shortLink(linkDomain, host, metadata.URI, signed && mayHaveSignature(c, owner), signingSecret))
+			if err != nil {
+				sugar.Errorf("error building QR code: %s", err)
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"error": "error building QR code",
+				})
+				return
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"data": metadata,
+		})
+	}
+}
+
+// shortLink the full short URL of a stored link, under its vanity domain
+// and with its signature when it has them
+func shortLink(linkDomain LinkDomain, host, uri string, signed bool, signingSecret []byte) string {
+	return storedShortURL(linkDomain, host, uri, signed, signingSecret).ShortenLongURL
+}
+
+// storedShortURL the short URL of a stored link like shortLink, in every
+// format. The uri stays bare
+func storedShortURL(linkDomain LinkDomain, host, uri string, signed bool, signingSecret []byte) *ShortenURL {
+	if host != "" {
+		linkDomain.Host = host
+	}
+	if !signed {
+		return linkDomain.NewShortenURL(uri)
+	}
+	short := linkDomain.NewShortenURL(signedURI(signingSecret, uri))
+	short.URI = uri
+	return short
+}
+
+// qrHandler a PNG QR code of a link's short URL. A signed link's signature
+// is only encoded for its owner or an admin, everyone else gets the bare link
+func qrHandler(ctx context.Context, dbConn db.Querier, linkDomain LinkDomain, signingSecret []byte, caseInsensitive bool, sugar *zap.SugaredLogger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sugar := requestSugar(c, sugar)
+		var uri, host, owner string
+		var signed bool
+		err := dbConn.QueryRow(ctx, "SELECT uri, COALESCE(domain, ''), signed, COALESCE(owner, '') FROM "+urlsTable+" WHERE "+uriCondition(caseInsensitive)+" LIMIT 1;", c.Param("uri")).Scan(&uri, &host, &signed, &owner)
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "uri not found",
+			})
+			return
+		}
+		if err != nil {
+			sugar.Errorf("error retrieving URI: %s", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "error retrieving URI",
+			})
+			return
+		}
+
+		qr, err := encodeQR(shortLink(linkDomain, host, uri, signed && mayHaveSignature(c, owner), signingSecret))
+		if err == nil {
+			var encoded []byte
+			if encoded, err = qr.PNG(); err == nil {
+				c.Data(http.StatusOK, "image/png", encoded)
+				return
+			}
+		}
+		sugar.Errorf("error building QR code: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error building QR code",
+		})
+	}
+}
+
+// recordHit count a redirect of a short link. last accessed is only moved
+// when the link wasn't already used within throttle so busy links don't
+// churn the column
+func recordHit(ctx context.Context, dbConn db.Querier, uri string, throttle time.Duration) error {
+	_, err := dbConn.Exec(ctx, `UPDATE `+urlsTable+` SET hits = hits + 1,
+		last_accessed = CASE WHEN last_accessed IS NULL OR last_accessed < now() - make_interval(secs => $2) THEN now() ELSE last_accessed END
+		WHERE uri = $1;`, uri, throttle.Seconds())
+	return err
+}
+
+// bulkDeleteHandler delete links matching the filters in a bounded batch
+// and return how many were deleted. created_before only matches links older
+// than the time and max_hits links with at most that many hits, so max_hits=0
+// matches never clicked links. At least one filter is required so a bare
+// request can't wipe the table. Deleted links are dropped from the redirect
+// cache so they stop redirecting straight away
+func bulkDeleteHandler(ctx context.Context, dbConn db.Querier, rc *RedirectCache, caseInsensitive bool, sugar *zap.SugaredLogger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sugar := requestSugar(c, sugar)
+		var createdBefore *time.Time
+		if val := c.Query("created_before"); val != "" {
+			parsed, err := time.Parse(time.RFC3339, val)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": "created_before must be an RFC 3339 time",
+				})
+				return
+			}
+			createdBefore = &parsed
+		}
+
+		var maxHits *int64
+		if val := c.Query("max_hits"); val != "" {
+			parsed, err := strconv.ParseInt(val, 10, 64)
+			if err != nil || parsed < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": "max_hits must be a non-negative number",
+				})
+				return
+			}
+			maxHits = &parsed
+		}
+
+		if createdBefore == nil && maxHits == nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "at least one filter is required",
+			})
+			return
+		}
+
+		limit := defaultBulkDeleteLimit
+		if val := c.Query("limit"); val != "" {
+			parsed, err := strconv.Atoi(val)
+			if err != nil || parsed <= 0 {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": "limit must be a positive number",
+				})
+				return
+			}
+			limit = parsed
+		}
+		if limit > maxBulkDeleteLimit {
+			limit = maxBulkDeleteLimit
+		}
+
+		// delete and audit in one statement so every deleted link has an audit row
+		var deleted []string
+		err := dbConn.QueryRow(ctx, `WITH deleted AS (
+				DELETE FROM `+urlsTable+` WHERE id IN (
+					SELECT id FROM `+urlsTable+`
+					WHERE ($1::timestamptz IS NULL OR created < $1) AND ($2::bigint IS NULL OR hits <= $2)
+					LIMIT $3
+				) RETURNING uri
+			), audited AS (
+				INSERT INTO audit_log(action, uri, actor) SELECT $4, uri, NULLIF($5, '') FROM deleted
+			)
+			SELECT COALESCE(array_agg(uri), '{}') FROM deleted;`,
+			createdBefore, maxHits, limit, auditActionDelete, actorID(c)).Scan(&deleted)
+		if err != nil {
+			sugar.Errorf("error deleting URLs: %s", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "error deleting URLs",
+			})
+			return
+		}
+
+		for _, uri := range deleted {
+			rc.Delete(cacheKey(uri, caseInsensitive))
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"data": gin.H{
+				"deleted": len(deleted),
+			},
+		})
+	}
+}
+
+// deleteLink delete a link, recording it in the audit log and dropping it
+// from the redirect cache. Only links owned by owner are deleted, any link
+// when owner is empty. The uri deleted is empty when there was no such link
+func deleteLink(ctx context.Context, dbConn db.Querier, rc *RedirectCache, uri, owner, actor string, caseInsensitive bool) (string, error) {
+	var deleted string
+	err := dbConn.QueryRow(ctx, `WITH deleted AS (
+			DELETE FROM `+urlsTable+` WHERE id = (
+				SELECT id FROM `+urlsTable+` WHERE `+uriCondition(caseInsensitive)+` AND ($2 = '' OR owner = $2) LIMIT 1
+			) RETURNING uri
+		), audited AS (
+			INSERT INTO audit_log(action, uri, actor) SELECT $3, uri, NULLIF($4, '') FROM deleted
+		)
+		SELECT uri FROM deleted;`, uri, owner, auditActionDelete, actor).Scan(&deleted)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	rc.Delete(cacheKey(deleted, caseInsensitive))
+
+	return deleted, nil
+}
+
+// deleteURLHandler delete one short link. Keys may delete the links they
+// own, admins any link
+func deleteURLHandler(ctx context.Context, dbConn db.Querier, rc *RedirectCache, caseInsensitive bool, sugar *zap.SugaredLogger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sugar := requestSugar(c, sugar)
+		key, _ := currentAPIKey(c)
+		owner := key.ID
+		if key.Admin {
+			owner = ""
+		}
+
+		uri, err := deleteLink(ctx, dbConn, rc, c.Param("uri"), owner, key.ID, caseInsensitive)
+		if err != nil {
+			sugar.Errorf("error deleting URI: %s", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "error deleting URI",
+			})
+			return
+		}
+		if uri == "" {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "uri not found",
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"data": gin.H{
+				"uri":     uri,
+				"deleted": true,
+			},
+		})
+	}
+}
+
+// unwrapHandler follow the redirects of a short link's destination and
+// return the final landing URL, which helps when links point at other
+// redirectors
+func unwrapHandler(ctx context.Context, dbConn db.Querier, caseInsensitive bool, client *http.Client, sugar *zap.SugaredLogger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sugar := requestSugar(c, sugar)
+		var originalURL string
+		err := dbConn.QueryRow(ctx, "SELECT original_url FROM "+urlsTable+" WHERE "+uriCondition(caseInsensitive)+" LIMIT 1;", c.Param("uri")).Scan(&originalURL)
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "uri not found",
+			})
+			return
+		}
+		if err != nil {
+			sugar.Errorf("error retrieving URI: %s", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "error retrieving URI",
+			})
+			return
+		}
+
+		finalURL, err := unwrapURL(c.Request.Context(), client, originalURL)
+		if err != nil {
+			sugar.Warnf("error unwrapping %s: %s", originalURL, err)
+			c.JSON(http.StatusBadGateway, gin.H{
+				"error": fmt.Sprintf("couldn't unwrap destination: %s", err),
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"data": gin.H{
+				"original_url": originalURL,
+				"final_url":    finalURL,
+			},
+		})
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestBulkDeleteHandler(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		status  int
+		deleted []string
+	}{
+		{"no filter", "", http.StatusBadRequest, nil},
+		{"bad created_before", "?created_before=last-week", http.StatusBadRequest, nil},
+		{"negative max_hits", "?max_hits=-1", http.StatusBadRequest, nil},
+		{"bad limit", "?max_hits=0&limit=0", http.StatusBadRequest, nil},
+		{"deletes and invalidates", "?max_hits=0", http.StatusOK, []string{"old", "Unused"}},
+		{"nothing matched", "?created_before=2020-01-01T00:00:00Z", http.StatusOK, []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := (&fakeDB{}).on("WITH deleted AS", fakeResult{rows: [][]interface{}{{tt.deleted}}})
+			rc := NewRedirectCache(10)
+			for _, uri := range append([]string{"kept"}, tt.deleted...) {
+				rc.Set(cacheKey(uri, true), RedirectLink{URI: uri}, time.Hour)
+			}
+
+			r := testRouter()
+			r.DELETE("/api/v1/urls", requireAdmin, bulkDeleteHandler(context.Background(), fake, rc, true, testSugar))
+			w := serve(r, http.MethodDelete, "/api/v1/urls"+tt.query, testAdminKey, "")
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status != http.StatusOK {
+				if len(fake.calls) != 0 {
+					t.Errorf("a rejected request reached the database")
+				}
+				return
+			}
+
+			for _, uri := range tt.deleted {
+				if _, ok := rc.Get(cacheKey(uri, true)); ok {
+					t.Errorf("deleted link %s is still cached", uri)
+				}
+			}
+			if _, ok := rc.Get(cacheKey("kept", true)); !ok {
+				t.Errorf("a link that wasn't deleted was dropped from the cache")
+			}
+		})
+	}
+}
+
+func TestBulkDeleteHandlerFilters(t *testing.T) {
+	createdBefore := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name          string
+		query         string
+		createdBefore *time.Time
+		maxHits       *int64
+		limit         int
+	}{
+		{"created_before", "?created_before=2022-01-01T00:00:00Z", &createdBefore, nil, defaultBulkDeleteLimit},
+		{"max_hits", "?max_hits=5", nil, int64Ptr(5), defaultBulkDeleteLimit},
+		{"never clicked", "?max_hits=0", nil, int64Ptr(0), defaultBulkDeleteLimit},
+		{"both", "?created_before=2022-01-01T00:00:00Z&max_hits=0", &createdBefore, int64Ptr(0), defaultBulkDeleteLimit},
+		{"limit", "?max_hits=0&limit=10", nil, int64Ptr(0), 10},
+		{"limit capped", "?max_hits=0&limit=1000000", nil, int64Ptr(0), maxBulkDeleteLimit},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := (&fakeDB{}).on("WITH deleted AS", fakeResult{rows: [][]interface{}{{[]string{"old", "unused"}}}})
+			r := testRouter()
+			r.DELETE("/api/v1/urls", requireAdmin, bulkDeleteHandler(context.Background(), fake, NewRedirectCache(10), true, testSugar))
+			w := serve(r, http.MethodDelete, "/api/v1/urls"+tt.query, testAdminKey, "")
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", w.Code, w.Body)
+			}
+
+			args := fake.statements("WITH deleted AS")[0].args
+			if got := args[0].(*time.Time); (got == nil) != (tt.createdBefore == nil) || (got != nil && !got.Equal(*tt.createdBefore)) {
+				t.Errorf("created_before = %v, want %v", got, tt.createdBefore)
+			}
+			if got := args[1].(*int64); (got == nil) != (tt.maxHits == nil) || (got != nil && *got != *tt.maxHits) {
+				t.Errorf("max_hits = %v, want %v", got, tt.maxHits)
+			}
+			if args[2] != tt.limit {
+				t.Errorf("limit = %v, want %d", args[2], tt.limit)
+			}
+			if args[3] != auditActionDelete || args[4] != testAdminKey.ID {
+				t.Errorf("audited as %v by %v, want %s by %s", args[3], args[4], auditActionDelete, testAdminKey.ID)
+			}
+
+			var body struct {
+				Data struct {
+					Deleted int `json:"deleted"`
+				} `json:"data"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Data.Deleted != 2 {
+				t.Errorf("body = %s, want 2 deleted", w.Body)
+			}
+		})
+	}
+}
+
+// int64Ptr a pointer to n
+func int64Ptr(n int64) *int64 {
+	return &n
+}
+
+func TestDeleteURLHandler(t *testing.T) {
+	tests := []struct {
+		name   string
+		key    APIKey
+		status int
+	}{
+		{"owner", testOwnerKey, http.StatusOK},
+		{"admin", testAdminKey, http.StatusOK},
+		{"someone else", testOtherKey, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := (&fakeDB{}).onFunc("WITH deleted AS", ownedLink("launch", testOwnerKey.ID, 1))
+			rc := NewRedirectCache(10)
+			rc.Set(cacheKey("launch", true), RedirectLink{URI: "launch"}, time.Hour)
+
+			r := testRouter()
+			r.DELETE("/api/v1/urls/:uri", requireAPIKey, deleteURLHandler(context.Background(), fake, rc, true, testSugar))
+			w := serve(r, http.MethodDelete, "/api/v1/urls/launch", tt.key, "")
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			_, cached := rc.Get(cacheKey("launch", true))
+			if cached != (tt.status != http.StatusOK) {
+				t.Errorf("cached = %t after a %d", cached, w.Code)
+			}
+		})
+	}
+}
+
+func TestUnwrapHandler(t *testing.T) {
+	server := redirectingServer(t)
+	client := newOutboundClient(OutboundConfig{MaxRedirects: 2, AllowPrivate: true, MinTLSVersion: defaultMinTLSVersion}, time.Second)
+	destinations := map[string]string{"launch": server.URL + "/hop/2", "loop": server.URL + "/hop/9"}
+
+	tests := []struct {
+		name   string
+		uri    string
+		status int
+		body   string
+	}{
+		{"unwrapped", "launch", http.StatusOK, `{"data":{"final_url":"` + server.URL + `/hop/0","original_url":"` + server.URL + `/hop/2"}}`},
+		{"too many redirects", "loop", http.StatusBadGateway, ""},
+		{"missing", "missing", http.StatusNotFound, `{"error":"uri not found"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := (&fakeDB{}).onFunc("SELECT original_url", func(args []interface{}) fakeResult {
+				destination, ok := destinations[args[0].(string)]
+				if !ok {
+					return fakeResult{}
+				}
+				return fakeResult{rows: [][]interface{}{{destination}}}
+			})
+			r := testRouter()
+			r.GET("/api/v1/urls/:uri/unwrap", unwrapHandler(context.Background(), fake, true, client, testSugar))
+			w := serve(r, http.MethodGet, "/api/v1/urls/"+tt.uri+"/unwrap", APIKey{}, "")
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.body != "" && w.Body.String() != tt.body {
+				t.Errorf("body = %s, want %s", w.Body, tt.body)
+			}
+		})
+	}
+}
+
+// metadataRow a urls row as urlMetadataHandler reads it
+func metadataRow(uri, title, description, owner, notes string) []interface{} {
+	created := time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+	return []interface{}{uri, "https://example.com/a", title, description, created, nil, int64(3), "", redirectPermanent, nil, "", false, owner, notes}
+}
+
+func TestURLMetadataHandler(t *testing.T) {
+	rows := map[string][]interface{}{
+		"launch":   metadataRow("launch", "Spring Launch", "Everything new this spring", testOwnerKey.ID, ""),
+		"untitled": metadataRow("untitled", "", "", "", ""),
+	}
+	tests := []struct {
+		name        string
+		uri         string
+		status      int
+		title       string
+		description string
+	}{
+		{"titled", "launch", http.StatusOK, "Spring Launch", "Everything new this spring"},
+		{"untitled", "untitled", http.StatusOK, "", ""},
+		{"missing", "missing", http.StatusNotFound, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := (&fakeDB{}).onFunc("COALESCE(notes", linkRows(rows))
+			r := testRouter()
+			r.GET("/api/v1/urls/:uri", urlMetadataHandler(context.Background(), fake, LinkDomain{Host: "fa.st", Scheme: "https"}, nil, true, testSugar))
+			w := serve(r, http.MethodGet, "/api/v1/urls/"+tt.uri, APIKey{}, "")
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			var body struct {
+				Data map[string]interface{} `json:"data"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("couldn't decode %s: %s", w.Body, err)
+			}
+			for field, want := range map[string]string{"title": tt.title, "description": tt.description} {
+				got, ok := body.Data[field]
+				if want == "" && ok {
+					t.Errorf("%s = %v, want it left out", field, got)
+				}
+				if want != "" && got != want {
+					t.Errorf("%s = %v, want %q", field, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestURLMetadataHandlerNotes(t *testing.T) {
+	rows := map[string][]interface{}{
+		"launch": metadataRow("launch", "", "", testOwnerKey.ID, "renew the domain in June"),
+		"legacy": metadataRow("legacy", "", "", "", "left by the import"),
+	}
+	tests := []struct {
+		name  string
+		uri   string
+		key   APIKey
+		notes string
+	}{
+		{"owner", "launch", testOwnerKey, "renew the domain in June"},
+		{"anonymous", "launch", APIKey{}, ""},
+		{"another key", "launch", testOtherKey, ""},
+		{"admin", "launch", testAdminKey, ""},
+		{"link without an owner", "legacy", testOwnerKey, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := (&fakeDB{}).onFunc("COALESCE(notes", linkRows(rows))
+			r := testRouter()
+			r.GET("/api/v1/urls/:uri", urlMetadataHandler(context.Background(), fake, LinkDomain{Host: "fa.st", Scheme: "https"}, nil, true, testSugar))
+			w := serve(r, http.MethodGet, "/api/v1/urls/"+tt.uri, tt.key, "")
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", w.Code, w.Body)
+			}
+
+			var body struct {
+				Data map[string]interface{} `json:"data"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("couldn't decode %s: %s", w.Body, err)
+			}
+			got, ok := body.Data["notes"]
+			if tt.notes == "" && ok {
+				t.Errorf("notes = %v, want them left out", got)
+			}
+			if tt.notes != "" && got != tt.notes {
+				t.Errorf("notes = %v, want %q", got, tt.notes)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maxValidateURLs            = 100 // The most URLs validated in one request
+	defaultValidateConcurrency = 8   // Destinations fetched at once unless configured otherwise
+	maxValidateConcurrency     = 64  // The most destinations fetched at once, whatever is configured
+)
+
+// ValidateURLsRequest destinations to check without creating links.
+// Reachability also fetches each valid destination
+type ValidateURLsRequest struct {
+	URLs         []string `json:"urls" yaml:"urls"`
+	Reachability bool     `json:"reachability,omitempty" yaml:"reachability,omitempty"`
+}
+
+// URLValidation whether a destination would be accepted for a new link.
+// Destination is the url as it would be stored, after rewrites and
+// normalization, and is what reachability fetches
+type URLValidation struct {
+	URL         string `json:"url" yaml:"url"`
+	Destination string `json:"destination,omitempty" yaml:"destination,omitempty"`
+	Valid       bool   `json:"valid" yaml:"valid"`
+	Error       string `json:"error,omitempty" yaml:"error,omitempty"`
+	Scheme      string `json:"scheme,omitempty" yaml:"scheme,omitempty"`
+	Host        string `json:"host,omitempty" yaml:"host,omitempty"`
+	Reachable   *bool  `json:"reachable,omitempty" yaml:"reachable,omitempty"`
+}
+
+// validateURL check a destination with the rules links are created with:
+// it is rewritten and normalized, then mustn't be a short link of ours, a
+// blocked shortener's or the vanity domain it would live under. The host is
+// reported the way it would be stored, in punycode
+func validateURL(creator *linkCreator, domain LinkDomain, destination string) URLValidation {
+	result := URLValidation{URL: destination}
+	destination, err := creator.normalize(destination)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	parsed, err := url.ParseRequestURI(destination)
+	if err != nil {
+		result.Error = fmt.Sprintf("couldn't parse url: %s", err)
+		return result
+	}
+	result.Destination = destination
+	result.Scheme = parsed.Scheme
+	result.Host = parsed.Host
+	if err := creator.checkDestination(destination, domain); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	result.Valid = true
+	return result
+}
+
+// validateURLsHandler report for each URL whether the key could create a
+// link for it, so clients can check a bulk import before running it. At
+// most concurrency destinations are fetched at once for reachability
+func validateURLsHandler(creator *linkCreator, concurrency int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var json ValidateURLsRequest
+		if err := c.ShouldBindJSON(&json); err != nil {
+			bindError(c, err)
+			return
+		}
+		if len(json.URLs) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "urls are required",
+			})
+			return
+		}
+		if len(json.URLs) > maxValidateURLs {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("at most %d urls can be validated at once", maxValidateURLs),
+			})
+			return
+		}
+
+		// links created with a key that has a vanity domain live under it
+		key, _ := currentAPIKey(c)
+		domain := keyDomain(key, creator.domain)
+		results := make([]URLValidation, len(json.URLs))
+		for i, destination := range json.URLs {
+			results[i] = validateURL(creator, domain, destination)
+		}
+
+		if json.Reachability {
+			indexes := make(chan int)
+			var wg sync.WaitGroup
+			for w := 0; w < concurrency; w++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i := range indexes {
+						_, err := unwrapURL(c.Request.Context(), creator.client, results[i].Destination)
+						reachable := err == nil
+						results[i].Reachable = &reachable
+					}
+				}()
+			}
+			for i := range results {
+				if results[i].Valid {
+					indexes <- i
+				}
+			}
+			close(indexes)
+			wg.Wait()
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"data": results,
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// validateResults the validations in a POST /api/v1/validate response
+func validateResults(t *testing.T, w *httptest.ResponseRecorder) []URLValidation {
+	t.Helper()
+	var body struct {
+		Data []URLValidation `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("couldn't decode %s: %s", w.Body, err)
+	}
+	return body.Data
+}
+
+func TestValidateURLsHandler(t *testing.T) {
+	vanityKey := APIKey{ID: "initech", Key: "initech-secret", Domain: "go.initech.example"}
+	creator := testCreator(nil)
+	creator.blocked = []string{"bit.ly"}
+	creator.idn = IDNConfig{Normalize: true}
+	creator.rewrites = []RewriteRule{{Match: `^https://bitly\.example/`, Replace: "https://bit.ly/", pattern: regexp.MustCompile(`^https://bitly\.example/`)}}
+	r := gin.New()
+	r.Use(authenticate(append([]APIKey{vanityKey}, testAPIKeys...)))
+	r.POST("/api/v1/validate", requireAPIKey, validateURLsHandler(creator, 2))
+
+	tests := []struct {
+		name  string
+		key   APIKey
+		url   string
+		valid bool
+		host  string
+	}{
+		{"valid", testOwnerKey, "https://example.com/a", true, "example.com"},
+		{"punycode host", testOwnerKey, "https://bücher.example/a", true, "xn--bcher-kva.example"},
+		{"not a url", testOwnerKey, "example", false, ""},
+		{"own short link", testOwnerKey, "https://fa.st/abc", false, ""},
+		{"blocked shortener", testOwnerKey, "https://bit.ly/abc", false, ""},
+		{"rewritten to a blocked shortener", testOwnerKey, "https://bitly.example/abc", false, ""},
+		{"the key's vanity domain", vanityKey, "https://go.initech.example/abc", false, ""},
+		{"another key's vanity domain", testOwnerKey, "https://go.initech.example/abc", true, "go.initech.example"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(r, http.MethodPost, "/api/v1/validate", tt.key, fmt.Sprintf(`{"urls": [%q]}`, tt.url))
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+			}
+			results := validateResults(t, w)
+			if len(results) != 1 || results[0].Valid != tt.valid || results[0].Reachable != nil {
+				t.Fatalf("results = %+v, want valid = %t and no reachability", results, tt.valid)
+			}
+			if tt.valid && results[0].Host != tt.host {
+				t.Errorf("host = %s, want %s", results[0].Host, tt.host)
+			}
+		})
+	}
+
+	if w := serve(r, http.MethodPost, "/api/v1/validate", APIKey{}, `{"urls": ["https://example.com"]}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestValidateURLsReachabilityConcurrency(t *testing.T) {
+	var mu sync.Mutex
+	var inFlight, most int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > most {
+			most = inFlight
+		}
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	creator := testCreator(nil)
+	creator.client = server.Client()
+	r := testRouter()
+	r.POST("/api/v1/validate", requireAPIKey, validateURLsHandler(creator, 2))
+
+	urls := []string{`"https://fa.st/skipped"`}
+	for i := 0; i < 8; i++ {
+		urls = append(urls, fmt.Sprintf("%q", fmt.Sprintf("%s/%d", server.URL, i)))
+	}
+	w := serve(r, http.MethodPost, "/api/v1/validate", testOwnerKey, fmt.Sprintf(`{"urls": [%s], "reachability": true}`, strings.Join(urls, ", ")))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+
+	results := validateResults(t, w)
+	if results[0].Reachable != nil {
+		t.Error("fetched an invalid url")
+	}
+	for _, result := range results[1:] {
+		if result.Reachable == nil || !*result.Reachable {
+			t.Errorf("%s isn't reachable", result.URL)
+		}
+	}
+	if most > 2 {
+		t.Errorf("fetched %d urls at once, want at most 2", most)
+	}
+}
+
+func TestValidateURLsReachabilityProbesStoredDestination(t *testing.T) {
+	var mu sync.Mutex
+	var fetched []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		fetched = append(fetched, r.URL.Path)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	creator := testCreator(nil)
+	creator.client = server.Client()
+	creator.rewrites = []RewriteRule{{Match: `^https://old\.example/`, Replace: server.URL + "/", pattern: regexp.MustCompile(`^https://old\.example/`)}}
+	r := testRouter()
+	r.POST("/api/v1/validate", requireAPIKey, validateURLsHandler(creator, 2))
+
+	tests := []struct {
+		name        string
+		url         string
+		destination string
+		path        string
+	}{
+		{"as given", server.URL + "/a", server.URL + "/a", "/a"},
+		{"rewritten", "https://old.example/b", server.URL + "/b", "/b"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mu.Lock()
+			fetched = nil
+			mu.Unlock()
+			w := serve(r, http.MethodPost, "/api/v1/validate", testOwnerKey, fmt.Sprintf(`{"urls": [%q], "reachability": true}`, tt.url))
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", w.Code, w.Body)
+			}
+			results := validateResults(t, w)
+			if len(results) != 1 || results[0].URL != tt.url || results[0].Destination != tt.destination {
+				t.Fatalf("results = %+v, want %s stored as %s", results, tt.url, tt.destination)
+			}
+			if results[0].Reachable == nil || !*results[0].Reachable {
+				t.Errorf("%s isn't reachable", tt.url)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if len(fetched) != 1 || fetched[0] != tt.path {
+				t.Errorf("fetched %v, want %s", fetched, tt.path)
+			}
+		})
+	}
+}
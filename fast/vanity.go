@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ownerDomain the domain links created by the request's API key live
+// under, the default domain unless the key has a vanity domain
+func ownerDomain(c *gin.Context, base LinkDomain) LinkDomain {
+	key, _ := currentAPIKey(c)
+	return keyDomain(key, base)
+}
+
+// keyDomain the domain links created with key live under
+func keyDomain(key APIKey, base LinkDomain) LinkDomain {
+	if key.Domain == "" {
+		return base
+	}
+
+	return LinkDomain{Host: key.Domain, Scheme: base.Scheme}
+}
+
+// vanityDomains the vanity domains configured on API keys
+func vanityDomains(keys []APIKey) map[string]bool {
+	domains := map[string]bool{}
+	for _, key := range keys {
+		if key.Domain != "" {
+			domains[key.Domain] = true
+		}
+	}
+	return domains
+}
+
+// vanityHost the vanity domain a request came in on, empty for requests to
+// the default domain or any host that isn't a vanity domain
+func vanityHost(host string, domains map[string]bool) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if domains[host] {
+		return host
+	}
+	return ""
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestVanityHost(t *testing.T) {
+	domains := map[string]bool{"go.acme.com": true, "go.globex.com": true}
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"go.acme.com", "go.acme.com"},
+		{"GO.Acme.com.", "go.acme.com"},
+		{"go.globex.com:8080", "go.globex.com"},
+		{"fa.st", ""},
+		{"evil.example.com", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			if got := vanityHost(tt.host, domains); got != tt.want {
+				t.Errorf("vanityHost(%q) = %q, want %q", tt.host, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLinkCreatorVanityDomains(t *testing.T) {
+	tests := []struct {
+		name   string
+		key    APIKey
+		domain string
+		short  string
+	}{
+		{"default domain", testOwnerKey, "", "https://fa.st/launch"},
+		{"acme", APIKey{ID: "acme", Key: "acme-secret", Domain: "go.acme.com"}, "go.acme.com", "https://go.acme.com/launch"},
+		{"globex", APIKey{ID: "globex", Key: "globex-secret", Domain: "go.globex.com"}, "go.globex.com", "https://go.globex.com/launch"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := (&fakeDB{}).
+				on("SELECT EXISTS", fakeResult{rows: [][]interface{}{{false}}}).
+				onFunc("INSERT INTO urls", insertedLinks)
+			link, err := testCreator(fake).create(context.Background(), ShortenURLRequest{URL: "https://example.com/a", Alias: "launch"}, creation{Key: tt.key}, testSugar)
+			if err != nil {
+				t.Fatalf("create() = %v", err)
+			}
+			if link.ShortenLongURL != tt.short {
+				t.Errorf("short url = %s, want %s", link.ShortenLongURL, tt.short)
+			}
+			if domain := fake.statements("INSERT INTO urls")[0].args[17]; domain != tt.domain {
+				t.Errorf("stored under %q, want %q", domain, tt.domain)
+			}
+		})
+	}
+}
+
+func TestShortURIHandlerVanityDomains(t *testing.T) {
+	acme := redirectRow("acme", "https://acme.example.com/")
+	acme[1] = "go.acme.com"
+	globex := redirectRow("globex", "https://globex.example.com/")
+	globex[1] = "go.globex.com"
+	rows := map[string][]interface{}{
+		"acme":   acme,
+		"globex": globex,
+		"plain":  redirectRow("plain", "https://example.com/"),
+	}
+
+	tests := []struct {
+		name   string
+		host   string
+		uri    string
+		status int
+	}{
+		{"acme on its domain", "go.acme.com", "acme", http.StatusMovedPermanently},
+		{"globex on its domain", "go.globex.com", "globex", http.StatusMovedPermanently},
+		{"acme on globex's domain", "go.globex.com", "acme", http.StatusNotFound},
+		{"acme on the default domain", "fa.st", "acme", http.StatusNotFound},
+		{"default link on the default domain", "fa.st", "plain", http.StatusMovedPermanently},
+		{"default link on a vanity domain", "go.acme.com", "plain", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := (&fakeDB{}).onFunc("SELECT uri, COALESCE(domain", linkRows(rows))
+			rd := testRedirector(fake)
+			rd.vanityHosts = map[string]bool{"go.acme.com": true, "go.globex.com": true}
+
+			req := httptest.NewRequest(http.MethodGet, "/"+tt.uri, nil)
+			req.Host = tt.host
+			w := httptest.NewRecorder()
+			redirectRouter(rd).ServeHTTP(w, req)
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BuildInfo the build the server was started from. Fields are empty when
+// the build didn't set them, as in go run and go test
+type BuildInfo struct {
+	Version   string `json:"version"`
+	GitCommit string `json:"git_commit"`
+	BuildTime string `json:"build_time"`
+}
+
+// versionHandler answer with the build information, so operators can
+// confirm which build is deployed
+func versionHandler(info BuildInfo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, info)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestVersionHandler(t *testing.T) {
+	tests := []struct {
+		name string
+		info BuildInfo
+	}{
+		{"unset in test builds", BuildInfo{}},
+		{"injected", BuildInfo{Version: "1.4.0", GitCommit: "201f48f", BuildTime: "2022-05-01T12:00:00Z"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			r.GET("/api/v1/version", versionHandler(tt.info))
+			w := serve(r, http.MethodGet, "/api/v1/version", APIKey{}, "")
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", w.Code, w.Body)
+			}
+
+			var body map[string]string
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("couldn't decode %s: %s", w.Body, err)
+			}
+			want := map[string]string{"version": tt.info.Version, "git_commit": tt.info.GitCommit, "build_time": tt.info.BuildTime}
+			for field, value := range want {
+				if got, ok := body[field]; !ok || got != value {
+					t.Errorf("%s = %q (present %t), want %q", field, got, ok, value)
+				}
+			}
+		})
+	}
+}